@@ -1,51 +1,84 @@
 package api
 
 import (
-	"database/sql"
-	"fmt"
 	"math"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/lib/pq"
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 
-	deroglobals "github.com/deroproject/derosuite/globals"
-
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/coingecko"
-	"github.com/peppinux/dero-merchant/config"
-	"github.com/peppinux/dero-merchant/postgres"
 	"github.com/peppinux/dero-merchant/processor"
-	"github.com/peppinux/dero-merchant/redis"
-	"github.com/peppinux/dero-merchant/stringutil"
+	"github.com/peppinux/dero-merchant/repository"
 )
 
+func init() {
+	// Amounts are sent as JSON numbers (not strings) for backward compatibility. Their text representation is still exact.
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
 // Payment represents a payment made to a store
 type Payment struct {
-	PaymentID         string    `json:"paymentID,omitempty"`
-	Status            string    `json:"status,omitempty"`
-	Currency          string    `json:"currency,omitempty"`
-	CurrencyAmount    float64   `json:"currencyAmount,omitempty"`
-	ExchangeRate      float64   `json:"exchangeRate,omitempty"`
-	DeroAmount        string    `json:"deroAmount,omitempty"`
-	AtomicDeroAmount  uint64    `json:"atomicDeroAmount,omitempty"`
-	IntegratedAddress string    `json:"integratedAddress,omitempty"`
-	CreationTime      time.Time `json:"creationTime,omitempty"`
-	TTL               int       `json:"ttl"`
-	StoreID           int       `json:"-"`
+	PaymentID        string          `json:"paymentID,omitempty"`
+	Status           string          `json:"status,omitempty"`
+	Currency         string          `json:"currency,omitempty"`
+	CurrencyAmount   decimal.Decimal `json:"currencyAmount"`
+	ExchangeRate     decimal.Decimal `json:"exchangeRate"`
+	DeroAmount       string          `json:"deroAmount,omitempty"`
+	AtomicDeroAmount uint64          `json:"atomicDeroAmount,omitempty"`
+	// MinAtomicDeroAmount is the minimum amount of atomic DERO the payment is accepted for (AtomicDeroAmount minus the underpayment tolerance)
+	MinAtomicDeroAmount uint64        `json:"minAtomicDeroAmount,omitempty"`
+	PricingPolicy       PricingPolicy `json:"pricingPolicy"`
+	IntegratedAddress   string        `json:"integratedAddress,omitempty"`
+	CreationTime        time.Time     `json:"creationTime,omitempty"`
+	TTL                 int           `json:"ttl"`
+	// MaxTTL is the number of minutes the payment had to be received in after its creation
+	MaxTTL           int `json:"maxTTL"`
+	MinConfirmations int `json:"minConfirmations"`
+	// RequiredConfirmations is the number of confirmations the payment needs to have to be considered paid,
+	// given the confirmation tier of the store its amount of DERO falls in (if any, otherwise MinConfirmations)
+	RequiredConfirmations int `json:"requiredConfirmations"`
+	// RequotedFrom is the Payment ID of the expired payment this payment was re-quoted from
+	RequotedFrom string `json:"requotedFrom,omitempty"`
+	// RequotedTo is the Payment ID of the payment this (expired) payment was re-quoted into
+	RequotedTo string `json:"requotedTo,omitempty"`
+	// SuccessURL and CancelURL are the URLs the pay helper page redirects customers to after the payment is paid or cancelled
+	SuccessURL string `json:"successURL,omitempty"`
+	CancelURL  string `json:"cancelURL,omitempty"`
+	// TxIDs are the IDs of the transactions the payment was received in
+	TxIDs []string `json:"txIDs,omitempty"`
+	// PaidTime is the time the payment was marked as paid at
+	PaidTime *time.Time `json:"paidTime,omitempty"`
+	// PaymentLinkID is the ID of the payment link the payment was created from, if any
+	PaymentLinkID int `json:"-"`
+	// InvoiceID is the ID of the invoice the payment was created for, if any
+	InvoiceID int `json:"-"`
+	StoreID   int `json:"-"`
+	// History is the status history of the payment. It is only fetched for single payments.
+	History []*StatusChange `json:"history,omitempty"`
+	processor.PaymentMetadata
+
+	confirmationTiers processor.ConfirmationTiers
 }
 
 // HasValidCurrency returns whether the currency of Payment is supported by CoinGecko API or not
 func (p *Payment) HasValidCurrency() bool {
-	currency := strings.ToLower(p.Currency)
+	return IsSupportedCurrency(p.Currency)
+}
+
+// IsSupportedCurrency returns whether payments can be created in currency, i.e. it is either DERO or supported by CoinGecko API
+func IsSupportedCurrency(currency string) bool {
+	currency = strings.ToLower(currency)
 
 	if currency == "dero" {
 		return true
 	}
 
-	// Check if currency is in cached set of supported currencies in Redis
-	supported, _ := redis.IsSupportedCurrency(currency)
+	// Check if currency is in cached set of supported currencies
+	supported, _ := cache.Default.IsSupportedCurrency(currency)
 	if supported {
 		return true
 	}
@@ -56,8 +89,8 @@ func (p *Payment) HasValidCurrency() bool {
 		return false
 	}
 
-	// Update set in Redis
-	go redis.SetSupportedCurrencies(currencies)
+	// Update set in cache
+	go cache.Default.SetSupportedCurrencies(currencies)
 
 	// Check if currency is supported
 	for _, c := range currencies {
@@ -71,38 +104,20 @@ func (p *Payment) HasValidCurrency() bool {
 
 // HasValidCurrencyAmount checks if the amount of currency of Payment is a positive number
 func (p *Payment) HasValidCurrencyAmount() bool {
-	return p.CurrencyAmount > 0
-}
-
-func isUniqueIntegratedAddress(iaddr, payid string) (bool, error) {
-	var existingPaymentID string
-	err := postgres.DB.QueryRow(`
-			SELECT payment_id
-			FROM payments
-			WHERE payment_id=$1 OR integrated_address=$2`, payid, iaddr).
-		Scan(&existingPaymentID)
-	if err != nil {
-		if err == sql.ErrNoRows { // Integrated Address and PaymentID are unique
-			return true, nil
-		}
-
-		return false, errors.Wrap(err, "cannot query database")
-	}
-
-	return false, nil
+	return p.CurrencyAmount.IsPositive()
 }
 
 // GenerateUniqueIntegratedAddress returns an integrated address and its payment ID, that have never been used for any other payments before
-func GenerateUniqueIntegratedAddress(w *processor.StoreWallet) (iaddr, payid string, err error) {
+func GenerateUniqueIntegratedAddress(payments repository.PaymentRepository, w *processor.StoreWallet) (iaddr, payid string, err error) {
 	for {
 		iaddr, payid = w.GenerateIntegratedAddress()
 
-		isUnique, err := isUniqueIntegratedAddress(iaddr, payid)
+		used, err := payments.UsedIdentifiers([]string{payid}, []string{iaddr})
 		if err != nil {
 			return "", "", errors.Wrap(err, "cannot check if generated integrated address is unique")
 		}
 
-		if isUnique {
+		if len(used) == 0 {
 			break
 		}
 	}
@@ -113,7 +128,7 @@ func GenerateUniqueIntegratedAddress(w *processor.StoreWallet) (iaddr, payid str
 // CalculateTTL calculates and updates Payment TTL based on the number of minutes passed from the creation of the payment
 func (p *Payment) CalculateTTL(minsFromCreation int) {
 	if p.Status == processor.PaymentStatusPending {
-		p.TTL = config.PaymentMaxTTL - minsFromCreation
+		p.TTL = p.MaxTTL - minsFromCreation
 		if p.TTL < 0 {
 			p.TTL = 0
 		}
@@ -123,47 +138,51 @@ func (p *Payment) CalculateTTL(minsFromCreation int) {
 // CreateNewPayment errors
 var (
 	ErrInvalidCurrency = errors.New("Invalid Param 'currency': required 3-4 chars long string")
-	ErrInvalidAmount   = errors.New("Invalid Param 'amount': required .12f float")
+	ErrInvalidAmount   = errors.New("Invalid Param 'amount': required positive decimal number or string")
 )
 
+// PaymentOptions represents the optional params of a new payment
+type PaymentOptions struct {
+	// TTL and MinConfirmations override the default payment requirements of the store if not nil
+	TTL              *int
+	MinConfirmations *int
+	Metadata         processor.PaymentMetadata
+	// SuccessURL and CancelURL are optional absolute URLs (https only in production)
+	SuccessURL string
+	CancelURL  string
+	// PaymentLinkID is the ID of the payment link of the store the payment is created from (0 if none)
+	PaymentLinkID int
+	// InvoiceID is the ID of the invoice of the store the payment is created for (0 if none)
+	InvoiceID int
+}
+
 // CreateNewPayment returns a new Payment ready to be stored in DB and be listened to by processor
-func CreateNewPayment(currency string, currencyAmount float64, storeID int) (p *Payment, w *processor.StoreWallet, errCode int, err error) {
-	p = &Payment{
-		Status:  processor.PaymentStatusPending,
-		TTL:     config.PaymentMaxTTL,
-		StoreID: storeID,
+func CreateNewPayment(stores repository.StoreRepository, payments repository.PaymentRepository, currency string, currencyAmount decimal.Decimal, opts PaymentOptions, storeID int) (p *Payment, w *processor.StoreWallet, errCode int, err error) {
+	p, errCode, err = newPayment(currency, currencyAmount, opts, storeID)
+	if err != nil {
+		return nil, nil, errCode, err
 	}
 
-	// Validate params
-	p.Currency = strings.ToUpper(currency)
-	if !p.HasValidCurrency() {
-		return nil, nil, http.StatusUnprocessableEntity, ErrInvalidCurrency
+	// Fetch default payment requirements of the store and override them with the requested ones
+	storeRequirements, err := FetchPaymentRequirements(stores, p.StoreID)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch payment requirements")
 	}
-	p.CurrencyAmount = currencyAmount
-	if !p.HasValidCurrencyAmount() {
-		return nil, nil, http.StatusUnprocessableEntity, ErrInvalidAmount
+	err = p.applyRequirements(opts, *storeRequirements)
+	if err != nil {
+		return nil, nil, http.StatusUnprocessableEntity, err
 	}
 
-	if p.Currency == "DERO" {
-		p.ExchangeRate = 1
-		p.DeroAmount = fmt.Sprintf("%.12f", p.CurrencyAmount)
-	} else {
-		// Get current exchange rate from CoinGecko API
-		exchangeRate, err := coingecko.DeroPrice(p.Currency) // DERO value in payment currency. 1 DERO = x CURRENCY. Exchange Rate = x CURRENCY
-		if err != nil {
-			return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot get DERO price")
-		}
-
-		// Convert amount of currency to DERO
-		p.ExchangeRate = exchangeRate
-		deroAmount := p.CurrencyAmount / exchangeRate // 1 DERO : Exchange Rate = Dero Amount : Currency Amount => Dero Amount = 1 * Currency Amount / Exchange Rate
-		p.DeroAmount = fmt.Sprintf("%.12f", deroAmount)
+	// Fetch pricing policy of the store
+	pp, err := FetchPricingPolicy(stores, p.StoreID)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch pricing policy")
 	}
+	p.PricingPolicy = *pp
 
-	// Convert amount of DERO to atomic DERO
-	p.AtomicDeroAmount, err = deroglobals.ParseAmount(p.DeroAmount)
+	errCode, err = p.quote()
 	if err != nil {
-		return nil, nil, http.StatusUnprocessableEntity, ErrInvalidAmount
+		return nil, nil, errCode, err
 	}
 
 	w, err = processor.ActiveWallets.GetWalletFromStoreID(p.StoreID)
@@ -176,7 +195,7 @@ func CreateNewPayment(currency string, currencyAmount float64, storeID int) (p *
 		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "daemon offline")
 	}
 
-	p.IntegratedAddress, p.PaymentID, err = GenerateUniqueIntegratedAddress(w)
+	p.IntegratedAddress, p.PaymentID, err = GenerateUniqueIntegratedAddress(payments, w)
 	if err != nil {
 		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot generate unique integrated address")
 	}
@@ -184,20 +203,136 @@ func CreateNewPayment(currency string, currencyAmount float64, storeID int) (p *
 	return
 }
 
-// Insert inserts a Payment into DB
-func (p *Payment) Insert() error {
-	err := postgres.DB.QueryRow(`
-		INSERT INTO payments (payment_id, status, currency, currency_amount, exchange_rate, dero_amount, atomic_dero_amount, integrated_address, store_id) 
-		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) 
-		RETURNING creation_time`, p.PaymentID, p.Status, p.Currency, p.CurrencyAmount, p.ExchangeRate, p.DeroAmount, p.AtomicDeroAmount, p.IntegratedAddress, p.StoreID).
-		Scan(&p.CreationTime)
+// newPayment returns a new pending Payment of a store whose params have been validated, but not yet quoted
+func newPayment(currency string, currencyAmount decimal.Decimal, opts PaymentOptions, storeID int) (p *Payment, errCode int, err error) {
+	p = &Payment{
+		Status:  processor.PaymentStatusPending,
+		StoreID: storeID,
+	}
+
+	p.Currency = strings.ToUpper(currency)
+	if !p.HasValidCurrency() {
+		return nil, http.StatusUnprocessableEntity, ErrInvalidCurrency
+	}
+	p.CurrencyAmount = RoundCurrencyAmount(currencyAmount, p.Currency)
+	if !p.HasValidCurrencyAmount() {
+		return nil, http.StatusUnprocessableEntity, ErrInvalidAmount
+	}
+	p.PaymentMetadata = opts.Metadata
+	err = SanitizePaymentMetadata(&p.PaymentMetadata)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	err = opts.SanitizeRedirectURLs()
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	p.SuccessURL, p.CancelURL = opts.SuccessURL, opts.CancelURL
+	p.PaymentLinkID = opts.PaymentLinkID
+	p.InvoiceID = opts.InvoiceID
+
+	return p, 0, nil
+}
+
+// applyRequirements sets the requirements of Payment to the default ones of its store, overridden by the requested ones
+func (p *Payment) applyRequirements(opts PaymentOptions, storeRequirements PaymentRequirements) error {
+	r, err := NewPaymentRequirements(opts.TTL, opts.MinConfirmations, storeRequirements)
+	if err != nil {
+		return err
+	}
+	p.MaxTTL, p.MinConfirmations = r.TTL, r.MinConfirmations
+	p.TTL = p.MaxTTL
+	p.confirmationTiers = r.ConfirmationTiers.processorTiers()
+
+	return nil
+}
+
+// exchangeRate returns the value of 1 DERO in currency
+func exchangeRate(currency string) (decimal.Decimal, error) {
+	if currency == "DERO" {
+		return decimal.NewFromInt(1), nil
+	}
+
+	// Get current exchange rate from CoinGecko API
+	rate, err := coingecko.DeroPrice(currency) // DERO value in payment currency. 1 DERO = x CURRENCY. Exchange Rate = x CURRENCY
+	if err != nil {
+		return decimal.Zero, errors.Wrap(err, "cannot get DERO price")
+	}
+
+	return rate, nil
+}
+
+// quote sets the exchange rate and the amounts of DERO due for the amount of currency of Payment,
+// given its pricing policy and confirmation tiers
+func (p *Payment) quote() (errCode int, err error) {
+	rate, err := exchangeRate(p.Currency)
 	if err != nil {
-		return errors.Wrap(err, "cannot query database")
+		return http.StatusInternalServerError, err
+	}
+
+	return p.quoteAt(rate)
+}
+
+// quoteAt is like quote, but uses the given exchange rate instead of fetching the current one
+func (p *Payment) quoteAt(rate decimal.Decimal) (errCode int, err error) {
+	p.ExchangeRate = rate
+
+	// Apply rate markup (only to currencies other than DERO, since DERO payments are not affected by exchange rate volatility)
+	amountDue := p.CurrencyAmount
+	if p.Currency != "DERO" {
+		amountDue = p.PricingPolicy.ApplyRateMarkup(amountDue)
 	}
 
+	// Convert amount of currency to atomic DERO.
+	// 1 DERO : Exchange Rate = Dero Amount : Currency Amount => Dero Amount = 1 * Currency Amount / Exchange Rate
+	p.AtomicDeroAmount, err = ConvertToAtomicDero(amountDue, p.ExchangeRate)
+	if err == nil {
+		p.AtomicDeroAmount, err = p.PricingPolicy.RoundAtomicDeroAmount(p.AtomicDeroAmount)
+	}
+	if err != nil {
+		if err == ErrInvalidExchangeRate {
+			return http.StatusInternalServerError, errors.Wrap(err, "cannot convert currency amount to DERO")
+		}
+
+		return http.StatusUnprocessableEntity, ErrInvalidAmount
+	}
+	p.DeroAmount = FormatAtomicDero(p.AtomicDeroAmount)
+	p.MinAtomicDeroAmount = p.PricingPolicy.MinAtomicDeroAmount(p.AtomicDeroAmount)
+	p.RequiredConfirmations = p.PendingPayment().RequiredConfirmations()
+
+	return 0, nil
+}
+
+// PendingPayment returns the PendingPayment the wallet of the store has to listen to in order to process Payment
+func (p *Payment) PendingPayment() *processor.PendingPayment {
+	pp := processor.NewPendingPayment(p.AtomicDeroAmount, p.MinAtomicDeroAmount, p.MaxTTL, p.MinConfirmations)
+	pp.ConfirmationTiers = p.confirmationTiers
+	pp.PaymentMetadata = p.PaymentMetadata
+	return pp
+}
+
+// Insert inserts a Payment into DB and notifies the dashboard sessions of its store
+func (p *Payment) Insert(payments repository.PaymentRepository) error {
+	r := p.record()
+	err := payments.Insert(r, processor.StatusCauseCreated)
+	if err != nil {
+		return err
+	}
+	p.CreationTime = r.CreationTime
+
+	p.notifyCreated()
 	return nil
 }
 
+// notifyCreated sends the creation of Payment to the dashboard sessions of its store
+func (p *Payment) notifyCreated() {
+	go processor.StoreWSConnections.SendEvent(p.StoreID, &processor.DashboardEvent{
+		Type:      processor.EventPaymentCreated,
+		PaymentID: p.PaymentID,
+		Status:    p.Status,
+	})
+}
+
 // Payment(s) not found errors
 var (
 	ErrPaymentNotFound     = errors.New("Payment not found")
@@ -206,81 +341,134 @@ var (
 	ErrNoPaymentsFoundPage = errors.New("No payments found on this page")
 )
 
-// FetchPaymentFromID returns a Payment fetched from DB based on its Payment ID
-func FetchPaymentFromID(paymentID string, storeID int) (p *Payment, errCode int, err error) {
-	p = &Payment{
-		PaymentID: paymentID,
-		StoreID:   storeID,
+// paymentFromRecord returns the Payment stored as r, with its TTL calculated
+func paymentFromRecord(r *repository.Payment) *Payment {
+	p := &Payment{
+		PaymentID:           r.PaymentID,
+		Status:              r.Status,
+		Currency:            r.Currency,
+		CurrencyAmount:      r.CurrencyAmount,
+		ExchangeRate:        r.ExchangeRate,
+		DeroAmount:          r.DeroAmount,
+		AtomicDeroAmount:    r.AtomicDeroAmount,
+		MinAtomicDeroAmount: r.MinAtomicDeroAmount,
+		PricingPolicy: PricingPolicy{
+			RateMarkup:            r.RateMarkup,
+			UnderpaymentTolerance: r.UnderpaymentTolerance,
+			DeroDecimals:          r.DeroDecimals,
+		},
+		IntegratedAddress:     r.IntegratedAddress,
+		CreationTime:          r.CreationTime,
+		MaxTTL:                r.MaxTTL,
+		MinConfirmations:      r.MinConfirmations,
+		RequiredConfirmations: r.RequiredConfirmations,
+		RequotedFrom:          r.RequotedFrom,
+		RequotedTo:            r.RequotedTo,
+		SuccessURL:            r.SuccessURL,
+		CancelURL:             r.CancelURL,
+		TxIDs:                 r.TxIDs,
+		PaidTime:              r.PaidTime,
+		PaymentLinkID:         r.PaymentLinkID,
+		InvoiceID:             r.InvoiceID,
+		StoreID:               r.StoreID,
+		PaymentMetadata: processor.PaymentMetadata{
+			OrderID:       r.OrderID,
+			Description:   r.Description,
+			CustomerEmail: r.CustomerEmail,
+			Metadata:      r.Metadata,
+		},
 	}
+	p.CalculateTTL(r.MinsFromCreation)
 
-	var minsFromCreation int
-	err = postgres.DB.QueryRow(`
-		SELECT status, currency, currency_amount, exchange_rate, dero_amount, atomic_dero_amount, integrated_address, creation_time, CEIL(EXTRACT('epoch' FROM NOW() - creation_time) / 60) 
-		FROM payments 
-		WHERE payment_id=$1 AND store_id=$2`, p.PaymentID, p.StoreID).
-		Scan(&p.Status, &p.Currency, &p.CurrencyAmount, &p.ExchangeRate, &p.DeroAmount, &p.AtomicDeroAmount, &p.IntegratedAddress, &p.CreationTime, &minsFromCreation)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, http.StatusNotFound, ErrPaymentNotFound
-		}
+	return p
+}
 
-		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot query database")
+// paymentsFromRecords returns the Payments stored as rs
+func paymentsFromRecords(rs []*repository.Payment) []*Payment {
+	ps := make([]*Payment, len(rs))
+	for i, r := range rs {
+		ps[i] = paymentFromRecord(r)
 	}
-
-	p.CalculateTTL(minsFromCreation)
-
-	return
+	return ps
 }
 
-// FetchPaymentsFromIDs returns a slice of Payments fetched from DB based on their Payment IDs
-func FetchPaymentsFromIDs(paymentIDs []string, storeID int) (ps []*Payment, errCode int, err error) {
-	rows, err := postgres.DB.Query(`
-		SELECT payment_id, status, currency, currency_amount, exchange_rate, dero_amount, atomic_dero_amount, integrated_address, creation_time, CEIL(EXTRACT('epoch' FROM NOW() - creation_time) / 60) 
-		FROM payments
-		WHERE store_id=$1 AND payment_id = ANY($2)`, storeID, pq.Array(paymentIDs))
-	if err != nil {
-		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot query database")
+// record returns the record Payment is stored as
+func (p *Payment) record() *repository.Payment {
+	return &repository.Payment{
+		PaymentID:             p.PaymentID,
+		Status:                p.Status,
+		Currency:              p.Currency,
+		CurrencyAmount:        p.CurrencyAmount,
+		ExchangeRate:          p.ExchangeRate,
+		DeroAmount:            p.DeroAmount,
+		AtomicDeroAmount:      p.AtomicDeroAmount,
+		MinAtomicDeroAmount:   p.MinAtomicDeroAmount,
+		IntegratedAddress:     p.IntegratedAddress,
+		MaxTTL:                p.MaxTTL,
+		MinConfirmations:      p.MinConfirmations,
+		RequiredConfirmations: p.RequiredConfirmations,
+		TxIDs:                 p.TxIDs,
+		CreationTime:          p.CreationTime,
+		PaidTime:              p.PaidTime,
+		StoreID:               p.StoreID,
+		RateMarkup:            p.PricingPolicy.RateMarkup,
+		UnderpaymentTolerance: p.PricingPolicy.UnderpaymentTolerance,
+		DeroDecimals:          p.PricingPolicy.DeroDecimals,
+		OrderID:               p.OrderID,
+		Description:           p.Description,
+		CustomerEmail:         p.CustomerEmail,
+		Metadata:              p.Metadata,
+		RequotedFrom:          p.RequotedFrom,
+		RequotedTo:            p.RequotedTo,
+		SuccessURL:            p.SuccessURL,
+		CancelURL:             p.CancelURL,
+		PaymentLinkID:         p.PaymentLinkID,
+		InvoiceID:             p.InvoiceID,
 	}
+}
 
-	defer rows.Close()
-
-	var minsFromCreation int
-	for rows.Next() {
-		var p Payment
-		err := rows.Scan(&p.PaymentID, &p.Status, &p.Currency, &p.CurrencyAmount, &p.ExchangeRate, &p.DeroAmount, &p.AtomicDeroAmount, &p.IntegratedAddress, &p.CreationTime, &minsFromCreation)
-		if err != nil {
-			continue
+// FetchPaymentFromID returns a Payment of a store fetched from DB based on its Payment ID
+func FetchPaymentFromID(payments repository.PaymentRepository, paymentID string, storeID int) (p *Payment, errCode int, err error) {
+	r, err := payments.ByID(paymentID)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, http.StatusNotFound, ErrPaymentNotFound
 		}
 
-		p.CalculateTTL(minsFromCreation)
-
-		ps = append(ps, &p)
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch payment")
+	}
+	if r.StoreID != storeID {
+		return nil, http.StatusNotFound, ErrPaymentNotFound
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot iterate over rows")
+	return paymentFromRecord(r), 0, nil
+}
+
+// FetchPaymentsFromIDs returns a slice of Payments fetched from DB based on their Payment IDs
+func FetchPaymentsFromIDs(payments repository.PaymentRepository, paymentIDs []string, storeID int) (ps []*Payment, errCode int, err error) {
+	rs, err := payments.ByIDs(storeID, paymentIDs)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch payments")
 	}
 
-	if len(ps) == 0 {
+	if len(rs) == 0 {
 		return nil, http.StatusNotFound, ErrPaymentsNotFound
 	}
 
-	return
+	return paymentsFromRecords(rs), 0, nil
 }
 
 // FetchFilteredPayments returns a slice of Payments fetched from DB based on given filters
-func FetchFilteredPayments(storeID, limit, page int, sortBy, orderBy, statusFilter, currencyFilter string) (ps []*Payment, totalPayments, totalPages, errCode int, err error) {
+func FetchFilteredPayments(payments repository.PaymentRepository, storeID, limit, page int, sortBy, orderBy string, filters PaymentFilters) (ps []*Payment, totalPayments, totalPages, errCode int, err error) {
 	// Note: Input comes already sanitized from caller function GetPaymentsFromStoreID.
 
+	filters.StoreID = storeID
+
 	// Fetch total number of filtered payments from DB
-	err = postgres.DB.QueryRow(`
-		SELECT COUNT(*)
-		FROM payments
-		WHERE store_id=$1 AND ($2='' OR status=LOWER($2)) AND ($3='' OR currency=UPPER($3))`, storeID, statusFilter, currencyFilter).
-		Scan(&totalPayments)
+	totalPayments, err = payments.Count(&filters)
 	if err != nil {
 		errCode = http.StatusInternalServerError
-		err = errors.Wrap(err, "cannot query database")
+		err = errors.Wrap(err, "cannot count payments")
 		return
 	}
 
@@ -290,17 +478,15 @@ func FetchFilteredPayments(storeID, limit, page int, sortBy, orderBy, statusFilt
 		return
 	}
 
-	baseQuery := `
-		SELECT payment_id, status, currency, currency_amount, exchange_rate, dero_amount, atomic_dero_amount, integrated_address, creation_time, CEIL(EXTRACT('epoch' FROM NOW() - creation_time) / 60) 
-		FROM payments 
-		WHERE store_id=$1 AND ($2='' OR status=LOWER($2)) AND ($3='' OR currency=UPPER($3)) 
-	`
-	orderByQuery := fmt.Sprintf(`ORDER BY %s %s `, sortBy, orderBy) // SQL Injection safe because params were previously validated. Could not use named parameters.
-	limitQuery := ""
+	q := &repository.PaymentQuery{
+		PaymentFilter: filters,
+		SortBy:        sortBy,
+		OrderBy:       orderBy,
+	}
 
 	if limit > 0 {
-		offset := (page - 1) * limit
-		limitQuery = fmt.Sprintf(`LIMIT %d OFFSET %d `, limit, offset)
+		q.Limit = limit
+		q.Offset = (page - 1) * limit
 
 		totalPages = int(math.Ceil(float64(totalPayments) / float64(limit)))
 	} else {
@@ -314,36 +500,12 @@ func FetchFilteredPayments(storeID, limit, page int, sortBy, orderBy, statusFilt
 	}
 
 	// Fetch filtered payments from DB
-	query := stringutil.Build(baseQuery, orderByQuery, limitQuery)
-	rows, err := postgres.DB.Query(query, storeID, statusFilter, currencyFilter)
+	rs, err := payments.Find(q)
 	if err != nil {
 		errCode = http.StatusInternalServerError
-		err = errors.Wrap(err, "cannot query database")
-		return
-	}
-
-	defer rows.Close()
-
-	var minsFromCreation int
-	for rows.Next() {
-		var p Payment
-		err = rows.Scan(&p.PaymentID, &p.Status, &p.Currency, &p.CurrencyAmount, &p.ExchangeRate, &p.DeroAmount, &p.AtomicDeroAmount, &p.IntegratedAddress, &p.CreationTime, &minsFromCreation)
-		if err != nil {
-			errCode = http.StatusInternalServerError
-			err = errors.Wrap(err, "cannot scan row")
-			return
-		}
-
-		p.CalculateTTL(minsFromCreation)
-
-		ps = append(ps, &p)
-	}
-
-	if err = rows.Err(); err != nil {
-		errCode = http.StatusInternalServerError
-		err = errors.Wrap(err, "cannot iterate over rows")
+		err = errors.Wrap(err, "cannot fetch payments")
 		return
 	}
 
-	return
+	return paymentsFromRecords(rs), totalPayments, totalPages, 0, nil
 }
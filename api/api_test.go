@@ -1,17 +1,27 @@
 package api
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/alexedwards/argon2id"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/config"
-	"github.com/peppinux/dero-merchant/postgres"
+	"github.com/peppinux/dero-merchant/export"
 	"github.com/peppinux/dero-merchant/processor"
-	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/repository"
 	"github.com/peppinux/dero-merchant/stringutil"
 )
 
@@ -41,6 +51,7 @@ type APITestSuite struct {
 
 	mockUser  *UserMock
 	mockStore *StoreMock
+	repos     *repository.Repositories
 }
 
 func (suite *APITestSuite) SetupSuite() {
@@ -49,24 +60,9 @@ func (suite *APITestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	redis.Pool = redis.NewPool(config.TestRedisAddress)
-	err = redis.Ping()
-	if err != nil {
-		panic(err)
-	}
-
-	err = redis.FlushAll()
-	if err != nil {
-		panic(err)
-	}
+	cache.Default = cache.NewMemoryCache()
 
-	postgres.DB, err = postgres.Connect(config.TestDBName, config.TestDBUser, config.TestDBPassword, config.TestDBHost, config.TestDBPort, "disable") // TODO: Enable SSLMode?
-	if err != nil {
-		panic(err)
-	}
-
-	postgres.DropTables()
-	postgres.CreateTablesIfNotExist()
+	suite.repos = repository.NewMemory()
 
 	suite.mockUser = &UserMock{
 		Username: "Test user",
@@ -94,11 +90,14 @@ func (suite *APITestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	err = postgres.DB.QueryRow(`
-		INSERT INTO users (username, email, password, verification_token, email_verified)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`, u.Username, u.Email, u.HashedPassword, u.VerificationToken, u.Verified).
-		Scan(&u.ID)
+	user := &repository.User{Username: u.Username, Email: u.Email, Password: u.HashedPassword}
+	err = suite.repos.Users.Insert(user, u.VerificationToken)
+	if err != nil {
+		panic(err)
+	}
+	u.ID = user.ID
+
+	err = suite.repos.Users.VerifyEmail(u.ID)
 	if err != nil {
 		panic(err)
 	}
@@ -120,18 +119,16 @@ func (suite *APITestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	err = postgres.DB.QueryRow(`
-		INSERT INTO stores (title, wallet_view_key, webhook, webhook_secret_key, api_key, secret_key, owner_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id`, s.Title, s.ViewKey, s.Webhook, s.WebhookSecretKey, s.APIKey, s.SecretKey, s.OwnerID).
-		Scan(&s.ID)
+	store := &repository.Store{Title: s.Title, WalletViewKey: s.ViewKey, Webhook: s.Webhook, WebhookSecretKey: s.WebhookSecretKey, APIKey: s.APIKey, SecretKey: s.SecretKey, OwnerID: s.OwnerID}
+	err = suite.repos.Stores.Insert(store)
 	if err != nil {
 		panic(err)
 	}
+	s.ID = store.ID
 
 	config.DeroNetwork = config.TestDeroNetwork
 	config.DeroDaemonAddress = config.TestDeroDaemonAddress
-	processor.ActiveWallets = processor.NewStoresWallets()
+	processor.ActiveWallets = processor.NewStoresWallets(suite.repos.Stores, suite.repos.Payments, suite.repos.Invoices)
 	err = processor.SetupDaemonConnection()
 	if err != nil {
 		panic(err)
@@ -145,53 +142,23 @@ func (suite *APITestSuite) SetupSuite() {
 }
 
 func (suite *APITestSuite) TearDownSuite() {
-	redis.FlushAll()
-	redis.Pool.Close()
-
-	postgres.DropTables()
-	postgres.DB.Close()
-
 	os.RemoveAll(config.TestWalletsPath)
 }
 
-func TestAPITestSuite(t *testing.T) {
-	suite.Run(t, new(APITestSuite))
-}
-
-func (suite *APITestSuite) TestHasValidCurrency() {
-	testPayments := map[*Payment]bool{
-		&Payment{Currency: "DERO"}: true,
-		&Payment{Currency: "usd"}:  true,
-		&Payment{Currency: "Eur"}:  true,
-		&Payment{Currency: "ABC"}:  false,
-		&Payment{Currency: "xyz"}:  false,
-	}
+// newMockStore inserts a new store owned by the mock user, so that tests can work on its payments in isolation
+func (suite *APITestSuite) newMockStore(title string) int {
+	webhookSecretKey, _ := stringutil.RandomHexString(32)
+	apiKey, _ := stringutil.RandomHexString(32)
+	secretKey, _ := stringutil.RandomHexString(32)
 
-	for p, shouldValid := range testPayments {
-		isValid := p.HasValidCurrency()
-		suite.Equal(shouldValid, isValid)
+	s := &repository.Store{Title: title, WalletViewKey: suite.mockStore.ViewKey, WebhookSecretKey: webhookSecretKey, APIKey: apiKey, SecretKey: secretKey, OwnerID: suite.mockUser.ID}
+	suite.Require().Nil(suite.repos.Stores.Insert(s))
 
-		// Test is so quick the goroutine the caches currencies in Redis does not finish execution.
-		// This is the reason why line 50 of api.go is not cover.
-		// To cover it in spite of testing speed uncomment the following line:
-		// time.Sleep(time.Millisecond * 100)
-	}
+	return s.ID
 }
 
-func (suite *APITestSuite) TestHasValidCurrencyAmount() {
-	testPayments := map[*Payment]bool{
-		&Payment{CurrencyAmount: 1}:        true,
-		&Payment{CurrencyAmount: 0.1}:      true,
-		&Payment{CurrencyAmount: 1234.567}: true,
-		&Payment{CurrencyAmount: 0}:        false,
-		&Payment{CurrencyAmount: -0.1}:     false,
-		&Payment{CurrencyAmount: -100}:     false,
-	}
-
-	for p, shouldValid := range testPayments {
-		isValid := p.HasValidCurrencyAmount()
-		suite.Equal(shouldValid, isValid)
-	}
+func TestAPITestSuite(t *testing.T) {
+	suite.Run(t, new(APITestSuite))
 }
 
 func (suite *APITestSuite) TestGenerateUniqueIntegratedAddress() {
@@ -201,7 +168,7 @@ func (suite *APITestSuite) TestGenerateUniqueIntegratedAddress() {
 	generatedAddresses := make([]string, count)
 
 	for i := 0; i < count; i++ {
-		iaddr, _, err := GenerateUniqueIntegratedAddress(w)
+		iaddr, _, err := GenerateUniqueIntegratedAddress(suite.repos.Payments, w)
 		suite.Nil(err)
 		suite.NotContains(generatedAddresses, iaddr)
 
@@ -209,46 +176,32 @@ func (suite *APITestSuite) TestGenerateUniqueIntegratedAddress() {
 	}
 }
 
-func (suite *APITestSuite) TestCalculateTTL() {
-	oldMaxTTL := config.PaymentMaxTTL
-
-	config.PaymentMaxTTL = 60
-
-	test := []struct {
-		Payment          *Payment
-		MinsFromCreation int
-		ExpectedTTL      int
-	}{
-		{Payment: &Payment{Status: processor.PaymentStatusPending}, MinsFromCreation: 0, ExpectedTTL: 60 - 0},
-		{Payment: &Payment{Status: processor.PaymentStatusPending}, MinsFromCreation: 1, ExpectedTTL: 60 - 1},
-		{Payment: &Payment{Status: processor.PaymentStatusPending}, MinsFromCreation: 20, ExpectedTTL: 60 - 20},
-		{Payment: &Payment{Status: processor.PaymentStatusPending}, MinsFromCreation: 60, ExpectedTTL: 0},
-		{Payment: &Payment{Status: processor.PaymentStatusPending}, MinsFromCreation: 100, ExpectedTTL: 0},
-		{Payment: &Payment{Status: processor.PaymentStatusPaid}, MinsFromCreation: 1000, ExpectedTTL: 0},
-	}
-
-	for _, t := range test {
-		t.Payment.CalculateTTL(t.MinsFromCreation)
-		suite.Equal(t.ExpectedTTL, t.Payment.TTL)
-	}
-
-	config.PaymentMaxTTL = oldMaxTTL
-}
-
 func (suite *APITestSuite) TestPayments() {
+	intPtr := func(i int) *int { return &i }
+
 	testPayments := []struct {
 		Currency       string
-		CurrencyAmount float64
+		CurrencyAmount decimal.Decimal
+		Options        PaymentOptions
 		StoreID        int
 
 		Payment         *Payment
 		ExpectedErrCode int
 		ExpectedErr     error
 	}{
-		{Currency: "DERO", CurrencyAmount: 50, StoreID: suite.mockStore.ID, ExpectedErrCode: 0, ExpectedErr: nil},
-		{Currency: "EUR", CurrencyAmount: 10, StoreID: suite.mockStore.ID, ExpectedErrCode: 0, ExpectedErr: nil},
-		{Currency: "ABC", CurrencyAmount: 10, StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidCurrency},
-		{Currency: "USD", CurrencyAmount: 0, StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidAmount},
+		{Currency: "DERO", CurrencyAmount: decimal.NewFromInt(50), StoreID: suite.mockStore.ID, ExpectedErrCode: 0, ExpectedErr: nil},
+		{Currency: "EUR", CurrencyAmount: decimal.NewFromInt(10), StoreID: suite.mockStore.ID, ExpectedErrCode: 0, ExpectedErr: nil},
+		{Currency: "ABC", CurrencyAmount: decimal.NewFromInt(10), StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidCurrency},
+		{Currency: "USD", CurrencyAmount: decimal.Zero, StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidAmount},
+		{Currency: "JPY", CurrencyAmount: decimal.RequireFromString("0.4"), StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidAmount}, // Rounded to 0 JPY
+		{Currency: "DERO", CurrencyAmount: decimal.NewFromInt(1), Options: PaymentOptions{TTL: intPtr(1), MinConfirmations: intPtr(config.PaymentMinConfirmations)}, StoreID: suite.mockStore.ID, ExpectedErrCode: 0, ExpectedErr: nil},
+		{Currency: "DERO", CurrencyAmount: decimal.NewFromInt(1), Options: PaymentOptions{TTL: intPtr(config.PaymentMaxTTL + 1)}, StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidTTL},
+		{Currency: "DERO", CurrencyAmount: decimal.NewFromInt(1), Options: PaymentOptions{MinConfirmations: intPtr(config.PaymentMaxConfirmations + 1)}, StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidMinConfirmations},
+		{Currency: "DERO", CurrencyAmount: decimal.NewFromInt(1), Options: PaymentOptions{Metadata: processor.PaymentMetadata{OrderID: "1234", Description: "Order #1234", CustomerEmail: "customer@example.com", Metadata: json.RawMessage(`{"sku":"abc"}`)}}, StoreID: suite.mockStore.ID, ExpectedErrCode: 0, ExpectedErr: nil},
+		{Currency: "DERO", CurrencyAmount: decimal.NewFromInt(1), Options: PaymentOptions{Metadata: processor.PaymentMetadata{Metadata: json.RawMessage(`"abc"`)}}, StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidMetadata},
+		{Currency: "DERO", CurrencyAmount: decimal.NewFromInt(1), Options: PaymentOptions{SuccessURL: "https://example.com/success", CancelURL: "https://example.com/cancel"}, StoreID: suite.mockStore.ID, ExpectedErrCode: 0, ExpectedErr: nil},
+		{Currency: "DERO", CurrencyAmount: decimal.NewFromInt(1), Options: PaymentOptions{SuccessURL: "example.com/success"}, StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidSuccessURL},
+		{Currency: "DERO", CurrencyAmount: decimal.NewFromInt(1), Options: PaymentOptions{CancelURL: "ftp://example.com/cancel"}, StoreID: suite.mockStore.ID, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: ErrInvalidCancelURL},
 	}
 
 	var (
@@ -259,7 +212,7 @@ func (suite *APITestSuite) TestPayments() {
 	)
 	for _, p := range testPayments {
 		// Test CreateNewPayment
-		p.Payment, _, errCode, err = CreateNewPayment(p.Currency, p.CurrencyAmount, p.StoreID)
+		p.Payment, _, errCode, err = CreateNewPayment(suite.repos.Stores, suite.repos.Payments, p.Currency, p.CurrencyAmount, p.Options, p.StoreID)
 		suite.Equal(p.ExpectedErrCode, errCode)
 		suite.Equal(p.ExpectedErr, err)
 
@@ -267,46 +220,101 @@ func (suite *APITestSuite) TestPayments() {
 			validPaymentIDs = append(validPaymentIDs, p.Payment.PaymentID)
 
 			// Test Insert
-			err = p.Payment.Insert()
+			err = p.Payment.Insert(suite.repos.Payments)
 			suite.Nil(err)
 			suite.NotZero(p.Payment.CreationTime)
 
 			// Test FetchPaymentFromID
-			_, errCode, err = FetchPaymentFromID(p.Payment.PaymentID, p.Payment.StoreID)
+			fetched, errCode, err := FetchPaymentFromID(suite.repos.Payments, p.Payment.PaymentID, p.Payment.StoreID)
 			suite.Zero(errCode)
 			suite.Nil(err)
+			suite.Equal(p.Payment.MaxTTL, fetched.MaxTTL)
+			suite.Equal(p.Payment.MinConfirmations, fetched.MinConfirmations)
+			suite.Equal(p.Payment.OrderID, fetched.OrderID)
+			suite.Equal(p.Payment.Description, fetched.Description)
+			suite.Equal(p.Payment.CustomerEmail, fetched.CustomerEmail)
+			suite.Equal(len(p.Payment.Metadata) == 0, len(fetched.Metadata) == 0)
+			suite.Equal(p.Payment.SuccessURL, fetched.SuccessURL)
+			suite.Equal(p.Payment.CancelURL, fetched.CancelURL)
 		}
 	}
 
 	// Test FetchPaymentFromID (payment not found)
 	invalidPaymentID, _ := stringutil.RandomHexString(32)
-	_, errCode, err = FetchPaymentFromID(invalidPaymentID, suite.mockStore.ID)
+	_, errCode, err = FetchPaymentFromID(suite.repos.Payments, invalidPaymentID, suite.mockStore.ID)
 	suite.Equal(http.StatusNotFound, errCode)
 	suite.Equal(ErrPaymentNotFound, err)
 
 	// Test FetchPaymentsFromIDs
-	_, errCode, err = FetchPaymentsFromIDs(validPaymentIDs, suite.mockStore.ID)
+	_, errCode, err = FetchPaymentsFromIDs(suite.repos.Payments, validPaymentIDs, suite.mockStore.ID)
 	suite.Zero(errCode)
 	suite.Nil(err)
 
 	// Test FetchPaymentsFromIDs (payments not found)
-	_, errCode, err = FetchPaymentsFromIDs([]string{invalidPaymentID}, suite.mockStore.ID)
+	_, errCode, err = FetchPaymentsFromIDs(suite.repos.Payments, []string{invalidPaymentID}, suite.mockStore.ID)
 	suite.Equal(http.StatusNotFound, errCode)
 	suite.Equal(ErrPaymentsNotFound, err)
 }
 
+func (suite *APITestSuite) TestCreateNewPayments() {
+	intPtr := func(i int) *int { return &i }
+
+	reqs := []BatchPaymentRequest{
+		{Index: 0, Currency: "DERO", Amount: decimal.NewFromInt(1)},
+		{Index: 2, Currency: "ABC", Amount: decimal.NewFromInt(1)},
+		{Index: 3, Currency: "DERO", Amount: decimal.NewFromInt(2), Options: PaymentOptions{TTL: intPtr(config.PaymentMaxTTL + 1)}},
+		{Index: 4, Currency: "DERO", Amount: decimal.NewFromInt(3), Options: PaymentOptions{Metadata: processor.PaymentMetadata{OrderID: "1234"}}},
+	}
+	results, w, errCode, err := CreateNewPayments(suite.repos.Stores, suite.repos.Payments, reqs, suite.mockStore.ID)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.NotNil(w)
+	suite.Len(results, len(reqs))
+
+	expectedErrs := []error{nil, ErrInvalidCurrency, ErrInvalidTTL, nil}
+	var paymentIDs []string
+	for i, r := range results {
+		suite.Equal(reqs[i].Index, r.Index)
+
+		if expectedErrs[i] != nil {
+			suite.Nil(r.Payment)
+			suite.Equal(http.StatusUnprocessableEntity, r.Error.Code)
+			suite.Equal(expectedErrs[i].Error(), r.Error.Message)
+			continue
+		}
+
+		suite.Nil(r.Error)
+		suite.NotZero(r.Payment.CreationTime)
+		suite.NotContains(paymentIDs, r.Payment.PaymentID)
+		paymentIDs = append(paymentIDs, r.Payment.PaymentID)
+	}
+
+	// Valid payments of the batch are inserted into DB
+	ps, errCode, err := FetchPaymentsFromIDs(suite.repos.Payments, paymentIDs, suite.mockStore.ID)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Len(ps, 2)
+
+	// Batch with no valid payments
+	results, w, errCode, err = CreateNewPayments(suite.repos.Stores, suite.repos.Payments, reqs[1:3], suite.mockStore.ID)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Nil(w)
+	suite.Len(results, 2)
+}
+
 func (suite *APITestSuite) TestFetchFilteredPayments() {
-	storeID := suite.mockStore.ID
+	storeID := suite.newMockStore("Filtered payments store")
 	mockPayments := []*Payment{}
-	addMockPayment := func(status string, currency string, amount float64) {
-		p, _, _, _ := CreateNewPayment(currency, amount, storeID)
+	addMockPayment := func(status string, currency string, amount int64) {
+		p, _, _, _ := CreateNewPayment(suite.repos.Stores, suite.repos.Payments, currency, decimal.NewFromInt(amount), PaymentOptions{}, storeID)
 		p.Status = status
-		p.Insert()
+		p.Insert(suite.repos.Payments)
 		mockPayments = append(mockPayments, p)
 	}
 
 	// Test fetching payments before adding any
-	payments, numPayments, numPages, errCode, err := FetchFilteredPayments(storeID, 0, 1, "creation_time", "desc", "", "")
+	payments, numPayments, numPages, errCode, err := FetchFilteredPayments(suite.repos.Payments, storeID, 0, 1, "creation_time", "desc", PaymentFilters{})
 	suite.Equal(http.StatusNotFound, errCode)
 	suite.Equal(ErrNoPaymentsFound, err)
 	suite.Equal(0, numPayments)
@@ -325,34 +333,34 @@ func (suite *APITestSuite) TestFetchFilteredPayments() {
 	addMockPayment("error", "EUR", 90)
 
 	// Test fetching payments one by one
-	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(storeID, 1, 1, "creation_time", "desc", "", "")
+	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 1, 1, "creation_time", "desc", PaymentFilters{})
 	suite.Zero(errCode)
 	suite.Nil(err)
 	suite.Equal(9, numPayments)
-	suite.Equal(9, numPages)                             // Because limit = 1
-	suite.Equal(float64(90), payments[0].CurrencyAmount) // Last added payment
+	suite.Equal(9, numPages)                                             // Because limit = 1
+	suite.True(decimal.NewFromInt(90).Equal(payments[0].CurrencyAmount)) // Last added payment
 
 	// Test fetching all payments
-	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(storeID, 0, 1, "creation_time", "desc", "", "")
+	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 0, 1, "creation_time", "desc", PaymentFilters{})
 	suite.Zero(errCode)
 	suite.Nil(err)
 	suite.Equal(9, numPayments)
-	suite.Equal(1, numPages)                             // Because no limit
-	suite.Equal(float64(90), payments[0].CurrencyAmount) // Last added payment
-	suite.Equal(float64(10), payments[8].CurrencyAmount) // First added payment
+	suite.Equal(1, numPages)                                             // Because no limit
+	suite.True(decimal.NewFromInt(90).Equal(payments[0].CurrencyAmount)) // Last added payment
+	suite.True(decimal.NewFromInt(10).Equal(payments[8].CurrencyAmount)) // First added payment
 
 	// Test fetching the 3rd page of the 9 payments divided in groups of 3
-	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(storeID, 3, 3, "creation_time", "desc", "", "")
+	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 3, 3, "creation_time", "desc", PaymentFilters{})
 	suite.Zero(errCode)
 	suite.Nil(err)
 	suite.Equal(9, numPayments)
 	suite.Equal(3, numPages)
-	suite.Equal(float64(30), payments[0].CurrencyAmount)
-	suite.Equal(float64(20), payments[1].CurrencyAmount)
-	suite.Equal(float64(10), payments[2].CurrencyAmount)
+	suite.True(decimal.NewFromInt(30).Equal(payments[0].CurrencyAmount))
+	suite.True(decimal.NewFromInt(20).Equal(payments[1].CurrencyAmount))
+	suite.True(decimal.NewFromInt(10).Equal(payments[2].CurrencyAmount))
 
 	// Test fetching the 5th page (out of range by 2) of the 9 payments divided in groups of 3
-	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(storeID, 3, 5, "creation_time", "desc", "", "")
+	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 3, 5, "creation_time", "desc", PaymentFilters{})
 	suite.Equal(http.StatusNotFound, errCode)
 	suite.Equal(ErrNoPaymentsFoundPage, err)
 	suite.Equal(9, numPayments)
@@ -360,10 +368,803 @@ func (suite *APITestSuite) TestFetchFilteredPayments() {
 	suite.Nil(payments)
 
 	// Test fetching the *only* the *first* *paid* payment in *USD*
-	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(storeID, 1, 1, "creation_time", "asc", "paid", "USD")
+	payments, numPayments, numPages, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 1, 1, "creation_time", "asc", PaymentFilters{Status: "paid", Currency: "USD"})
 	suite.Zero(errCode)
 	suite.Nil(err)
 	suite.Equal(1, numPayments)
 	suite.Equal(1, numPages)
-	suite.Equal(float64(50), payments[0].CurrencyAmount)
+	suite.True(decimal.NewFromInt(50).Equal(payments[0].CurrencyAmount))
+
+	// Test fetching payments by amount range
+	min, max := decimal.NewFromInt(60), decimal.NewFromInt(80)
+	payments, numPayments, _, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 0, 1, "currency_amount", "asc", PaymentFilters{MinAmount: &min, MaxAmount: &max})
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal(3, numPayments)
+	suite.True(decimal.NewFromInt(60).Equal(payments[0].CurrencyAmount))
+	suite.True(decimal.NewFromInt(80).Equal(payments[2].CurrencyAmount))
+
+	// Test fetching payments by creation time range
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1)
+	_, numPayments, _, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 0, 1, "creation_time", "desc", PaymentFilters{To: tomorrow})
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal(9, numPayments)
+	_, _, _, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 0, 1, "creation_time", "desc", PaymentFilters{From: tomorrow})
+	suite.Equal(http.StatusNotFound, errCode)
+	suite.Equal(ErrNoPaymentsFound, err)
+
+	// Test fetching a payment by integrated address
+	payments, numPayments, _, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 0, 1, "creation_time", "desc", PaymentFilters{IntegratedAddress: mockPayments[4].IntegratedAddress})
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal(1, numPayments)
+	suite.Equal(mockPayments[4].PaymentID, payments[0].PaymentID)
+
+	// Test fetching payments by order ID, description and metadata
+	opts := PaymentOptions{Metadata: processor.PaymentMetadata{OrderID: "ORDER_100%", Description: "Blue T-Shirt", Metadata: json.RawMessage(`{"sku":"abc","qty":2}`)}}
+	p, _, _, _ := CreateNewPayment(suite.repos.Stores, suite.repos.Payments, "DERO", decimal.NewFromInt(100), opts, storeID)
+	p.Insert(suite.repos.Payments)
+	for _, f := range []PaymentFilters{
+		{OrderID: "order_100"},
+		{OrderID: "_100%"},
+		{Description: "t-shirt"},
+		{Metadata: json.RawMessage(`{"sku":"abc"}`)},
+		{OrderID: "100", Description: "blue", Metadata: json.RawMessage(`{"qty":2}`)},
+	} {
+		payments, numPayments, _, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 0, 1, "creation_time", "desc", f)
+		suite.Zero(errCode)
+		suite.Nil(err)
+		suite.Equal(1, numPayments)
+		suite.Equal(p.PaymentID, payments[0].PaymentID)
+	}
+	for _, f := range []PaymentFilters{
+		{OrderID: "order-100"}, // '_' is not a wildcard
+		{Description: "red"},
+		{Metadata: json.RawMessage(`{"sku":"def"}`)},
+	} {
+		_, _, _, errCode, err = FetchFilteredPayments(suite.repos.Payments, storeID, 0, 1, "creation_time", "desc", f)
+		suite.Equal(http.StatusNotFound, errCode)
+		suite.Equal(ErrNoPaymentsFound, err)
+	}
+}
+
+func (suite *APITestSuite) TestFetchPaymentsAfterCursor() {
+	storeID := suite.newMockStore("Cursor payments store")
+
+	// Test fetching payments before adding any
+	_, _, _, errCode, err := FetchPaymentsAfterCursor(suite.repos.Payments, storeID, 2, "creation_time", "desc", "", PaymentFilters{}, true)
+	suite.Equal(http.StatusNotFound, errCode)
+	suite.Equal(ErrNoPaymentsFound, err)
+
+	// 5 mock payments, 2 with the same amount
+	for _, amount := range []int64{10, 20, 20, 30, 40} {
+		p, _, _, _ := CreateNewPayment(suite.repos.Stores, suite.repos.Payments, "USD", decimal.NewFromInt(amount), PaymentOptions{}, storeID)
+		p.Insert(suite.repos.Payments)
+	}
+
+	for _, sortBy := range []string{"creation_time", "currency_amount"} {
+		for _, orderBy := range []string{"asc", "desc"} {
+			var (
+				cursor  string
+				fetched []*Payment
+				pages   int
+			)
+			for {
+				ps, nextCursor, total, errCode, err := FetchPaymentsAfterCursor(suite.repos.Payments, storeID, 2, sortBy, orderBy, cursor, PaymentFilters{}, pages == 0)
+				suite.Zero(errCode)
+				suite.Nil(err)
+				suite.LessOrEqual(len(ps), 2)
+				if pages == 0 {
+					suite.Equal(5, total) // Total only counted on request
+				} else {
+					suite.Zero(total)
+				}
+
+				fetched = append(fetched, ps...)
+				pages++
+				if nextCursor == "" {
+					break
+				}
+				cursor = nextCursor
+			}
+
+			// Every payment is fetched exactly once and in order
+			suite.Equal(3, pages)
+			suite.Len(fetched, 5)
+			ids := make(map[string]bool)
+			for i, p := range fetched {
+				ids[p.PaymentID] = true
+				if i > 0 && sortBy == "currency_amount" {
+					cmp := p.CurrencyAmount.Cmp(fetched[i-1].CurrencyAmount)
+					if orderBy == "asc" {
+						suite.GreaterOrEqual(cmp, 0)
+					} else {
+						suite.LessOrEqual(cmp, 0)
+					}
+				}
+			}
+			suite.Len(ids, 5)
+		}
+	}
+
+	// Test filtering and invalid cursor
+	min := decimal.NewFromInt(20)
+	ps, nextCursor, _, errCode, err := FetchPaymentsAfterCursor(suite.repos.Payments, storeID, 0, "currency_amount", "asc", "", PaymentFilters{MinAmount: &min}, false)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Len(ps, 4)
+	suite.Empty(nextCursor) // No limit
+
+	_, _, _, errCode, err = FetchPaymentsAfterCursor(suite.repos.Payments, storeID, 2, "currency_amount", "asc", "invalid", PaymentFilters{}, false)
+	suite.Equal(http.StatusUnprocessableEntity, errCode)
+	suite.Equal(ErrInvalidCursor, err)
+}
+
+func (suite *APITestSuite) TestExportPayments() {
+	storeID := suite.newMockStore("Export payments store")
+
+	for _, orderID := range []string{"1", "2", "=3"} {
+		opts := PaymentOptions{Metadata: processor.PaymentMetadata{OrderID: orderID, Metadata: json.RawMessage(`{"sku":"abc"}`)}}
+		p, _, _, _ := CreateNewPayment(suite.repos.Stores, suite.repos.Payments, "USD", decimal.NewFromInt(10), opts, storeID)
+		p.Insert(suite.repos.Payments)
+	}
+
+	// Test CSV
+	var buf bytes.Buffer
+	flushes := 0
+	err := ExportPayments(suite.repos.Payments, &buf, export.FormatCSV, storeID, "creation_time", "asc", PaymentFilters{}, func() { flushes++ })
+	suite.Nil(err)
+	records, err := csv.NewReader(&buf).ReadAll()
+	suite.Nil(err)
+	suite.Len(records, 4) // Header and 3 payments
+	suite.Equal(len(paymentsExportHeader), len(records[0]))
+	suite.Equal("10", records[1][4])
+	suite.Equal("1", records[1][9])
+	suite.Equal("'=3", records[3][9]) // Not evaluated as formula
+	suite.Equal(`{"sku":"abc"}`, records[1][12])
+	suite.Zero(flushes)
+
+	// Test JSON Lines with filters
+	buf.Reset()
+	err = ExportPayments(suite.repos.Payments, &buf, export.FormatJSONL, storeID, "creation_time", "desc", PaymentFilters{OrderID: "2"}, nil)
+	suite.Nil(err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	suite.Len(lines, 1)
+	var p Payment
+	suite.Nil(json.Unmarshal([]byte(lines[0]), &p))
+	suite.Equal("2", p.OrderID)
+
+	// Test XLSX
+	buf.Reset()
+	err = ExportPayments(suite.repos.Payments, &buf, export.FormatXLSX, storeID, "creation_time", "desc", PaymentFilters{}, nil)
+	suite.Nil(err)
+	_, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	suite.Nil(err)
+
+	// Test invalid format
+	err = ExportPayments(suite.repos.Payments, &buf, "pdf", storeID, "creation_time", "desc", PaymentFilters{}, nil)
+	suite.Equal(export.ErrInvalidFormat, err)
+}
+
+// aggregatedPayments is a PaymentRepository that returns fixed aggregates, recording the range they were requested for
+type aggregatedPayments struct {
+	repository.PaymentRepository
+
+	aggregates []*repository.PaymentAggregate
+	interval   string
+	loc        *time.Location
+	from, to   time.Time
+}
+
+func (p *aggregatedPayments) Aggregate(storeID int, interval string, loc *time.Location, from, to time.Time) ([]*repository.PaymentAggregate, error) {
+	p.interval, p.loc, p.from, p.to = interval, loc, from, to
+	return p.aggregates, nil
+}
+
+func (suite *APITestSuite) TestFetchStoreStats() {
+	storeID := suite.newMockStore("Stats store")
+
+	payments := &aggregatedPayments{aggregates: []*repository.PaymentAggregate{
+		{Period: "2020-05-01", Status: processor.PaymentStatusPaid, Currency: "DERO", Count: 1, CurrencyAmount: decimal.NewFromInt(10), AtomicDeroAmount: 10e12, PaidCount: 1, PaidSeconds: 60},
+		{Period: "2020-05-01", Status: processor.PaymentStatusPaid, Currency: "USD", Count: 1, CurrencyAmount: decimal.NewFromInt(20), AtomicDeroAmount: 2e12, PaidCount: 1, PaidSeconds: 180},
+		{Period: "2020-05-01", Status: processor.PaymentStatusExpired, Currency: "USD", Count: 1, CurrencyAmount: decimal.NewFromInt(5), AtomicDeroAmount: 5e11},
+		{Period: "2020-05-02", Status: processor.PaymentStatusPaid, Currency: "USD", Count: 1, CurrencyAmount: decimal.NewFromInt(30), AtomicDeroAmount: 3e12, PaidCount: 1, PaidSeconds: 120},
+		{Period: "2020-05-02", Status: processor.PaymentStatusCancelled, Currency: "EUR", Count: 1, CurrencyAmount: decimal.NewFromInt(40), AtomicDeroAmount: 4e12},
+	}}
+
+	r, err := NewStatsRange("day", "Europe/Rome", "2020-05-01", "2020-05-03")
+	suite.Nil(err)
+	ss, errCode, err := FetchStoreStats(payments, storeID, r)
+	suite.Zero(errCode)
+	suite.Nil(err)
+
+	// Payments are aggregated from the start of From to the end of To in the time zone of the stats
+	suite.Equal("day", payments.interval)
+	suite.Equal("Europe/Rome", payments.loc.String())
+	suite.True(time.Date(2020, 5, 1, 0, 0, 0, 0, payments.loc).Equal(payments.from))
+	suite.True(time.Date(2020, 5, 4, 0, 0, 0, 0, payments.loc).Equal(payments.to))
+
+	suite.Len(ss.Periods, 2)
+	suite.Equal("2020-05-01", ss.Periods[0].Period)
+	suite.Equal(3, ss.Periods[0].Created)
+	suite.Equal(2, ss.Periods[0].Paid)
+	suite.Equal(1, ss.Periods[0].Expired)
+	suite.Equal(int64(120), ss.Periods[0].AvgTimeToPayment)
+	suite.True(decimal.NewFromInt(20).Equal(ss.Periods[0].CurrencyRevenue["USD"]))
+	suite.True(decimal.NewFromInt(10).Equal(ss.Periods[0].CurrencyRevenue["DERO"]))
+	suite.Equal("2020-05-02", ss.Periods[1].Period)
+
+	totals := ss.Totals
+	suite.Equal(5, totals.Created)
+	suite.Equal(3, totals.Paid)
+	suite.Equal(1, totals.Cancelled)
+	suite.True(decimal.RequireFromString("0.6").Equal(totals.ConversionRate))
+	suite.Equal(int64(120), totals.AvgTimeToPayment)
+	suite.True(decimal.NewFromInt(50).Equal(totals.CurrencyRevenue["USD"]))
+	suite.NotContains(totals.CurrencyRevenue, "EUR") // Cancelled
+	suite.Equal(uint64(15e12), totals.AtomicDeroRevenue)
+
+	// Range with no payments
+	payments.aggregates = nil
+	r, err = NewStatsRange("week", "UTC", "2020-06-01", "2020-06-30")
+	suite.Nil(err)
+	ss, _, err = FetchStoreStats(payments, storeID, r)
+	suite.Nil(err)
+	suite.Empty(ss.Periods)
+	suite.Zero(ss.Totals.Created)
+	suite.True(ss.Totals.ConversionRate.IsZero())
+}
+
+func (suite *APITestSuite) TestIdempotencyKeys() {
+	storeID := suite.mockStore.ID
+	key := "idempotency key"
+
+	// Test reserving a new key
+	resp, errCode, err := ReserveIdempotencyKey(suite.repos.IdempotencyKeys, storeID, key, "hash1")
+	suite.Nil(resp)
+	suite.Zero(errCode)
+	suite.Nil(err)
+
+	// Test reusing the key while its first request is still being processed
+	resp, errCode, err = ReserveIdempotencyKey(suite.repos.IdempotencyKeys, storeID, key, "hash1")
+	suite.Nil(resp)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrIdempotencyKeyInProgress, err)
+
+	// Test reusing the key with different params
+	resp, errCode, err = ReserveIdempotencyKey(suite.repos.IdempotencyKeys, storeID, key, "hash2")
+	suite.Nil(resp)
+	suite.Equal(http.StatusUnprocessableEntity, errCode)
+	suite.Equal(ErrIdempotencyKeyReused, err)
+
+	// Test replaying the stored response
+	err = SaveIdempotentResponse(suite.repos.IdempotencyKeys, storeID, key, &IdempotentResponse{StatusCode: http.StatusCreated, Body: []byte(`{"paymentID":"foo"}`)})
+	suite.Nil(err)
+	resp, errCode, err = ReserveIdempotencyKey(suite.repos.IdempotencyKeys, storeID, key, "hash1")
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal(http.StatusCreated, resp.StatusCode)
+	suite.Equal(`{"paymentID":"foo"}`, string(resp.Body))
+
+	// Test reserving the key again after it was released
+	err = ReleaseIdempotencyKey(suite.repos.IdempotencyKeys, storeID, key)
+	suite.Nil(err)
+	resp, errCode, err = ReserveIdempotencyKey(suite.repos.IdempotencyKeys, storeID, key, "hash2")
+	suite.Nil(resp)
+	suite.Zero(errCode)
+	suite.Nil(err)
+
+	// Test reserving the key again after it expired
+	suite.Nil(DeleteExpiredIdempotencyKeys(suite.repos.IdempotencyKeys))
+	resp, errCode, err = ReserveIdempotencyKey(suite.repos.IdempotencyKeys, storeID, key, "hash1")
+	suite.Nil(resp)
+	suite.Equal(http.StatusUnprocessableEntity, errCode) // Not expired yet
+	suite.Equal(ErrIdempotencyKeyReused, err)
+	suite.Nil(suite.repos.IdempotencyKeys.DeleteExpired(0))
+	resp, errCode, err = ReserveIdempotencyKey(suite.repos.IdempotencyKeys, storeID, key, "hash1")
+	suite.Nil(resp)
+	suite.Zero(errCode)
+	suite.Nil(err)
+}
+
+func (suite *APITestSuite) TestCancelAndExtendPayment() {
+	storeID := suite.mockStore.ID
+	ttl := 10
+
+	p, w, _, err := CreateNewPayment(suite.repos.Stores, suite.repos.Payments, "DERO", decimal.NewFromInt(1), PaymentOptions{TTL: &ttl}, storeID)
+	suite.Nil(err)
+	suite.Nil(p.Insert(suite.repos.Payments))
+	suite.Nil(w.AddPendingPayment(p.PaymentID, p.PendingPayment()))
+
+	// Test extending by invalid numbers of minutes
+	_, errCode, err := ExtendPayment(suite.repos.Payments, p.PaymentID, storeID, 0, false)
+	suite.Equal(http.StatusUnprocessableEntity, errCode)
+	suite.Equal(ErrInvalidExtendMinutes, err)
+	_, errCode, err = ExtendPayment(suite.repos.Payments, p.PaymentID, storeID, config.PaymentMaxTTL, false)
+	suite.Equal(http.StatusUnprocessableEntity, errCode)
+	suite.Equal(ErrInvalidExtendMinutes, err)
+
+	// Test extending (and re-quoting)
+	extended, errCode, err := ExtendPayment(suite.repos.Payments, p.PaymentID, storeID, 5, true)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal(15, extended.MaxTTL)
+	suite.Equal(p.AtomicDeroAmount, extended.AtomicDeroAmount)
+	pending, ok := w.PendingPayments.Get(p.PaymentID)
+	suite.True(ok)
+	suite.Equal(15, pending.TTL)
+	fetched, _, _ := FetchPaymentFromID(suite.repos.Payments, p.PaymentID, storeID)
+	suite.Equal(15, fetched.MaxTTL)
+
+	// Test cancelling
+	cancelled, errCode, err := CancelPayment(suite.repos.Payments, p.PaymentID, storeID, processor.StatusCauseAPICancel)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal(processor.PaymentStatusCancelled, cancelled.Status)
+	_, ok = w.PendingPayments.Get(p.PaymentID)
+	suite.False(ok)
+	fetched, _, _ = FetchPaymentFromID(suite.repos.Payments, p.PaymentID, storeID)
+	suite.Equal(processor.PaymentStatusCancelled, fetched.Status)
+
+	// Test cancelling and extending a payment that is no longer pending
+	_, errCode, err = CancelPayment(suite.repos.Payments, p.PaymentID, storeID, processor.StatusCauseAPICancel)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrPaymentNotPending, err)
+	_, errCode, err = ExtendPayment(suite.repos.Payments, p.PaymentID, storeID, 5, false)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrPaymentNotPending, err)
+
+	// Test cancelling a payment not found
+	invalidPaymentID, _ := stringutil.RandomHexString(32)
+	_, errCode, err = CancelPayment(suite.repos.Payments, invalidPaymentID, storeID, processor.StatusCauseAPICancel)
+	suite.Equal(http.StatusNotFound, errCode)
+	suite.Equal(ErrPaymentNotFound, err)
+}
+
+func (suite *APITestSuite) TestPaymentHistory() {
+	storeID := suite.mockStore.ID
+
+	p, w, _, err := CreateNewPayment(suite.repos.Stores, suite.repos.Payments, "DERO", decimal.NewFromInt(1), PaymentOptions{}, storeID)
+	suite.Nil(err)
+	suite.Nil(p.Insert(suite.repos.Payments))
+	suite.Nil(w.AddPendingPayment(p.PaymentID, p.PendingPayment()))
+
+	// Test creation being recorded
+	h, err := suite.repos.Payments.StatusHistory(p.PaymentID)
+	suite.Nil(err)
+	suite.Len(h, 1)
+	suite.Empty(h[0].OldStatus)
+	suite.Equal(processor.PaymentStatusPending, h[0].NewStatus)
+	suite.Equal(processor.StatusCauseCreated, h[0].Cause)
+	suite.True(h[0].Time.Equal(p.CreationTime))
+
+	// Test cancellation being recorded
+	_, _, err = CancelPayment(suite.repos.Payments, p.PaymentID, storeID, processor.StatusCauseAPICancel)
+	suite.Nil(err)
+	h, err = suite.repos.Payments.StatusHistory(p.PaymentID)
+	suite.Nil(err)
+	suite.Len(h, 2)
+	suite.Equal(processor.PaymentStatusPending, h[1].OldStatus)
+	suite.Equal(processor.PaymentStatusCancelled, h[1].NewStatus)
+	suite.Equal(processor.StatusCauseAPICancel, h[1].Cause)
+	suite.False(h[1].Time.Before(h[0].Time))
+
+	// Test history being append-only
+	h[0].Cause = processor.StatusCauseProcessor
+	h, err = suite.repos.Payments.StatusHistory(p.PaymentID)
+	suite.Nil(err)
+	suite.Len(h, 2)
+	suite.Equal(processor.StatusCauseCreated, h[0].Cause)
+
+	// Test payment without history
+	invalidPaymentID, _ := stringutil.RandomHexString(32)
+	h, err = suite.repos.Payments.StatusHistory(invalidPaymentID)
+	suite.Nil(err)
+	suite.Empty(h)
+}
+
+func (suite *APITestSuite) TestResendPaymentWebhook() {
+	storeID := suite.newMockStore("Webhook store")
+
+	p, _, _, err := CreateNewPayment(suite.repos.Stores, suite.repos.Payments, "DERO", decimal.NewFromInt(1), PaymentOptions{}, storeID)
+	suite.Nil(err)
+	suite.Nil(p.Insert(suite.repos.Payments))
+
+	// Test store without webhook
+	errCode, err := ResendPaymentWebhook(suite.repos.Stores, suite.repos.Payments, p.PaymentID, storeID)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrWebhookNotSet, err)
+
+	responseStatus := http.StatusOK
+	var received []processor.PaymentUpdateEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e processor.PaymentUpdateEvent
+		json.NewDecoder(r.Body).Decode(&e)
+		received = append(received, e)
+		w.WriteHeader(responseStatus)
+	}))
+	defer server.Close()
+
+	err = suite.repos.Stores.UpdateWebhook(storeID, suite.mockUser.ID, server.URL)
+	suite.Nil(err)
+
+	// Test successful delivery
+	errCode, err = ResendPaymentWebhook(suite.repos.Stores, suite.repos.Payments, p.PaymentID, storeID)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Len(received, 1)
+	suite.Equal(p.PaymentID, received[0].PaymentID)
+	suite.Equal(processor.PaymentStatusPending, received[0].Status)
+
+	// Test failed delivery
+	responseStatus = http.StatusInternalServerError
+	errCode, err = ResendPaymentWebhook(suite.repos.Stores, suite.repos.Payments, p.PaymentID, storeID)
+	suite.Equal(http.StatusBadGateway, errCode)
+	suite.Equal(ErrWebhookDeliveryFailed, err)
+
+	// Test deliveries being recorded
+	ds, err := suite.repos.Payments.WebhookDeliveries(p.PaymentID)
+	suite.Nil(err)
+	suite.Len(ds, 2)
+	suite.True(ds[0].Succeeded())
+	suite.Equal(http.StatusOK, ds[0].ResponseStatus)
+	suite.False(ds[1].Succeeded())
+	suite.Equal(http.StatusInternalServerError, ds[1].ResponseStatus)
+	suite.NotEmpty(ds[1].Error)
+
+	// Test payment not found
+	invalidPaymentID, _ := stringutil.RandomHexString(32)
+	errCode, err = ResendPaymentWebhook(suite.repos.Stores, suite.repos.Payments, invalidPaymentID, storeID)
+	suite.Equal(http.StatusNotFound, errCode)
+	suite.Equal(ErrPaymentNotFound, err)
+}
+
+func (suite *APITestSuite) TestRequotePayment() {
+	storeID := suite.mockStore.ID
+	metadata := processor.PaymentMetadata{OrderID: "5678", Description: "Order #5678"}
+
+	expired, _, _, err := CreateNewPayment(suite.repos.Stores, suite.repos.Payments, "DERO", decimal.NewFromInt(3), PaymentOptions{Metadata: metadata}, storeID)
+	suite.Nil(err)
+	expired.Status = processor.PaymentStatusExpired
+	suite.Nil(expired.Insert(suite.repos.Payments))
+
+	// Test re-quoting
+	p, errCode, err := RequotePayment(suite.repos.Stores, suite.repos.Payments, suite.repos.Links, expired.PaymentID, storeID)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.NotEqual(expired.PaymentID, p.PaymentID)
+	suite.NotEqual(expired.IntegratedAddress, p.IntegratedAddress)
+	suite.Equal(processor.PaymentStatusPending, p.Status)
+	suite.True(expired.CurrencyAmount.Equal(p.CurrencyAmount))
+	suite.Equal(metadata.OrderID, p.OrderID)
+	suite.Equal(metadata.Description, p.Description)
+	suite.Equal(expired.PaymentID, p.RequotedFrom)
+
+	fetched, _, _ := FetchPaymentFromID(suite.repos.Payments, expired.PaymentID, storeID)
+	suite.Equal(p.PaymentID, fetched.RequotedTo)
+	fetched, _, _ = FetchPaymentFromID(suite.repos.Payments, p.PaymentID, storeID)
+	suite.Equal(expired.PaymentID, fetched.RequotedFrom)
+
+	// Test re-quoting a payment twice
+	_, errCode, err = RequotePayment(suite.repos.Stores, suite.repos.Payments, suite.repos.Links, expired.PaymentID, storeID)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrPaymentAlreadyRequoted, err)
+
+	// Test re-quoting a payment that is not expired
+	_, errCode, err = RequotePayment(suite.repos.Stores, suite.repos.Payments, suite.repos.Links, p.PaymentID, storeID)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrPaymentNotExpired, err)
+
+	// Test re-quoting a payment of a disabled payment link
+	l := &repository.PaymentLink{Slug: "requote-link", Title: "Link", Currency: "DERO", Active: true, StoreID: storeID}
+	suite.Nil(suite.repos.Links.Insert(l))
+	expired, _, _, err = CreateNewPayment(suite.repos.Stores, suite.repos.Payments, "DERO", decimal.NewFromInt(3), PaymentOptions{PaymentLinkID: l.ID}, storeID)
+	suite.Nil(err)
+	expired.Status = processor.PaymentStatusExpired
+	suite.Nil(expired.Insert(suite.repos.Payments))
+	suite.Nil(suite.repos.Links.SetActive(l.ID, storeID, false))
+
+	_, errCode, err = RequotePayment(suite.repos.Stores, suite.repos.Payments, suite.repos.Links, expired.PaymentID, storeID)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrPaymentLinkDisabled, err)
+}
+
+func (suite *APITestSuite) TestPaymentReceipt() {
+	storeID := suite.mockStore.ID
+	metadata := processor.PaymentMetadata{OrderID: "9012", Description: "Order #9012"}
+
+	p, _, _, err := CreateNewPayment(suite.repos.Stores, suite.repos.Payments, "DERO", decimal.NewFromInt(4), PaymentOptions{Metadata: metadata}, storeID)
+	suite.Nil(err)
+	suite.Nil(p.Insert(suite.repos.Payments))
+
+	// Test receipt of a payment that is not paid
+	_, errCode, err := FetchPaymentReceipt(suite.repos.Stores, suite.repos.Payments, p.PaymentID, storeID)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrPaymentNotPaid, err)
+
+	// Test receipt of a paid payment
+	txIDs := []string{strings.Repeat("a", 64), strings.Repeat("b", 64)}
+	err = suite.repos.Payments.ChangeStatus(p.PaymentID, processor.PaymentStatusPending, processor.PaymentStatusPaid, processor.StatusCauseProcessor, txIDs, true)
+	suite.Nil(err)
+
+	fetched, _, _ := FetchPaymentFromID(suite.repos.Payments, p.PaymentID, storeID)
+	suite.Equal(txIDs, fetched.TxIDs)
+	suite.NotNil(fetched.PaidTime)
+
+	r, errCode, err := FetchPaymentReceipt(suite.repos.Stores, suite.repos.Payments, p.PaymentID, storeID)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal(suite.mockStore.Title, r.StoreTitle)
+	suite.Equal(metadata.OrderID, r.OrderID)
+	suite.Equal(p.DeroAmount, r.DeroAmount)
+	suite.Equal(p.IntegratedAddress, r.IntegratedAddress)
+	suite.Equal(txIDs, r.TxIDs)
+	suite.False(r.PaidTime.IsZero())
+
+	var pdf bytes.Buffer
+	suite.Nil(r.Render(&pdf))
+	suite.True(bytes.HasPrefix(pdf.Bytes(), []byte("%PDF-")))
+
+	// Test receipt of a payment not found
+	_, errCode, err = FetchPaymentReceipt(suite.repos.Stores, suite.repos.Payments, p.PaymentID, storeID+1)
+	suite.Equal(http.StatusNotFound, errCode)
+	suite.Equal(ErrPaymentNotFound, err)
+}
+
+func (suite *APITestSuite) TestInvoices() {
+	storeID := suite.mockStore.ID
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(InvoiceDueDateLayout)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(InvoiceDueDateLayout)
+	item := LineItem{Description: "Item", Quantity: decimal.NewFromInt(2), UnitPrice: decimal.NewFromInt(3), TaxRate: decimal.NewFromInt(10)}
+	customer := InvoiceCustomer{Name: " ACME Inc. ", Email: "billing@acme.com", Address: "1 Road"}
+
+	tests := []struct {
+		Currency string
+		Details  InvoiceDetails
+
+		ExpectedErr error
+	}{
+		{"DERO", InvoiceDetails{Number: "INV-1", Customer: customer, LineItems: []LineItem{item}, DueDate: tomorrow}, nil},
+		{"FOO", InvoiceDetails{Customer: customer, LineItems: []LineItem{item}, DueDate: tomorrow}, ErrInvalidCurrency},
+		{"DERO", InvoiceDetails{Number: strings.Repeat("a", MaxInvoiceNumberLength+1), Customer: customer, LineItems: []LineItem{item}, DueDate: tomorrow}, ErrInvalidInvoiceNumber},
+		{"DERO", InvoiceDetails{Customer: InvoiceCustomer{Name: " "}, LineItems: []LineItem{item}, DueDate: tomorrow}, ErrInvalidCustomerName},
+		{"DERO", InvoiceDetails{Customer: InvoiceCustomer{Name: "Name", Email: "not an email"}, LineItems: []LineItem{item}, DueDate: tomorrow}, ErrInvalidInvoiceEmail},
+		{"DERO", InvoiceDetails{Customer: customer, LineItems: nil, DueDate: tomorrow}, ErrInvalidLineItems},
+		{"DERO", InvoiceDetails{Customer: customer, LineItems: []LineItem{{Description: "Item", Quantity: decimal.Zero, UnitPrice: decimal.NewFromInt(1)}}, DueDate: tomorrow}, ErrInvalidLineItem},
+		{"DERO", InvoiceDetails{Customer: customer, LineItems: []LineItem{{Description: "Item", Quantity: decimal.NewFromInt(1), UnitPrice: decimal.NewFromInt(-1)}}, DueDate: tomorrow}, ErrInvalidLineItem},
+		{"DERO", InvoiceDetails{Customer: customer, LineItems: []LineItem{{Description: "Item", Quantity: decimal.NewFromInt(1), UnitPrice: decimal.NewFromInt(1), TaxRate: decimal.NewFromInt(101)}}, DueDate: tomorrow}, ErrInvalidLineItem},
+		{"DERO", InvoiceDetails{Customer: customer, LineItems: []LineItem{{Quantity: decimal.NewFromInt(1), UnitPrice: decimal.NewFromInt(1)}}, DueDate: tomorrow}, ErrInvalidLineItem},
+		{"DERO", InvoiceDetails{Customer: customer, LineItems: []LineItem{{Description: "Free", Quantity: decimal.NewFromInt(1), UnitPrice: decimal.Zero}}, DueDate: tomorrow}, ErrInvalidInvoiceTotal},
+		{"DERO", InvoiceDetails{Customer: customer, LineItems: []LineItem{item}, DueDate: yesterday}, ErrInvalidDueDate},
+		{"DERO", InvoiceDetails{Customer: customer, LineItems: []LineItem{item}, DueDate: "31/12/2099"}, ErrInvalidDueDate},
+		{"DERO", InvoiceDetails{Customer: customer, LineItems: []LineItem{item}, DueDate: tomorrow, Notes: strings.Repeat("a", MaxInvoiceNotesLength+1)}, ErrInvalidInvoiceNotes},
+	}
+
+	for _, t := range tests {
+		i, errCode, err := CreateNewInvoice(suite.repos.Invoices, t.Currency, t.Details, storeID)
+		suite.Equal(t.ExpectedErr, err)
+		if err != nil {
+			suite.Equal(http.StatusUnprocessableEntity, errCode)
+			continue
+		}
+
+		suite.Equal("ACME Inc.", i.Customer.Name)
+		suite.Equal(processor.InvoiceStatusOpen, i.Status)
+		suite.Len(i.InvoiceID, 64)
+		suite.Equal("6.6", i.Total.String())
+		suite.Nil(i.Insert(suite.repos.Invoices))
+
+		fetched, _, err := FetchInvoiceFromID(suite.repos.Invoices, i.InvoiceID, storeID)
+		suite.Nil(err)
+		suite.Equal(i.Number, fetched.Number)
+		suite.Equal(i.Customer, fetched.Customer)
+		suite.Equal(tomorrow, fetched.DueDate)
+		suite.Len(fetched.LineItems, 1)
+		suite.True(i.LineItems[0].Tax.Equal(fetched.LineItems[0].Tax))
+		suite.True(i.Total.Equal(fetched.Total))
+		suite.Nil(fetched.PaidTime)
+
+		_, errCode, err = FetchInvoiceFromID(suite.repos.Invoices, i.InvoiceID, storeID+1)
+		suite.Equal(http.StatusNotFound, errCode)
+		suite.Equal(ErrInvoiceNotFound, err)
+	}
+
+	newInvoice := func(dueDate string) *Invoice {
+		i, _, err := CreateNewInvoice(suite.repos.Invoices, "DERO", InvoiceDetails{Customer: customer, LineItems: []LineItem{item}, DueDate: tomorrow}, storeID)
+		suite.Nil(err)
+		i.DueDate = dueDate
+		suite.Nil(i.Insert(suite.repos.Invoices))
+		return i
+	}
+
+	// Test paying an invoice
+	i := newInvoice(tomorrow)
+	paymentID, errCode, err := PayInvoice(suite.repos.Stores, suite.repos.Payments, suite.repos.Invoices, i.InvoiceID)
+	suite.Zero(errCode)
+	suite.Nil(err)
+
+	p, _, err := FetchPaymentFromID(suite.repos.Payments, paymentID, storeID)
+	suite.Nil(err)
+	suite.Equal(i.ID, p.InvoiceID)
+	suite.Equal(processor.PaymentStatusPending, p.Status)
+	suite.True(i.Total.Equal(p.CurrencyAmount))
+	suite.Equal(customer.Email, p.CustomerEmail)
+
+	// The pending payment is reused
+	samePaymentID, _, err := PayInvoice(suite.repos.Stores, suite.repos.Payments, suite.repos.Invoices, i.InvoiceID)
+	suite.Nil(err)
+	suite.Equal(paymentID, samePaymentID)
+
+	fetched, _, _ := FetchInvoiceFromID(suite.repos.Invoices, i.InvoiceID, storeID)
+	suite.Equal(paymentID, fetched.PaymentID)
+
+	// Test voiding an invoice cancels its pending payment
+	voided, errCode, err := VoidInvoice(suite.repos.Payments, suite.repos.Invoices, i.InvoiceID, storeID)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal(processor.InvoiceStatusVoid, voided.Status)
+
+	p, _, _ = FetchPaymentFromID(suite.repos.Payments, paymentID, storeID)
+	suite.Equal(processor.PaymentStatusCancelled, p.Status)
+
+	_, errCode, err = PayInvoice(suite.repos.Stores, suite.repos.Payments, suite.repos.Invoices, i.InvoiceID)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrInvoiceNotOpen, err)
+
+	_, errCode, err = VoidInvoice(suite.repos.Payments, suite.repos.Invoices, i.InvoiceID, storeID)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrInvoiceNotOpen, err)
+
+	// Test overdue invoices can still be paid
+	i = newInvoice(yesterday)
+	fetched, _, _ = FetchPublicInvoice(suite.repos.Invoices, i.InvoiceID)
+	suite.Equal(processor.InvoiceStatusOverdue, fetched.Status)
+
+	_, _, err = PayInvoice(suite.repos.Stores, suite.repos.Payments, suite.repos.Invoices, i.InvoiceID)
+	suite.Nil(err)
+
+	// Test concurrent requests to pay an invoice share a single pending payment
+	i = newInvoice(tomorrow)
+	const requests = 5
+	paymentIDs := make(chan string, requests)
+	var wg sync.WaitGroup
+	for n := 0; n < requests; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			paymentID, _, err := PayInvoice(suite.repos.Stores, suite.repos.Payments, suite.repos.Invoices, i.InvoiceID)
+			suite.Nil(err)
+			paymentIDs <- paymentID
+		}()
+	}
+	wg.Wait()
+	close(paymentIDs)
+
+	fetched, _, _ = FetchInvoiceFromID(suite.repos.Invoices, i.InvoiceID, storeID)
+	for paymentID := range paymentIDs {
+		suite.Equal(fetched.PaymentID, paymentID)
+	}
+
+	pending, err := suite.repos.Payments.Find(&repository.PaymentQuery{PaymentFilter: repository.PaymentFilter{StoreID: storeID, Status: processor.PaymentStatusPending}, SortBy: "creation_time", OrderBy: "asc"})
+	suite.Nil(err)
+	var pendingPayments int
+	for _, p := range pending {
+		if p.InvoiceID == i.ID {
+			pendingPayments++
+		}
+	}
+	suite.Equal(1, pendingPayments)
+}
+
+func (suite *APITestSuite) TestSubscriptions() {
+	storeID := suite.mockStore.ID
+	today := time.Now().UTC().Format(InvoiceDueDateLayout)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(InvoiceDueDateLayout)
+	customer := InvoiceCustomer{Email: " customer@acme.com "}
+
+	tests := []struct {
+		Currency string
+		Details  SubscriptionDetails
+
+		ExpectedErr error
+	}{
+		{"DERO", SubscriptionDetails{Amount: decimal.NewFromInt(5), Interval: " Week ", Customer: customer, GracePeriodDays: 3}, nil},
+		{"FOO", SubscriptionDetails{Amount: decimal.NewFromInt(5), Interval: "week", Customer: customer}, ErrInvalidCurrency},
+		{"USD", SubscriptionDetails{Amount: decimal.RequireFromString("0.001"), Interval: "week", Customer: customer}, ErrInvalidSubscriptionAmount},
+		{"DERO", SubscriptionDetails{Amount: decimal.NewFromInt(5), Interval: "hour", Customer: customer}, ErrInvalidInterval},
+		{"DERO", SubscriptionDetails{Amount: decimal.NewFromInt(5), Interval: "month", IntervalCount: MaxIntervalCount + 1, Customer: customer}, ErrInvalidIntervalCount},
+		{"DERO", SubscriptionDetails{Amount: decimal.NewFromInt(5), Interval: "month", Customer: InvoiceCustomer{Name: "No email"}}, ErrInvalidSubscriptionEmail},
+		{"DERO", SubscriptionDetails{Amount: decimal.NewFromInt(5), Interval: "month", Customer: customer, Description: strings.Repeat("a", MaxSubscriptionDescriptionLength+1)}, ErrInvalidSubscriptionDescription},
+		{"DERO", SubscriptionDetails{Amount: decimal.NewFromInt(5), Interval: "month", Customer: customer, GracePeriodDays: MaxGracePeriodDays + 1}, ErrInvalidGracePeriod},
+		{"DERO", SubscriptionDetails{Amount: decimal.NewFromInt(5), Interval: "month", Customer: customer, StartDate: yesterday}, ErrInvalidStartDate},
+	}
+
+	for _, t := range tests {
+		s, errCode, err := CreateNewSubscription(suite.repos.Subscriptions, t.Currency, t.Details, storeID)
+		suite.Equal(t.ExpectedErr, err)
+		if err != nil {
+			suite.Equal(http.StatusUnprocessableEntity, errCode)
+			continue
+		}
+
+		suite.Equal(SubscriptionStatusActive, s.Status)
+		suite.Equal(IntervalWeek, s.Interval)
+		suite.Equal(1, s.IntervalCount)
+		suite.Equal("customer@acme.com", s.Customer.Name)
+		suite.Equal(today, s.StartDate)
+		suite.Equal(today, s.NextBillingDate)
+	}
+
+	// Test billing a subscription
+	s, _, err := CreateNewSubscription(suite.repos.Subscriptions, "DERO", SubscriptionDetails{Amount: decimal.NewFromInt(5), Interval: IntervalWeek, Customer: customer, Description: "Weekly plan", GracePeriodDays: 3}, storeID)
+	suite.Nil(err)
+	suite.Nil(s.Insert(suite.repos.Subscriptions))
+
+	suite.Nil(BillDueSubscriptions(suite.repos.Stores, suite.repos.Invoices, suite.repos.Subscriptions))
+
+	fetched, _, err := FetchSubscriptionFromID(suite.repos.Subscriptions, s.SubscriptionID, storeID)
+	suite.Nil(err)
+	suite.Equal(1, fetched.BilledCycles)
+	suite.Equal(time.Now().UTC().AddDate(0, 0, 7).Format(InvoiceDueDateLayout), fetched.NextBillingDate)
+	suite.NotEmpty(fetched.InvoiceID)
+
+	i, _, err := FetchInvoiceFromID(suite.repos.Invoices, fetched.InvoiceID, storeID)
+	suite.Nil(err)
+	suite.True(s.Amount.Equal(i.Total))
+	suite.Equal(time.Now().UTC().AddDate(0, 0, 3).Format(InvoiceDueDateLayout), i.DueDate)
+	suite.Equal("Weekly plan", i.LineItems[0].Description)
+	suite.Equal(s.Customer.Email, i.Customer.Email)
+	suite.Empty(i.PaymentID) // Payment is created when the customer pays the invoice
+
+	// The same billing cycle is not billed twice
+	i, err = billSubscription(suite.repos.Invoices, suite.repos.Subscriptions, s)
+	suite.Nil(err)
+	suite.Nil(i)
+
+	// Test missed billing cycles
+	overdueInvoiceID, _ := stringutil.RandomHexString(32)
+	overdue := &repository.Invoice{InvoiceID: overdueInvoiceID, Status: processor.InvoiceStatusOpen, Currency: "DERO", CustomerEmail: s.Customer.Email,
+		LineItems: json.RawMessage(`[]`), Total: s.Amount, DueDate: yesterday, SubscriptionID: s.ID, StoreID: storeID}
+	suite.Nil(suite.repos.Invoices.Insert(overdue))
+	suite.Nil(BillDueSubscriptions(suite.repos.Stores, suite.repos.Invoices, suite.repos.Subscriptions))
+
+	fetched, _, _ = FetchSubscriptionFromID(suite.repos.Subscriptions, s.SubscriptionID, storeID)
+	suite.Equal(SubscriptionStatusPastDue, fetched.Status)
+	suite.Equal(1, fetched.MissedCycles)
+
+	paymentID, _ := stringutil.RandomHexString(32)
+	suite.Nil(suite.repos.Invoices.MarkPaid(overdue.ID, paymentID))
+	suite.Nil(BillDueSubscriptions(suite.repos.Stores, suite.repos.Invoices, suite.repos.Subscriptions))
+
+	fetched, _, _ = FetchSubscriptionFromID(suite.repos.Subscriptions, s.SubscriptionID, storeID)
+	suite.Equal(SubscriptionStatusActive, fetched.Status)
+	suite.Zero(fetched.MissedCycles)
+
+	// Test cancelling a subscription
+	cancelled, errCode, err := CancelSubscription(suite.repos.Subscriptions, s.SubscriptionID, storeID)
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal(SubscriptionStatusCancelled, cancelled.Status)
+	suite.NotNil(cancelled.CancelledTime)
+
+	_, errCode, err = CancelSubscription(suite.repos.Subscriptions, s.SubscriptionID, storeID)
+	suite.Equal(http.StatusConflict, errCode)
+	suite.Equal(ErrSubscriptionCancelled, err)
+
+	_, errCode, err = CancelSubscription(suite.repos.Subscriptions, s.SubscriptionID, storeID+1)
+	suite.Equal(http.StatusNotFound, errCode)
+	suite.Equal(ErrSubscriptionNotFound, err)
+}
+
+func (suite *APITestSuite) TestInvoiceEmail() {
+	s := &Subscription{Description: "Monthly plan"}
+	i := &Invoice{Number: "abc-1", Customer: InvoiceCustomer{Name: "John"}, Total: decimal.NewFromInt(10), Currency: "EUR", DueDate: "2030-01-31"}
+
+	subject, body := invoiceEmail("Test store", "https://example.com/invoice/abc", s, i)
+	suite.Equal("Test store: invoice abc-1 for your subscription", subject)
+	suite.Contains(body, "Hello John,")
+	suite.Contains(body, "10 EUR for your subscription (Monthly plan)")
+	suite.Contains(body, "by 2030-01-31")
+	suite.Contains(body, "https://example.com/invoice/abc")
 }
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/peppinux/dero-merchant/httperror"
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// Batch payments errors
+var (
+	ErrEmptyBatch    = errors.New("Invalid request body: required non-empty array of payments")
+	ErrBatchTooLarge = errors.New("Invalid request body: required max number of payments per batch allowed by the server")
+)
+
+// BatchPaymentRequest represents the params of a payment of a batch
+type BatchPaymentRequest struct {
+	Index    int // Position of the payment in the batch
+	Currency string
+	Amount   decimal.Decimal
+	Options  PaymentOptions
+}
+
+// BatchPaymentResult represents the result of the creation of a payment of a batch. Either Payment or Error is set.
+type BatchPaymentResult struct {
+	Index   int                  `json:"index"`
+	Payment *Payment             `json:"payment,omitempty"`
+	Error   *httperror.HTTPError `json:"error,omitempty"`
+}
+
+// CreateNewPayments creates a batch of new payments of a store. Payments with invalid params are reported as errors
+// in their result, while the valid ones are inserted into DB in a single transaction.
+// The created payments still have to be added to the pending payments of the returned wallet.
+func CreateNewPayments(stores repository.StoreRepository, payments repository.PaymentRepository, reqs []BatchPaymentRequest, storeID int) (results []*BatchPaymentResult, w *processor.StoreWallet, errCode int, err error) {
+	// Fetch payment requirements and pricing policy of the store once for the whole batch
+	storeRequirements, err := FetchPaymentRequirements(stores, storeID)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch payment requirements")
+	}
+	pp, err := FetchPricingPolicy(stores, storeID)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch pricing policy")
+	}
+
+	rates := make(map[string]decimal.Decimal) // Exchange rates are fetched only once per currency
+	var created []*Payment
+	for _, r := range reqs {
+		result := &BatchPaymentResult{Index: r.Index}
+		results = append(results, result)
+
+		p, errCode, err := newPayment(r.Currency, r.Amount, r.Options, storeID)
+		if err != nil {
+			result.Error = httperror.NewHTTPError(errCode, err.Error())
+			continue
+		}
+
+		err = p.applyRequirements(r.Options, *storeRequirements)
+		if err != nil {
+			result.Error = httperror.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+			continue
+		}
+		p.PricingPolicy = *pp
+
+		rate, ok := rates[p.Currency]
+		if !ok {
+			rate, err = exchangeRate(p.Currency)
+			if err != nil {
+				return nil, nil, http.StatusInternalServerError, err
+			}
+			rates[p.Currency] = rate
+		}
+
+		errCode, err = p.quoteAt(rate)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				return nil, nil, errCode, err
+			}
+
+			result.Error = httperror.NewHTTPError(errCode, err.Error())
+			continue
+		}
+
+		result.Payment = p
+		created = append(created, p)
+	}
+
+	if len(created) == 0 {
+		return results, nil, 0, nil
+	}
+
+	w, err = processor.ActiveWallets.GetWalletFromStoreID(storeID)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot get wallet from Store ID")
+	}
+
+	err = w.DeroWallet.IsDaemonOnline()
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "daemon offline")
+	}
+
+	err = generateUniqueIntegratedAddresses(payments, w, created)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot generate unique integrated addresses")
+	}
+
+	records := make([]*repository.Payment, len(created))
+	for i, p := range created {
+		records[i] = p.record()
+	}
+	err = payments.InsertAll(records, processor.StatusCauseCreated)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot insert payments into DB")
+	}
+
+	for i, p := range created {
+		p.CreationTime = records[i].CreationTime
+		p.notifyCreated()
+	}
+
+	return results, w, 0, nil
+}
+
+// generateUniqueIntegratedAddresses sets to each of created an integrated address and its payment ID,
+// that have never been used for any other payments before nor by any other payments of the batch
+func generateUniqueIntegratedAddresses(payments repository.PaymentRepository, w *processor.StoreWallet, created []*Payment) error {
+	pending := created
+	used := make(map[string]bool)
+	for len(pending) > 0 {
+		iaddrs := make([]string, len(pending))
+		payids := make([]string, len(pending))
+		for i, p := range pending {
+			iaddrs[i], payids[i] = w.GenerateIntegratedAddress()
+			p.IntegratedAddress, p.PaymentID = iaddrs[i], payids[i]
+		}
+
+		stored, err := payments.UsedIdentifiers(payids, iaddrs)
+		if err != nil {
+			return err
+		}
+		for id := range stored {
+			used[id] = true
+		}
+
+		// Keep the unique ones and generate the colliding ones again
+		var colliding []*Payment
+		for _, p := range pending {
+			if used[p.PaymentID] || used[p.IntegratedAddress] {
+				colliding = append(colliding, p)
+				continue
+			}
+			used[p.PaymentID], used[p.IntegratedAddress] = true, true
+		}
+		pending = colliding
+	}
+
+	return nil
+}
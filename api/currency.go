@@ -0,0 +1,112 @@
+package api
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+/*
+	Rounding rules
+
+	Amounts of currency are rounded (half up) to the number of decimals of the smallest unit of their currency:
+	  - 0 decimals: currencies with no minor unit (e.g. JPY, KRW, VND, CLP) and satoshis (SATS)
+	  - 3 decimals: BHD, KWD
+	  - 8 decimals: BTC, BCH, LTC, BNB
+	  - 12 decimals: DERO (1 atomic unit)
+	  - 2 decimals: any other currency (e.g. USD, EUR, GBP)
+	See currencyDecimals for the complete list.
+
+	Amounts of DERO converted from other currencies are always rounded UP to the nearest atomic unit (0.000000000001 DERO),
+	so that rounding never makes a store receive less than the requested amount of currency.
+*/
+
+// DeroDecimals is the number of decimals of an atomic unit of DERO
+const DeroDecimals = 12
+
+// defaultCurrencyDecimals is the number of decimals of currencies not listed in currencyDecimals
+const defaultCurrencyDecimals = 2
+
+// currencyDecimals maps (upper case) currencies to the number of decimals of their smallest unit, when different from defaultCurrencyDecimals
+var currencyDecimals = map[string]int32{
+	"DERO": DeroDecimals,
+	// Fiat currencies (ISO 4217 minor units)
+	"BHD": 3,
+	"CLP": 0,
+	"IDR": 0,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"VND": 0,
+	// Cryptocurrencies and commodities supported by CoinGecko API V3
+	"BCH":  8,
+	"BITS": 2,
+	"BNB":  8,
+	"BTC":  8,
+	"DOT":  10,
+	"EOS":  4,
+	"ETH":  18,
+	"LINK": 18,
+	"LTC":  8,
+	"SATS": 0,
+	"XLM":  7,
+	"XRP":  6,
+	"YFI":  18,
+}
+
+// CurrencyDecimals returns the number of decimals amounts of currency are rounded to
+func CurrencyDecimals(currency string) int32 {
+	if d, ok := currencyDecimals[strings.ToUpper(currency)]; ok {
+		return d
+	}
+	return defaultCurrencyDecimals
+}
+
+// RoundCurrencyAmount rounds an amount of currency to the number of decimals of the smallest unit of the currency
+func RoundCurrencyAmount(amount decimal.Decimal, currency string) decimal.Decimal {
+	return amount.Round(CurrencyDecimals(currency))
+}
+
+var maxAtomicDeroAmount = new(big.Int).SetUint64(^uint64(0))
+
+// Conversion errors
+var (
+	ErrInvalidExchangeRate = errors.New("invalid exchange rate")
+	ErrAmountOutOfRange    = errors.New("amount out of range")
+)
+
+// ConvertToAtomicDero converts an amount of currency to atomic DERO given the exchange rate (value of 1 DERO in currency).
+// The result is exact, rounded UP to the nearest atomic unit.
+func ConvertToAtomicDero(currencyAmount, exchangeRate decimal.Decimal) (uint64, error) {
+	if !exchangeRate.IsPositive() {
+		return 0, ErrInvalidExchangeRate
+	}
+
+	// Atomic DERO Amount = ceil(Currency Amount * 10^12 / Exchange Rate).
+	// Both operands are scaled to integers with the same exponent so that the division is performed on big integers.
+	n := currencyAmount.Shift(DeroDecimals)
+	exp := n.Exponent()
+	if e := exchangeRate.Exponent(); e < exp {
+		exp = e
+	}
+	num := n.Shift(-exp).BigInt()
+	den := exchangeRate.Shift(-exp).BigInt()
+
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+
+	if q.Sign() < 0 || q.Cmp(maxAtomicDeroAmount) > 0 {
+		return 0, ErrAmountOutOfRange
+	}
+
+	return q.Uint64(), nil
+}
+
+// FormatAtomicDero returns the string representation of an amount of atomic DERO in DERO (e.g. 1000000000000 => "1.000000000000")
+func FormatAtomicDero(atomicDeroAmount uint64) string {
+	return decimal.NewFromBigInt(new(big.Int).SetUint64(atomicDeroAmount), -DeroDecimals).StringFixed(DeroDecimals)
+}
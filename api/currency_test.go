@@ -0,0 +1,107 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peppinux/dero-merchant/cache"
+)
+
+func TestHasValidCurrency(t *testing.T) {
+	oldCache := cache.Default
+	defer func() { cache.Default = oldCache }()
+
+	cache.Default = cache.NewMemoryCache()
+	cache.Default.SetSupportedCurrencies([]string{"usd", "eur"})
+
+	testPayments := map[*Payment]bool{
+		&Payment{Currency: "DERO"}: true,
+		&Payment{Currency: "usd"}:  true,
+		&Payment{Currency: "Eur"}:  true,
+		&Payment{Currency: "ABC"}:  false,
+		&Payment{Currency: "xyz"}:  false,
+	}
+
+	for p, shouldValid := range testPayments {
+		isValid := p.HasValidCurrency()
+		assert.Equal(t, shouldValid, isValid)
+
+		// Test is so quick the goroutine the caches currencies in Redis does not finish execution.
+		// This is the reason why line 50 of api.go is not cover.
+		// To cover it in spite of testing speed uncomment the following line:
+		// time.Sleep(time.Millisecond * 100)
+	}
+}
+
+func TestHasValidCurrencyAmount(t *testing.T) {
+	testPayments := map[*Payment]bool{
+		&Payment{CurrencyAmount: decimal.RequireFromString("1")}:        true,
+		&Payment{CurrencyAmount: decimal.RequireFromString("0.1")}:      true,
+		&Payment{CurrencyAmount: decimal.RequireFromString("1234.567")}: true,
+		&Payment{CurrencyAmount: decimal.RequireFromString("0")}:        false,
+		&Payment{CurrencyAmount: decimal.RequireFromString("-0.1")}:     false,
+		&Payment{CurrencyAmount: decimal.RequireFromString("-100")}:     false,
+	}
+
+	for p, shouldValid := range testPayments {
+		isValid := p.HasValidCurrencyAmount()
+		assert.Equal(t, shouldValid, isValid)
+	}
+}
+
+func TestRoundCurrencyAmount(t *testing.T) {
+	tests := []struct {
+		Currency string
+		Amount   string
+		Expected string
+	}{
+		{Currency: "USD", Amount: "0.1", Expected: "0.1"},
+		{Currency: "usd", Amount: "10.005", Expected: "10.01"},
+		{Currency: "EUR", Amount: "10.004", Expected: "10"},
+		{Currency: "JPY", Amount: "1234.5", Expected: "1235"},
+		{Currency: "KWD", Amount: "1.2345", Expected: "1.235"},
+		{Currency: "BTC", Amount: "0.123456789", Expected: "0.12345679"},
+		{Currency: "DERO", Amount: "1.0000000000004", Expected: "1"},
+	}
+
+	for _, test := range tests {
+		actual := RoundCurrencyAmount(decimal.RequireFromString(test.Amount), test.Currency)
+		assert.True(t, decimal.RequireFromString(test.Expected).Equal(actual), "%s %s => %s", test.Amount, test.Currency, actual)
+	}
+}
+
+func TestConvertToAtomicDero(t *testing.T) {
+	tests := []struct {
+		CurrencyAmount string
+		ExchangeRate   string
+		Expected       uint64
+		ExpectedErr    error
+	}{
+		{CurrencyAmount: "1", ExchangeRate: "1", Expected: 1000000000000},
+		{CurrencyAmount: "0.1", ExchangeRate: "1", Expected: 100000000000},
+		{CurrencyAmount: "0.000000000001", ExchangeRate: "1", Expected: 1},
+		{CurrencyAmount: "100", ExchangeRate: "0.475913", Expected: 210122438344824}, // 210.122438344823...
+		{CurrencyAmount: "110", ExchangeRate: "0.427524", Expected: 257295496860995}, // 257.295496860995...
+		{CurrencyAmount: "0.3", ExchangeRate: "0.1", Expected: 3000000000000},
+		{CurrencyAmount: "1", ExchangeRate: "3", Expected: 333333333334}, // Rounded up
+		{CurrencyAmount: "1000", ExchangeRate: "1000", Expected: 1000000000000},
+		{CurrencyAmount: "1", ExchangeRate: "0", ExpectedErr: ErrInvalidExchangeRate},
+		{CurrencyAmount: "1", ExchangeRate: "-1", ExpectedErr: ErrInvalidExchangeRate},
+		{CurrencyAmount: "100000000", ExchangeRate: "0.000001", ExpectedErr: ErrAmountOutOfRange},
+	}
+
+	for _, test := range tests {
+		actual, err := ConvertToAtomicDero(decimal.RequireFromString(test.CurrencyAmount), decimal.RequireFromString(test.ExchangeRate))
+		assert.Equal(t, test.ExpectedErr, err)
+		assert.Equal(t, test.Expected, actual, "%s / %s", test.CurrencyAmount, test.ExchangeRate)
+	}
+}
+
+func TestFormatAtomicDero(t *testing.T) {
+	assert.Equal(t, "0.000000000000", FormatAtomicDero(0))
+	assert.Equal(t, "0.000000000001", FormatAtomicDero(1))
+	assert.Equal(t, "1.000000000000", FormatAtomicDero(1000000000000))
+	assert.Equal(t, "210.122438344824", FormatAtomicDero(210122438344824))
+}
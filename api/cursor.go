@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// ErrInvalidCursor is returned when a cursor cannot be decoded or was generated for a different sorting
+var ErrInvalidCursor = errors.New("Query param 'cursor' not valid. Allowed values: (empty) or nextCursor of a previous request with the same sort_by and order_by")
+
+// paymentsCursor represents the position of the last payment of a page of payments sorted by SortBy.
+// Payment ID breaks ties between payments with the same sort key.
+type paymentsCursor struct {
+	SortBy    string `json:"s"`
+	OrderBy   string `json:"o"`
+	Value     string `json:"v"`
+	PaymentID string `json:"id"`
+}
+
+// encode returns the opaque string representation of c
+func (c *paymentsCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodePaymentsCursor decodes a cursor returned by encode, and checks it was generated for the same sorting
+// and that its value can be parsed as a value of column sortBy
+func decodePaymentsCursor(s, sortBy, orderBy string) (*paymentsCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c paymentsCursor
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.SortBy != sortBy || c.OrderBy != orderBy || c.PaymentID == "" {
+		return nil, ErrInvalidCursor
+	}
+	if (&repository.PaymentCursor{Value: c.Value, PaymentID: c.PaymentID}).Validate(sortBy) != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// FetchPaymentsAfterCursor returns a page of at most limit (0 = no limit) Payments fetched from DB based on given filters,
+// that come after the payment cursor points to (from the first one if cursor is empty).
+// nextCursor points to the last payment of the page, and is empty if there are no more payments.
+// The total number of filtered payments is only counted if includeTotal is true.
+func FetchPaymentsAfterCursor(payments repository.PaymentRepository, storeID, limit int, sortBy, orderBy, cursor string, filters PaymentFilters, includeTotal bool) (ps []*Payment, nextCursor string, totalPayments, errCode int, err error) {
+	// Note: Input comes already sanitized from caller function GetPaymentsFromStoreID.
+
+	filters.StoreID = storeID
+
+	if includeTotal {
+		totalPayments, err = payments.Count(&filters)
+		if err != nil {
+			return nil, "", 0, http.StatusInternalServerError, errors.Wrap(err, "cannot count payments")
+		}
+	}
+
+	q := &repository.PaymentQuery{
+		PaymentFilter: filters,
+		SortBy:        sortBy,
+		OrderBy:       orderBy,
+	}
+
+	if cursor != "" {
+		c, err := decodePaymentsCursor(cursor, sortBy, orderBy)
+		if err != nil {
+			return nil, "", 0, http.StatusUnprocessableEntity, err
+		}
+
+		q.After = &repository.PaymentCursor{Value: c.Value, PaymentID: c.PaymentID}
+	}
+
+	if limit > 0 {
+		q.Limit = limit + 1 // One more payment than requested tells if there is a next page
+	}
+
+	rs, err := payments.Find(q)
+	if err != nil {
+		return nil, "", 0, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch payments")
+	}
+
+	if len(rs) == 0 {
+		return nil, "", totalPayments, http.StatusNotFound, ErrNoPaymentsFound
+	}
+
+	if limit > 0 && len(rs) > limit {
+		rs = rs[:limit]
+		last := rs[limit-1]
+		c := &paymentsCursor{
+			SortBy:    sortBy,
+			OrderBy:   orderBy,
+			Value:     last.SortValue(sortBy),
+			PaymentID: last.PaymentID,
+		}
+		nextCursor = c.encode()
+	}
+
+	return paymentsFromRecords(rs), nextCursor, totalPayments, 0, nil
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentsCursor(t *testing.T) {
+	c := &paymentsCursor{SortBy: "creation_time", OrderBy: "desc", Value: "2020-05-01T10:30:00.123456", PaymentID: "abc"}
+	encoded := c.encode()
+
+	decoded, err := decodePaymentsCursor(encoded, "creation_time", "desc")
+	assert.Nil(t, err)
+	assert.Equal(t, c, decoded)
+
+	// Cursor generated for a different sorting
+	_, err = decodePaymentsCursor(encoded, "currency_amount", "desc")
+	assert.Equal(t, ErrInvalidCursor, err)
+	_, err = decodePaymentsCursor(encoded, "creation_time", "asc")
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	// Malformed cursors
+	for _, s := range []string{"abc", "!!!", c.encode()[1:]} {
+		_, err = decodePaymentsCursor(s, "creation_time", "desc")
+		assert.Equal(t, ErrInvalidCursor, err)
+	}
+
+	// Cursors with values that are not valid for their sorting
+	tests := []struct {
+		sortBy string
+		value  string
+	}{
+		{"creation_time", "yesterday"},
+		{"currency_amount", "one"},
+		{"exchange_rate", ""},
+		{"atomic_dero_amount", "-1"},
+		{"atomic_dero_amount", "1.5"},
+	}
+	for _, test := range tests {
+		s := (&paymentsCursor{SortBy: test.sortBy, OrderBy: "asc", Value: test.value, PaymentID: "abc"}).encode()
+		_, err = decodePaymentsCursor(s, test.sortBy, "asc")
+		assert.Equal(t, ErrInvalidCursor, err)
+	}
+
+	c = &paymentsCursor{SortBy: "atomic_dero_amount", OrderBy: "asc", Value: "1000000000000", PaymentID: "abc"}
+	decoded, err = decodePaymentsCursor(c.encode(), "atomic_dero_amount", "asc")
+	assert.Nil(t, err)
+	assert.Equal(t, c, decoded)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/export"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// ErrInvalidExportFormat is returned when payments are exported in an unsupported format
+var ErrInvalidExportFormat = errors.New("Query param 'format' not valid. Allowed values: (empty), csv, jsonl, xlsx")
+
+// exportFlushInterval is the number of payments after which the exported data is flushed to the client
+const exportFlushInterval = 500
+
+// exportWriteTimeout is the time allowed to write the first exportFlushInterval payments of an export, and each following exportFlushInterval.
+// It replaces the write timeout of the server, which would cut off the exports of large stores.
+const exportWriteTimeout = 10 * time.Second
+
+// paymentsExportHeader are the column names of the tabular exports of payments
+var paymentsExportHeader = []interface{}{
+	"Creation time", "Status", "Payment ID", "Currency", "Currency amount", "Exchange rate", "DERO amount", "Atomic DERO amount",
+	"Integrated address", "Order ID", "Description", "Customer email", "Metadata", "Paid time", "Transaction IDs",
+}
+
+// exportRow returns the cells of the row of Payment in the tabular exports of payments
+func (p *Payment) exportRow() []interface{} {
+	var paidTime string
+	if p.PaidTime != nil {
+		paidTime = p.PaidTime.UTC().Format(time.RFC3339)
+	}
+
+	return []interface{}{
+		p.CreationTime.UTC().Format(time.RFC3339),
+		p.Status,
+		p.PaymentID,
+		p.Currency,
+		export.Number(p.CurrencyAmount.String()),
+		export.Number(p.ExchangeRate.String()),
+		export.Number(p.DeroAmount),
+		export.Number(strconv.FormatUint(p.AtomicDeroAmount, 10)),
+		p.IntegratedAddress,
+		p.OrderID,
+		p.Description,
+		p.CustomerEmail,
+		string(p.Metadata),
+		paidTime,
+		strings.Join(p.TxIDs, " "),
+	}
+}
+
+// ExportPayments writes the payments of a store matching filters to w in the given format, sorted by sortBy in orderBy order.
+// Payments are read from DB and written one by one, so that the whole result set is never kept in memory.
+// flush, if not nil, is called every exportFlushInterval payments.
+func ExportPayments(payments repository.PaymentRepository, w io.Writer, format string, storeID int, sortBy, orderBy string, filters PaymentFilters, flush func()) error {
+	// Note: Input comes already sanitized from caller function ExportFilteredPaymentsFromStoreID.
+
+	var (
+		writeRow    func(p *Payment) error
+		closeWriter func() error
+	)
+	if format == export.FormatJSONL {
+		enc := json.NewEncoder(w) // Encode appends a newline to every payment
+		writeRow = func(p *Payment) error { return enc.Encode(p) }
+		closeWriter = func() error { return nil }
+	} else {
+		rw, err := export.NewRowWriter(w, format)
+		if err != nil {
+			return err
+		}
+		err = rw.WriteRow(paymentsExportHeader...)
+		if err != nil {
+			return errors.Wrap(err, "cannot write header")
+		}
+		writeRow = func(p *Payment) error { return rw.WriteRow(p.exportRow()...) }
+		closeWriter = rw.Close
+	}
+
+	filters.StoreID = storeID
+	q := &repository.PaymentQuery{
+		PaymentFilter: filters,
+		SortBy:        sortBy,
+		OrderBy:       orderBy,
+	}
+
+	var n int
+	err := payments.Each(q, func(r *repository.Payment) error {
+		err := writeRow(paymentFromRecord(r))
+		if err != nil {
+			return errors.Wrap(err, "cannot write payment")
+		}
+
+		n++
+		if flush != nil && n%exportFlushInterval == 0 {
+			flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return closeWriter()
+}
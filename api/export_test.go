@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// slowPayments is a PaymentRepository whose exports take delay for every payment
+type slowPayments struct {
+	repository.PaymentRepository
+	delay time.Duration
+}
+
+func (r *slowPayments) Each(q *repository.PaymentQuery, fn func(p *repository.Payment) error) error {
+	return r.PaymentRepository.Each(q, func(p *repository.Payment) error {
+		time.Sleep(r.delay)
+		return fn(p)
+	})
+}
+
+func TestExportFilteredPaymentsLongerThanWriteTimeout(t *testing.T) {
+	const n = 2*exportFlushInterval + 100
+
+	payments := repository.NewMemoryPaymentRepository()
+	records := make([]*repository.Payment, n)
+	for i := range records {
+		records[i] = &repository.Payment{
+			PaymentID:         fmt.Sprintf("payment%d", i),
+			Status:            "pending",
+			Currency:          "DERO",
+			CurrencyAmount:    decimal.NewFromInt(1),
+			IntegratedAddress: fmt.Sprintf("iaddr%d", i),
+			StoreID:           1,
+		}
+	}
+	assert.Nil(t, payments.InsertAll(records, "created"))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/export", func(c *gin.Context) {
+		ExportFilteredPaymentsFromStoreID(c, &slowPayments{PaymentRepository: payments, delay: time.Millisecond}, 1)
+	})
+
+	// The export takes more than a second, while the server allows 500ms to write a response
+	server := httptest.NewUnstartedServer(r)
+	server.Config.WriteTimeout = 500 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/export?format=csv")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, n+1, bytes.Count(body, []byte("\n"))) // Header included
+}
@@ -1,12 +1,23 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 
+	"github.com/peppinux/dero-merchant/config"
+	"github.com/peppinux/dero-merchant/export"
 	"github.com/peppinux/dero-merchant/httperror"
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
 )
 
 // PingGetHandler handles GET requests to /api/v1/ping
@@ -17,8 +28,16 @@ func PingGetHandler(c *gin.Context) {
 }
 
 type paymentPostRequest struct {
-	Currency string  `json:"currency" binding:"required,max=4,min=3"`
-	Amount   float64 `json:"amount" binding:"required"`
+	Currency         string           `json:"currency" binding:"required,max=4,min=3"`
+	Amount           *decimal.Decimal `json:"amount" binding:"required"` // Accepts both JSON numbers and decimal strings (e.g. 10.5 or "10.5") without loss of precision
+	TTL              *int             `json:"ttl"`                       // Optional. Defaults to the payment TTL of the store
+	MinConfirmations *int             `json:"minConfirmations"`          // Optional. Defaults to the payment min confirmations of the store
+	OrderID          string           `json:"orderID"`
+	Description      string           `json:"description"`
+	CustomerEmail    string           `json:"customerEmail"`
+	Metadata         json.RawMessage  `json:"metadata"`   // Free-form JSON object
+	SuccessURL       string           `json:"successURL"` // Optional. Where the pay helper page redirects customers to after the payment is paid
+	CancelURL        string           `json:"cancelURL"`  // Optional. Where the pay helper page redirects customers to after the payment is cancelled
 }
 
 var paymentPostFieldsErrors = map[string]string{
@@ -26,125 +45,405 @@ var paymentPostFieldsErrors = map[string]string{
 	"Amount":   ErrInvalidAmount.Error(),
 }
 
+// options returns the PaymentOptions of a new payment requested by paymentPostRequest
+func (req *paymentPostRequest) options() PaymentOptions {
+	return PaymentOptions{
+		TTL:              req.TTL,
+		MinConfirmations: req.MinConfirmations,
+		Metadata: processor.PaymentMetadata{
+			OrderID:       req.OrderID,
+			Description:   req.Description,
+			CustomerEmail: req.CustomerEmail,
+			Metadata:      req.Metadata,
+		},
+		SuccessURL: req.SuccessURL,
+		CancelURL:  req.CancelURL,
+	}
+}
+
 // PaymentPostHandler handles POST requests to /api/v1/payment
-func PaymentPostHandler(c *gin.Context) {
-	// Get and validate request params
-	var req paymentPostRequest
-	err := c.ShouldBindJSON(&req)
-	if err != nil {
-		errs, ok := err.(validator.ValidationErrors)
-		if !ok {
+func PaymentPostHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get and validate request params
+		var req paymentPostRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			errs, ok := err.(validator.ValidationErrors)
+			if !ok {
+				httperror.Send(c, http.StatusBadRequest, "Invalid request params")
+				return
+			}
+
+			for _, err := range errs {
+				httperror.Send(c, http.StatusUnprocessableEntity, paymentPostFieldsErrors[err.Field()])
+				return
+			}
+		}
+
+		storeID := c.MustGet("storeID").(int)
+
+		// Create Payment
+		p, w, errCode, err := CreateNewPayment(stores, payments, req.Currency, *req.Amount, req.options(), storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error creating new payment")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		// Insert Payment into DB
+		err = p.Insert(payments)
+		if httperror.Send500IfErr(c, err, "Error inserting Payment into DB") != nil {
+			return
+		}
+
+		// Add Payment to wallet's pending payments
+		err = w.AddPendingPayment(p.PaymentID, p.PendingPayment())
+		if httperror.Send500IfErr(c, err, "Error adding pending payment to wallet") != nil {
+			return
+		}
+
+		c.JSON(http.StatusCreated, p)
+	}
+}
+
+// PaymentsBatchPostHandler handles POST requests to /api/v1/payments/batch
+func PaymentsBatchPostHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get request params
+		var reqs []paymentPostRequest
+		err := c.ShouldBindJSON(&reqs)
+		if err != nil {
 			httperror.Send(c, http.StatusBadRequest, "Invalid request params")
 			return
 		}
 
-		for _, err := range errs {
-			httperror.Send(c, http.StatusUnprocessableEntity, paymentPostFieldsErrors[err.Field()])
+		if len(reqs) == 0 {
+			httperror.Send(c, http.StatusUnprocessableEntity, ErrEmptyBatch.Error())
 			return
 		}
+		if len(reqs) > config.PaymentBatchMaxSize {
+			httperror.Send(c, http.StatusUnprocessableEntity, ErrBatchTooLarge.Error())
+			return
+		}
+
+		storeID := c.MustGet("storeID").(int)
+
+		// Validate params of every payment. Invalid payments get their error as result, the valid ones get created.
+		results := make([]*BatchPaymentResult, len(reqs))
+		var batch []BatchPaymentRequest
+		for i := range reqs {
+			req := &reqs[i]
+			err := binding.Validator.ValidateStruct(req)
+			if err != nil {
+				msg := "Invalid request params"
+				if errs, ok := err.(validator.ValidationErrors); ok && len(errs) > 0 {
+					if fieldMsg := paymentPostFieldsErrors[errs[0].Field()]; fieldMsg != "" {
+						msg = fieldMsg
+					}
+				}
+				results[i] = &BatchPaymentResult{
+					Index: i,
+					Error: httperror.NewHTTPError(http.StatusUnprocessableEntity, msg),
+				}
+				continue
+			}
+
+			batch = append(batch, BatchPaymentRequest{
+				Index:    i,
+				Currency: req.Currency,
+				Amount:   *req.Amount,
+				Options:  req.options(),
+			})
+		}
+
+		// Create Payments
+		if len(batch) > 0 {
+			created, w, errCode, err := CreateNewPayments(stores, payments, batch, storeID)
+			if err != nil {
+				if errCode == http.StatusInternalServerError {
+					httperror.Send500(c, err, "Error creating new payments")
+					return
+				}
+
+				httperror.Send(c, errCode, err.Error())
+				return
+			}
+
+			for _, r := range created {
+				results[r.Index] = r
+
+				if r.Payment == nil {
+					continue
+				}
+
+				// Add Payment to wallet's pending payments
+				err = w.AddPendingPayment(r.Payment.PaymentID, r.Payment.PendingPayment())
+				if err != nil {
+					c.Error(errors.Wrap(err, "Error adding pending payment to wallet"))
+					r.Payment = nil
+					r.Error = httperror.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+				}
+			}
+		}
+
+		// 201 if every payment was created, 422 if none was, 207 otherwise
+		var failed int
+		for _, r := range results {
+			if r.Error != nil {
+				failed++
+			}
+		}
+		status := http.StatusMultiStatus
+		switch failed {
+		case 0:
+			status = http.StatusCreated
+		case len(results):
+			status = http.StatusUnprocessableEntity
+		}
+
+		c.JSON(status, results)
 	}
+}
 
-	storeID := c.MustGet("storeID").(int)
+// PaymentGetHandler handles GET requests to /api/v1/payment/:payment_id
+func PaymentGetHandler(payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
+		storeID := c.MustGet("storeID").(int)
+
+		p, errCode, err := FetchPaymentFromID(payments, paymentID, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error fetching payment from database")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
 
-	// Create Payment
-	p, w, errCode, err := CreateNewPayment(req.Currency, req.Amount, storeID)
-	if err != nil {
-		if errCode == http.StatusInternalServerError {
-			httperror.Send500(c, err, "Error creating new payment")
+		p.History, err = payments.StatusHistory(paymentID)
+		if httperror.Send500IfErr(c, err, "Error fetching payment status history from database") != nil {
 			return
 		}
 
-		httperror.Send(c, errCode, err.Error())
-		return
+		c.JSON(http.StatusOK, p)
 	}
+}
 
-	// Insert Payment into DB
-	err = p.Insert()
-	if httperror.Send500IfErr(c, err, "Error inserting Payment into DB") != nil {
-		return
+// PaymentReceiptGetHandler handles GET requests to /api/v1/payment/:payment_id/receipt
+func PaymentReceiptGetHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
+		storeID := c.MustGet("storeID").(int)
+
+		r, errCode, err := FetchPaymentReceipt(stores, payments, paymentID, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error fetching payment receipt from database")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		var pdf bytes.Buffer
+		err = r.Render(&pdf)
+		if httperror.Send500IfErr(c, err, "Error rendering payment receipt") != nil {
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="receipt-`+paymentID+`.pdf"`)
+		c.Data(http.StatusOK, "application/pdf", pdf.Bytes())
 	}
+}
 
-	// Add Payment to wallet's pending payments
-	err = w.AddPendingPayment(p.PaymentID, p.AtomicDeroAmount)
-	if httperror.Send500IfErr(c, err, "Error adding pending payment to wallet") != nil {
-		return
+// PaymentCancelPostHandler handles POST requests to /api/v1/payment/:payment_id/cancel
+func PaymentCancelPostHandler(payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
+		storeID := c.MustGet("storeID").(int)
+
+		p, errCode, err := CancelPayment(payments, paymentID, storeID, processor.StatusCauseAPICancel)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error cancelling payment")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, p)
 	}
+}
 
-	c.JSON(http.StatusCreated, p)
+type paymentExtendPostRequest struct {
+	Minutes *int `json:"minutes" binding:"required"`
+	Requote bool `json:"requote"` // Optional. Whether to update the amount of DERO due to the current exchange rate
 }
 
-// PaymentGetHandler handles GET requests to /api/v1/payment/:payment_id
-func PaymentGetHandler(c *gin.Context) {
-	paymentID := c.Param("payment_id")
-	storeID := c.MustGet("storeID").(int)
+// PaymentExtendPostHandler handles POST requests to /api/v1/payment/:payment_id/extend
+func PaymentExtendPostHandler(payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get and validate request params
+		var req paymentExtendPostRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			if _, ok := err.(validator.ValidationErrors); ok {
+				httperror.Send(c, http.StatusUnprocessableEntity, ErrInvalidExtendMinutes.Error())
+				return
+			}
 
-	p, errCode, err := FetchPaymentFromID(paymentID, storeID)
-	if err != nil {
-		if errCode == http.StatusInternalServerError {
-			httperror.Send500(c, err, "Error fetching payment from database")
+			httperror.Send(c, http.StatusBadRequest, "Invalid request params")
 			return
 		}
 
-		httperror.Send(c, errCode, err.Error())
-		return
+		paymentID := c.Param("payment_id")
+		storeID := c.MustGet("storeID").(int)
+
+		p, errCode, err := ExtendPayment(payments, paymentID, storeID, *req.Minutes, req.Requote)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error extending payment")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, p)
 	}
+}
+
+// PaymentRequotePostHandler handles POST requests to /api/v1/payment/:payment_id/requote
+func PaymentRequotePostHandler(stores repository.StoreRepository, payments repository.PaymentRepository, links repository.LinkRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
+		storeID := c.MustGet("storeID").(int)
+
+		p, errCode, err := RequotePayment(stores, payments, links, paymentID, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error re-quoting payment")
+				return
+			}
 
-	c.JSON(http.StatusOK, p)
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusCreated, p)
+	}
 }
 
 type paymentsPostRequest []string
 type paymentsPostResponse []*Payment
 
 // PaymentsPostHandler handles POST requests to /api/v1/payments
-func PaymentsPostHandler(c *gin.Context) {
-	storeID := c.MustGet("storeID").(int)
-
-	var (
-		paymentIDs paymentsPostRequest
-		payments   paymentsPostResponse
-	)
+func PaymentsPostHandler(payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storeID := c.MustGet("storeID").(int)
+
+		var (
+			paymentIDs paymentsPostRequest
+			resp       paymentsPostResponse
+		)
+
+		// Get request params
+		err := c.ShouldBindJSON(&paymentIDs)
+		if err != nil {
+			httperror.Send(c, http.StatusBadRequest, "Invalid request params")
+			return
+		}
 
-	// Get request params
-	err := c.ShouldBindJSON(&paymentIDs)
-	if err != nil {
-		httperror.Send(c, http.StatusBadRequest, "Invalid request params")
-		return
-	}
+		if len(paymentIDs) == 0 {
+			httperror.Send(c, http.StatusBadRequest, "No Payment IDs submitted")
+			return
+		}
 
-	if len(paymentIDs) == 0 {
-		httperror.Send(c, http.StatusBadRequest, "No Payment IDs submitted")
-		return
-	}
+		resp, errCode, err := FetchPaymentsFromIDs(payments, paymentIDs, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error fetching payments from database")
+				return
+			}
 
-	payments, errCode, err := FetchPaymentsFromIDs(paymentIDs, storeID)
-	if err != nil {
-		if errCode == http.StatusInternalServerError {
-			httperror.Send500(c, err, "Error fetching payments from database")
+			httperror.Send(c, errCode, err.Error())
 			return
 		}
 
-		httperror.Send(c, errCode, err.Error())
-		return
-	}
+		if len(resp) == 0 {
+			httperror.Send(c, http.StatusNotFound, "Payments not found")
+			return
+		}
 
-	if len(payments) == 0 {
-		httperror.Send(c, http.StatusNotFound, "Payments not found")
-		return
+		c.JSON(http.StatusOK, resp)
 	}
-
-	c.JSON(http.StatusOK, payments)
 }
 
 type paymentsGetRequest struct {
 	// Pagination
 	Limit int `form:"limit,default=0" binding:"min=0"`
 	Page  int `form:"page,default=1" binding:"min=1"`
+	// Keyset pagination. Used instead of page if cursor is set, even if empty (first page)
+	Cursor       string `form:"cursor,default="`
+	IncludeTotal bool   `form:"include_total,default=false"` // Total number of payments is only counted on request with keyset pagination
 	// Sorting
 	SortBy  string `form:"sort_by,default=creation_time" binding:"eq=|eq=currency_amount|eq=exchange_rate|eq=atomic_dero_amount|eq=creation_time"`
 	OrderBy string `form:"order_by,default=desc" binding:"eq=|eq=asc|eq=desc"`
 	// Filtering
-	Status   string `form:"status,default=" binding:"eq=|eq=pending|eq=paid|eq=expired|eq=error"`
-	Currency string `form:"currency,default=" binding:"max=4"`
+	Status            string `form:"status,default=" binding:"eq=|eq=pending|eq=paid|eq=expired|eq=error|eq=cancelled"`
+	Currency          string `form:"currency,default=" binding:"max=4"`
+	From              string `form:"from,default="` // YYYY-MM-DD or RFC 3339
+	To                string `form:"to,default="`   // YYYY-MM-DD or RFC 3339
+	MinAmount         string `form:"min_amount,default="`
+	MaxAmount         string `form:"max_amount,default="`
+	IntegratedAddress string `form:"integrated_address,default=" binding:"max=142"`
+	OrderID           string `form:"order_id,default=" binding:"max=64"`
+	Description       string `form:"description,default=" binding:"max=256"`
+	Metadata          string `form:"metadata,default=" binding:"max=4096"` // JSON object
+}
+
+// filters parses the filtering query params of paymentsGetRequest
+func (req *paymentsGetRequest) filters() (f PaymentFilters, err error) {
+	f = PaymentFilters{
+		Status:            req.Status,
+		Currency:          req.Currency,
+		IntegratedAddress: req.IntegratedAddress,
+		OrderID:           req.OrderID,
+		Description:       req.Description,
+	}
+
+	f.From, err = ParseFilterTime(req.From, false)
+	if err != nil {
+		return f, ErrInvalidFromFilter
+	}
+	f.To, err = ParseFilterTime(req.To, true)
+	if err != nil {
+		return f, ErrInvalidToFilter
+	}
+	f.MinAmount, err = ParseFilterAmount(req.MinAmount)
+	if err != nil {
+		return f, ErrInvalidMinAmount
+	}
+	f.MaxAmount, err = ParseFilterAmount(req.MaxAmount)
+	if err != nil {
+		return f, ErrInvalidMaxAmount
+	}
+	f.Metadata, err = ParseFilterMetadata(req.Metadata)
+	if err != nil {
+		return f, err
+	}
+
+	return f, nil
 }
 
 var paymentsGetFieldsErrors = map[string]string{
@@ -152,8 +451,13 @@ var paymentsGetFieldsErrors = map[string]string{
 	"Page":     "Query param 'page' not valid. Allowed values: (empty) or min 1",
 	"SortBy":   "Query param 'sort_by' not valid. Allowed values: (empty), creation_time, currency_amount, exchange_rate, atomic_dero_amount",
 	"OrderBy":  "Query param 'order_by' not valid. Allowed values: (empty), asc, desc",
-	"Status":   "Query param 'status' not valid. Allowed values: (empty), pending, paid, expired, error",
+	"Status":   "Query param 'status' not valid. Allowed values: (empty), pending, paid, expired, error, cancelled",
 	"Currency": "Query param 'currency' not valid. Allowed values: (empty) or max 4 characters",
+
+	"IntegratedAddress": "Query param 'integrated_address' not valid. Allowed values: (empty) or max 142 characters",
+	"OrderID":           "Query param 'order_id' not valid. Allowed values: (empty) or max 64 characters",
+	"Description":       "Query param 'description' not valid. Allowed values: (empty) or max 256 characters",
+	"Metadata":          "Query param 'metadata' not valid. Allowed values: (empty) or JSON object max 4096 characters",
 }
 
 type paymentsGetResponse struct {
@@ -163,29 +467,26 @@ type paymentsGetResponse struct {
 	// Total number of filtered Payment(s) and pages (of "Limit" # of items)
 	TotalPayments int `json:"totalPayments,omitempty"`
 	TotalPages    int `json:"totalPages,omitempty"`
+	// Cursor of the next page with keyset pagination. Empty if there are no more Payment(s)
+	NextCursor string `json:"nextCursor,omitempty"`
 	// Array of "limit" number of Payment(s)
 	Payments []*Payment `json:"payments"`
 }
 
-// GetFilteredPaymentsFromStoreID is called by both PaymentsGetHandler (in this file) and PaymentsGetHandler (in webapp/store/handler.go)
-func GetFilteredPaymentsFromStoreID(c *gin.Context, storeID int) {
-	var (
-		req  paymentsGetRequest
-		resp paymentsGetResponse
-	)
-
-	// Get and Validate URL Query params
+// bindPaymentsGetRequest gets and validates the URL Query params of a request for the payments of a store.
+// If any of them is invalid, an error is sent and ok is false.
+func bindPaymentsGetRequest(c *gin.Context) (req paymentsGetRequest, filters PaymentFilters, ok bool) {
 	err := c.ShouldBindQuery(&req)
 	if err != nil {
 		errs, ok := err.(validator.ValidationErrors)
 		if !ok {
 			httperror.Send(c, http.StatusBadRequest, "Invalid query params")
-			return
+			return req, filters, false
 		}
 
 		for _, err := range errs {
 			httperror.Send(c, http.StatusUnprocessableEntity, paymentsGetFieldsErrors[err.Field()])
-			return
+			return req, filters, false
 		}
 	}
 
@@ -197,11 +498,48 @@ func GetFilteredPaymentsFromStoreID(c *gin.Context, storeID int) {
 		req.OrderBy = "desc"
 	}
 
+	filters, err = req.filters()
+	if err != nil {
+		httperror.Send(c, http.StatusUnprocessableEntity, err.Error())
+		return req, filters, false
+	}
+
+	return req, filters, true
+}
+
+// GetFilteredPaymentsFromStoreID is called by both PaymentsGetHandler (in this file) and PaymentsGetHandler (in webapp/store/handler.go)
+func GetFilteredPaymentsFromStoreID(c *gin.Context, payments repository.PaymentRepository, storeID int) {
+	var resp paymentsGetResponse
+
+	req, filters, ok := bindPaymentsGetRequest(c)
+	if !ok {
+		return
+	}
+
 	resp.Limit = req.Limit
-	resp.Page = req.Page
 
-	var errCode int
-	resp.Payments, resp.TotalPayments, resp.TotalPages, errCode, err = FetchFilteredPayments(storeID, req.Limit, req.Page, req.SortBy, req.OrderBy, req.Status, req.Currency)
+	var (
+		errCode int
+		err     error
+	)
+	if _, useCursor := c.GetQuery("cursor"); useCursor {
+		resp.Payments, resp.NextCursor, resp.TotalPayments, errCode, err = FetchPaymentsAfterCursor(payments, storeID, req.Limit, req.SortBy, req.OrderBy, req.Cursor, filters, req.IncludeTotal)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error fetching filtered payments")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.Page = req.Page
+	resp.Payments, resp.TotalPayments, resp.TotalPages, errCode, err = FetchFilteredPayments(payments, storeID, req.Limit, req.Page, req.SortBy, req.OrderBy, filters)
 	if err != nil {
 		if errCode == http.StatusInternalServerError {
 			httperror.Send500(c, err, "Error fetching filtered payments")
@@ -216,7 +554,312 @@ func GetFilteredPaymentsFromStoreID(c *gin.Context, storeID int) {
 }
 
 // PaymentsGetHandler handles GET requests to /api/v1/payments
-func PaymentsGetHandler(c *gin.Context) {
-	storeID := c.MustGet("storeID").(int)
-	GetFilteredPaymentsFromStoreID(c, storeID)
+func PaymentsGetHandler(payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storeID := c.MustGet("storeID").(int)
+		GetFilteredPaymentsFromStoreID(c, payments, storeID)
+	}
+}
+
+// ExportFilteredPaymentsFromStoreID is called by both PaymentsExportGetHandler (in this file) and PaymentsExportGetHandler (in webapp/store/handler.go).
+// It accepts the same filtering and sorting query params of GetFilteredPaymentsFromStoreID, while pagination is ignored.
+func ExportFilteredPaymentsFromStoreID(c *gin.Context, payments repository.PaymentRepository, storeID int) {
+	format := c.DefaultQuery("format", export.FormatCSV)
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if format != export.FormatCSV && format != export.FormatJSONL && format != export.FormatXLSX {
+		httperror.Send(c, http.StatusUnprocessableEntity, ErrInvalidExportFormat.Error())
+		return
+	}
+
+	req, filters, ok := bindPaymentsGetRequest(c)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("payments-%s.%s", time.Now().UTC().Format("2006-01-02"), format)
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	// The write deadline is pushed forward on every flush, so that a stalled client is still dropped
+	rc := http.NewResponseController(c.Writer)
+	rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	flush := func() {
+		c.Writer.Flush()
+		rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	}
+
+	// Headers are already sent, so errors can only be logged
+	err := ExportPayments(payments, c.Writer, format, storeID, req.SortBy, req.OrderBy, filters, flush)
+	if err != nil {
+		c.Error(errors.Wrap(err, "Error exporting payments"))
+	}
+}
+
+// PaymentsExportGetHandler handles GET requests to /api/v1/payments/export
+func PaymentsExportGetHandler(payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storeID := c.MustGet("storeID").(int)
+		ExportFilteredPaymentsFromStoreID(c, payments, storeID)
+	}
+}
+
+type statsGetRequest struct {
+	Interval string `form:"interval,default=day"`
+	Timezone string `form:"timezone,default=UTC"`
+	From     string `form:"from,default="` // YYYY-MM-DD
+	To       string `form:"to,default="`   // YYYY-MM-DD
+}
+
+// GetStatsFromStoreID is called by both StatsGetHandler (in this file) and StatsGetHandler (in webapp/store/handler.go)
+func GetStatsFromStoreID(c *gin.Context, payments repository.PaymentRepository, storeID int) {
+	var req statsGetRequest
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		httperror.Send(c, http.StatusBadRequest, "Invalid query params")
+		return
+	}
+
+	r, err := NewStatsRange(req.Interval, req.Timezone, req.From, req.To)
+	if err != nil {
+		httperror.Send(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	stats, _, err := FetchStoreStats(payments, storeID, r)
+	if httperror.Send500IfErr(c, err, "Error fetching stats") != nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// StatsGetHandler handles GET requests to /api/v1/stats
+func StatsGetHandler(payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storeID := c.MustGet("storeID").(int)
+		GetStatsFromStoreID(c, payments, storeID)
+	}
+}
+
+type invoicePostRequest struct {
+	Currency  string           `json:"currency" binding:"required,max=4,min=3"`
+	Number    string           `json:"number"` // Optional. Invoice number of the store
+	Customer  *InvoiceCustomer `json:"customer" binding:"required"`
+	LineItems []LineItem       `json:"lineItems" binding:"required"` // Amount and tax of line items are calculated
+	DueDate   string           `json:"dueDate" binding:"required"`   // YYYY-MM-DD
+	Notes     string           `json:"notes"`
+}
+
+var invoicePostFieldsErrors = map[string]string{
+	"Currency":  ErrInvalidCurrency.Error(),
+	"Customer":  ErrInvalidCustomerName.Error(),
+	"LineItems": ErrInvalidLineItems.Error(),
+	"DueDate":   ErrInvalidDueDate.Error(),
+}
+
+// InvoicePostHandler handles POST requests to /api/v1/invoice
+func InvoicePostHandler(invoices repository.InvoiceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get and validate request params
+		var req invoicePostRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			errs, ok := err.(validator.ValidationErrors)
+			if !ok {
+				httperror.Send(c, http.StatusBadRequest, "Invalid request params")
+				return
+			}
+
+			for _, err := range errs {
+				httperror.Send(c, http.StatusUnprocessableEntity, invoicePostFieldsErrors[err.Field()])
+				return
+			}
+		}
+
+		storeID := c.MustGet("storeID").(int)
+
+		// Create Invoice
+		details := InvoiceDetails{
+			Number:    req.Number,
+			Customer:  *req.Customer,
+			LineItems: req.LineItems,
+			DueDate:   req.DueDate,
+			Notes:     req.Notes,
+		}
+		i, errCode, err := CreateNewInvoice(invoices, req.Currency, details, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error creating new invoice")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		// Insert Invoice into DB
+		err = i.Insert(invoices)
+		if httperror.Send500IfErr(c, err, "Error inserting Invoice into DB") != nil {
+			return
+		}
+
+		c.JSON(http.StatusCreated, i)
+	}
+}
+
+// InvoiceGetHandler handles GET requests to /api/v1/invoice/:invoice_id
+func InvoiceGetHandler(invoices repository.InvoiceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invoiceID := c.Param("invoice_id")
+		storeID := c.MustGet("storeID").(int)
+
+		i, errCode, err := FetchInvoiceFromID(invoices, invoiceID, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error fetching invoice from database")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, i)
+	}
+}
+
+// InvoiceVoidPostHandler handles POST requests to /api/v1/invoice/:invoice_id/void
+func InvoiceVoidPostHandler(payments repository.PaymentRepository, invoices repository.InvoiceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invoiceID := c.Param("invoice_id")
+		storeID := c.MustGet("storeID").(int)
+
+		i, errCode, err := VoidInvoice(payments, invoices, invoiceID, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error voiding invoice")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, i)
+	}
+}
+
+type subscriptionPostRequest struct {
+	Currency        string           `json:"currency" binding:"required,max=4,min=3"`
+	Amount          *decimal.Decimal `json:"amount" binding:"required"`
+	Interval        string           `json:"interval" binding:"required"`
+	IntervalCount   int              `json:"intervalCount"` // Optional. Defaults to 1
+	Customer        *InvoiceCustomer `json:"customer" binding:"required"`
+	Description     string           `json:"description"`
+	GracePeriodDays int              `json:"gracePeriodDays"`
+	StartDate       string           `json:"startDate"` // Optional. YYYY-MM-DD, defaults to today
+}
+
+var subscriptionPostFieldsErrors = map[string]string{
+	"Currency": ErrInvalidCurrency.Error(),
+	"Amount":   ErrInvalidSubscriptionAmount.Error(),
+	"Interval": ErrInvalidInterval.Error(),
+	"Customer": ErrInvalidSubscriptionEmail.Error(),
+}
+
+// SubscriptionPostHandler handles POST requests to /api/v1/subscription
+func SubscriptionPostHandler(subscriptions repository.SubscriptionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get and validate request params
+		var req subscriptionPostRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			errs, ok := err.(validator.ValidationErrors)
+			if !ok {
+				httperror.Send(c, http.StatusBadRequest, "Invalid request params")
+				return
+			}
+
+			for _, err := range errs {
+				httperror.Send(c, http.StatusUnprocessableEntity, subscriptionPostFieldsErrors[err.Field()])
+				return
+			}
+		}
+
+		storeID := c.MustGet("storeID").(int)
+
+		// Create Subscription
+		details := SubscriptionDetails{
+			Amount:          *req.Amount,
+			Interval:        req.Interval,
+			IntervalCount:   req.IntervalCount,
+			Customer:        *req.Customer,
+			Description:     req.Description,
+			GracePeriodDays: req.GracePeriodDays,
+			StartDate:       req.StartDate,
+		}
+		s, errCode, err := CreateNewSubscription(subscriptions, req.Currency, details, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error creating new subscription")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		// Insert Subscription into DB
+		err = s.Insert(subscriptions)
+		if httperror.Send500IfErr(c, err, "Error inserting Subscription into DB") != nil {
+			return
+		}
+
+		c.JSON(http.StatusCreated, s)
+	}
+}
+
+// SubscriptionGetHandler handles GET requests to /api/v1/subscription/:subscription_id
+func SubscriptionGetHandler(subscriptions repository.SubscriptionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subscriptionID := c.Param("subscription_id")
+		storeID := c.MustGet("storeID").(int)
+
+		s, errCode, err := FetchSubscriptionFromID(subscriptions, subscriptionID, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error fetching subscription from database")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, s)
+	}
+}
+
+// SubscriptionCancelPostHandler handles POST requests to /api/v1/subscription/:subscription_id/cancel
+func SubscriptionCancelPostHandler(subscriptions repository.SubscriptionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subscriptionID := c.Param("subscription_id")
+		storeID := c.MustGet("storeID").(int)
+
+		s, errCode, err := CancelSubscription(subscriptions, subscriptionID, storeID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error cancelling subscription")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, s)
+	}
 }
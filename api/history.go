@@ -0,0 +1,8 @@
+package api
+
+import (
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// StatusChange represents a transition of the status of a payment, as recorded in its status history
+type StatusChange = repository.StatusChange
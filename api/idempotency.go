@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/cryptoutil"
+	"github.com/peppinux/dero-merchant/httperror"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// Idempotency keys settings
+const (
+	IdempotencyKeyHeader       = "Idempotency-Key"
+	MaxIdempotencyKeyLength    = 255 // Chars
+	IdempotencyKeyTTL          = 24  // Hours a key and the response to its first request are stored for
+	IdempotencyKeyReclaimAfter = 5   // Minutes after which a key still being processed is assumed abandoned and can be reserved again
+)
+
+// IdempotencyKeysCleanerInterval is how often expired idempotency keys are deleted
+const IdempotencyKeysCleanerInterval = time.Hour
+
+// Idempotency keys errors
+var (
+	ErrInvalidIdempotencyKey    = errors.New("Invalid Header Idempotency-Key: required max 255 characters long string")
+	ErrIdempotencyKeyReused     = errors.New("Idempotency-Key already used for a request with different params")
+	ErrIdempotencyKeyInProgress = errors.New("A request with the same Idempotency-Key is still being processed")
+)
+
+// IdempotentResponse represents the response stored for the first request made with an idempotency key
+type IdempotentResponse struct {
+	StatusCode int
+	Body       []byte
+}
+
+// ReserveIdempotencyKey reserves an idempotency key of a store for a request whose body hashes to requestHash.
+// If the key was already used for the same request, the stored response is returned and has to be replayed.
+// Keys still being processed after IdempotencyKeyReclaimAfter minutes are reserved again.
+func ReserveIdempotencyKey(keys repository.IdempotencyRepository, storeID int, key, requestHash string) (resp *IdempotentResponse, errCode int, err error) {
+	stored, err := keys.Reserve(&repository.IdempotencyKey{
+		StoreID:     storeID,
+		Key:         key,
+		RequestHash: requestHash,
+	}, IdempotencyKeyReclaimAfter*time.Minute)
+	if err != nil {
+		if err == repository.ErrNotFound { // Key released by the request that reserved it in the meantime
+			return nil, http.StatusConflict, ErrIdempotencyKeyInProgress
+		}
+
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot reserve idempotency key")
+	}
+	if stored == nil { // Key reserved for this request
+		return nil, 0, nil
+	}
+
+	if stored.RequestHash != requestHash {
+		return nil, http.StatusUnprocessableEntity, ErrIdempotencyKeyReused
+	}
+	if stored.ResponseStatus == 0 {
+		return nil, http.StatusConflict, ErrIdempotencyKeyInProgress
+	}
+
+	return &IdempotentResponse{
+		StatusCode: stored.ResponseStatus,
+		Body:       stored.ResponseBody,
+	}, 0, nil
+}
+
+// SaveIdempotentResponse stores the response to the request an idempotency key was reserved for
+func SaveIdempotentResponse(keys repository.IdempotencyRepository, storeID int, key string, resp *IdempotentResponse) error {
+	err := keys.SaveResponse(storeID, key, resp.StatusCode, resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "cannot update idempotency key")
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes a reserved idempotency key, so that the request it was reserved for can be retried
+func ReleaseIdempotencyKey(keys repository.IdempotencyRepository, storeID int, key string) error {
+	err := keys.Delete(storeID, key)
+	if err != nil {
+		return errors.Wrap(err, "cannot delete idempotency key")
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes the idempotency keys first used more than IdempotencyKeyTTL hours ago
+func DeleteExpiredIdempotencyKeys(keys repository.IdempotencyRepository) error {
+	err := keys.DeleteExpired(IdempotencyKeyTTL * time.Hour)
+	if err != nil {
+		return errors.Wrap(err, "cannot delete expired idempotency keys")
+	}
+	return nil
+}
+
+// RunIdempotencyKeysCleaner deletes expired idempotency keys every IdempotencyKeysCleanerInterval. It is supposed to be run in its own goroutine.
+func RunIdempotencyKeysCleaner(keys repository.IdempotencyRepository) {
+	for {
+		err := DeleteExpiredIdempotencyKeys(keys)
+		if err != nil {
+			log.Println("Error cleaning idempotency keys:", err)
+		}
+
+		time.Sleep(IdempotencyKeysCleanerInterval)
+	}
+}
+
+// responseRecorder is a gin.ResponseWriter that keeps a copy of the body written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotent provides a middleware that makes requests with an Idempotency-Key header safe to retry.
+// The first successful response to a key is stored and replayed to following requests with the same key and body,
+// while requests reusing the key with a different body are rejected.
+// Requests that fail release the key, so that they can be retried. Needs to be used in conjuction with APIKeyAuth.
+func Idempotent(keys repository.IdempotencyRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" { // Idempotency keys are optional
+			c.Next()
+			return
+		}
+		if len(key) > MaxIdempotencyKeyLength {
+			httperror.Send(c, http.StatusBadRequest, ErrInvalidIdempotencyKey.Error())
+			return
+		}
+
+		body, err := c.GetRawData()
+		if httperror.Send500IfErr(c, err, "Error reading request body") != nil {
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body)) // Restore body so that it can be read by handler
+
+		storeID := c.MustGet("storeID").(int)
+		requestHash := cryptoutil.HashStringToSHA256Hex(c.Request.Method + " " + c.Request.URL.Path + "\n" + string(body))
+
+		resp, errCode, err := ReserveIdempotencyKey(keys, storeID, key, requestHash)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				httperror.Send500(c, err, "Error reserving idempotency key")
+				return
+			}
+
+			httperror.Send(c, errCode, err.Error())
+			return
+		}
+		if resp != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(resp.StatusCode, gin.MIMEJSON+"; charset=utf-8", resp.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		statusCode := recorder.Status()
+		if statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices {
+			err = SaveIdempotentResponse(keys, storeID, key, &IdempotentResponse{
+				StatusCode: statusCode,
+				Body:       recorder.body.Bytes(),
+			})
+			if err != nil {
+				// Release the key rather than leaving it in progress, so that the request can be retried
+				if releaseErr := ReleaseIdempotencyKey(keys, storeID, key); releaseErr != nil {
+					err = errors.Wrapf(err, "cannot release idempotency key (%v)", releaseErr)
+				}
+			}
+		} else {
+			err = ReleaseIdempotencyKey(keys, storeID, key)
+		}
+		if err != nil {
+			c.Error(err)
+		}
+	}
+}
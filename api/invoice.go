@@ -0,0 +1,434 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+	"github.com/peppinux/dero-merchant/stringutil"
+)
+
+// Invoice limits
+const (
+	MaxInvoiceNumberLength       = 64   // Chars
+	MaxCustomerNameLength        = 128  // Chars
+	MaxCustomerAddressLength     = 512  // Chars
+	MaxInvoiceNotesLength        = 1024 // Chars
+	MaxLineItems                 = 100
+	MaxLineItemDescriptionLength = 256 // Chars
+	InvoiceDueDateLayout         = "2006-01-02"
+)
+
+// Invoice validation errors
+var (
+	ErrInvalidInvoiceNumber   = errors.New("Invalid Param 'number': required max 64 chars long string")
+	ErrInvalidCustomerName    = errors.New("Invalid Param 'customer.name': required non-empty string max 128 chars long")
+	ErrInvalidCustomerAddress = errors.New("Invalid Param 'customer.address': required max 512 chars long string")
+	ErrInvalidInvoiceEmail    = errors.New("Invalid Param 'customer.email': required valid email address max 64 chars long")
+	ErrInvalidLineItems       = errors.New("Invalid Param 'lineItems': required between 1 and 100 line items")
+	ErrInvalidLineItem        = errors.New("Invalid Param 'lineItems': every line item requires a non-empty description max 256 chars long, a positive quantity, a non-negative unit price and a tax rate between 0 and 100")
+	ErrInvalidDueDate         = errors.New("Invalid Param 'dueDate': required date in YYYY-MM-DD format not in the past")
+	ErrInvalidInvoiceNotes    = errors.New("Invalid Param 'notes': required max 1024 chars long string")
+	ErrInvalidInvoiceTotal    = errors.New("Invoice total needs to be a positive amount")
+)
+
+// InvoiceCustomer represents the customer an invoice is billed to
+type InvoiceCustomer struct {
+	Name    string `json:"name"`
+	Email   string `json:"email,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// LineItem represents a line of an invoice
+type LineItem struct {
+	Description string          `json:"description"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	UnitPrice   decimal.Decimal `json:"unitPrice"`
+	TaxRate     decimal.Decimal `json:"taxRate"` // Percentage of Amount
+	// Amount (Quantity * UnitPrice) and Tax are calculated and rounded to the decimals of the currency of the invoice
+	Amount decimal.Decimal `json:"amount"`
+	Tax    decimal.Decimal `json:"tax"`
+}
+
+// Invoice represents an invoice issued by a store to a customer, to be paid in DERO through a payment
+type Invoice struct {
+	InvoiceID string          `json:"invoiceID"`
+	Number    string          `json:"number,omitempty"`
+	Status    string          `json:"status"`
+	Currency  string          `json:"currency"`
+	Customer  InvoiceCustomer `json:"customer"`
+	LineItems []LineItem      `json:"lineItems"`
+	Subtotal  decimal.Decimal `json:"subtotal"`
+	TaxTotal  decimal.Decimal `json:"taxTotal"`
+	Total     decimal.Decimal `json:"total"`
+	DueDate   string          `json:"dueDate"` // YYYY-MM-DD
+	Notes     string          `json:"notes,omitempty"`
+	// PaymentID is the Payment ID of the latest payment created to pay the invoice (or of the payment it was paid with)
+	PaymentID    string     `json:"paymentID,omitempty"`
+	CreationTime time.Time  `json:"creationTime"`
+	PaidTime     *time.Time `json:"paidTime,omitempty"`
+	ID           int        `json:"-"`
+	// SubscriptionID is the ID of the subscription the invoice was issued for, if any
+	SubscriptionID int `json:"-"`
+	StoreID        int `json:"-"`
+}
+
+// InvoiceDetails are the details of a new invoice
+type InvoiceDetails struct {
+	Number    string
+	Customer  InvoiceCustomer
+	LineItems []LineItem
+	DueDate   string
+	Notes     string
+}
+
+// IsOpen returns whether Invoice can still be paid
+func (i *Invoice) IsOpen() bool {
+	return i.Status == processor.InvoiceStatusOpen || i.Status == processor.InvoiceStatusOverdue
+}
+
+// CalculateTotals calculates the amount and tax of every line item of Invoice and the totals of Invoice,
+// rounding them to the decimals of its currency
+func (i *Invoice) CalculateTotals() {
+	decimals := CurrencyDecimals(i.Currency)
+	hundred := decimal.NewFromInt(100)
+
+	i.Subtotal = decimal.Zero
+	i.TaxTotal = decimal.Zero
+	for n := range i.LineItems {
+		item := &i.LineItems[n]
+		item.Amount = item.Quantity.Mul(item.UnitPrice).Round(decimals)
+		item.Tax = item.Amount.Mul(item.TaxRate).Div(hundred).Round(decimals)
+
+		i.Subtotal = i.Subtotal.Add(item.Amount)
+		i.TaxTotal = i.TaxTotal.Add(item.Tax)
+	}
+	i.Total = i.Subtotal.Add(i.TaxTotal)
+}
+
+func sanitizeInvoiceDetails(d *InvoiceDetails) error {
+	d.Number = strings.TrimSpace(d.Number)
+	if utf8.RuneCountInString(d.Number) > MaxInvoiceNumberLength {
+		return ErrInvalidInvoiceNumber
+	}
+
+	c := &d.Customer
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" || utf8.RuneCountInString(c.Name) > MaxCustomerNameLength {
+		return ErrInvalidCustomerName
+	}
+	c.Email = strings.TrimSpace(c.Email)
+	if c.Email != "" && !isValidEmail(c.Email) {
+		return ErrInvalidInvoiceEmail
+	}
+	c.Address = strings.TrimSpace(c.Address)
+	if utf8.RuneCountInString(c.Address) > MaxCustomerAddressLength {
+		return ErrInvalidCustomerAddress
+	}
+
+	if len(d.LineItems) == 0 || len(d.LineItems) > MaxLineItems {
+		return ErrInvalidLineItems
+	}
+	hundred := decimal.NewFromInt(100)
+	for n := range d.LineItems {
+		item := &d.LineItems[n]
+		item.Description = strings.TrimSpace(item.Description)
+		if item.Description == "" || utf8.RuneCountInString(item.Description) > MaxLineItemDescriptionLength ||
+			!item.Quantity.IsPositive() || item.UnitPrice.IsNegative() || item.TaxRate.IsNegative() || item.TaxRate.GreaterThan(hundred) {
+			return ErrInvalidLineItem
+		}
+	}
+
+	d.DueDate = strings.TrimSpace(d.DueDate)
+	dueDate, err := time.Parse(InvoiceDueDateLayout, d.DueDate)
+	if err != nil || dueDate.Before(time.Now().UTC().Truncate(24*time.Hour)) {
+		return ErrInvalidDueDate
+	}
+
+	d.Notes = strings.TrimSpace(d.Notes)
+	if utf8.RuneCountInString(d.Notes) > MaxInvoiceNotesLength {
+		return ErrInvalidInvoiceNotes
+	}
+
+	return nil
+}
+
+// GenerateUniqueInvoiceID generates an Invoice ID that is not already in use by other invoices
+func GenerateUniqueInvoiceID(invoices repository.InvoiceRepository) (invoiceID string, err error) {
+	for {
+		invoiceID, err = stringutil.RandomHexString(32)
+		if err != nil {
+			return "", errors.Wrap(err, "cannot generate random hex string")
+		}
+
+		exists, err := invoices.InvoiceIDExists(invoiceID)
+		if err != nil {
+			return "", errors.Wrap(err, "cannot check if invoice ID is unique")
+		}
+
+		if !exists {
+			break
+		}
+	}
+
+	return
+}
+
+// CreateNewInvoice returns a new open Invoice ready to be stored in DB
+func CreateNewInvoice(invoices repository.InvoiceRepository, currency string, details InvoiceDetails, storeID int) (i *Invoice, errCode int, err error) {
+	i = &Invoice{
+		Status:  processor.InvoiceStatusOpen,
+		StoreID: storeID,
+	}
+
+	// Validate params
+	i.Currency = strings.ToUpper(currency)
+	if !IsSupportedCurrency(i.Currency) {
+		return nil, http.StatusUnprocessableEntity, ErrInvalidCurrency
+	}
+	err = sanitizeInvoiceDetails(&details)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+
+	i.Number = details.Number
+	i.Customer = details.Customer
+	i.LineItems = details.LineItems
+	i.DueDate = details.DueDate
+	i.Notes = details.Notes
+
+	i.CalculateTotals()
+	if !i.Total.IsPositive() {
+		return nil, http.StatusUnprocessableEntity, ErrInvalidInvoiceTotal
+	}
+
+	i.InvoiceID, err = GenerateUniqueInvoiceID(invoices)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot generate unique invoice ID")
+	}
+
+	return
+}
+
+// record returns the record Invoice is stored as
+func (i *Invoice) record() (*repository.Invoice, error) {
+	lineItems, err := json.Marshal(i.LineItems)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal line items")
+	}
+
+	return &repository.Invoice{
+		ID:              i.ID,
+		InvoiceID:       i.InvoiceID,
+		Number:          i.Number,
+		Status:          i.Status,
+		Currency:        i.Currency,
+		CustomerName:    i.Customer.Name,
+		CustomerEmail:   i.Customer.Email,
+		CustomerAddress: i.Customer.Address,
+		LineItems:       lineItems,
+		Subtotal:        i.Subtotal,
+		TaxTotal:        i.TaxTotal,
+		Total:           i.Total,
+		DueDate:         i.DueDate,
+		Notes:           i.Notes,
+		PaymentID:       i.PaymentID,
+		CreationTime:    i.CreationTime,
+		PaidTime:        i.PaidTime,
+		SubscriptionID:  i.SubscriptionID,
+		StoreID:         i.StoreID,
+	}, nil
+}
+
+// invoiceFromRecord returns the Invoice stored as r. Open invoices past their due date are reported as overdue.
+func invoiceFromRecord(r *repository.Invoice) (*Invoice, error) {
+	i := &Invoice{
+		InvoiceID: r.InvoiceID,
+		Number:    r.Number,
+		Status:    r.Status,
+		Currency:  r.Currency,
+		Customer: InvoiceCustomer{
+			Name:    r.CustomerName,
+			Email:   r.CustomerEmail,
+			Address: r.CustomerAddress,
+		},
+		Subtotal:       r.Subtotal,
+		TaxTotal:       r.TaxTotal,
+		Total:          r.Total,
+		DueDate:        r.DueDate,
+		Notes:          r.Notes,
+		PaymentID:      r.PaymentID,
+		CreationTime:   r.CreationTime,
+		PaidTime:       r.PaidTime,
+		ID:             r.ID,
+		SubscriptionID: r.SubscriptionID,
+		StoreID:        r.StoreID,
+	}
+	if r.IsOverdue() {
+		i.Status = processor.InvoiceStatusOverdue
+	}
+
+	err := json.Unmarshal(r.LineItems, &i.LineItems)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot unmarshal line items")
+	}
+
+	return i, nil
+}
+
+// Insert inserts an Invoice into DB
+func (i *Invoice) Insert(invoices repository.InvoiceRepository) error {
+	r, err := i.record()
+	if err != nil {
+		return err
+	}
+
+	err = invoices.Insert(r)
+	if err != nil {
+		return err
+	}
+	i.ID, i.CreationTime = r.ID, r.CreationTime
+
+	return nil
+}
+
+// ErrInvoiceNotFound is returned when an invoice does not exist or is not issued by the store
+var ErrInvoiceNotFound = errors.New("Invoice not found")
+
+// FetchInvoiceFromID returns an Invoice of a store fetched from DB based on its Invoice ID
+func FetchInvoiceFromID(invoices repository.InvoiceRepository, invoiceID string, storeID int) (i *Invoice, errCode int, err error) {
+	i, errCode, err = FetchPublicInvoice(invoices, invoiceID)
+	if err != nil {
+		return nil, errCode, err
+	}
+	if i.StoreID != storeID {
+		return nil, http.StatusNotFound, ErrInvoiceNotFound
+	}
+
+	return
+}
+
+// FetchPublicInvoice returns an Invoice fetched from DB based on its Invoice ID, regardless of the store that issued it.
+// Used by the hosted invoice page.
+func FetchPublicInvoice(invoices repository.InvoiceRepository, invoiceID string) (i *Invoice, errCode int, err error) {
+	r, err := invoices.ByInvoiceID(invoiceID)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, http.StatusNotFound, ErrInvoiceNotFound
+		}
+
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch invoice")
+	}
+
+	i, err = invoiceFromRecord(r)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return
+}
+
+// Invoice payment errors
+var (
+	ErrInvoiceNotOpen = errors.New("Invoice is not open")
+)
+
+// PayInvoice returns the Payment ID of the pending payment of an open invoice.
+// If the invoice has no pending payment, a new payment for its total is created at the current exchange rate.
+// The invoice is marked as paid by the processor once the payment is paid.
+func PayInvoice(stores repository.StoreRepository, payments repository.PaymentRepository, invoices repository.InvoiceRepository, invoiceID string) (paymentID string, errCode int, err error) {
+	i, errCode, err := FetchPublicInvoice(invoices, invoiceID)
+	if err != nil {
+		return "", errCode, err
+	}
+	if !i.IsOpen() {
+		return "", http.StatusConflict, ErrInvoiceNotOpen
+	}
+
+	pending, err := payments.ByInvoice(i.ID, processor.PaymentStatusPending)
+	if err == nil {
+		return pending.PaymentID, 0, nil
+	}
+	if err != repository.ErrNotFound {
+		return "", http.StatusInternalServerError, errors.Wrap(err, "cannot get pending payment of invoice")
+	}
+
+	// The payment is quoted before locking the invoice, since the exchange rate is fetched from a remote API
+	description := "Invoice"
+	if i.Number != "" {
+		description += " " + i.Number
+	}
+	opts := PaymentOptions{
+		Metadata: processor.PaymentMetadata{
+			OrderID:       i.Number,
+			Description:   description,
+			CustomerEmail: i.Customer.Email,
+		},
+		InvoiceID: i.ID,
+	}
+	p, w, errCode, err := CreateNewPayment(stores, payments, i.Currency, i.Total, opts, i.StoreID)
+	if err != nil {
+		return "", errCode, err
+	}
+
+	// Insert the payment and link the invoice to it, unless another request created a pending payment for it in the meantime
+	r := p.record()
+	paymentID, err = payments.InsertForInvoice(r, processor.StatusCauseCreated)
+	switch {
+	case err == repository.ErrInvoiceNotOpen: // Invoice was paid or voided in the meantime
+		return "", http.StatusConflict, ErrInvoiceNotOpen
+	case err != nil:
+		return "", http.StatusInternalServerError, errors.Wrap(err, "cannot insert payment into DB")
+	case paymentID != "":
+		return paymentID, 0, nil
+	}
+	p.CreationTime = r.CreationTime
+	p.notifyCreated()
+
+	err = w.AddPendingPayment(p.PaymentID, p.PendingPayment())
+	if err != nil {
+		return "", http.StatusInternalServerError, errors.Wrap(err, "cannot add pending payment to wallet")
+	}
+
+	return p.PaymentID, 0, nil
+}
+
+// VoidInvoice voids an open invoice of a store, so that it can no longer be paid. Its pending payment, if any, is cancelled.
+func VoidInvoice(payments repository.PaymentRepository, invoices repository.InvoiceRepository, invoiceID string, storeID int) (i *Invoice, errCode int, err error) {
+	i, errCode, err = FetchInvoiceFromID(invoices, invoiceID, storeID)
+	if err != nil {
+		return nil, errCode, err
+	}
+	if !i.IsOpen() {
+		return nil, http.StatusConflict, ErrInvoiceNotOpen
+	}
+
+	err = invoices.Void(i.ID)
+	if err == repository.ErrInvoiceNotOpen { // Invoice was paid in the meantime
+		return nil, http.StatusConflict, ErrInvoiceNotOpen
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot void invoice")
+	}
+	i.Status = processor.InvoiceStatusVoid
+
+	pending, err := payments.ByInvoice(i.ID, processor.PaymentStatusPending)
+	if err != nil && err != repository.ErrNotFound {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot get pending payment of invoice")
+	}
+	if pending != nil {
+		_, errCode, err = CancelPayment(payments, pending.PaymentID, storeID, processor.StatusCauseInvoiceVoid)
+		if err != nil && err != ErrPaymentNotPending {
+			return nil, errCode, errors.Wrap(err, "cannot cancel pending payment of invoice")
+		}
+	}
+
+	return i, 0, nil
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoiceCalculateTotals(t *testing.T) {
+	i := &Invoice{
+		Currency: "USD",
+		LineItems: []LineItem{
+			{Description: "Consulting", Quantity: decimal.RequireFromString("2.5"), UnitPrice: decimal.RequireFromString("100"), TaxRate: decimal.RequireFromString("22")},
+			{Description: "Hosting", Quantity: decimal.RequireFromString("3"), UnitPrice: decimal.RequireFromString("9.99"), TaxRate: decimal.RequireFromString("7.5")},
+			{Description: "Discounted item", Quantity: decimal.RequireFromString("1"), UnitPrice: decimal.RequireFromString("0.333"), TaxRate: decimal.Zero},
+		},
+	}
+
+	i.CalculateTotals()
+	assert.Equal(t, "250", i.LineItems[0].Amount.String())
+	assert.Equal(t, "55", i.LineItems[0].Tax.String())
+	assert.Equal(t, "29.97", i.LineItems[1].Amount.String())
+	assert.Equal(t, "2.25", i.LineItems[1].Tax.String()) // 2.24775 rounded to 2 decimals
+	assert.Equal(t, "0.33", i.LineItems[2].Amount.String())
+	assert.Equal(t, "280.3", i.Subtotal.String())
+	assert.Equal(t, "57.25", i.TaxTotal.String())
+	assert.Equal(t, "337.55", i.Total.String())
+}
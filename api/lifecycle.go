@@ -0,0 +1,209 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/config"
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// Payment cancellation, extension and re-quote errors
+var (
+	ErrPaymentNotPending      = errors.New("Payment is not pending")
+	ErrPaymentNotExpired      = errors.New("Only expired payments can be re-quoted")
+	ErrPaymentAlreadyRequoted = errors.New("Payment was already re-quoted")
+	ErrPaymentLinkDisabled    = errors.New("Payment link of the payment is disabled")
+	ErrInvoicePaymentPending  = errors.New("Invoice of the payment already has a pending payment")
+	ErrPaymentTTLElapsed      = errors.New("Payment TTL has already elapsed")
+	ErrInvalidExtendMinutes   = errors.New("Invalid Param 'minutes': required number of minutes between 1 and the max TTL allowed by the server minus the minutes left")
+	errPendingPaymentMissing  = errors.New("pending payment not found in wallet")
+)
+
+// fetchPendingPayment returns a pending Payment of a store fetched from DB and the wallet listening to it
+func fetchPendingPayment(payments repository.PaymentRepository, paymentID string, storeID int) (p *Payment, w *processor.StoreWallet, errCode int, err error) {
+	p, errCode, err = FetchPaymentFromID(payments, paymentID, storeID)
+	if err != nil {
+		return
+	}
+	if p.Status != processor.PaymentStatusPending {
+		return nil, nil, http.StatusConflict, ErrPaymentNotPending
+	}
+
+	w, err = processor.ActiveWallets.GetWalletFromStoreID(storeID)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.Wrap(err, "cannot get wallet from Store ID")
+	}
+
+	return
+}
+
+// CancelPayment cancels a pending payment of a store, so that it is no longer checked for.
+// cause is recorded in the status history of the payment.
+func CancelPayment(payments repository.PaymentRepository, paymentID string, storeID int, cause string) (p *Payment, errCode int, err error) {
+	p, w, errCode, err := fetchPendingPayment(payments, paymentID, storeID)
+	if err != nil {
+		return nil, errCode, err
+	}
+
+	err = w.CancelPendingPayment(paymentID, cause)
+	if err != nil {
+		if err == processor.ErrPaymentNotPending { // Payment was paid or expired in the meantime
+			return nil, http.StatusConflict, ErrPaymentNotPending
+		}
+
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot cancel pending payment")
+	}
+
+	p.Status = processor.PaymentStatusCancelled
+	p.TTL = 0
+
+	return
+}
+
+// ExtendPayment adds minutes to the TTL of a pending payment of a store, as long as the minutes left do not exceed the max TTL allowed by the server.
+// If requote is true, the amount of DERO due is also updated to the current exchange rate.
+// Concurrent extensions add up, since minutes are added to the TTL stored in DB.
+func ExtendPayment(payments repository.PaymentRepository, paymentID string, storeID int, minutes int, requote bool) (p *Payment, errCode int, err error) {
+	p, w, errCode, err := fetchPendingPayment(payments, paymentID, storeID)
+	if err != nil {
+		return nil, errCode, err
+	}
+	if p.TTL == 0 { // Payment is about to be marked as expired
+		return nil, http.StatusConflict, ErrPaymentTTLElapsed
+	}
+	if minutes < 1 || p.TTL+minutes > config.PaymentMaxTTL {
+		return nil, http.StatusUnprocessableEntity, ErrInvalidExtendMinutes
+	}
+
+	pending, ok := w.PendingPayments.Get(paymentID)
+	if !ok {
+		return nil, http.StatusInternalServerError, errPendingPaymentMissing
+	}
+
+	var quote *repository.PaymentQuote
+	if requote {
+		p.confirmationTiers = pending.ConfirmationTiers
+		errCode, err = p.quote()
+		if err != nil {
+			return nil, errCode, err
+		}
+
+		quote = &repository.PaymentQuote{
+			ExchangeRate:          p.ExchangeRate,
+			DeroAmount:            p.DeroAmount,
+			AtomicDeroAmount:      p.AtomicDeroAmount,
+			MinAtomicDeroAmount:   p.MinAtomicDeroAmount,
+			RequiredConfirmations: p.RequiredConfirmations,
+		}
+	}
+
+	// Update Payment in DB. The TTL is checked again, since the payment may have been extended in the meantime.
+	r, err := payments.Extend(paymentID, storeID, processor.PaymentStatusPending, minutes, config.PaymentMaxTTL, quote)
+	if err == repository.ErrNotFound { // Payment was paid, expired or extended in the meantime
+		errCode, err = extendErr(payments, paymentID, storeID)
+		return nil, errCode, err
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot extend payment")
+	}
+	p = paymentFromRecord(r)
+
+	// Replace the pending payment the wallet listens to with a copy updated to the row in DB.
+	// If another extension updated it in the meantime, the longer TTL (i.e. the latest update) is kept.
+	extended := *pending
+	extended.TTL = p.MaxTTL
+	extended.AtomicDeroAmount = p.AtomicDeroAmount
+	extended.MinAtomicDeroAmount = p.MinAtomicDeroAmount
+	w.PendingPayments.Replace(paymentID, &extended)
+
+	return
+}
+
+// extendErr returns the error code and the error of an extension of a payment of a store that did not update its row in DB
+func extendErr(payments repository.PaymentRepository, paymentID string, storeID int) (errCode int, err error) {
+	p, errCode, err := FetchPaymentFromID(payments, paymentID, storeID)
+	switch {
+	case err != nil:
+		return errCode, err
+	case p.Status != processor.PaymentStatusPending:
+		return http.StatusConflict, ErrPaymentNotPending
+	case p.TTL == 0:
+		return http.StatusConflict, ErrPaymentTTLElapsed
+	default:
+		return http.StatusUnprocessableEntity, ErrInvalidExtendMinutes
+	}
+}
+
+// RequotePayment creates a new payment for the same amount of currency of an expired payment of a store, at the current exchange rate,
+// and links the two payments to each other. The new payment has a new integrated address, carries over the metadata and redirect URLs of the expired one
+// (as well as the payment link or invoice it was created from) and is subject to the current pricing policy and payment requirements of the store.
+// An expired payment can only be re-quoted once, and only while its payment link is still enabled or its invoice still open.
+func RequotePayment(stores repository.StoreRepository, payments repository.PaymentRepository, links repository.LinkRepository, paymentID string, storeID int) (p *Payment, errCode int, err error) {
+	expired, errCode, err := FetchPaymentFromID(payments, paymentID, storeID)
+	if err != nil {
+		return nil, errCode, err
+	}
+	if expired.Status != processor.PaymentStatusExpired {
+		return nil, http.StatusConflict, ErrPaymentNotExpired
+	}
+	if expired.RequotedTo != "" {
+		return nil, http.StatusConflict, ErrPaymentAlreadyRequoted
+	}
+
+	if expired.PaymentLinkID != 0 {
+		l, err := links.ByID(expired.PaymentLinkID)
+		if err != nil && err != repository.ErrNotFound {
+			return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch payment link")
+		}
+		if err == repository.ErrNotFound || !l.Active || l.Removed {
+			return nil, http.StatusConflict, ErrPaymentLinkDisabled
+		}
+	}
+
+	opts := PaymentOptions{
+		Metadata:      expired.PaymentMetadata,
+		SuccessURL:    expired.SuccessURL,
+		CancelURL:     expired.CancelURL,
+		PaymentLinkID: expired.PaymentLinkID,
+		InvoiceID:     expired.InvoiceID,
+	}
+	p, w, errCode, err := CreateNewPayment(stores, payments, expired.Currency, expired.CurrencyAmount, opts, storeID)
+	if err != nil {
+		return nil, errCode, err
+	}
+	p.RequotedFrom = expired.PaymentID
+
+	// Insert the payment and link the expired payment (and the invoice, if any) to it
+	r := p.record()
+	existingPaymentID, err := payments.Requote(r, processor.StatusCauseCreated)
+	switch {
+	case err == repository.ErrInvoiceNotOpen: // Invoice was paid or voided in the meantime
+		return nil, http.StatusConflict, ErrInvoiceNotOpen
+	case err != nil:
+		// Requote fails if the payment was re-quoted in the meantime, since requoted_from is unique
+		if _, err := payments.ByRequotedFrom(expired.PaymentID); err == nil {
+			return nil, http.StatusConflict, ErrPaymentAlreadyRequoted
+		}
+
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot insert payment into DB")
+	case existingPaymentID != "": // Invoice was paid through the invoice pay page in the meantime
+		return nil, http.StatusConflict, ErrInvoicePaymentPending
+	}
+	p.CreationTime = r.CreationTime
+	p.notifyCreated()
+
+	err = w.AddPendingPayment(p.PaymentID, p.PendingPayment())
+	if err != nil {
+		// Nobody would check for the payment, so it cannot be left pending
+		cancelErr := payments.ChangeStatus(p.PaymentID, processor.PaymentStatusPending, processor.PaymentStatusCancelled, processor.StatusCauseWalletError, nil, false)
+		if cancelErr != nil {
+			err = errors.Wrapf(err, "cannot cancel payment (%v)", cancelErr)
+		}
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot add pending payment to wallet")
+	}
+
+	return
+}
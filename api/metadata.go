@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/processor"
+)
+
+// Payment metadata limits
+const (
+	MaxOrderIDLength       = 64   // Chars
+	MaxDescriptionLength   = 256  // Chars
+	MaxCustomerEmailLength = 64   // Chars
+	MaxMetadataSize        = 4096 // Bytes of compacted JSON
+)
+
+// Payment metadata validation errors
+var (
+	ErrInvalidOrderID       = errors.New("Invalid Param 'orderID': required max 64 chars long string")
+	ErrInvalidDescription   = errors.New("Invalid Param 'description': required max 256 chars long string")
+	ErrInvalidCustomerEmail = errors.New("Invalid Param 'customerEmail': required valid email address max 64 chars long")
+	ErrInvalidMetadata      = errors.New("Invalid Param 'metadata': required JSON object max 4096 bytes long")
+)
+
+// isValidEmail returns whether email is a plain email address (without display name) max MaxCustomerEmailLength chars long
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email && utf8.RuneCountInString(email) <= MaxCustomerEmailLength
+}
+
+// SanitizePaymentMetadata trims the fields of PaymentMetadata, compacts its JSON metadata
+// and returns an error if any of them is invalid or exceeds its limits
+func SanitizePaymentMetadata(m *processor.PaymentMetadata) error {
+	m.OrderID = strings.TrimSpace(m.OrderID)
+	if utf8.RuneCountInString(m.OrderID) > MaxOrderIDLength {
+		return ErrInvalidOrderID
+	}
+
+	m.Description = strings.TrimSpace(m.Description)
+	if utf8.RuneCountInString(m.Description) > MaxDescriptionLength {
+		return ErrInvalidDescription
+	}
+
+	m.CustomerEmail = strings.TrimSpace(m.CustomerEmail)
+	if m.CustomerEmail != "" && !isValidEmail(m.CustomerEmail) {
+		return ErrInvalidCustomerEmail
+	}
+
+	metadata := bytes.TrimSpace(m.Metadata)
+	if len(metadata) == 0 || bytes.Equal(metadata, []byte("null")) {
+		m.Metadata = nil
+		return nil
+	}
+	if metadata[0] != '{' {
+		return ErrInvalidMetadata
+	}
+
+	var compacted bytes.Buffer
+	err := json.Compact(&compacted, metadata)
+	if err != nil || compacted.Len() > MaxMetadataSize {
+		return ErrInvalidMetadata
+	}
+	m.Metadata = compacted.Bytes()
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peppinux/dero-merchant/processor"
+)
+
+func TestSanitizePaymentMetadata(t *testing.T) {
+	tests := []struct {
+		Metadata processor.PaymentMetadata
+
+		Expected    processor.PaymentMetadata
+		ExpectedErr error
+	}{
+		{Metadata: processor.PaymentMetadata{}, Expected: processor.PaymentMetadata{}},
+		{
+			Metadata: processor.PaymentMetadata{OrderID: " #1234 ", Description: "2x T-shirt", CustomerEmail: "customer@example.com", Metadata: json.RawMessage(`{ "cart": [1, 2] }`)},
+			Expected: processor.PaymentMetadata{OrderID: "#1234", Description: "2x T-shirt", CustomerEmail: "customer@example.com", Metadata: json.RawMessage(`{"cart":[1,2]}`)},
+		},
+		{Metadata: processor.PaymentMetadata{Metadata: json.RawMessage(`null`)}, Expected: processor.PaymentMetadata{}},
+		{Metadata: processor.PaymentMetadata{OrderID: strings.Repeat("a", MaxOrderIDLength+1)}, ExpectedErr: ErrInvalidOrderID},
+		{Metadata: processor.PaymentMetadata{Description: strings.Repeat("a", MaxDescriptionLength+1)}, ExpectedErr: ErrInvalidDescription},
+		{Metadata: processor.PaymentMetadata{CustomerEmail: "customer"}, ExpectedErr: ErrInvalidCustomerEmail},
+		{Metadata: processor.PaymentMetadata{CustomerEmail: "Customer <customer@example.com>"}, ExpectedErr: ErrInvalidCustomerEmail},
+		{Metadata: processor.PaymentMetadata{Metadata: json.RawMessage(`[1, 2]`)}, ExpectedErr: ErrInvalidMetadata},
+		{Metadata: processor.PaymentMetadata{Metadata: json.RawMessage(`{"a":`)}, ExpectedErr: ErrInvalidMetadata},
+		{Metadata: processor.PaymentMetadata{Metadata: json.RawMessage(`{"a":"` + strings.Repeat("a", MaxMetadataSize) + `"}`)}, ExpectedErr: ErrInvalidMetadata},
+	}
+
+	for _, test := range tests {
+		err := SanitizePaymentMetadata(&test.Metadata)
+		assert.Equal(t, test.ExpectedErr, err)
+		if err == nil {
+			assert.Equal(t, test.Expected.OrderID, test.Metadata.OrderID)
+			assert.Equal(t, test.Expected.Description, test.Metadata.Description)
+			assert.Equal(t, test.Expected.CustomerEmail, test.Metadata.CustomerEmail)
+			assert.Equal(t, string(test.Expected.Metadata), string(test.Metadata.Metadata))
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"math/big"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// PricingPolicy represents the pricing settings of a store, applied to its payments when they are created and verified
+type PricingPolicy struct {
+	// RateMarkup is the percentage added to the amount of DERO due for payments in currencies other than DERO, to cover exchange rate volatility
+	RateMarkup decimal.Decimal `json:"rateMarkup"`
+	// UnderpaymentTolerance is the percentage of the amount of DERO due a payment can be short of and still be considered paid
+	UnderpaymentTolerance decimal.Decimal `json:"underpaymentTolerance"`
+	// DeroDecimals is the number of decimals the amount of DERO due is rounded UP to
+	DeroDecimals int32 `json:"deroDecimals"`
+}
+
+// Pricing policy limits
+var (
+	MaxRateMarkup            = decimal.NewFromInt(50)
+	MaxUnderpaymentTolerance = decimal.NewFromInt(10)
+)
+
+// percentageDecimals is the max number of decimals of percentages of PricingPolicy
+const percentageDecimals = 2
+
+// DefaultPricingPolicy returns the pricing policy of stores that did not set one: no markup, no tolerance, no rounding
+func DefaultPricingPolicy() PricingPolicy {
+	return PricingPolicy{
+		RateMarkup:            decimal.Zero,
+		UnderpaymentTolerance: decimal.Zero,
+		DeroDecimals:          DeroDecimals,
+	}
+}
+
+// PricingPolicy validation errors
+var (
+	ErrInvalidRateMarkup            = errors.New("Invalid rate markup: required percentage between 0 and 50 with max 2 decimals")
+	ErrInvalidUnderpaymentTolerance = errors.New("Invalid underpayment tolerance: required percentage between 0 and 10 with max 2 decimals")
+	ErrInvalidDeroDecimals          = errors.New("Invalid DERO decimals: required integer between 0 and 12")
+)
+
+func isValidPercentage(percentage, max decimal.Decimal) bool {
+	return !percentage.IsNegative() && percentage.LessThanOrEqual(max) && percentage.Equal(percentage.Round(percentageDecimals))
+}
+
+// Validate returns an error if any of the settings of PricingPolicy is out of bounds
+func (pp *PricingPolicy) Validate() error {
+	if !isValidPercentage(pp.RateMarkup, MaxRateMarkup) {
+		return ErrInvalidRateMarkup
+	}
+	if !isValidPercentage(pp.UnderpaymentTolerance, MaxUnderpaymentTolerance) {
+		return ErrInvalidUnderpaymentTolerance
+	}
+	if pp.DeroDecimals < 0 || pp.DeroDecimals > DeroDecimals {
+		return ErrInvalidDeroDecimals
+	}
+	return nil
+}
+
+// PricingPolicyUpdate is a partial update of a PricingPolicy. Settings left nil keep their current value.
+type PricingPolicyUpdate struct {
+	RateMarkup            *decimal.Decimal `json:"rateMarkup"`
+	UnderpaymentTolerance *decimal.Decimal `json:"underpaymentTolerance"`
+	DeroDecimals          *int32           `json:"deroDecimals"`
+}
+
+// Apply returns pp with the settings of PricingPolicyUpdate that are set
+func (u *PricingPolicyUpdate) Apply(pp PricingPolicy) PricingPolicy {
+	if u.RateMarkup != nil {
+		pp.RateMarkup = *u.RateMarkup
+	}
+	if u.UnderpaymentTolerance != nil {
+		pp.UnderpaymentTolerance = *u.UnderpaymentTolerance
+	}
+	if u.DeroDecimals != nil {
+		pp.DeroDecimals = *u.DeroDecimals
+	}
+	return pp
+}
+
+// Validate returns an error if any of the settings of PricingPolicyUpdate that are set is out of bounds
+func (u *PricingPolicyUpdate) Validate() error {
+	pp := u.Apply(DefaultPricingPolicy()) // Default settings are always valid
+	return pp.Validate()
+}
+
+// ApplyRateMarkup returns an amount of currency increased by the rate markup percentage
+func (pp *PricingPolicy) ApplyRateMarkup(currencyAmount decimal.Decimal) decimal.Decimal {
+	return currencyAmount.Add(currencyAmount.Mul(pp.RateMarkup).Shift(-2))
+}
+
+// RoundAtomicDeroAmount rounds an amount of atomic DERO UP to the number of decimals of PricingPolicy
+func (pp *PricingPolicy) RoundAtomicDeroAmount(atomicDeroAmount uint64) (uint64, error) {
+	if pp.DeroDecimals >= DeroDecimals {
+		return atomicDeroAmount, nil
+	}
+
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(DeroDecimals-pp.DeroDecimals)), nil)
+	q, r := new(big.Int).QuoRem(new(big.Int).SetUint64(atomicDeroAmount), unit, new(big.Int))
+	if r.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	q.Mul(q, unit)
+
+	if q.Cmp(maxAtomicDeroAmount) > 0 {
+		return 0, ErrAmountOutOfRange
+	}
+
+	return q.Uint64(), nil
+}
+
+// MinAtomicDeroAmount returns the minimum amount of atomic DERO accepted for a payment of atomicDeroAmount, given the underpayment tolerance
+func (pp *PricingPolicy) MinAtomicDeroAmount(atomicDeroAmount uint64) uint64 {
+	amount := decimal.NewFromBigInt(new(big.Int).SetUint64(atomicDeroAmount), 0)
+	shortfall := amount.Mul(pp.UnderpaymentTolerance).Shift(-2).Floor() // Rounded down so that the tolerance is never exceeded
+	return amount.Sub(shortfall).BigInt().Uint64()
+}
+
+// StorePricingPolicy returns the pricing policy of a store
+func StorePricingPolicy(s *repository.Store) PricingPolicy {
+	return PricingPolicy{
+		RateMarkup:            s.RateMarkup,
+		UnderpaymentTolerance: s.UnderpaymentTolerance,
+		DeroDecimals:          s.DeroDecimals,
+	}
+}
+
+// FetchPricingPolicy returns the pricing policy of a store fetched from DB
+func FetchPricingPolicy(stores repository.StoreRepository, storeID int) (*PricingPolicy, error) {
+	s, err := stores.ByID(storeID)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, errors.New("store not found")
+		}
+
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	pp := StorePricingPolicy(s)
+	return &pp, nil
+}
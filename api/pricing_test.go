@@ -0,0 +1,100 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPricingPolicyValidate(t *testing.T) {
+	tests := []struct {
+		PricingPolicy PricingPolicy
+		ExpectedErr   error
+	}{
+		{PricingPolicy: DefaultPricingPolicy(), ExpectedErr: nil},
+		{PricingPolicy: PricingPolicy{RateMarkup: decimal.NewFromInt(50), UnderpaymentTolerance: decimal.NewFromInt(10), DeroDecimals: 0}, ExpectedErr: nil},
+		{PricingPolicy: PricingPolicy{RateMarkup: decimal.RequireFromString("1.25"), UnderpaymentTolerance: decimal.RequireFromString("0.05"), DeroDecimals: 5}, ExpectedErr: nil},
+		{PricingPolicy: PricingPolicy{RateMarkup: decimal.RequireFromString("-0.01"), DeroDecimals: 12}, ExpectedErr: ErrInvalidRateMarkup},
+		{PricingPolicy: PricingPolicy{RateMarkup: decimal.RequireFromString("50.01"), DeroDecimals: 12}, ExpectedErr: ErrInvalidRateMarkup},
+		{PricingPolicy: PricingPolicy{RateMarkup: decimal.RequireFromString("1.125"), DeroDecimals: 12}, ExpectedErr: ErrInvalidRateMarkup},
+		{PricingPolicy: PricingPolicy{UnderpaymentTolerance: decimal.RequireFromString("10.5"), DeroDecimals: 12}, ExpectedErr: ErrInvalidUnderpaymentTolerance},
+		{PricingPolicy: PricingPolicy{DeroDecimals: -1}, ExpectedErr: ErrInvalidDeroDecimals},
+		{PricingPolicy: PricingPolicy{DeroDecimals: 13}, ExpectedErr: ErrInvalidDeroDecimals},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.ExpectedErr, test.PricingPolicy.Validate())
+	}
+}
+
+func TestPricingPolicyUpdate(t *testing.T) {
+	rateMarkup := decimal.RequireFromString("2.5")
+	deroDecimals := int32(13)
+	current := PricingPolicy{RateMarkup: decimal.NewFromInt(1), UnderpaymentTolerance: decimal.NewFromInt(2), DeroDecimals: 4}
+
+	// Settings not set keep their current value
+	u := PricingPolicyUpdate{RateMarkup: &rateMarkup}
+	assert.Nil(t, u.Validate())
+	pp := u.Apply(current)
+	assert.Equal(t, "2.5", pp.RateMarkup.String())
+	assert.Equal(t, "2", pp.UnderpaymentTolerance.String())
+	assert.Equal(t, int32(4), pp.DeroDecimals)
+
+	u = PricingPolicyUpdate{}
+	assert.Nil(t, u.Validate())
+	assert.Equal(t, current, u.Apply(current))
+
+	u = PricingPolicyUpdate{DeroDecimals: &deroDecimals}
+	assert.Equal(t, ErrInvalidDeroDecimals, u.Validate())
+}
+
+func TestApplyRateMarkup(t *testing.T) {
+	pp := PricingPolicy{RateMarkup: decimal.RequireFromString("2.5")}
+	assert.Equal(t, "102.5", pp.ApplyRateMarkup(decimal.NewFromInt(100)).String())
+	assert.Equal(t, "0.1025", pp.ApplyRateMarkup(decimal.RequireFromString("0.1")).String())
+
+	pp = DefaultPricingPolicy()
+	assert.Equal(t, "100", pp.ApplyRateMarkup(decimal.NewFromInt(100)).String())
+}
+
+func TestRoundAtomicDeroAmount(t *testing.T) {
+	tests := []struct {
+		DeroDecimals int32
+		Amount       uint64
+		Expected     uint64
+		ExpectedErr  error
+	}{
+		{DeroDecimals: 12, Amount: 210122438344824, Expected: 210122438344824},
+		{DeroDecimals: 4, Amount: 210122438344824, Expected: 210122500000000},
+		{DeroDecimals: 4, Amount: 210122400000000, Expected: 210122400000000},
+		{DeroDecimals: 0, Amount: 1, Expected: 1000000000000},
+		{DeroDecimals: 0, Amount: 0, Expected: 0},
+		{DeroDecimals: 0, Amount: ^uint64(0), ExpectedErr: ErrAmountOutOfRange},
+	}
+
+	for _, test := range tests {
+		pp := PricingPolicy{DeroDecimals: test.DeroDecimals}
+		actual, err := pp.RoundAtomicDeroAmount(test.Amount)
+		assert.Equal(t, test.ExpectedErr, err)
+		assert.Equal(t, test.Expected, actual)
+	}
+}
+
+func TestMinAtomicDeroAmount(t *testing.T) {
+	tests := []struct {
+		UnderpaymentTolerance string
+		Amount                uint64
+		Expected              uint64
+	}{
+		{UnderpaymentTolerance: "0", Amount: 1000000000000, Expected: 1000000000000},
+		{UnderpaymentTolerance: "1", Amount: 1000000000000, Expected: 990000000000},
+		{UnderpaymentTolerance: "0.5", Amount: 3, Expected: 3}, // Shortfall of 0.015 atomic units rounded down
+		{UnderpaymentTolerance: "10", Amount: 333333333334, Expected: 300000000001},
+	}
+
+	for _, test := range tests {
+		pp := PricingPolicy{UnderpaymentTolerance: decimal.RequireFromString(test.UnderpaymentTolerance)}
+		assert.Equal(t, test.Expected, pp.MinAtomicDeroAmount(test.Amount))
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/receipt"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// ErrPaymentNotPaid is returned when the receipt of a payment that is not paid is requested
+var ErrPaymentNotPaid = errors.New("Payment not paid")
+
+// FetchPaymentReceipt returns the Receipt of a paid payment, branded as set by its store
+func FetchPaymentReceipt(stores repository.StoreRepository, payments repository.PaymentRepository, paymentID string, storeID int) (r *receipt.Receipt, errCode int, err error) {
+	p, errCode, err := FetchPaymentFromID(payments, paymentID, storeID)
+	if err != nil {
+		return
+	}
+
+	if p.Status != processor.PaymentStatusPaid {
+		return nil, http.StatusConflict, ErrPaymentNotPaid
+	}
+
+	r = &receipt.Receipt{
+		PaymentID:         p.PaymentID,
+		OrderID:           p.OrderID,
+		Description:       p.Description,
+		Currency:          p.Currency,
+		CurrencyAmount:    p.CurrencyAmount.String(),
+		ExchangeRate:      p.ExchangeRate.String(),
+		DeroAmount:        p.DeroAmount,
+		IntegratedAddress: p.IntegratedAddress,
+		TxIDs:             p.TxIDs,
+		CreationTime:      p.CreationTime,
+	}
+	if p.PaidTime != nil {
+		r.PaidTime = *p.PaidTime
+	}
+
+	s, err := stores.ByID(storeID)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, http.StatusNotFound, ErrPaymentNotFound
+		}
+
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch store")
+	}
+	r.StoreTitle = s.Title
+	r.Branding.Color, r.Branding.Footer = s.ReceiptColor, s.ReceiptFooter
+
+	return
+}
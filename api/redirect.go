@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/cryptoutil"
+)
+
+// MaxRedirectURLLength is the max length of the success and cancel URLs of a payment
+const MaxRedirectURLLength = 2048 // Chars
+
+// Redirect URLs validation errors
+var (
+	ErrInvalidSuccessURL = errors.New("Invalid Param 'successURL': required absolute http(s) URL max 2048 chars long (https only in production)")
+	ErrInvalidCancelURL  = errors.New("Invalid Param 'cancelURL': required absolute http(s) URL max 2048 chars long (https only in production)")
+)
+
+// IsValidRedirectURL returns whether rawURL is an absolute URL customers can be redirected to.
+// Only https URLs are allowed when the server runs in release mode (GIN_MODE=release).
+func IsValidRedirectURL(rawURL string) bool {
+	if len(rawURL) > MaxRedirectURLLength {
+		return false
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || u.User != nil {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		return gin.Mode() != gin.ReleaseMode
+	}
+	return false
+}
+
+// SanitizeRedirectURLs trims the success and cancel URLs of PaymentOptions and returns an error if any of them is invalid
+func (opts *PaymentOptions) SanitizeRedirectURLs() error {
+	opts.SuccessURL = strings.TrimSpace(opts.SuccessURL)
+	if opts.SuccessURL != "" && !IsValidRedirectURL(opts.SuccessURL) {
+		return ErrInvalidSuccessURL
+	}
+
+	opts.CancelURL = strings.TrimSpace(opts.CancelURL)
+	if opts.CancelURL != "" && !IsValidRedirectURL(opts.CancelURL) {
+		return ErrInvalidCancelURL
+	}
+
+	return nil
+}
+
+// RedirectSignatureMessage returns the message signed in the signature query param of redirect URLs
+func RedirectSignatureMessage(paymentID, status string) string {
+	return "paymentID=" + paymentID + "&status=" + status
+}
+
+// SignRedirectURL returns rawURL with the query params paymentID, status and signature added.
+// Signature is the hex encoded HMAC-SHA256 of RedirectSignatureMessage generated using the (hex encoded) Webhook Secret Key of the store,
+// so that stores can verify customers were actually redirected by DERO Merchant.
+func SignRedirectURL(rawURL, paymentID, status, webhookSecretKey string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot parse URL")
+	}
+
+	key, err := hex.DecodeString(webhookSecretKey)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot decode hex string")
+	}
+
+	signature, err := cryptoutil.SignMessage([]byte(RedirectSignatureMessage(paymentID, status)), key)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot sign message")
+	}
+
+	q := u.Query()
+	q.Set("paymentID", paymentID)
+	q.Set("status", status)
+	q.Set("signature", hex.EncodeToString(signature))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/peppinux/dero-merchant/config"
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// PaymentRequirements represents the time a payment has to be received in and the confirmations it needs to have to be considered paid
+type PaymentRequirements struct {
+	// TTL is the number of MINUTES allowed to receive a payment before it expires
+	TTL int `json:"ttl"`
+	// MinConfirmations is the MINIMUM number of confirmations a payment needs to have before it is considered valid,
+	// if its amount does not fall in any of the ConfirmationTiers
+	MinConfirmations  int               `json:"minConfirmations"`
+	ConfirmationTiers ConfirmationTiers `json:"confirmationTiers"`
+}
+
+// ConfirmationTier requires payments of less than MaxDeroAmount DERO to have Confirmations confirmations
+type ConfirmationTier struct {
+	MaxDeroAmount *decimal.Decimal `json:"maxDeroAmount"` // Exclusive upper bound. Null means no upper bound
+	Confirmations int              `json:"confirmations"`
+}
+
+// ConfirmationTiers is a list of ConfirmationTier(s) sorted by MaxDeroAmount in ascending order, with the unbounded tier (if any) last.
+// Payments require the confirmations of the first tier their amount of DERO falls in.
+type ConfirmationTiers []ConfirmationTier
+
+// MaxConfirmationTiers is the max number of confirmation tiers a store can set
+const MaxConfirmationTiers = 10
+
+// PaymentRequirements validation errors
+var (
+	ErrInvalidTTL               = errors.New("Invalid Param 'ttl': required number of minutes between 1 and the max TTL allowed by the server")
+	ErrInvalidMinConfirmations  = errors.New("Invalid Param 'minConfirmations': required number of confirmations between the min and the max allowed by the server")
+	ErrInvalidConfirmationTiers = errors.New("Invalid confirmation tiers: required up to 10 tiers sorted by ascending max DERO amount (only the last one can have no max amount), " +
+		"each requiring a number of confirmations between the min allowed by the server for confirmation tiers (or the min allowed by the server, if it has no max amount) and the max allowed by the server")
+)
+
+func isValidTTL(ttl int) bool {
+	return ttl >= 1 && ttl <= config.PaymentMaxTTL
+}
+
+func isValidMinConfirmations(minConfirmations int) bool {
+	return minConfirmations >= config.PaymentMinConfirmations && minConfirmations <= config.PaymentMaxConfirmations
+}
+
+// isValidTierConfirmations returns whether a tier can require confirmations.
+// Tiers with a max amount can go as low as PaymentTierMinConfirmations, the unbounded tier cannot go lower than PaymentMinConfirmations.
+func isValidTierConfirmations(confirmations int, bounded bool) bool {
+	if !bounded {
+		return isValidMinConfirmations(confirmations)
+	}
+	return confirmations >= config.PaymentTierMinConfirmations && confirmations <= config.PaymentMaxConfirmations
+}
+
+// ValidateDefaults returns an error if any of the requirements is out of the limits set by the operator.
+// Requirements set to 0 are valid, meaning that the requirements of the operator apply.
+func (r *PaymentRequirements) ValidateDefaults() error {
+	if r.TTL != 0 && !isValidTTL(r.TTL) {
+		return ErrInvalidTTL
+	}
+	if r.MinConfirmations != 0 && !isValidMinConfirmations(r.MinConfirmations) {
+		return ErrInvalidMinConfirmations
+	}
+	return r.ConfirmationTiers.Validate()
+}
+
+// Validate returns an error if ConfirmationTiers are not sorted, have invalid amounts or require a number of confirmations out of the limits set by the operator
+func (t ConfirmationTiers) Validate() error {
+	if len(t) > MaxConfirmationTiers {
+		return ErrInvalidConfirmationTiers
+	}
+
+	var prevMax decimal.Decimal
+	for i, tier := range t {
+		if !isValidTierConfirmations(tier.Confirmations, tier.MaxDeroAmount != nil) {
+			return ErrInvalidConfirmationTiers
+		}
+
+		if tier.MaxDeroAmount == nil {
+			if i != len(t)-1 { // Only the last tier can be unbounded
+				return ErrInvalidConfirmationTiers
+			}
+			continue
+		}
+
+		max := *tier.MaxDeroAmount
+		if !max.GreaterThan(prevMax) || !max.Equal(max.Round(DeroDecimals)) || max.Shift(DeroDecimals).BigInt().Cmp(maxAtomicDeroAmount) > 0 {
+			return ErrInvalidConfirmationTiers
+		}
+		prevMax = max
+	}
+
+	return nil
+}
+
+// processorTiers converts ConfirmationTiers to the tiers evaluated by processor.
+// Confirmations out of the limits set by the operator (e.g. after the operator changed them) are replaced by the closest valid value.
+func (t ConfirmationTiers) processorTiers() processor.ConfirmationTiers {
+	var tiers processor.ConfirmationTiers
+	for _, tier := range t {
+		pt := processor.ConfirmationTier{
+			Confirmations: confirmationsWithinLimits(tier.Confirmations),
+		}
+		if tier.MaxDeroAmount != nil {
+			pt.MaxAtomicDeroAmount = tier.MaxDeroAmount.Shift(DeroDecimals).BigInt().Uint64()
+			pt.Confirmations = tierConfirmationsWithinLimits(tier.Confirmations)
+		}
+		tiers = append(tiers, pt)
+	}
+	return tiers
+}
+
+// Encode returns ConfirmationTiers as the JSON array stored in the settings of stores
+func (t ConfirmationTiers) Encode() (json.RawMessage, error) {
+	if t == nil {
+		return json.RawMessage("[]"), nil
+	}
+
+	return json.Marshal(t)
+}
+
+// StorePaymentRequirements returns the default payment requirements of a store
+func StorePaymentRequirements(s *repository.Store) (r PaymentRequirements, err error) {
+	r = PaymentRequirements{
+		TTL:              s.PaymentTTL,
+		MinConfirmations: s.PaymentMinConfirmations,
+	}
+	if len(s.ConfirmationTiers) > 0 {
+		err = json.Unmarshal(s.ConfirmationTiers, &r.ConfirmationTiers)
+		if err != nil {
+			return r, errors.Wrap(err, "cannot unmarshal confirmation tiers")
+		}
+	}
+
+	return
+}
+
+// withinLimits returns a copy of PaymentRequirements where requirements that are not set (0) or
+// are out of the limits set by the operator (e.g. after the operator changed them) are replaced by the closest valid value
+func (r PaymentRequirements) withinLimits() PaymentRequirements {
+	if r.TTL <= 0 || r.TTL > config.PaymentMaxTTL {
+		r.TTL = config.PaymentMaxTTL
+	}
+
+	r.MinConfirmations = confirmationsWithinLimits(r.MinConfirmations)
+
+	return r
+}
+
+// confirmationsWithinLimits returns the closest number of confirmations to confirmations within the limits set by the operator
+func confirmationsWithinLimits(confirmations int) int {
+	switch {
+	case confirmations < config.PaymentMinConfirmations:
+		return config.PaymentMinConfirmations
+	case confirmations > config.PaymentMaxConfirmations:
+		return config.PaymentMaxConfirmations
+	}
+	return confirmations
+}
+
+// tierConfirmationsWithinLimits returns the closest number of confirmations to confirmations within the limits set by the operator for tiers with a max amount
+func tierConfirmationsWithinLimits(confirmations int) int {
+	if confirmations < config.PaymentTierMinConfirmations {
+		return config.PaymentTierMinConfirmations
+	}
+	if confirmations > config.PaymentMaxConfirmations {
+		return config.PaymentMaxConfirmations
+	}
+	return confirmations
+}
+
+// NewPaymentRequirements returns the requirements of a new payment.
+// ttl and minConfirmations, if not nil, override the defaults of the store,
+// which in turn override the ones of the operator (PAYMENT_MAX_TTL and PAYMENT_MIN_CONFIRMATIONS env variables).
+func NewPaymentRequirements(ttl, minConfirmations *int, storeDefaults PaymentRequirements) (r PaymentRequirements, err error) {
+	r = storeDefaults.withinLimits()
+
+	if ttl != nil {
+		if !isValidTTL(*ttl) {
+			return r, ErrInvalidTTL
+		}
+		r.TTL = *ttl
+	}
+
+	if minConfirmations != nil {
+		if !isValidMinConfirmations(*minConfirmations) {
+			return r, ErrInvalidMinConfirmations
+		}
+		r.MinConfirmations = *minConfirmations
+		r.ConfirmationTiers = nil // Confirmations requested for the payment override the confirmation tiers of the store
+	}
+
+	return
+}
+
+// FetchPaymentRequirements returns the default payment requirements of a store fetched from DB
+func FetchPaymentRequirements(stores repository.StoreRepository, storeID int) (*PaymentRequirements, error) {
+	s, err := stores.ByID(storeID)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, errors.New("store not found")
+		}
+
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	r, err := StorePaymentRequirements(s)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
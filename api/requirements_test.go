@@ -0,0 +1,129 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peppinux/dero-merchant/config"
+	"github.com/peppinux/dero-merchant/processor"
+)
+
+func TestConfirmationTiers(t *testing.T) {
+	oldMinConfirmations, oldMaxConfirmations, oldTierMinConfirmations := config.PaymentMinConfirmations, config.PaymentMaxConfirmations, config.PaymentTierMinConfirmations
+
+	config.PaymentMinConfirmations = 2
+	config.PaymentMaxConfirmations = 30
+	config.PaymentTierMinConfirmations = 1
+
+	decPtr := func(s string) *decimal.Decimal {
+		d := decimal.RequireFromString(s)
+		return &d
+	}
+
+	tiers := ConfirmationTiers{
+		{MaxDeroAmount: decPtr("10"), Confirmations: 2},
+		{MaxDeroAmount: decPtr("1000"), Confirmations: 10},
+		{Confirmations: 30},
+	}
+	assert.Nil(t, tiers.Validate())
+	assert.Equal(t, processor.ConfirmationTiers{
+		{MaxAtomicDeroAmount: 10000000000000, Confirmations: 2},
+		{MaxAtomicDeroAmount: 1000000000000000, Confirmations: 10},
+		{MaxAtomicDeroAmount: 0, Confirmations: 30},
+	}, tiers.processorTiers())
+
+	invalidTiers := []ConfirmationTiers{
+		{{MaxDeroAmount: decPtr("10"), Confirmations: 0}},                                                 // Too few confirmations
+		{{MaxDeroAmount: decPtr("10"), Confirmations: 1}, {Confirmations: 1}},                             // Too few confirmations for the unbounded tier
+		{{MaxDeroAmount: decPtr("10"), Confirmations: 31}},                                                // Too many confirmations
+		{{MaxDeroAmount: decPtr("0"), Confirmations: 2}},                                                  // Non positive amount
+		{{MaxDeroAmount: decPtr("0.0000000000001"), Confirmations: 2}},                                    // Too many decimals
+		{{MaxDeroAmount: decPtr("10"), Confirmations: 2}, {MaxDeroAmount: decPtr("5"), Confirmations: 3}}, // Not sorted
+		{{Confirmations: 2}, {MaxDeroAmount: decPtr("5"), Confirmations: 3}},                              // Unbounded tier not last
+		make(ConfirmationTiers, MaxConfirmationTiers+1),
+	}
+	for _, invalid := range invalidTiers {
+		assert.Equal(t, ErrInvalidConfirmationTiers, invalid.Validate())
+	}
+
+	// Tiers with a max amount can require fewer confirmations than PaymentMinConfirmations
+	smallAmountTiers := ConfirmationTiers{
+		{MaxDeroAmount: decPtr("1"), Confirmations: 1},
+		{Confirmations: 2},
+	}
+	assert.Nil(t, smallAmountTiers.Validate())
+
+	config.PaymentTierMinConfirmations = 2
+	assert.Equal(t, ErrInvalidConfirmationTiers, smallAmountTiers.Validate())
+	assert.Equal(t, 2, smallAmountTiers.processorTiers()[0].Confirmations) // Raised to the new min
+	config.PaymentTierMinConfirmations = 1
+
+	// Confirmations requested for the payment override confirmation tiers
+	confirmations := 5
+	r, err := NewPaymentRequirements(nil, &confirmations, PaymentRequirements{ConfirmationTiers: tiers})
+	assert.Nil(t, err)
+	assert.Nil(t, r.ConfirmationTiers)
+
+	config.PaymentMinConfirmations, config.PaymentMaxConfirmations, config.PaymentTierMinConfirmations = oldMinConfirmations, oldMaxConfirmations, oldTierMinConfirmations
+}
+
+func TestCalculateTTL(t *testing.T) {
+	tests := []struct {
+		Payment          *Payment
+		MinsFromCreation int
+		ExpectedTTL      int
+	}{
+		{Payment: &Payment{Status: processor.PaymentStatusPending, MaxTTL: 60}, MinsFromCreation: 0, ExpectedTTL: 60 - 0},
+		{Payment: &Payment{Status: processor.PaymentStatusPending, MaxTTL: 60}, MinsFromCreation: 1, ExpectedTTL: 60 - 1},
+		{Payment: &Payment{Status: processor.PaymentStatusPending, MaxTTL: 60}, MinsFromCreation: 20, ExpectedTTL: 60 - 20},
+		{Payment: &Payment{Status: processor.PaymentStatusPending, MaxTTL: 60}, MinsFromCreation: 60, ExpectedTTL: 0},
+		{Payment: &Payment{Status: processor.PaymentStatusPending, MaxTTL: 60}, MinsFromCreation: 100, ExpectedTTL: 0},
+		{Payment: &Payment{Status: processor.PaymentStatusPending, MaxTTL: 10}, MinsFromCreation: 5, ExpectedTTL: 10 - 5},
+		{Payment: &Payment{Status: processor.PaymentStatusPaid, MaxTTL: 60}, MinsFromCreation: 1000, ExpectedTTL: 0},
+	}
+
+	for _, test := range tests {
+		test.Payment.CalculateTTL(test.MinsFromCreation)
+		assert.Equal(t, test.ExpectedTTL, test.Payment.TTL)
+	}
+}
+
+func TestNewPaymentRequirements(t *testing.T) {
+	oldMaxTTL, oldMinConfirmations, oldMaxConfirmations := config.PaymentMaxTTL, config.PaymentMinConfirmations, config.PaymentMaxConfirmations
+
+	config.PaymentMaxTTL = 60
+	config.PaymentMinConfirmations = 5
+	config.PaymentMaxConfirmations = 50
+
+	intPtr := func(i int) *int { return &i }
+
+	tests := []struct {
+		TTL              *int
+		MinConfirmations *int
+		StoreDefaults    PaymentRequirements
+
+		Expected    PaymentRequirements
+		ExpectedErr error
+	}{
+		{Expected: PaymentRequirements{TTL: 60, MinConfirmations: 5}},
+		{StoreDefaults: PaymentRequirements{TTL: 10, MinConfirmations: 1}, Expected: PaymentRequirements{TTL: 10, MinConfirmations: 5}},
+		{StoreDefaults: PaymentRequirements{TTL: 120, MinConfirmations: 100}, Expected: PaymentRequirements{TTL: 60, MinConfirmations: 50}},
+		{TTL: intPtr(30), MinConfirmations: intPtr(20), StoreDefaults: PaymentRequirements{TTL: 10, MinConfirmations: 10}, Expected: PaymentRequirements{TTL: 30, MinConfirmations: 20}},
+		{TTL: intPtr(0), ExpectedErr: ErrInvalidTTL},
+		{TTL: intPtr(61), ExpectedErr: ErrInvalidTTL},
+		{MinConfirmations: intPtr(4), ExpectedErr: ErrInvalidMinConfirmations},
+		{MinConfirmations: intPtr(51), ExpectedErr: ErrInvalidMinConfirmations},
+	}
+
+	for _, test := range tests {
+		r, err := NewPaymentRequirements(test.TTL, test.MinConfirmations, test.StoreDefaults)
+		assert.Equal(t, test.ExpectedErr, err)
+		if err == nil {
+			assert.Equal(t, test.Expected, r)
+		}
+	}
+
+	config.PaymentMaxTTL, config.PaymentMinConfirmations, config.PaymentMaxConfirmations = oldMaxTTL, oldMinConfirmations, oldMaxConfirmations
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// Payment filters errors
+var (
+	ErrInvalidFromFilter     = errors.New("Query param 'from' not valid. Allowed values: (empty), date (YYYY-MM-DD) or RFC 3339 date-time")
+	ErrInvalidToFilter       = errors.New("Query param 'to' not valid. Allowed values: (empty), date (YYYY-MM-DD) or RFC 3339 date-time")
+	ErrInvalidMinAmount      = errors.New("Query param 'min_amount' not valid. Allowed values: (empty) or decimal number")
+	ErrInvalidMaxAmount      = errors.New("Query param 'max_amount' not valid. Allowed values: (empty) or decimal number")
+	ErrInvalidMetadataFilter = errors.New("Query param 'metadata' not valid. Allowed values: (empty) or JSON object")
+)
+
+// PaymentFilters represents the filters the payments of a store can be searched by. Zero values are ignored.
+// StoreID is set by the functions that search the payments of a store.
+type PaymentFilters = repository.PaymentFilter
+
+// ParseFilterTime parses the from/to filter of a payment search. Dates (YYYY-MM-DD) are also accepted.
+// Since To is exclusive, a date passed as to is moved to the day after, so that payments of the whole day are included.
+func ParseFilterTime(s string, isTo bool) (t time.Time, err error) {
+	if s == "" {
+		return
+	}
+
+	t, err = time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t.UTC(), nil
+	}
+
+	t, err = time.Parse("2006-01-02", s)
+	if err != nil {
+		return
+	}
+	if isTo {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
+
+// ParseFilterAmount parses the min/max amount filter of a payment search. It returns nil if s is empty.
+func ParseFilterAmount(s string) (*decimal.Decimal, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// ParseFilterMetadata parses the metadata filter of a payment search. It returns nil if s is empty.
+func ParseFilterMetadata(s string) (json.RawMessage, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var obj map[string]interface{}
+	err := json.Unmarshal([]byte(s), &obj)
+	if err != nil || obj == nil {
+		return nil, ErrInvalidMetadataFilter
+	}
+
+	return json.RawMessage(s), nil
+}
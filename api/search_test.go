@@ -0,0 +1,43 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePaymentFilters(t *testing.T) {
+	from, err := ParseFilterTime("2020-05-01", false)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), from)
+	to, err := ParseFilterTime("2020-05-01", true)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC), to) // Whole day included
+	to, err = ParseFilterTime("2020-05-01T12:30:00+02:00", true)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2020, 5, 1, 10, 30, 0, 0, time.UTC), to)
+	_, err = ParseFilterTime("01/05/2020", false)
+	assert.NotNil(t, err)
+	empty, err := ParseFilterTime("", false)
+	assert.Nil(t, err)
+	assert.True(t, empty.IsZero())
+
+	amount, err := ParseFilterAmount("10.5")
+	assert.Nil(t, err)
+	assert.True(t, decimal.RequireFromString("10.5").Equal(*amount))
+	amount, err = ParseFilterAmount("")
+	assert.Nil(t, err)
+	assert.Nil(t, amount)
+	_, err = ParseFilterAmount("ten")
+	assert.NotNil(t, err)
+
+	metadata, err := ParseFilterMetadata(`{"sku":"abc"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"sku":"abc"}`, string(metadata))
+	for _, m := range []string{`"abc"`, `[1]`, `null`, `{`} {
+		_, err = ParseFilterMetadata(m)
+		assert.Equal(t, ErrInvalidMetadataFilter, err)
+	}
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// Stats intervals
+const (
+	StatsIntervalDay   = "day"
+	StatsIntervalWeek  = "week"
+	StatsIntervalMonth = "month"
+)
+
+// Stats settings
+const (
+	DefaultStatsRange = 30   // Days of stats returned if no range is requested
+	MaxStatsRange     = 1096 // Days (3 years)
+)
+
+// Stats errors
+var (
+	ErrInvalidStatsInterval = errors.New("Query param 'interval' not valid. Allowed values: (empty), day, week, month")
+	ErrInvalidTimezone      = errors.New("Query param 'timezone' not valid. Allowed values: (empty) or IANA time zone name (e.g. Europe/Rome)")
+	ErrInvalidStatsFrom     = errors.New("Query param 'from' not valid. Allowed values: (empty) or date (YYYY-MM-DD)")
+	ErrInvalidStatsTo       = errors.New("Query param 'to' not valid. Allowed values: (empty) or date (YYYY-MM-DD)")
+	ErrInvalidStatsRange    = errors.New("Invalid stats range: 'from' has to be before 'to' and the range cannot be longer than 1096 days")
+)
+
+// StatsRange represents the dates (inclusive) stats are computed for, grouped by interval in a time zone
+type StatsRange struct {
+	Interval string
+	Location *time.Location
+	From     time.Time
+	To       time.Time
+}
+
+// NewStatsRange validates the params of a StatsRange. Empty params default to the last DefaultStatsRange days by day in UTC.
+func NewStatsRange(interval, timezone, from, to string) (r StatsRange, err error) {
+	r.Interval = interval
+	if r.Interval == "" {
+		r.Interval = StatsIntervalDay
+	}
+	if r.Interval != StatsIntervalDay && r.Interval != StatsIntervalWeek && r.Interval != StatsIntervalMonth {
+		return r, ErrInvalidStatsInterval
+	}
+
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	r.Location, err = time.LoadLocation(timezone)
+	if err != nil || timezone == "Local" {
+		return r, ErrInvalidTimezone
+	}
+
+	now := time.Now().In(r.Location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, r.Location)
+
+	r.To = today
+	if to != "" {
+		r.To, err = time.ParseInLocation("2006-01-02", to, r.Location)
+		if err != nil {
+			return r, ErrInvalidStatsTo
+		}
+	}
+
+	r.From = r.To.AddDate(0, 0, -(DefaultStatsRange - 1))
+	if from != "" {
+		r.From, err = time.ParseInLocation("2006-01-02", from, r.Location)
+		if err != nil {
+			return r, ErrInvalidStatsFrom
+		}
+	}
+
+	if r.From.After(r.To) || r.To.Sub(r.From) > MaxStatsRange*24*time.Hour {
+		return r, ErrInvalidStatsRange
+	}
+
+	return r, nil
+}
+
+// Stats represents the aggregate statistics of the payments of a store created in a period of time
+type Stats struct {
+	// Period is the first day of the period (YYYY-MM-DD) in the time zone of the stats. Empty for totals
+	Period string `json:"period,omitempty"`
+	// Number of payments created and by status
+	Created   int `json:"created"`
+	Pending   int `json:"pending"`
+	Paid      int `json:"paid"`
+	Expired   int `json:"expired"`
+	Error     int `json:"error"`
+	Cancelled int `json:"cancelled"`
+	// ConversionRate is the ratio of paid payments to created payments (0 to 1)
+	ConversionRate decimal.Decimal `json:"conversionRate"`
+	// AvgTimeToPayment is the average number of seconds paid payments took to get paid since their creation
+	AvgTimeToPayment int64 `json:"avgTimeToPayment"`
+	// Revenue of paid payments in DERO and in each of their original currencies
+	AtomicDeroRevenue uint64                     `json:"atomicDeroRevenue"`
+	DeroRevenue       string                     `json:"deroRevenue"`
+	CurrencyRevenue   map[string]decimal.Decimal `json:"currencyRevenue"`
+
+	// Used to compute the average time to payment of totals
+	paidTimeCount   int64
+	paidTimeSeconds int64
+}
+
+// StoreStats represents the statistics of the payments of a store, in total and for each period of a StatsRange
+type StoreStats struct {
+	Interval string   `json:"interval"`
+	Timezone string   `json:"timezone"`
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Totals   *Stats   `json:"totals"`
+	Periods  []*Stats `json:"periods"`
+}
+
+func newStats(period string) *Stats {
+	return &Stats{
+		Period:          period,
+		CurrencyRevenue: make(map[string]decimal.Decimal),
+	}
+}
+
+// add adds the counts and revenues of o to s
+func (s *Stats) add(o *Stats) {
+	s.Created += o.Created
+	s.Pending += o.Pending
+	s.Paid += o.Paid
+	s.Expired += o.Expired
+	s.Error += o.Error
+	s.Cancelled += o.Cancelled
+	s.AtomicDeroRevenue += o.AtomicDeroRevenue
+	s.paidTimeCount += o.paidTimeCount
+	s.paidTimeSeconds += o.paidTimeSeconds
+	for currency, amount := range o.CurrencyRevenue {
+		s.CurrencyRevenue[currency] = s.CurrencyRevenue[currency].Add(amount)
+	}
+}
+
+// finalize computes the derived fields of s
+func (s *Stats) finalize() {
+	s.ConversionRate = decimal.Zero
+	if s.Created > 0 {
+		s.ConversionRate = decimal.NewFromInt(int64(s.Paid)).DivRound(decimal.NewFromInt(int64(s.Created)), 4)
+	}
+	if s.paidTimeCount > 0 {
+		s.AvgTimeToPayment = s.paidTimeSeconds / s.paidTimeCount
+	}
+	s.DeroRevenue = FormatAtomicDero(s.AtomicDeroRevenue)
+}
+
+// FetchStoreStats returns the statistics of the payments of a store created in r, computed from the aggregates of its payments
+func FetchStoreStats(payments repository.PaymentRepository, storeID int, r StatsRange) (ss *StoreStats, errCode int, err error) {
+	// Payments are selected by creation time from the start of From to the end of To in the time zone of the stats
+	aggregates, err := payments.Aggregate(storeID, r.Interval, r.Location, r.From, r.To.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot aggregate payments")
+	}
+
+	ss = &StoreStats{
+		Interval: r.Interval,
+		Timezone: r.Location.String(),
+		From:     r.From.Format("2006-01-02"),
+		To:       r.To.Format("2006-01-02"),
+		Totals:   newStats(""),
+		Periods:  []*Stats{},
+	}
+	periods := make(map[string]*Stats)
+	for _, a := range aggregates { // Sorted by period
+		s, ok := periods[a.Period]
+		if !ok {
+			s = newStats(a.Period)
+			ss.Periods = append(ss.Periods, s)
+			periods[a.Period] = s
+		}
+
+		s.Created += a.Count
+		switch a.Status {
+		case processor.PaymentStatusPending:
+			s.Pending += a.Count
+		case processor.PaymentStatusPaid:
+			s.Paid += a.Count
+			s.AtomicDeroRevenue += a.AtomicDeroAmount
+			s.paidTimeCount += a.PaidCount
+			s.paidTimeSeconds += a.PaidSeconds
+			s.CurrencyRevenue[a.Currency] = s.CurrencyRevenue[a.Currency].Add(a.CurrencyAmount)
+		case processor.PaymentStatusExpired:
+			s.Expired += a.Count
+		case processor.PaymentStatusError:
+			s.Error += a.Count
+		case processor.PaymentStatusCancelled:
+			s.Cancelled += a.Count
+		}
+	}
+
+	for _, s := range ss.Periods {
+		s.finalize()
+		ss.Totals.add(s)
+	}
+	ss.Totals.finalize()
+
+	return ss, 0, nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStatsRange(t *testing.T) {
+	// Defaults
+	r, err := NewStatsRange("", "", "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, StatsIntervalDay, r.Interval)
+	assert.Equal(t, "UTC", r.Location.String())
+	assert.Equal(t, DefaultStatsRange-1, int(r.To.Sub(r.From).Hours()/24))
+
+	r, err = NewStatsRange("month", "Europe/Rome", "2020-01-01", "2020-12-31")
+	assert.Nil(t, err)
+	assert.Equal(t, StatsIntervalMonth, r.Interval)
+	assert.Equal(t, time.Date(2019, 12, 31, 23, 0, 0, 0, time.UTC), r.From.UTC()) // Midnight in Rome
+
+	tests := []struct {
+		Interval, Timezone, From, To string
+		ExpectedErr                  error
+	}{
+		{"year", "", "", "", ErrInvalidStatsInterval},
+		{"", "Mars/Olympus", "", "", ErrInvalidTimezone},
+		{"", "Local", "", "", ErrInvalidTimezone},
+		{"", "", "01/01/2020", "", ErrInvalidStatsFrom},
+		{"", "", "", "2020-13-01", ErrInvalidStatsTo},
+		{"", "", "2020-02-01", "2020-01-01", ErrInvalidStatsRange},
+		{"", "", "2015-01-01", "2020-01-01", ErrInvalidStatsRange},
+	}
+	for _, test := range tests {
+		_, err = NewStatsRange(test.Interval, test.Timezone, test.From, test.To)
+		assert.Equal(t, test.ExpectedErr, err)
+	}
+}
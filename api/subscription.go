@@ -0,0 +1,501 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/peppinux/dero-merchant/config"
+	"github.com/peppinux/dero-merchant/mailer"
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+	"github.com/peppinux/dero-merchant/stringutil"
+)
+
+// Subscription statuses
+const (
+	SubscriptionStatusActive    = repository.SubscriptionStatusActive
+	SubscriptionStatusPastDue   = repository.SubscriptionStatusPastDue
+	SubscriptionStatusCancelled = repository.SubscriptionStatusCancelled
+)
+
+// Subscription billing intervals
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+// Subscription limits
+const (
+	MaxIntervalCount                 = 12
+	MaxGracePeriodDays               = 30
+	MaxSubscriptionDescriptionLength = 256 // Chars
+)
+
+// SubscriptionSchedulerInterval is how often due subscriptions are billed and missed cycles are checked for
+const SubscriptionSchedulerInterval = 15 * time.Minute
+
+// Subscription validation errors
+var (
+	ErrInvalidSubscriptionAmount      = errors.New("Invalid Param 'amount': required positive number")
+	ErrInvalidInterval                = errors.New("Invalid Param 'interval': required one of day, week, month, year")
+	ErrInvalidIntervalCount           = errors.New("Invalid Param 'intervalCount': required integer between 1 and 12")
+	ErrInvalidSubscriptionEmail       = errors.New("Invalid Param 'customer.email': required valid email address max 64 chars long")
+	ErrInvalidSubscriptionDescription = errors.New("Invalid Param 'description': required max 256 chars long string")
+	ErrInvalidGracePeriod             = errors.New("Invalid Param 'gracePeriodDays': required integer between 0 and 30")
+	ErrInvalidStartDate               = errors.New("Invalid Param 'startDate': required date in YYYY-MM-DD format not in the past")
+)
+
+// Subscription represents a plan a customer is billed for on a schedule. Every billing cycle, an invoice is issued
+// for the amount of the plan and sent to the customer, who has to pay it within the grace period of the subscription.
+type Subscription struct {
+	SubscriptionID  string          `json:"subscriptionID"`
+	Status          string          `json:"status"`
+	Currency        string          `json:"currency"`
+	Amount          decimal.Decimal `json:"amount"`
+	Interval        string          `json:"interval"`
+	IntervalCount   int             `json:"intervalCount"`
+	Customer        InvoiceCustomer `json:"customer"`
+	Description     string          `json:"description,omitempty"`
+	GracePeriodDays int             `json:"gracePeriodDays"`
+	StartDate       string          `json:"startDate"`       // YYYY-MM-DD
+	NextBillingDate string          `json:"nextBillingDate"` // YYYY-MM-DD
+	// MissedCycles is the number of invoices of the subscription still unpaid after their due date
+	MissedCycles int `json:"missedCycles"`
+	// InvoiceID is the Invoice ID of the latest invoice issued for the subscription, if any
+	InvoiceID     string     `json:"invoiceID,omitempty"`
+	CreationTime  time.Time  `json:"creationTime"`
+	CancelledTime *time.Time `json:"cancelledTime,omitempty"`
+	BilledCycles  int        `json:"-"`
+	ID            int        `json:"-"`
+	StoreID       int        `json:"-"`
+}
+
+// SubscriptionDetails are the details of a new subscription
+type SubscriptionDetails struct {
+	Amount          decimal.Decimal
+	Interval        string
+	IntervalCount   int // Defaults to 1
+	Customer        InvoiceCustomer
+	Description     string
+	GracePeriodDays int
+	StartDate       string // Defaults to today
+}
+
+func sanitizeSubscriptionDetails(currency string, d *SubscriptionDetails) error {
+	d.Amount = d.Amount.Round(CurrencyDecimals(currency))
+	if !d.Amount.IsPositive() {
+		return ErrInvalidSubscriptionAmount
+	}
+
+	d.Interval = strings.ToLower(strings.TrimSpace(d.Interval))
+	switch d.Interval {
+	case IntervalDay, IntervalWeek, IntervalMonth, IntervalYear:
+	default:
+		return ErrInvalidInterval
+	}
+	if d.IntervalCount == 0 {
+		d.IntervalCount = 1
+	}
+	if d.IntervalCount < 1 || d.IntervalCount > MaxIntervalCount {
+		return ErrInvalidIntervalCount
+	}
+
+	c := &d.Customer
+	c.Email = strings.TrimSpace(c.Email)
+	if !isValidEmail(c.Email) {
+		return ErrInvalidSubscriptionEmail
+	}
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		c.Name = c.Email
+	}
+	if utf8.RuneCountInString(c.Name) > MaxCustomerNameLength {
+		return ErrInvalidCustomerName
+	}
+	c.Address = strings.TrimSpace(c.Address)
+	if utf8.RuneCountInString(c.Address) > MaxCustomerAddressLength {
+		return ErrInvalidCustomerAddress
+	}
+
+	d.Description = strings.TrimSpace(d.Description)
+	if utf8.RuneCountInString(d.Description) > MaxSubscriptionDescriptionLength {
+		return ErrInvalidSubscriptionDescription
+	}
+
+	if d.GracePeriodDays < 0 || d.GracePeriodDays > MaxGracePeriodDays {
+		return ErrInvalidGracePeriod
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	d.StartDate = strings.TrimSpace(d.StartDate)
+	if d.StartDate == "" {
+		d.StartDate = today.Format(InvoiceDueDateLayout)
+	}
+	startDate, err := time.Parse(InvoiceDueDateLayout, d.StartDate)
+	if err != nil || startDate.Before(today) {
+		return ErrInvalidStartDate
+	}
+
+	return nil
+}
+
+// GenerateUniqueSubscriptionID generates a Subscription ID that is not already in use by other subscriptions
+func GenerateUniqueSubscriptionID(subscriptions repository.SubscriptionRepository) (subscriptionID string, err error) {
+	for {
+		subscriptionID, err = stringutil.RandomHexString(32)
+		if err != nil {
+			return "", errors.Wrap(err, "cannot generate random hex string")
+		}
+
+		exists, err := subscriptions.SubscriptionIDExists(subscriptionID)
+		if err != nil {
+			return "", errors.Wrap(err, "cannot check if subscription ID is unique")
+		}
+
+		if !exists {
+			break
+		}
+	}
+
+	return
+}
+
+// CreateNewSubscription returns a new active Subscription ready to be stored in DB.
+// Its first billing cycle starts on its start date.
+func CreateNewSubscription(subscriptions repository.SubscriptionRepository, currency string, details SubscriptionDetails, storeID int) (s *Subscription, errCode int, err error) {
+	s = &Subscription{
+		Status:  SubscriptionStatusActive,
+		StoreID: storeID,
+	}
+
+	// Validate params
+	s.Currency = strings.ToUpper(currency)
+	if !IsSupportedCurrency(s.Currency) {
+		return nil, http.StatusUnprocessableEntity, ErrInvalidCurrency
+	}
+	err = sanitizeSubscriptionDetails(s.Currency, &details)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+
+	s.Amount = details.Amount
+	s.Interval = details.Interval
+	s.IntervalCount = details.IntervalCount
+	s.Customer = details.Customer
+	s.Description = details.Description
+	s.GracePeriodDays = details.GracePeriodDays
+	s.StartDate = details.StartDate
+	s.NextBillingDate = details.StartDate
+
+	s.SubscriptionID, err = GenerateUniqueSubscriptionID(subscriptions)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot generate unique subscription ID")
+	}
+
+	return
+}
+
+// record returns the record Subscription is stored as
+func (s *Subscription) record() *repository.Subscription {
+	return &repository.Subscription{
+		ID:              s.ID,
+		SubscriptionID:  s.SubscriptionID,
+		Status:          s.Status,
+		Currency:        s.Currency,
+		Amount:          s.Amount,
+		Interval:        s.Interval,
+		IntervalCount:   s.IntervalCount,
+		CustomerName:    s.Customer.Name,
+		CustomerEmail:   s.Customer.Email,
+		CustomerAddress: s.Customer.Address,
+		Description:     s.Description,
+		GracePeriodDays: s.GracePeriodDays,
+		StartDate:       s.StartDate,
+		NextBillingDate: s.NextBillingDate,
+		BilledCycles:    s.BilledCycles,
+		MissedCycles:    s.MissedCycles,
+		InvoiceID:       s.InvoiceID,
+		CreationTime:    s.CreationTime,
+		CancelledTime:   s.CancelledTime,
+		StoreID:         s.StoreID,
+	}
+}
+
+// subscriptionFromRecord returns the Subscription stored as r
+func subscriptionFromRecord(r *repository.Subscription) *Subscription {
+	return &Subscription{
+		SubscriptionID: r.SubscriptionID,
+		Status:         r.Status,
+		Currency:       r.Currency,
+		Amount:         r.Amount,
+		Interval:       r.Interval,
+		IntervalCount:  r.IntervalCount,
+		Customer: InvoiceCustomer{
+			Name:    r.CustomerName,
+			Email:   r.CustomerEmail,
+			Address: r.CustomerAddress,
+		},
+		Description:     r.Description,
+		GracePeriodDays: r.GracePeriodDays,
+		StartDate:       r.StartDate,
+		NextBillingDate: r.NextBillingDate,
+		MissedCycles:    r.MissedCycles,
+		InvoiceID:       r.InvoiceID,
+		CreationTime:    r.CreationTime,
+		CancelledTime:   r.CancelledTime,
+		BilledCycles:    r.BilledCycles,
+		ID:              r.ID,
+		StoreID:         r.StoreID,
+	}
+}
+
+// Insert inserts a Subscription into DB
+func (s *Subscription) Insert(subscriptions repository.SubscriptionRepository) error {
+	r := s.record()
+	err := subscriptions.Insert(r)
+	if err != nil {
+		return err
+	}
+	s.ID, s.CreationTime = r.ID, r.CreationTime
+
+	return nil
+}
+
+// ErrSubscriptionNotFound is returned when a subscription does not exist or is not owned by the store
+var ErrSubscriptionNotFound = errors.New("Subscription not found")
+
+// FetchSubscriptionFromID returns a Subscription of a store fetched from DB based on its Subscription ID
+func FetchSubscriptionFromID(subscriptions repository.SubscriptionRepository, subscriptionID string, storeID int) (s *Subscription, errCode int, err error) {
+	r, err := subscriptions.BySubscriptionID(subscriptionID)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, http.StatusNotFound, ErrSubscriptionNotFound
+		}
+
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch subscription")
+	}
+	if r.StoreID != storeID {
+		return nil, http.StatusNotFound, ErrSubscriptionNotFound
+	}
+
+	return subscriptionFromRecord(r), 0, nil
+}
+
+// ErrSubscriptionCancelled is returned when a subscription that is already cancelled is about to be cancelled
+var ErrSubscriptionCancelled = errors.New("Subscription is already cancelled")
+
+// CancelSubscription cancels a subscription of a store, so that it is no longer billed.
+// Invoices already issued for the subscription can still be paid.
+func CancelSubscription(subscriptions repository.SubscriptionRepository, subscriptionID string, storeID int) (s *Subscription, errCode int, err error) {
+	s, errCode, err = FetchSubscriptionFromID(subscriptions, subscriptionID, storeID)
+	if err != nil {
+		return nil, errCode, err
+	}
+
+	err = subscriptions.Cancel(s.ID)
+	if err == repository.ErrNotFound { // Subscription exists, therefore it was already cancelled
+		return nil, http.StatusConflict, ErrSubscriptionCancelled
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot cancel subscription")
+	}
+
+	return FetchSubscriptionFromID(subscriptions, subscriptionID, storeID)
+}
+
+// billSubscription issues the invoice of the current billing cycle of a subscription and moves it to the next cycle.
+// It returns a nil Invoice if the cycle was already billed or the subscription was cancelled in the meantime.
+func billSubscription(invoices repository.InvoiceRepository, subscriptions repository.SubscriptionRepository, s *Subscription) (*Invoice, error) {
+	billingDate, err := time.Parse(InvoiceDueDateLayout, s.NextBillingDate)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse billing date")
+	}
+
+	// Invoices of cycles billed late (e.g. server was offline) are due today at the earliest
+	dueDate := billingDate.AddDate(0, 0, s.GracePeriodDays)
+	if today := time.Now().UTC().Truncate(24 * time.Hour); dueDate.Before(today) {
+		dueDate = today
+	}
+
+	description := s.Description
+	if description == "" {
+		description = "Subscription"
+	}
+	details := InvoiceDetails{
+		Number:   fmt.Sprintf("%s-%d", s.SubscriptionID[:8], s.BilledCycles+1),
+		Customer: s.Customer,
+		LineItems: []LineItem{
+			{Description: description, Quantity: decimal.NewFromInt(1), UnitPrice: s.Amount},
+		},
+		DueDate: dueDate.Format(InvoiceDueDateLayout),
+		Notes:   "Billing period starting on " + s.NextBillingDate + ".",
+	}
+	i, _, err := CreateNewInvoice(invoices, s.Currency, details, s.StoreID)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create new invoice")
+	}
+	i.SubscriptionID = s.ID
+
+	r, err := i.record()
+	if err != nil {
+		return nil, err
+	}
+	billed, err := subscriptions.Bill(s.record(), r)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot bill subscription")
+	}
+	if !billed {
+		return nil, nil
+	}
+	i.ID, i.CreationTime = r.ID, r.CreationTime
+
+	return i, nil
+}
+
+// fetchStoreWebhook returns the title and the webhook of a store
+func fetchStoreWebhook(stores repository.StoreRepository, storeID int) (title string, w *processor.Webhook, err error) {
+	s, err := stores.ByID(storeID)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "cannot fetch store")
+	}
+
+	return s.Title, &processor.Webhook{URL: s.Webhook, SecretKey: s.WebhookSecretKey}, nil
+}
+
+// invoiceEmail returns the subject and body of the email an invoice issued for a subscription is sent to the customer with
+func invoiceEmail(storeTitle, payURL string, s *Subscription, i *Invoice) (subject, body string) {
+	subject = fmt.Sprintf("%s: invoice %s for your subscription", storeTitle, i.Number)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hello %s,\n\n", i.Customer.Name)
+	fmt.Fprintf(&b, "%s issued a new invoice of %s %s for your subscription", storeTitle, i.Total, i.Currency)
+	if s.Description != "" {
+		fmt.Fprintf(&b, " (%s)", s.Description)
+	}
+	fmt.Fprintf(&b, ".\nPlease pay it in DERO by %s at:\n\n%s\n\n", i.DueDate, payURL)
+	b.WriteString("This email was sent by DERO Merchant on behalf of " + storeTitle + ".\n")
+
+	return subject, b.String()
+}
+
+// notifySubscriptionInvoice sends the link to pay an invoice issued for a subscription to the customer by email and to the webhook of the store.
+// Its payment is created when the customer pays it from its hosted page.
+func notifySubscriptionInvoice(stores repository.StoreRepository, s *Subscription, i *Invoice) {
+	storeTitle, w, err := fetchStoreWebhook(stores, s.StoreID)
+	if err != nil {
+		log.Println("Error fetching store of subscription:", err)
+		return
+	}
+
+	payURL := config.BaseURL + "/invoice/" + i.InvoiceID
+
+	if w.IsSet() {
+		go w.SendSubscriptionEvent(&processor.SubscriptionEvent{
+			SubscriptionID: s.SubscriptionID,
+			Status:         s.Status,
+			InvoiceID:      i.InvoiceID,
+			PayURL:         payURL,
+		})
+	}
+
+	if mailer.IsSet() {
+		subject, body := invoiceEmail(storeTitle, payURL, s, i)
+		go func() {
+			err := mailer.Send(i.Customer.Email, subject, body)
+			if err != nil {
+				log.Println("Error sending subscription invoice email:", err)
+			}
+		}()
+	}
+}
+
+// updateMissedCycles updates the missed cycles of subscriptions that are not cancelled, i.e. the number of their invoices
+// still open after their due date. Subscriptions with missed cycles are past due, and become active again once they are all paid.
+// The webhooks of the stores are notified of the subscriptions whose status changed.
+func updateMissedCycles(stores repository.StoreRepository, subscriptions repository.SubscriptionRepository) error {
+	changes, err := subscriptions.UpdateMissedCycles()
+	if err != nil {
+		return errors.Wrap(err, "cannot update missed cycles")
+	}
+
+	for _, c := range changes {
+		if c.NewStatus == c.OldStatus {
+			continue
+		}
+
+		_, w, err := fetchStoreWebhook(stores, c.StoreID)
+		if err != nil {
+			log.Println("Error fetching store of subscription:", err)
+			continue
+		}
+
+		if w.IsSet() {
+			go w.SendSubscriptionEvent(&processor.SubscriptionEvent{
+				SubscriptionID: c.SubscriptionID,
+				Status:         c.NewStatus,
+			})
+		}
+	}
+
+	return nil
+}
+
+// BillDueSubscriptions issues the invoices of the subscriptions of stores not removed whose billing date has come,
+// sending them to the customers, then updates the missed cycles of subscriptions
+func BillDueSubscriptions(stores repository.StoreRepository, invoices repository.InvoiceRepository, subscriptions repository.SubscriptionRepository) error {
+	due, err := subscriptions.Due()
+	if err != nil {
+		return errors.Wrap(err, "cannot fetch due subscriptions")
+	}
+
+	removed := make(map[int]bool)
+	for _, r := range due {
+		if _, ok := removed[r.StoreID]; !ok {
+			st, err := stores.ByID(r.StoreID)
+			if err != nil {
+				log.Println("Error fetching store of subscription:", err)
+				continue
+			}
+			removed[r.StoreID] = st.Removed
+		}
+		if removed[r.StoreID] {
+			continue
+		}
+
+		s := subscriptionFromRecord(r)
+		i, err := billSubscription(invoices, subscriptions, s)
+		if err != nil {
+			log.Println("Error billing subscription:", err)
+			continue
+		}
+		if i == nil { // Already billed
+			continue
+		}
+
+		notifySubscriptionInvoice(stores, s, i)
+	}
+
+	return updateMissedCycles(stores, subscriptions)
+}
+
+// RunSubscriptionScheduler bills due subscriptions every SubscriptionSchedulerInterval. It is supposed to be run in its own goroutine.
+// Subscriptions that missed more than one billing cycle (e.g. server was offline) are billed one cycle per run.
+func RunSubscriptionScheduler(stores repository.StoreRepository, invoices repository.InvoiceRepository, subscriptions repository.SubscriptionRepository) {
+	for {
+		err := BillDueSubscriptions(stores, invoices, subscriptions)
+		if err != nil {
+			log.Println("Error billing due subscriptions:", err)
+		}
+
+		time.Sleep(SubscriptionSchedulerInterval)
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// Webhook resend errors
+var (
+	ErrWebhookNotSet         = errors.New("Webhook of the store is not set")
+	ErrWebhookDeliveryFailed = errors.New("Webhook delivery failed")
+)
+
+// WebhookDelivery represents a delivery of the status of a payment to the webhook of its store
+type WebhookDelivery = repository.WebhookDelivery
+
+// ResendPaymentWebhook sends the current status of a payment of a store to the webhook of the store again.
+// The delivery is recorded in payments whether it succeeds or not.
+func ResendPaymentWebhook(stores repository.StoreRepository, payments repository.PaymentRepository, paymentID string, storeID int) (errCode int, err error) {
+	p, errCode, err := FetchPaymentFromID(payments, paymentID, storeID)
+	if err != nil {
+		return
+	}
+
+	_, w, err := fetchStoreWebhook(stores, storeID)
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "cannot fetch store webhook")
+	}
+	if !w.IsSet() {
+		return http.StatusConflict, ErrWebhookNotSet
+	}
+
+	err = w.SendPaymentUpdateEvent(payments, p.PaymentID, p.Status, p.PaymentMetadata)
+	if err != nil {
+		return http.StatusBadGateway, ErrWebhookDeliveryFailed
+	}
+
+	return 0, nil
+}
@@ -2,21 +2,32 @@ package auth
 
 import (
 	"bytes"
-	"database/sql"
 	"encoding/hex"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/cryptoutil"
 	"github.com/peppinux/dero-merchant/httperror"
-	"github.com/peppinux/dero-merchant/postgres"
-	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/repository"
 )
 
+// activeStoreByAPIKey returns the store with apiKey, or repository.ErrNotFound if it does not exist or was removed
+func activeStoreByAPIKey(stores repository.StoreRepository, apiKey string) (*repository.Store, error) {
+	s, err := stores.ByAPIKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if s.Removed {
+		return nil, repository.ErrNotFound
+	}
+	return s, nil
+}
+
 // APIKeyAuth provides a middleware that rejects unauthenticated requests to the API
-func APIKeyAuth() gin.HandlerFunc {
+func APIKeyAuth(stores repository.StoreRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get and validate X-API-Key header
 		apiKey := c.GetHeader("X-API-Key")
@@ -25,18 +36,14 @@ func APIKeyAuth() gin.HandlerFunc {
 			return
 		}
 
-		// Fetch Store ID associated to (hashed) API Key from Redis
+		// Fetch Store ID associated to (hashed) API Key from cache
 		hashedAPIKey := cryptoutil.HashStringToSHA256Hex(apiKey)
-		storeID, err := redis.GetAPIKeyStore(hashedAPIKey)
+		storeID, err := cache.Default.GetAPIKeyStore(hashedAPIKey)
 		if err != nil {
-			// If Store ID was not found in Redis, try fetching it from DB
-			err := postgres.DB.QueryRow(`
-				SELECT id
-				FROM stores
-				WHERE api_key=$1 AND removed=$2`, apiKey, false).
-				Scan(&storeID)
+			// If Store ID was not found in cache, try fetching it from DB
+			s, err := activeStoreByAPIKey(stores, apiKey)
 			if err != nil {
-				if err == sql.ErrNoRows { // No store associated to API Key was found
+				if err == repository.ErrNotFound { // No store associated to API Key was found
 					httperror.Send(c, http.StatusForbidden, "Invalid API Key")
 				} else {
 					httperror.Send500(c, err, "Error querying database")
@@ -44,9 +51,10 @@ func APIKeyAuth() gin.HandlerFunc {
 
 				return
 			}
+			storeID = s.ID
 
-			// Store value in Redis for quick retrieving in future requests
-			redis.SetAPIKeyStore(hashedAPIKey, storeID)
+			// Store value in cache for quick retrieving in future requests
+			cache.Default.SetAPIKeyStore(hashedAPIKey, storeID)
 		}
 
 		c.Set("apiKey", apiKey)
@@ -56,7 +64,7 @@ func APIKeyAuth() gin.HandlerFunc {
 }
 
 // SecretKeyAuth provides a middleware that rejects unauthorized requests to the API. Needs to be used in conjuction with APIKeyAuth
-func SecretKeyAuth() gin.HandlerFunc {
+func SecretKeyAuth(stores repository.StoreRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get and validate X-Signature header
 		signature := c.GetHeader("X-Signature")
@@ -68,17 +76,13 @@ func SecretKeyAuth() gin.HandlerFunc {
 		apiKey := c.MustGet("apiKey").(string)
 		hashedAPIKey := cryptoutil.HashStringToSHA256Hex(apiKey)
 
-		// Fetch Secret Key associated to API Key from Redis
-		secretKey, err := redis.GetAPIKeySecretKey(hashedAPIKey)
+		// Fetch Secret Key associated to API Key from cache
+		secretKey, err := cache.Default.GetAPIKeySecretKey(hashedAPIKey)
 		if err != nil {
-			// If Secret Key was not found in Redis, try fetching it from DB
-			err = postgres.DB.QueryRow(`
-				SELECT secret_key
-				FROM stores
-				WHERE api_key=$1 AND removed=$2`, apiKey, false).
-				Scan(&secretKey)
+			// If Secret Key was not found in cache, try fetching it from DB
+			s, err := activeStoreByAPIKey(stores, apiKey)
 			if err != nil {
-				if err == sql.ErrNoRows {
+				if err == repository.ErrNotFound {
 					httperror.Send(c, http.StatusUnauthorized, "Invalid Signature")
 				} else {
 					httperror.Send500(c, err, "Error querying database")
@@ -86,9 +90,10 @@ func SecretKeyAuth() gin.HandlerFunc {
 
 				return
 			}
+			secretKey = s.SecretKey
 
-			// Store value in Redis for quick retrieving in future requests
-			redis.SetAPIKeySecretKey(hashedAPIKey, secretKey)
+			// Store value in cache for quick retrieving in future requests
+			cache.Default.SetAPIKeySecretKey(hashedAPIKey, secretKey)
 		}
 
 		body, _ := c.GetRawData()                                // Read request body from stream
@@ -12,10 +12,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/config"
 	"github.com/peppinux/dero-merchant/cryptoutil"
-	"github.com/peppinux/dero-merchant/postgres"
-	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/repository"
 	"github.com/peppinux/dero-merchant/stringutil"
 )
 
@@ -45,6 +45,7 @@ type APIAuthTestSuite struct {
 
 	mockUser  *APIAuthUserMock
 	mockStore *APIAuthStoreMock
+	repos     *repository.Repositories
 
 	doRequest func(r *gin.Engine, body, apiKey, secretKey string) *httptest.ResponseRecorder
 }
@@ -55,24 +56,9 @@ func (suite *APIAuthTestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	redis.Pool = redis.NewPool(config.TestRedisAddress)
-	err = redis.Ping()
-	if err != nil {
-		panic(err)
-	}
-
-	err = redis.FlushAll()
-	if err != nil {
-		panic(err)
-	}
-
-	postgres.DB, err = postgres.Connect(config.TestDBName, config.TestDBUser, config.TestDBPassword, config.TestDBHost, config.TestDBPort, "disable") // TODO: Enable SSLMode?
-	if err != nil {
-		panic(err)
-	}
+	cache.Default = cache.NewMemoryCache()
 
-	postgres.DropTables()
-	postgres.CreateTablesIfNotExist()
+	suite.repos = repository.NewMemory()
 
 	suite.mockUser = &APIAuthUserMock{
 		Username: "Test user foo",
@@ -100,11 +86,14 @@ func (suite *APIAuthTestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	err = postgres.DB.QueryRow(`
-		INSERT INTO users (username, email, password, verification_token, email_verified)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`, u.Username, u.Email, u.HashedPassword, u.VerificationToken, u.Verified).
-		Scan(&u.ID)
+	user := &repository.User{Username: u.Username, Email: u.Email, Password: u.HashedPassword}
+	err = suite.repos.Users.Insert(user, u.VerificationToken)
+	if err != nil {
+		panic(err)
+	}
+	u.ID = user.ID
+
+	err = suite.repos.Users.VerifyEmail(u.ID)
 	if err != nil {
 		panic(err)
 	}
@@ -126,14 +115,12 @@ func (suite *APIAuthTestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	err = postgres.DB.QueryRow(`
-		INSERT INTO stores (title, wallet_view_key, webhook, webhook_secret_key, api_key, secret_key, owner_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id`, s.Title, s.ViewKey, s.Webhook, s.WebhookSecretKey, s.APIKey, s.SecretKey, s.OwnerID).
-		Scan(&s.ID)
+	store := &repository.Store{Title: s.Title, WalletViewKey: s.ViewKey, Webhook: s.Webhook, WebhookSecretKey: s.WebhookSecretKey, APIKey: s.APIKey, SecretKey: s.SecretKey, OwnerID: s.OwnerID}
+	err = suite.repos.Stores.Insert(store)
 	if err != nil {
 		panic(err)
 	}
+	s.ID = store.ID
 
 	// HTTP request reusable function
 	suite.doRequest = func(r *gin.Engine, body, apiKey, secretKey string) *httptest.ResponseRecorder {
@@ -153,14 +140,6 @@ func (suite *APIAuthTestSuite) SetupSuite() {
 	}
 }
 
-func (suite *APIAuthTestSuite) TearDownSuite() {
-	redis.FlushAll()
-	redis.Pool.Close()
-
-	postgres.DropTables()
-	postgres.DB.Close()
-}
-
 func TestAPIAuthTestSuite(t *testing.T) {
 	suite.Run(t, new(APIAuthTestSuite))
 }
@@ -171,7 +150,7 @@ func (suite *APIAuthTestSuite) TestAPIKeyAuth() {
 	// Setup router
 	r := gin.Default()
 	r.Use(gzip.Gzip(gzip.DefaultCompression))
-	r.Use(APIKeyAuth())
+	r.Use(APIKeyAuth(suite.repos.Stores))
 
 	r.POST("/", func(c *gin.Context) {
 		c.Status(http.StatusOK)
@@ -205,8 +184,8 @@ func (suite *APIAuthTestSuite) TestSecretKeyAuth() {
 	// Setup router
 	r := gin.Default()
 	r.Use(gzip.Gzip(gzip.DefaultCompression))
-	r.Use(APIKeyAuth())
-	r.Use(SecretKeyAuth())
+	r.Use(APIKeyAuth(suite.repos.Stores))
+	r.Use(SecretKeyAuth(suite.repos.Stores))
 
 	r.POST("/", func(c *gin.Context) {
 		c.Status(http.StatusOK)
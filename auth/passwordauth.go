@@ -9,11 +9,11 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/peppinux/dero-merchant/httperror"
-	"github.com/peppinux/dero-merchant/postgres"
+	"github.com/peppinux/dero-merchant/repository"
 )
 
 // RequireUserPassword provides a middleware that requires users – authenticated by their Session ID – to provide additional authorization by confirming their password
-func RequireUserPassword() gin.HandlerFunc {
+func RequireUserPassword(users repository.UserRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		h := c.GetHeader("Authorization")
 		if len(h) == 0 {
@@ -38,17 +38,15 @@ func RequireUserPassword() gin.HandlerFunc {
 
 		userID := c.MustGet("session").(*Session).UserID
 
-		var hashedPassword string
-		err := postgres.DB.QueryRow(`
-			SELECT password
-			FROM users
-			WHERE id=$1 AND email_verified=$2`, userID, true).
-			Scan(&hashedPassword)
+		u, err := users.ByID(userID)
+		if err == nil && !u.EmailVerified {
+			err = repository.ErrNotFound
+		}
 		if httperror.Send500IfErr(c, err, "Error querying databse") != nil {
 			return
 		}
 
-		match, err := argon2id.ComparePasswordAndHash(password, hashedPassword)
+		match, err := argon2id.ComparePasswordAndHash(password, u.Password)
 		if httperror.Send500IfErr(c, err, "Error comparing passwords") != nil {
 			return
 		}
@@ -10,10 +10,9 @@ import (
 	"github.com/alexedwards/argon2id"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/config"
-	"github.com/peppinux/dero-merchant/postgres"
-	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/repository"
 	"github.com/peppinux/dero-merchant/stringutil"
 	"github.com/stretchr/testify/suite"
 )
@@ -38,6 +37,7 @@ type PasswordAuthTestSuite struct {
 	suite.Suite
 
 	mockUsers map[string]*PasswordAuthUserMock
+	users     repository.UserRepository
 
 	doRequest func(r *gin.Engine, sessionID, authHeader string) *httptest.ResponseRecorder
 }
@@ -48,24 +48,9 @@ func (suite *PasswordAuthTestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	redis.Pool = redis.NewPool(config.TestRedisAddress)
-	err = redis.Ping()
-	if err != nil {
-		panic(err)
-	}
+	cache.Default = cache.NewMemoryCache()
 
-	err = redis.FlushAll()
-	if err != nil {
-		panic(err)
-	}
-
-	postgres.DB, err = postgres.Connect(config.TestDBName, config.TestDBUser, config.TestDBPassword, config.TestDBHost, config.TestDBPort, "disable") // TODO: Enable SSLMode?
-	if err != nil {
-		panic(err)
-	}
-
-	postgres.DropTables()
-	postgres.CreateTablesIfNotExist()
+	suite.users = repository.NewMemoryUserRepository()
 
 	suite.mockUsers = map[string]*PasswordAuthUserMock{
 		"valid": {
@@ -91,11 +76,12 @@ func (suite *PasswordAuthTestSuite) SetupSuite() {
 		u.HashedPassword, _ = argon2id.CreateHash(u.Password, argon2id.DefaultParams)
 		u.VerificationToken, _ = stringutil.RandomBase64RawURLString(48)
 
-		postgres.DB.QueryRow(`
-			INSERT INTO users (username, email, password, verification_token, email_verified)
-			VALUES ($1, $2, $3, $4, $5)
-			RETURNING id`, u.Username, u.Email, u.HashedPassword, u.VerificationToken, u.Verified).
-			Scan(&u.ID)
+		user := &repository.User{Username: u.Username, Email: u.Email, Password: u.HashedPassword}
+		suite.users.Insert(user, u.VerificationToken)
+		u.ID = user.ID
+		if u.Verified {
+			suite.users.VerifyEmail(u.ID)
+		}
 	}
 
 	// Mock valid user's session
@@ -124,14 +110,6 @@ func (suite *PasswordAuthTestSuite) SetupSuite() {
 	}
 }
 
-func (suite *PasswordAuthTestSuite) TearDownSuite() {
-	redis.FlushAll()
-	redis.Pool.Close()
-
-	postgres.DropTables()
-	postgres.DB.Close()
-}
-
 func TestPasswordAuthTestSuite(t *testing.T) {
 	suite.Run(t, new(PasswordAuthTestSuite))
 }
@@ -142,7 +120,7 @@ func (suite *PasswordAuthTestSuite) TestRequireUserPassword() {
 	r.Use(gzip.Gzip(gzip.DefaultCompression))
 	r.Use(SessionAuth())
 	r.Use(SessionAuthOrForbidden())
-	r.Use(RequireUserPassword())
+	r.Use(RequireUserPassword(suite.users))
 
 	r.POST("/", func(c *gin.Context) {
 		c.Status(http.StatusOK)
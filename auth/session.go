@@ -3,8 +3,8 @@ package auth
 import (
 	"github.com/pkg/errors"
 
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/cryptoutil"
-	"github.com/peppinux/dero-merchant/redis"
 	"github.com/peppinux/dero-merchant/stringutil"
 )
 
@@ -15,7 +15,7 @@ type Session struct {
 	UserID   int
 }
 
-// GetSessionFromCookie returns a new user Session loaded from Redis through the sessionid cookie
+// GetSessionFromCookie returns a new user Session loaded from cache through the sessionid cookie
 func GetSessionFromCookie(cookie string) (s *Session) {
 	s = &Session{}
 
@@ -26,7 +26,7 @@ func GetSessionFromCookie(cookie string) (s *Session) {
 	s.ID = cryptoutil.HashStringToSHA256Hex(cookie)
 
 	var err error
-	s.UserID, err = redis.GetSessionUser(s.ID)
+	s.UserID, err = cache.Default.GetSessionUser(s.ID)
 	if err != nil {
 		s.SignedIn = false
 	} else {
@@ -38,34 +38,34 @@ func GetSessionFromCookie(cookie string) (s *Session) {
 
 // Username returns the username of the user associated to the session
 func (s *Session) Username() (username string, err error) {
-	username, err = redis.GetUserUsername(s.UserID)
+	username, err = cache.Default.GetUserUsername(s.UserID)
 	if err != nil {
-		err = errors.Wrap(err, "cannot get user's username from Redis")
+		err = errors.Wrap(err, "cannot get user's username from cache")
 	}
 	return
 }
 
 // Email returns the email of the user associated to the session
 func (s *Session) Email() (email string, err error) {
-	email, err = redis.GetUserEmail(s.UserID)
+	email, err = cache.Default.GetUserEmail(s.UserID)
 	if err != nil {
-		err = errors.Wrap(err, "cannot get user's email from Redis")
+		err = errors.Wrap(err, "cannot get user's email from cache")
 	}
 	return
 }
 
 // StoresMap returns a map of the stores (ID: Title) of the user associated to the session
 func (s *Session) StoresMap() (storesMap map[int]string, err error) {
-	stores, err := redis.GetUserStores(s.UserID)
+	stores, err := cache.Default.GetUserStores(s.UserID)
 	if err != nil {
-		err = errors.Wrap(err, "cannot get user's stores from Redis")
+		err = errors.Wrap(err, "cannot get user's stores from cache")
 		return
 	}
 
 	storesMap = make(map[int]string, len(stores))
 
 	for _, storeID := range stores {
-		storesMap[storeID], err = redis.GetStoreTitle(storeID)
+		storesMap[storeID], err = cache.Default.GetStoreTitle(storeID)
 		if err != nil {
 			err = errors.Wrap(err, "cannot get store's title")
 			return
@@ -90,7 +90,7 @@ func GenerateUniqueSessionID() (sessionID string, err error) {
 		}
 
 		// Get User ID associated to generated Session ID
-		userID, _ := redis.GetSessionUser(sessionID)
+		userID, _ := cache.Default.GetSessionUser(sessionID)
 
 		// If NO User ID is found, generated Session ID is unique, therefore return its value
 		if userID == 0 {
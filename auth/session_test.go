@@ -5,9 +5,8 @@ import (
 
 	"github.com/stretchr/testify/suite"
 
-	"github.com/peppinux/dero-merchant/config"
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/cryptoutil"
-	"github.com/peppinux/dero-merchant/redis"
 	"github.com/peppinux/dero-merchant/stringutil"
 )
 
@@ -19,26 +18,7 @@ type SessionTestSuite struct {
 }
 
 func (suite *SessionTestSuite) SetupSuite() {
-	err := config.LoadFromENV("../.env")
-	if err != nil {
-		panic(err)
-	}
-
-	redis.Pool = redis.NewPool(config.RedisAddress)
-	err = redis.Ping()
-	if err != nil {
-		panic(err)
-	}
-
-	err = redis.FlushAll()
-	if err != nil {
-		panic(err)
-	}
-}
-
-func (suite *SessionTestSuite) TearDownSuite() {
-	redis.FlushAll()
-	redis.Pool.Close()
+	cache.Default = cache.NewMemoryCache()
 }
 
 func TestSessionTestSuite(t *testing.T) {
@@ -48,7 +28,7 @@ func TestSessionTestSuite(t *testing.T) {
 func mockSessionID(userID int) (sessionID, hash string) {
 	sessionID, _ = GenerateUniqueSessionID()
 	hash = cryptoutil.HashStringToSHA256Hex(sessionID)
-	redis.SetSessionUser(hash, userID)
+	cache.Default.SetSessionUser(hash, userID)
 	return
 }
 
@@ -95,7 +75,7 @@ func (suite *SessionTestSuite) TestGetSessionFromCookie() {
 func (suite *SessionTestSuite) TestUsername() {
 	userID := 123
 	username := "foobar"
-	redis.SetUserUsername(userID, username)
+	cache.Default.SetUserUsername(userID, username)
 
 	sessionID, _ := mockSessionID(userID)
 
@@ -116,7 +96,7 @@ func (suite *SessionTestSuite) TestUsername() {
 func (suite *SessionTestSuite) TestEmail() {
 	userID := 123
 	email := "foo@bar.baz"
-	redis.SetUserEmail(userID, email)
+	cache.Default.SetUserEmail(userID, email)
 
 	sessionID, _ := mockSessionID(userID)
 
@@ -145,8 +125,8 @@ func (suite *SessionTestSuite) TestStoresMap() {
 	sessionID, _ := mockSessionID(userID)
 
 	for id, title := range storesMap {
-		redis.AddUserStore(userID, id)
-		redis.SetStoreTitle(id, title)
+		cache.Default.AddUserStore(userID, id)
+		cache.Default.SetStoreTitle(id, title)
 	}
 
 	session := GetSessionFromCookie(sessionID)
@@ -10,8 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/suite"
 
-	"github.com/peppinux/dero-merchant/config"
-	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/cache"
 )
 
 type SessionAuthTestSuite struct {
@@ -21,21 +20,7 @@ type SessionAuthTestSuite struct {
 }
 
 func (suite *SessionAuthTestSuite) SetupSuite() {
-	err := config.LoadFromENV("../.env")
-	if err != nil {
-		panic(err)
-	}
-
-	redis.Pool = redis.NewPool(config.TestRedisAddress)
-	err = redis.Ping()
-	if err != nil {
-		panic(err)
-	}
-
-	err = redis.FlushAll()
-	if err != nil {
-		panic(err)
-	}
+	cache.Default = cache.NewMemoryCache()
 
 	// HTTP request reusable function
 	suite.doRequest = func(r *gin.Engine, sessionID string) *httptest.ResponseRecorder {
@@ -50,11 +35,6 @@ func (suite *SessionAuthTestSuite) SetupSuite() {
 	}
 }
 
-func (suite *SessionAuthTestSuite) TearDownSuite() {
-	redis.FlushAll()
-	redis.Pool.Close()
-}
-
 func TestSessionAuthTestSuite(t *testing.T) {
 	suite.Run(t, new(SessionAuthTestSuite))
 }
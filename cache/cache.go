@@ -0,0 +1,84 @@
+// Package cache provides the cache of sessions, API Keys, store titles and supported currencies used by the application.
+// It is implemented on top of Redis, used by the application, and in memory, used by tests that do not need a Redis server.
+package cache
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned when a key is not cached (or expired)
+var ErrNotFound = errors.New("key not found in cache")
+
+// Default is the global Cache set up in main
+var Default Cache
+
+// Cache caches data that is either short-lived (sessions) or frequently accessed (users' stores, API Keys, supported currencies).
+// TTLs are in seconds. Keys set without a TTL never expire, and setting a key again removes its TTL.
+type Cache interface {
+	// SetSessionUser sets the user of a session
+	SetSessionUser(sessionID string, userID int) error
+	// SetSessionExpiration sets a session to expire after ttl seconds
+	SetSessionExpiration(sessionID string, ttl int) error
+	// GetSessionUser returns the user of a session, or ErrNotFound
+	GetSessionUser(sessionID string) (userID int, err error)
+	// DeleteSession deletes a session
+	DeleteSession(sessionID string) error
+
+	// AddUserSession adds a session to the sessions of a user
+	AddUserSession(userID int, sessionID string) error
+	// RemoveUserSession removes a session from the sessions of a user
+	RemoveUserSession(userID int, sessionID string) error
+	// GetUserSessions returns the sessions of a user
+	GetUserSessions(userID int) (sessionIDs []string, err error)
+	// DeleteUserSessions deletes the sessions of a user. The sessions themselves have to be deleted with DeleteSession.
+	DeleteUserSessions(userID int) error
+
+	// SetUserUsername sets the username of a user
+	SetUserUsername(userID int, username string) error
+	// GetUserUsername returns the username of a user, or ErrNotFound
+	GetUserUsername(userID int) (username string, err error)
+	// SetUserEmail sets the email of a user
+	SetUserEmail(userID int, email string) error
+	// GetUserEmail returns the email of a user, or ErrNotFound
+	GetUserEmail(userID int) (email string, err error)
+
+	// AddUserStore adds a store to the stores of a user
+	AddUserStore(userID, storeID int) error
+	// GetUserStores returns the stores of a user
+	GetUserStores(userID int) (storeIDs []int, err error)
+	// UserOwnsStore returns whether a store is one of the stores of a user or not
+	UserOwnsStore(userID, storeID int) (bool, error)
+	// RemoveUserStore removes a store from the stores of a user
+	RemoveUserStore(userID, storeID int) error
+	// GetUsersWithStores returns the users whose stores are cached
+	GetUsersWithStores() (userIDs []int, err error)
+
+	// SetStoreTitle sets the title of a store
+	SetStoreTitle(storeID int, title string) error
+	// GetStoreTitle returns the title of a store, or ErrNotFound
+	GetStoreTitle(storeID int) (title string, err error)
+	// DeleteStoreTitle deletes the title of a store
+	DeleteStoreTitle(storeID int) error
+	// GetStoresWithTitle returns the stores whose title is cached
+	GetStoresWithTitle() (storeIDs []int, err error)
+
+	// SetAPIKeyStore sets the store of an API Key
+	SetAPIKeyStore(apiKey string, storeID int) error
+	// GetAPIKeyStore returns the store of an API Key, or ErrNotFound
+	GetAPIKeyStore(apiKey string) (storeID int, err error)
+	// DeleteAPIKeyStore deletes the store of an API Key
+	DeleteAPIKeyStore(apiKey string) error
+	// SetAPIKeySecretKey sets the Secret Key of an API Key
+	SetAPIKeySecretKey(apiKey, secretKey string) error
+	// GetAPIKeySecretKey returns the Secret Key of an API Key, or ErrNotFound
+	GetAPIKeySecretKey(apiKey string) (secretKey string, err error)
+	// DeleteAPIKeySecretKey deletes the Secret Key of an API Key
+	DeleteAPIKeySecretKey(apiKey string) error
+	// GetAPIKeys returns the API Keys whose store or Secret Key is cached
+	GetAPIKeys() (apiKeys []string, err error)
+
+	// SetSupportedCurrencies adds currencies to the supported currencies
+	SetSupportedCurrencies(currencies []string) error
+	// IsSupportedCurrency returns whether currency is supported or not
+	IsSupportedCurrency(currency string) (bool, error)
+}
@@ -0,0 +1,320 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peppinux/dero-merchant/config"
+	"github.com/peppinux/dero-merchant/redis"
+)
+
+// testCache runs the tests every Cache implementation has to pass
+func testCache(t *testing.T, c Cache) {
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, c) })
+	t.Run("SessionExpiration", func(t *testing.T) { testSessionExpiration(t, c) })
+	t.Run("UserSessions", func(t *testing.T) { testUserSessions(t, c) })
+	t.Run("UserData", func(t *testing.T) { testUserData(t, c) })
+	t.Run("UserStores", func(t *testing.T) { testUserStores(t, c) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, c) })
+	t.Run("SupportedCurrencies", func(t *testing.T) { testSupportedCurrencies(t, c) })
+	t.Run("Listing", func(t *testing.T) { testListing(t, c) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, c) })
+}
+
+func TestMemoryCache(t *testing.T) {
+	testCache(t, NewMemoryCache())
+}
+
+func TestRedisCache(t *testing.T) {
+	err := config.LoadFromENV("../.env")
+	if err != nil {
+		t.Skip("Cannot load test configuration:", err)
+	}
+
+	redis.Pool = redis.NewPool(config.TestRedisAddress)
+	redis.Namespace = config.TestRedisNamespace
+	defer redis.Pool.Close()
+
+	err = redis.Ping()
+	if err != nil {
+		t.Skip("Redis server not available:", err)
+	}
+
+	redis.FlushNamespace()
+	defer redis.FlushNamespace()
+
+	testCache(t, NewRedisCache())
+}
+
+func testSessions(t *testing.T, c Cache) {
+	sessionID := "session-foo"
+	userID := 123
+
+	_, err := c.GetSessionUser(sessionID)
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, c.SetSessionUser(sessionID, userID))
+	uid, err := c.GetSessionUser(sessionID)
+	assert.Nil(t, err)
+	assert.Equal(t, userID, uid)
+
+	assert.Nil(t, c.DeleteSession(sessionID))
+	uid, err = c.GetSessionUser(sessionID)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Zero(t, uid)
+
+	// Deleting a session that does not exist is not an error
+	assert.Nil(t, c.DeleteSession(sessionID))
+}
+
+func testSessionExpiration(t *testing.T, c Cache) {
+	expiring := "session-expiring"
+	persistent := "session-persistent"
+	reset := "session-reset"
+
+	assert.Nil(t, c.SetSessionUser(expiring, 1))
+	assert.Nil(t, c.SetSessionUser(persistent, 2))
+	assert.Nil(t, c.SetSessionUser(reset, 3))
+
+	assert.Nil(t, c.SetSessionExpiration(expiring, 1))
+	assert.Nil(t, c.SetSessionExpiration(persistent, 60))
+	assert.Nil(t, c.SetSessionExpiration(reset, 1))
+	// Setting a key again removes its TTL
+	assert.Nil(t, c.SetSessionUser(reset, 3))
+
+	// Expiring a session that does not exist is not an error
+	assert.Nil(t, c.SetSessionExpiration("session-unset", 1))
+
+	uid, err := c.GetSessionUser(expiring)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, uid)
+
+	time.Sleep(1500 * time.Millisecond)
+
+	_, err = c.GetSessionUser(expiring)
+	assert.Equal(t, ErrNotFound, err)
+
+	uid, err = c.GetSessionUser(persistent)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, uid)
+
+	uid, err = c.GetSessionUser(reset)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, uid)
+
+	_, err = c.GetSessionUser("session-unset")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func testUserSessions(t *testing.T, c Cache) {
+	userID := 123
+
+	sessions, err := c.GetUserSessions(userID)
+	assert.Nil(t, err)
+	assert.Empty(t, sessions)
+
+	assert.Nil(t, c.AddUserSession(userID, "foo"))
+	assert.Nil(t, c.AddUserSession(userID, "bar"))
+	assert.Nil(t, c.AddUserSession(userID, "bar"))
+
+	sessions, err = c.GetUserSessions(userID)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"foo", "bar"}, sessions)
+
+	assert.Nil(t, c.RemoveUserSession(userID, "foo"))
+	assert.Nil(t, c.RemoveUserSession(userID, "baz"))
+
+	sessions, err = c.GetUserSessions(userID)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bar"}, sessions)
+
+	assert.Nil(t, c.DeleteUserSessions(userID))
+
+	sessions, err = c.GetUserSessions(userID)
+	assert.Nil(t, err)
+	assert.Empty(t, sessions)
+}
+
+func testUserData(t *testing.T, c Cache) {
+	userID := 123
+
+	_, err := c.GetUserUsername(userID)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = c.GetUserEmail(userID)
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, c.SetUserUsername(userID, "foo"))
+	assert.Nil(t, c.SetUserEmail(userID, "foo@bar.baz"))
+	assert.Nil(t, c.SetUserEmail(userID, "bar@bar.baz"))
+
+	username, err := c.GetUserUsername(userID)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", username)
+
+	email, err := c.GetUserEmail(userID)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar@bar.baz", email)
+}
+
+func testUserStores(t *testing.T, c Cache) {
+	userID := 123
+
+	stores, err := c.GetUserStores(userID)
+	assert.Nil(t, err)
+	assert.Empty(t, stores)
+
+	for _, storeID := range []int{1, 2, 3} {
+		assert.Nil(t, c.AddUserStore(userID, storeID))
+		assert.Nil(t, c.SetStoreTitle(storeID, "Store"))
+	}
+
+	stores, err = c.GetUserStores(userID)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 2, 3}, stores)
+
+	owns, err := c.UserOwnsStore(userID, 2)
+	assert.Nil(t, err)
+	assert.True(t, owns)
+
+	owns, err = c.UserOwnsStore(userID+1, 2)
+	assert.Nil(t, err)
+	assert.False(t, owns)
+
+	assert.Nil(t, c.RemoveUserStore(userID, 2))
+	assert.Nil(t, c.DeleteStoreTitle(2))
+
+	owns, _ = c.UserOwnsStore(userID, 2)
+	assert.False(t, owns)
+
+	stores, _ = c.GetUserStores(userID)
+	assert.ElementsMatch(t, []int{1, 3}, stores)
+
+	title, err := c.GetStoreTitle(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Store", title)
+
+	_, err = c.GetStoreTitle(2)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func testAPIKeys(t *testing.T, c Cache) {
+	apiKey := "apikey-foo"
+
+	_, err := c.GetAPIKeyStore(apiKey)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = c.GetAPIKeySecretKey(apiKey)
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, c.SetAPIKeyStore(apiKey, 42))
+	assert.Nil(t, c.SetAPIKeySecretKey(apiKey, "secretkey-foo"))
+
+	storeID, err := c.GetAPIKeyStore(apiKey)
+	assert.Nil(t, err)
+	assert.Equal(t, 42, storeID)
+
+	secretKey, err := c.GetAPIKeySecretKey(apiKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "secretkey-foo", secretKey)
+
+	assert.Nil(t, c.DeleteAPIKeyStore(apiKey))
+	assert.Nil(t, c.DeleteAPIKeySecretKey(apiKey))
+
+	_, err = c.GetAPIKeyStore(apiKey)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = c.GetAPIKeySecretKey(apiKey)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func testSupportedCurrencies(t *testing.T, c Cache) {
+	supported, err := c.IsSupportedCurrency("USD")
+	assert.Nil(t, err)
+	assert.False(t, supported)
+
+	assert.Nil(t, c.SetSupportedCurrencies([]string{"USD", "EUR"}))
+	assert.Nil(t, c.SetSupportedCurrencies([]string{"EUR", "GBP"}))
+
+	for _, currency := range []string{"USD", "EUR", "GBP"} {
+		supported, err = c.IsSupportedCurrency(currency)
+		assert.Nil(t, err)
+		assert.True(t, supported)
+	}
+
+	supported, err = c.IsSupportedCurrency("XYZ")
+	assert.Nil(t, err)
+	assert.False(t, supported)
+}
+
+func testListing(t *testing.T, c Cache) {
+	userID := 789
+	storeID := 789
+	apiKey := "apikey-listing"
+
+	users, err := c.GetUsersWithStores()
+	assert.Nil(t, err)
+	assert.NotContains(t, users, userID)
+
+	assert.Nil(t, c.AddUserStore(userID, storeID))
+	assert.Nil(t, c.SetStoreTitle(storeID, "Listed store"))
+	assert.Nil(t, c.SetAPIKeySecretKey(apiKey, "secretkey-listing"))
+
+	users, err = c.GetUsersWithStores()
+	assert.Nil(t, err)
+	assert.Contains(t, users, userID)
+
+	stores, err := c.GetStoresWithTitle()
+	assert.Nil(t, err)
+	assert.Contains(t, stores, storeID)
+
+	apiKeys, err := c.GetAPIKeys()
+	assert.Nil(t, err)
+	assert.Contains(t, apiKeys, apiKey)
+
+	// API Keys are listed once even if both their store and Secret Key are cached
+	assert.Nil(t, c.SetAPIKeyStore(apiKey, storeID))
+	apiKeys, _ = c.GetAPIKeys()
+	count := 0
+	for _, k := range apiKeys {
+		if k == apiKey {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+
+	assert.Nil(t, c.RemoveUserStore(userID, storeID))
+	assert.Nil(t, c.DeleteStoreTitle(storeID))
+	assert.Nil(t, c.DeleteAPIKeyStore(apiKey))
+	assert.Nil(t, c.DeleteAPIKeySecretKey(apiKey))
+
+	users, _ = c.GetUsersWithStores()
+	assert.NotContains(t, users, userID)
+	stores, _ = c.GetStoresWithTitle()
+	assert.NotContains(t, stores, storeID)
+	apiKeys, _ = c.GetAPIKeys()
+	assert.NotContains(t, apiKeys, apiKey)
+}
+
+func testConcurrency(t *testing.T, c Cache) {
+	userID := 456
+	count := 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(storeID int) {
+			defer wg.Done()
+
+			assert.Nil(t, c.AddUserStore(userID, storeID))
+			assert.Nil(t, c.SetStoreTitle(storeID, "Concurrent store"))
+			c.UserOwnsStore(userID, storeID)
+			c.GetStoreTitle(storeID)
+		}(i + 1)
+	}
+	wg.Wait()
+
+	stores, err := c.GetUserStores(userID)
+	assert.Nil(t, err)
+	assert.Len(t, stores, count)
+}
@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/cryptoutil"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// ConsistencyCheckInterval is how often RunConsistencyChecker checks the cache
+var ConsistencyCheckInterval = 10 * time.Minute
+
+// Warm caches the stores of users, the titles of stores and the store and Secret Key of (hashed) API Keys
+// of all the stores that are not removed, so that they do not need to be fetched from DB again.
+// Entries of removed stores are left untouched: they are deleted by Check.
+func Warm(c Cache, stores repository.StoreRepository) error {
+	active, err := stores.Active()
+	if err != nil {
+		return errors.Wrap(err, "cannot get active stores")
+	}
+
+	for _, s := range active {
+		err = c.AddUserStore(s.OwnerID, s.ID)
+		if err != nil {
+			return errors.Wrap(err, "cannot cache user store")
+		}
+
+		err = c.SetStoreTitle(s.ID, s.Title)
+		if err != nil {
+			return errors.Wrap(err, "cannot cache store title")
+		}
+
+		hashedAPIKey := cryptoutil.HashStringToSHA256Hex(s.APIKey)
+		err = c.SetAPIKeyStore(hashedAPIKey, s.ID)
+		if err != nil {
+			return errors.Wrap(err, "cannot cache API Key store")
+		}
+		err = c.SetAPIKeySecretKey(hashedAPIKey, s.SecretKey)
+		if err != nil {
+			return errors.Wrap(err, "cannot cache API Key secret key")
+		}
+	}
+
+	return nil
+}
+
+// Check compares the stores of users, the titles of stores and the API Keys in the cache with the stores that are not removed,
+// repairing the entries that are missing, outdated or belong to stores that were removed (or whose API Key was regenerated).
+// It returns a description of every repair.
+func Check(c Cache, stores repository.StoreRepository) (repairs []string, err error) {
+	active, err := stores.Active()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get active stores")
+	}
+
+	userStores := make(map[int]map[int]bool)
+	titles := make(map[int]string, len(active))
+	apiKeys := make(map[string]*repository.Store, len(active))
+	for _, s := range active {
+		if userStores[s.OwnerID] == nil {
+			userStores[s.OwnerID] = make(map[int]bool)
+		}
+		userStores[s.OwnerID][s.ID] = true
+		titles[s.ID] = s.Title
+		apiKeys[cryptoutil.HashStringToSHA256Hex(s.APIKey)] = s
+	}
+
+	r, err := checkUserStores(c, stores, userStores)
+	repairs = append(repairs, r...)
+	if err != nil {
+		return repairs, err
+	}
+
+	r, err = checkStoreTitles(c, stores, titles)
+	repairs = append(repairs, r...)
+	if err != nil {
+		return repairs, err
+	}
+
+	r, err = checkAPIKeys(c, apiKeys)
+	repairs = append(repairs, r...)
+	return repairs, err
+}
+
+// createdSince returns whether the store with storeID owned by ownerID is active, even though it was not when the check started
+func createdSince(stores repository.StoreRepository, storeID, ownerID int) (bool, error) {
+	s, err := stores.ByID(storeID)
+	if err == repository.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "cannot get store")
+	}
+	return !s.Removed && (ownerID == 0 || s.OwnerID == ownerID), nil
+}
+
+func checkUserStores(c Cache, stores repository.StoreRepository, userStores map[int]map[int]bool) (repairs []string, err error) {
+	cachedUsers, err := c.GetUsersWithStores()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get users with cached stores")
+	}
+
+	users := make(map[int]bool, len(userStores))
+	for userID := range userStores {
+		users[userID] = true
+	}
+	for _, userID := range cachedUsers {
+		users[userID] = true
+	}
+
+	for _, userID := range sortedKeys(users) {
+		cached, err := c.GetUserStores(userID)
+		if err != nil {
+			return repairs, errors.Wrap(err, "cannot get cached user stores")
+		}
+
+		isCached := make(map[int]bool, len(cached))
+		for _, storeID := range cached {
+			isCached[storeID] = true
+
+			if userStores[userID][storeID] {
+				continue
+			}
+			created, err := createdSince(stores, storeID, userID)
+			if err != nil {
+				return repairs, err
+			}
+			if created {
+				continue
+			}
+
+			err = c.RemoveUserStore(userID, storeID)
+			if err != nil {
+				return repairs, errors.Wrap(err, "cannot remove cached user store")
+			}
+			repairs = append(repairs, fmt.Sprintf("userid:%d:storeids: removed store %d", userID, storeID))
+		}
+
+		for _, storeID := range sortedKeys(userStores[userID]) {
+			if isCached[storeID] {
+				continue
+			}
+
+			err = c.AddUserStore(userID, storeID)
+			if err != nil {
+				return repairs, errors.Wrap(err, "cannot cache user store")
+			}
+			repairs = append(repairs, fmt.Sprintf("userid:%d:storeids: added store %d", userID, storeID))
+		}
+	}
+
+	return repairs, nil
+}
+
+func checkStoreTitles(c Cache, stores repository.StoreRepository, titles map[int]string) (repairs []string, err error) {
+	cachedStores, err := c.GetStoresWithTitle()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get stores with cached title")
+	}
+
+	for _, storeID := range cachedStores {
+		if _, ok := titles[storeID]; ok {
+			continue
+		}
+		created, err := createdSince(stores, storeID, 0)
+		if err != nil {
+			return repairs, err
+		}
+		if created {
+			continue
+		}
+
+		err = c.DeleteStoreTitle(storeID)
+		if err != nil {
+			return repairs, errors.Wrap(err, "cannot delete cached store title")
+		}
+		repairs = append(repairs, fmt.Sprintf("storeid:%d:title: deleted", storeID))
+	}
+
+	storeIDs := make([]int, 0, len(titles))
+	for storeID := range titles {
+		storeIDs = append(storeIDs, storeID)
+	}
+	sort.Ints(storeIDs)
+
+	for _, storeID := range storeIDs {
+		title, err := c.GetStoreTitle(storeID)
+		if err != nil && err != ErrNotFound {
+			return repairs, errors.Wrap(err, "cannot get cached store title")
+		}
+		if err == nil && title == titles[storeID] {
+			continue
+		}
+
+		// The store is fetched again, so that a title changed or a store removed since the check started is not overwritten with a stale one
+		s, err := stores.ByID(storeID)
+		if err != nil && err != repository.ErrNotFound {
+			return repairs, errors.Wrap(err, "cannot get store")
+		}
+		if err == repository.ErrNotFound || s.Removed || s.Title == title {
+			continue
+		}
+
+		err = c.SetStoreTitle(storeID, s.Title)
+		if err != nil {
+			return repairs, errors.Wrap(err, "cannot cache store title")
+		}
+		repairs = append(repairs, fmt.Sprintf("storeid:%d:title: set", storeID))
+	}
+
+	return repairs, nil
+}
+
+func checkAPIKeys(c Cache, apiKeys map[string]*repository.Store) (repairs []string, err error) {
+	cachedAPIKeys, err := c.GetAPIKeys()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get cached API Keys")
+	}
+
+	// API Keys of stores created since the check started are deleted too, costing them a fetch from DB on their next request
+	for _, apiKey := range cachedAPIKeys {
+		if _, ok := apiKeys[apiKey]; ok {
+			continue
+		}
+
+		err = c.DeleteAPIKeyStore(apiKey)
+		if err != nil {
+			return repairs, errors.Wrap(err, "cannot delete cached API Key store")
+		}
+		err = c.DeleteAPIKeySecretKey(apiKey)
+		if err != nil {
+			return repairs, errors.Wrap(err, "cannot delete cached API Key secret key")
+		}
+		repairs = append(repairs, fmt.Sprintf("apikey:%s: deleted", shorten(apiKey)))
+	}
+
+	hashedAPIKeys := make([]string, 0, len(apiKeys))
+	for apiKey := range apiKeys {
+		hashedAPIKeys = append(hashedAPIKeys, apiKey)
+	}
+	sort.Strings(hashedAPIKeys)
+
+	for _, apiKey := range hashedAPIKeys {
+		s := apiKeys[apiKey]
+
+		storeID, err := c.GetAPIKeyStore(apiKey)
+		if err != nil && err != ErrNotFound {
+			return repairs, errors.Wrap(err, "cannot get cached API Key store")
+		}
+		if err == ErrNotFound || storeID != s.ID {
+			err = c.SetAPIKeyStore(apiKey, s.ID)
+			if err != nil {
+				return repairs, errors.Wrap(err, "cannot cache API Key store")
+			}
+			repairs = append(repairs, fmt.Sprintf("apikey:%s:storeid: set", shorten(apiKey)))
+		}
+
+		secretKey, err := c.GetAPIKeySecretKey(apiKey)
+		if err != nil && err != ErrNotFound {
+			return repairs, errors.Wrap(err, "cannot get cached API Key secret key")
+		}
+		if err == ErrNotFound || secretKey != s.SecretKey {
+			err = c.SetAPIKeySecretKey(apiKey, s.SecretKey)
+			if err != nil {
+				return repairs, errors.Wrap(err, "cannot cache API Key secret key")
+			}
+			repairs = append(repairs, fmt.Sprintf("apikey:%s:secretkey: set", shorten(apiKey)))
+		}
+	}
+
+	return repairs, nil
+}
+
+func sortedKeys(m map[int]bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// shorten shortens long keys for logging
+func shorten(key string) string {
+	if len(key) > 15 {
+		return key[0:12] + "..."
+	}
+	return key
+}
+
+// RunConsistencyChecker checks the cache every ConsistencyCheckInterval, starting immediately. It is supposed to be run in its own goroutine.
+func RunConsistencyChecker(c Cache, stores repository.StoreRepository) {
+	for {
+		repairs, err := Check(c, stores)
+		if err != nil {
+			log.Println("Error checking cache consistency:", err)
+		}
+		if len(repairs) > 0 {
+			log.Printf("Cache consistency check: %d entries repaired.\n", len(repairs))
+		}
+
+		time.Sleep(ConsistencyCheckInterval)
+	}
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peppinux/dero-merchant/cryptoutil"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+func newTestStores(t *testing.T) (*repository.MemoryStoreRepository, []*repository.Store) {
+	stores := repository.NewMemoryStoreRepository()
+	mockStores := []*repository.Store{
+		{Title: "Foo", APIKey: "apikey1", SecretKey: "secretkey1", WebhookSecretKey: "webhooksecretkey1", OwnerID: 1},
+		{Title: "Bar", APIKey: "apikey2", SecretKey: "secretkey2", WebhookSecretKey: "webhooksecretkey2", OwnerID: 1},
+		{Title: "Baz", APIKey: "apikey3", SecretKey: "secretkey3", WebhookSecretKey: "webhooksecretkey3", OwnerID: 2},
+	}
+	for _, s := range mockStores {
+		assert.Nil(t, stores.Insert(s))
+	}
+	return stores, mockStores
+}
+
+// assertConsistent asserts that c holds exactly the entries of the stores that are not removed
+func assertConsistent(t *testing.T, c Cache, stores repository.StoreRepository) {
+	active, _ := stores.Active()
+
+	expectedUserStores := make(map[int][]int)
+	var expectedStoreIDs []int
+	var expectedAPIKeys []string
+	for _, s := range active {
+		expectedUserStores[s.OwnerID] = append(expectedUserStores[s.OwnerID], s.ID)
+		expectedStoreIDs = append(expectedStoreIDs, s.ID)
+
+		title, err := c.GetStoreTitle(s.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, s.Title, title)
+
+		hashedAPIKey := cryptoutil.HashStringToSHA256Hex(s.APIKey)
+		expectedAPIKeys = append(expectedAPIKeys, hashedAPIKey)
+
+		storeID, err := c.GetAPIKeyStore(hashedAPIKey)
+		assert.Nil(t, err)
+		assert.Equal(t, s.ID, storeID)
+
+		secretKey, err := c.GetAPIKeySecretKey(hashedAPIKey)
+		assert.Nil(t, err)
+		assert.Equal(t, s.SecretKey, secretKey)
+	}
+
+	users, _ := c.GetUsersWithStores()
+	assert.Len(t, users, len(expectedUserStores))
+	for userID, storeIDs := range expectedUserStores {
+		cached, _ := c.GetUserStores(userID)
+		assert.ElementsMatch(t, storeIDs, cached)
+	}
+
+	storeIDs, _ := c.GetStoresWithTitle()
+	assert.ElementsMatch(t, expectedStoreIDs, storeIDs)
+
+	apiKeys, _ := c.GetAPIKeys()
+	assert.ElementsMatch(t, expectedAPIKeys, apiKeys)
+}
+
+func TestWarm(t *testing.T) {
+	stores, _ := newTestStores(t)
+	c := NewMemoryCache()
+
+	assert.Nil(t, Warm(c, stores))
+	assertConsistent(t, c, stores)
+
+	// Warming up an already warm cache changes nothing
+	assert.Nil(t, Warm(c, stores))
+	assertConsistent(t, c, stores)
+
+	repairs, err := Check(c, stores)
+	assert.Nil(t, err)
+	assert.Empty(t, repairs)
+}
+
+func TestCheck(t *testing.T) {
+	stores, mockStores := newTestStores(t)
+	c := NewMemoryCache()
+
+	// Empty cache
+	repairs, err := Check(c, stores)
+	assert.Nil(t, err)
+	assert.Len(t, repairs, 3*4) // 3 user stores, 3 titles, 3 API Keys (store and Secret Key)
+	assertConsistent(t, c, stores)
+
+	repairs, err = Check(c, stores)
+	assert.Nil(t, err)
+	assert.Empty(t, repairs)
+
+	foo, bar, baz := mockStores[0], mockStores[1], mockStores[2]
+
+	// Removed store
+	_, err = stores.Remove(bar.ID, bar.OwnerID)
+	assert.Nil(t, err)
+
+	// Regenerated API Key, with the old one still cached
+	oldHashedAPIKey := cryptoutil.HashStringToSHA256Hex(baz.APIKey)
+	baz.APIKey, baz.SecretKey = "apikey4", "secretkey4"
+	assert.Nil(t, stores.UpdateKeys(baz.ID, baz.OwnerID, baz.APIKey, baz.SecretKey))
+
+	// Outdated and stray entries
+	c.SetStoreTitle(foo.ID, "Outdated title")
+	c.SetAPIKeySecretKey(cryptoutil.HashStringToSHA256Hex(foo.APIKey), "outdatedsecretkey")
+	c.AddUserStore(3, 100)
+	c.SetStoreTitle(100, "Unknown store")
+
+	repairs, err = Check(c, stores)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, repairs)
+	assertConsistent(t, c, stores)
+
+	owns, _ := c.UserOwnsStore(bar.OwnerID, bar.ID)
+	assert.False(t, owns)
+	_, err = c.GetStoreTitle(bar.ID)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = c.GetAPIKeyStore(oldHashedAPIKey)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = c.GetAPIKeySecretKey(oldHashedAPIKey)
+	assert.Equal(t, ErrNotFound, err)
+
+	repairs, err = Check(c, stores)
+	assert.Nil(t, err)
+	assert.Empty(t, repairs)
+}
+
+func TestCheckStoreCreatedDuringCheck(t *testing.T) {
+	stores, _ := newTestStores(t)
+	c := NewMemoryCache()
+	assert.Nil(t, Warm(c, stores))
+
+	// A store cached by its owner but missing from the stores fetched by Check is kept if it exists
+	created := &repository.Store{Title: "New", APIKey: "apikey5", SecretKey: "secretkey5", WebhookSecretKey: "webhooksecretkey5", OwnerID: 1}
+	assert.Nil(t, stores.Insert(created))
+	c.AddUserStore(created.OwnerID, created.ID)
+	c.SetStoreTitle(created.ID, created.Title)
+
+	repairs, err := checkUserStores(c, stores, map[int]map[int]bool{})
+	assert.Nil(t, err)
+	owns, _ := c.UserOwnsStore(created.OwnerID, created.ID)
+	assert.True(t, owns)
+	for _, r := range repairs {
+		assert.NotContains(t, r, "removed")
+	}
+
+	_, err = checkStoreTitles(c, stores, map[int]string{})
+	assert.Nil(t, err)
+	title, err := c.GetStoreTitle(created.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, created.Title, title)
+}
+
+func TestCheckStoreTitlesChangedDuringCheck(t *testing.T) {
+	stores, mockStores := newTestStores(t)
+	foo, bar := mockStores[0], mockStores[1]
+	c := NewMemoryCache()
+	assert.Nil(t, Warm(c, stores))
+
+	// The titles fetched by Check are stale: foo was renamed and bar removed since
+	titles := map[int]string{foo.ID: "Old foo", bar.ID: bar.Title}
+	c.SetStoreTitle(foo.ID, "Cached foo")
+	c.DeleteStoreTitle(bar.ID)
+	_, err := stores.Remove(bar.ID, bar.OwnerID)
+	assert.Nil(t, err)
+
+	repairs, err := checkStoreTitles(c, stores, titles)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{fmt.Sprintf("storeid:%d:title: set", foo.ID)}, repairs)
+
+	title, err := c.GetStoreTitle(foo.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, foo.Title, title)
+	_, err = c.GetStoreTitle(bar.ID)
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,423 @@
+package cache
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/stringutil"
+)
+
+// sweepInterval is how often MemoryCache deletes the keys that expired without being accessed again
+const sweepInterval = time.Minute
+
+// memoryEntry is the value of a key of MemoryCache: either a string or a set
+type memoryEntry struct {
+	value   string
+	members map[string]struct{}
+	// expiresAt is zero if the key does not expire
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// MemoryCache is a concurrency-safe Cache that keeps keys in memory, laid out like RedisCache does in Redis
+type MemoryCache struct {
+	mutex     sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryCache returns a new empty MemoryCache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries:   make(map[string]*memoryEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the entry of key, or nil if it does not exist or expired. c.mutex has to be locked.
+func (c *MemoryCache) get(key string) *memoryEntry {
+	now := time.Now()
+
+	if now.Sub(c.lastSweep) >= sweepInterval {
+		for k, e := range c.entries {
+			if e.expired(now) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if e.expired(now) {
+		delete(c.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (c *MemoryCache) setString(key, value string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[key] = &memoryEntry{value: value}
+	return nil
+}
+
+func (c *MemoryCache) getString(key string) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e := c.get(key)
+	if e == nil {
+		return "", ErrNotFound
+	}
+	if e.members != nil {
+		return "", errors.Errorf("key %s holds a set", key)
+	}
+	return e.value, nil
+}
+
+func (c *MemoryCache) getInt(key string) (int, error) {
+	s, err := c.getString(key)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot convert value of key %s to int", key)
+	}
+	return value, nil
+}
+
+func (c *MemoryCache) delete(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, key)
+	return nil
+}
+
+func (c *MemoryCache) expire(key string, ttl int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e := c.get(key)
+	if e == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		delete(c.entries, key)
+		return nil
+	}
+	e.expiresAt = time.Now().Add(time.Duration(ttl) * time.Second)
+	return nil
+}
+
+// getSet returns the set of key, creating it if create is true. c.mutex has to be locked.
+func (c *MemoryCache) getSet(key string, create bool) (map[string]struct{}, error) {
+	e := c.get(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		e = &memoryEntry{members: make(map[string]struct{})}
+		c.entries[key] = e
+	}
+	if e.members == nil {
+		return nil, errors.Errorf("key %s does not hold a set", key)
+	}
+	return e.members, nil
+}
+
+func (c *MemoryCache) addMember(key, member string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	members, err := c.getSet(key, true)
+	if err != nil {
+		return err
+	}
+	members[member] = struct{}{}
+	return nil
+}
+
+func (c *MemoryCache) removeMember(key, member string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	members, err := c.getSet(key, false)
+	if err != nil {
+		return err
+	}
+	delete(members, member)
+	if len(members) == 0 { // Like Redis, do not keep empty sets
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *MemoryCache) isMember(key, member string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	members, err := c.getSet(key, false)
+	if err != nil {
+		return false, err
+	}
+	_, ok := members[member]
+	return ok, nil
+}
+
+func (c *MemoryCache) getMembers(key string) ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	members, err := c.getSet(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(members))
+	for m := range members {
+		values = append(values, m)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// keys returns the keys (not expired) formatted as <typ>:<id>:<field>, returning their IDs. field matches any field if empty.
+func (c *MemoryCache) keys(typ, field string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	found := make(map[string]bool)
+	var ids []string
+	for k := range c.entries {
+		parts := strings.Split(k, ":")
+		if len(parts) != 3 || parts[0] != typ || (field != "" && parts[2] != field) || found[parts[1]] {
+			continue
+		}
+		if c.get(k) == nil {
+			continue
+		}
+
+		found[parts[1]] = true
+		ids = append(ids, parts[1])
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// intKeys returns the IDs of keys formatted as <typ>:<id>:<field> as ints
+func (c *MemoryCache) intKeys(typ, field string) ([]int, error) {
+	var ids []int
+	for _, k := range c.keys(typ, field) {
+		id, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot convert string to int")
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func sessionKey(sessionID string) string {
+	return stringutil.Build("sessionid:", sessionID, ":userid")
+}
+
+func userKey(userID int, field string) string {
+	return stringutil.Build("userid:", strconv.Itoa(userID), ":", field)
+}
+
+func storeTitleKey(storeID int) string {
+	return stringutil.Build("storeid:", strconv.Itoa(storeID), ":title")
+}
+
+func apiKeyKey(apiKey, field string) string {
+	return stringutil.Build("apikey:", apiKey, ":", field)
+}
+
+const supportedCurrenciesKey = "supportedcurrencies"
+
+// SetSessionUser sets the user of a session
+func (c *MemoryCache) SetSessionUser(sessionID string, userID int) error {
+	return c.setString(sessionKey(sessionID), strconv.Itoa(userID))
+}
+
+// SetSessionExpiration sets a session to expire after ttl seconds
+func (c *MemoryCache) SetSessionExpiration(sessionID string, ttl int) error {
+	return c.expire(sessionKey(sessionID), ttl)
+}
+
+// GetSessionUser returns the user of a session, or ErrNotFound
+func (c *MemoryCache) GetSessionUser(sessionID string) (int, error) {
+	return c.getInt(sessionKey(sessionID))
+}
+
+// DeleteSession deletes a session
+func (c *MemoryCache) DeleteSession(sessionID string) error {
+	return c.delete(sessionKey(sessionID))
+}
+
+// AddUserSession adds a session to the sessions of a user
+func (c *MemoryCache) AddUserSession(userID int, sessionID string) error {
+	return c.addMember(userKey(userID, "sessionids"), sessionID)
+}
+
+// RemoveUserSession removes a session from the sessions of a user
+func (c *MemoryCache) RemoveUserSession(userID int, sessionID string) error {
+	return c.removeMember(userKey(userID, "sessionids"), sessionID)
+}
+
+// GetUserSessions returns the sessions of a user
+func (c *MemoryCache) GetUserSessions(userID int) ([]string, error) {
+	return c.getMembers(userKey(userID, "sessionids"))
+}
+
+// DeleteUserSessions deletes the sessions of a user
+func (c *MemoryCache) DeleteUserSessions(userID int) error {
+	return c.delete(userKey(userID, "sessionids"))
+}
+
+// SetUserUsername sets the username of a user
+func (c *MemoryCache) SetUserUsername(userID int, username string) error {
+	return c.setString(userKey(userID, "username"), username)
+}
+
+// GetUserUsername returns the username of a user, or ErrNotFound
+func (c *MemoryCache) GetUserUsername(userID int) (string, error) {
+	return c.getString(userKey(userID, "username"))
+}
+
+// SetUserEmail sets the email of a user
+func (c *MemoryCache) SetUserEmail(userID int, email string) error {
+	return c.setString(userKey(userID, "email"), email)
+}
+
+// GetUserEmail returns the email of a user, or ErrNotFound
+func (c *MemoryCache) GetUserEmail(userID int) (string, error) {
+	return c.getString(userKey(userID, "email"))
+}
+
+// AddUserStore adds a store to the stores of a user
+func (c *MemoryCache) AddUserStore(userID, storeID int) error {
+	return c.addMember(userKey(userID, "storeids"), strconv.Itoa(storeID))
+}
+
+// GetUserStores returns the stores of a user
+func (c *MemoryCache) GetUserStores(userID int) ([]int, error) {
+	members, err := c.getMembers(userKey(userID, "storeids"))
+	if err != nil {
+		return nil, err
+	}
+
+	var storeIDs []int
+	for _, m := range members {
+		id, err := strconv.Atoi(m)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot convert string to int")
+		}
+		storeIDs = append(storeIDs, id)
+	}
+	sort.Ints(storeIDs)
+	return storeIDs, nil
+}
+
+// UserOwnsStore returns whether a store is one of the stores of a user or not
+func (c *MemoryCache) UserOwnsStore(userID, storeID int) (bool, error) {
+	return c.isMember(userKey(userID, "storeids"), strconv.Itoa(storeID))
+}
+
+// RemoveUserStore removes a store from the stores of a user
+func (c *MemoryCache) RemoveUserStore(userID, storeID int) error {
+	return c.removeMember(userKey(userID, "storeids"), strconv.Itoa(storeID))
+}
+
+// GetUsersWithStores returns the users whose stores are cached
+func (c *MemoryCache) GetUsersWithStores() ([]int, error) {
+	return c.intKeys("userid", "storeids")
+}
+
+// SetStoreTitle sets the title of a store
+func (c *MemoryCache) SetStoreTitle(storeID int, title string) error {
+	return c.setString(storeTitleKey(storeID), title)
+}
+
+// GetStoreTitle returns the title of a store, or ErrNotFound
+func (c *MemoryCache) GetStoreTitle(storeID int) (string, error) {
+	return c.getString(storeTitleKey(storeID))
+}
+
+// DeleteStoreTitle deletes the title of a store
+func (c *MemoryCache) DeleteStoreTitle(storeID int) error {
+	return c.delete(storeTitleKey(storeID))
+}
+
+// GetStoresWithTitle returns the stores whose title is cached
+func (c *MemoryCache) GetStoresWithTitle() ([]int, error) {
+	return c.intKeys("storeid", "title")
+}
+
+// SetAPIKeyStore sets the store of an API Key
+func (c *MemoryCache) SetAPIKeyStore(apiKey string, storeID int) error {
+	return c.setString(apiKeyKey(apiKey, "storeid"), strconv.Itoa(storeID))
+}
+
+// GetAPIKeyStore returns the store of an API Key, or ErrNotFound
+func (c *MemoryCache) GetAPIKeyStore(apiKey string) (int, error) {
+	return c.getInt(apiKeyKey(apiKey, "storeid"))
+}
+
+// DeleteAPIKeyStore deletes the store of an API Key
+func (c *MemoryCache) DeleteAPIKeyStore(apiKey string) error {
+	return c.delete(apiKeyKey(apiKey, "storeid"))
+}
+
+// SetAPIKeySecretKey sets the Secret Key of an API Key
+func (c *MemoryCache) SetAPIKeySecretKey(apiKey, secretKey string) error {
+	return c.setString(apiKeyKey(apiKey, "secretkey"), secretKey)
+}
+
+// GetAPIKeySecretKey returns the Secret Key of an API Key, or ErrNotFound
+func (c *MemoryCache) GetAPIKeySecretKey(apiKey string) (string, error) {
+	return c.getString(apiKeyKey(apiKey, "secretkey"))
+}
+
+// DeleteAPIKeySecretKey deletes the Secret Key of an API Key
+func (c *MemoryCache) DeleteAPIKeySecretKey(apiKey string) error {
+	return c.delete(apiKeyKey(apiKey, "secretkey"))
+}
+
+// GetAPIKeys returns the API Keys whose store or Secret Key is cached
+func (c *MemoryCache) GetAPIKeys() ([]string, error) {
+	return c.keys("apikey", ""), nil
+}
+
+// SetSupportedCurrencies adds currencies to the supported currencies
+func (c *MemoryCache) SetSupportedCurrencies(currencies []string) error {
+	for _, currency := range currencies {
+		err := c.addMember(supportedCurrenciesKey, currency)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsSupportedCurrency returns whether currency is supported or not
+func (c *MemoryCache) IsSupportedCurrency(currency string) (bool, error) {
+	return c.isMember(supportedCurrenciesKey, currency)
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/redis"
+)
+
+// RedisCache is a Cache backed by the Redis server of redis.Pool
+type RedisCache struct{}
+
+// NewRedisCache returns a new RedisCache
+func NewRedisCache() *RedisCache {
+	return &RedisCache{}
+}
+
+// notFound replaces the error returned by Redis when getting a key that does not exist with ErrNotFound
+func notFound(err error) error {
+	if errors.Cause(err) == redigo.ErrNil {
+		return ErrNotFound
+	}
+	return err
+}
+
+// SetSessionUser sets the user of a session
+func (c *RedisCache) SetSessionUser(sessionID string, userID int) error {
+	return redis.SetSessionUser(sessionID, userID)
+}
+
+// SetSessionExpiration sets a session to expire after ttl seconds
+func (c *RedisCache) SetSessionExpiration(sessionID string, ttl int) error {
+	return redis.SetSessionExpiration(sessionID, ttl)
+}
+
+// GetSessionUser returns the user of a session, or ErrNotFound
+func (c *RedisCache) GetSessionUser(sessionID string) (int, error) {
+	userID, err := redis.GetSessionUser(sessionID)
+	return userID, notFound(err)
+}
+
+// DeleteSession deletes a session
+func (c *RedisCache) DeleteSession(sessionID string) error {
+	return redis.DeleteSession(sessionID)
+}
+
+// AddUserSession adds a session to the sessions of a user
+func (c *RedisCache) AddUserSession(userID int, sessionID string) error {
+	return redis.AddUserSession(userID, sessionID)
+}
+
+// RemoveUserSession removes a session from the sessions of a user
+func (c *RedisCache) RemoveUserSession(userID int, sessionID string) error {
+	return redis.RemoveUserSession(userID, sessionID)
+}
+
+// GetUserSessions returns the sessions of a user
+func (c *RedisCache) GetUserSessions(userID int) ([]string, error) {
+	return redis.GetUserSessions(userID)
+}
+
+// DeleteUserSessions deletes the sessions of a user
+func (c *RedisCache) DeleteUserSessions(userID int) error {
+	return redis.DeleteUserSessions(userID)
+}
+
+// SetUserUsername sets the username of a user
+func (c *RedisCache) SetUserUsername(userID int, username string) error {
+	return redis.SetUserUsername(userID, username)
+}
+
+// GetUserUsername returns the username of a user, or ErrNotFound
+func (c *RedisCache) GetUserUsername(userID int) (string, error) {
+	username, err := redis.GetUserUsername(userID)
+	return username, notFound(err)
+}
+
+// SetUserEmail sets the email of a user
+func (c *RedisCache) SetUserEmail(userID int, email string) error {
+	return redis.SetUserEmail(userID, email)
+}
+
+// GetUserEmail returns the email of a user, or ErrNotFound
+func (c *RedisCache) GetUserEmail(userID int) (string, error) {
+	email, err := redis.GetUserEmail(userID)
+	return email, notFound(err)
+}
+
+// AddUserStore adds a store to the stores of a user
+func (c *RedisCache) AddUserStore(userID, storeID int) error {
+	return redis.AddUserStore(userID, storeID)
+}
+
+// GetUserStores returns the stores of a user
+func (c *RedisCache) GetUserStores(userID int) ([]int, error) {
+	return redis.GetUserStores(userID)
+}
+
+// UserOwnsStore returns whether a store is one of the stores of a user or not
+func (c *RedisCache) UserOwnsStore(userID, storeID int) (bool, error) {
+	return redis.UserOwnsStore(userID, storeID)
+}
+
+// RemoveUserStore removes a store from the stores of a user
+func (c *RedisCache) RemoveUserStore(userID, storeID int) error {
+	return redis.RemoveUserStore(userID, storeID)
+}
+
+// GetUsersWithStores returns the users whose stores are cached
+func (c *RedisCache) GetUsersWithStores() ([]int, error) {
+	return redis.GetUsersWithStores()
+}
+
+// SetStoreTitle sets the title of a store
+func (c *RedisCache) SetStoreTitle(storeID int, title string) error {
+	return redis.SetStoreTitle(storeID, title)
+}
+
+// GetStoreTitle returns the title of a store, or ErrNotFound
+func (c *RedisCache) GetStoreTitle(storeID int) (string, error) {
+	title, err := redis.GetStoreTitle(storeID)
+	return title, notFound(err)
+}
+
+// DeleteStoreTitle deletes the title of a store
+func (c *RedisCache) DeleteStoreTitle(storeID int) error {
+	return redis.DeleteStoreTitle(storeID)
+}
+
+// GetStoresWithTitle returns the stores whose title is cached
+func (c *RedisCache) GetStoresWithTitle() ([]int, error) {
+	return redis.GetStoresWithTitle()
+}
+
+// SetAPIKeyStore sets the store of an API Key
+func (c *RedisCache) SetAPIKeyStore(apiKey string, storeID int) error {
+	return redis.SetAPIKeyStore(apiKey, storeID)
+}
+
+// GetAPIKeyStore returns the store of an API Key, or ErrNotFound
+func (c *RedisCache) GetAPIKeyStore(apiKey string) (int, error) {
+	storeID, err := redis.GetAPIKeyStore(apiKey)
+	return storeID, notFound(err)
+}
+
+// DeleteAPIKeyStore deletes the store of an API Key
+func (c *RedisCache) DeleteAPIKeyStore(apiKey string) error {
+	return redis.DeleteAPIKeyStore(apiKey)
+}
+
+// SetAPIKeySecretKey sets the Secret Key of an API Key
+func (c *RedisCache) SetAPIKeySecretKey(apiKey, secretKey string) error {
+	return redis.SetAPIKeySecretKey(apiKey, secretKey)
+}
+
+// GetAPIKeySecretKey returns the Secret Key of an API Key, or ErrNotFound
+func (c *RedisCache) GetAPIKeySecretKey(apiKey string) (string, error) {
+	secretKey, err := redis.GetAPIKeySecretKey(apiKey)
+	return secretKey, notFound(err)
+}
+
+// DeleteAPIKeySecretKey deletes the Secret Key of an API Key
+func (c *RedisCache) DeleteAPIKeySecretKey(apiKey string) error {
+	return redis.DeleteAPIKeySecretKey(apiKey)
+}
+
+// GetAPIKeys returns the API Keys whose store or Secret Key is cached
+func (c *RedisCache) GetAPIKeys() ([]string, error) {
+	return redis.GetAPIKeys()
+}
+
+// SetSupportedCurrencies adds currencies to the supported currencies
+func (c *RedisCache) SetSupportedCurrencies(currencies []string) error {
+	return redis.SetSupportedCurrencies(currencies)
+}
+
+// IsSupportedCurrency returns whether currency is supported or not
+func (c *RedisCache) IsSupportedCurrency(currency string) (bool, error) {
+	return redis.IsSupportedCurrency(currency)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 
 	"github.com/peppinux/dero-merchant/stringutil"
 )
@@ -69,8 +70,9 @@ func SupportedVsCurrencies() (supportedVsCurrencies []string, err error) {
 	return
 }
 
-// DeroPrice returns the price of Dero compared to a currency
-func DeroPrice(vsCurrency string) (deroPrice float64, err error) {
+// DeroPrice returns the price of Dero compared to a currency.
+// The price is parsed as an exact decimal number from the JSON response.
+func DeroPrice(vsCurrency string) (deroPrice decimal.Decimal, err error) {
 	vsCurrency = strings.ToLower(vsCurrency)
 	query := stringutil.Build("?ids=dero&vs_currencies=", vsCurrency)
 	var body []byte
@@ -80,13 +82,16 @@ func DeroPrice(vsCurrency string) (deroPrice float64, err error) {
 		return
 	}
 
-	resp := make(map[string](map[string]float64))
+	resp := make(map[string](map[string]decimal.Decimal))
 	err = json.Unmarshal(body, &resp)
 	if err != nil {
 		err = errors.Wrap(err, "cannot unmarshal response body")
 		return
 	}
 
-	deroPrice = resp["dero"][vsCurrency]
+	deroPrice, ok := resp["dero"][vsCurrency]
+	if !ok {
+		err = errors.Errorf("price of DERO in %s not found", vsCurrency)
+	}
 	return
 }
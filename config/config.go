@@ -21,8 +21,14 @@ var (
 	DBPort     int
 )
 
-// RedisAddress is the host:port of the Redis server the application will connect to
-var RedisAddress string
+// Redis config
+var (
+	// RedisAddress is the host:port of the Redis server the application will connect to
+	RedisAddress string
+	// RedisNamespace prefixes all the keys of the application, so that the Redis server can be shared with other applications
+	// (optional, defaults to DefaultRedisNamespace)
+	RedisNamespace string
+)
 
 // Dero Network, wallet and payments config
 var (
@@ -36,8 +42,47 @@ var (
 	PaymentMaxTTL int
 	// PaymentMinConfirmations is the MINIMUM number of confirmations a payment needs to have before it is considered valid
 	PaymentMinConfirmations int
+	// PaymentMaxConfirmations is the MAX number of confirmations stores can require payments to have (optional, defaults to DefaultPaymentMaxConfirmations)
+	PaymentMaxConfirmations int
+	// PaymentTierMinConfirmations is the MINIMUM number of confirmations stores can require payments of less than a max amount to have
+	// through confirmation tiers. It can be lower than PaymentMinConfirmations (optional, defaults to DefaultPaymentTierMinConfirmations)
+	PaymentTierMinConfirmations int
+	// PaymentBatchMaxSize is the MAX number of payments that can be created by a single batch request (optional, defaults to DefaultPaymentBatchMaxSize)
+	PaymentBatchMaxSize int
+)
+
+// BaseURL is the public URL (scheme and host) of the web server, used to build the links sent to customers by email.
+// Optional, defaults to http://localhost:ServerPort
+var BaseURL string
+
+// SMTP server config used to send emails to customers (optional, emails are not sent if SMTPHost is not set)
+var (
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// SMTPFrom is the address emails are sent from
+	SMTPFrom string
 )
 
+// DefaultSMTPPort is the value of SMTPPort when SMTP_PORT env variable is not set
+const DefaultSMTPPort = 587
+
+// DefaultRedisNamespace is the value of RedisNamespace when REDIS_NAMESPACE env variable is not set
+const DefaultRedisNamespace = "deromerchant"
+
+// DefaultTestRedisNamespace is the value of TestRedisNamespace when TEST_REDIS_NAMESPACE env variable is not set
+const DefaultTestRedisNamespace = "deromerchant_test"
+
+// DefaultPaymentMaxConfirmations is the value of PaymentMaxConfirmations when PAYMENT_MAX_CONFIRMATIONS env variable is not set
+const DefaultPaymentMaxConfirmations = 100
+
+// DefaultPaymentTierMinConfirmations is the value of PaymentTierMinConfirmations when PAYMENT_TIER_MIN_CONFIRMATIONS env variable is not set
+const DefaultPaymentTierMinConfirmations = 1
+
+// DefaultPaymentBatchMaxSize is the value of PaymentBatchMaxSize when PAYMENT_BATCH_MAX_SIZE env variable is not set
+const DefaultPaymentBatchMaxSize = 1000
+
 // Config for testing
 var (
 	TestDBName            string
@@ -46,6 +91,7 @@ var (
 	TestDBHost            string
 	TestDBPort            int
 	TestRedisAddress      string
+	TestRedisNamespace    string
 	TestDeroNetwork       string
 	TestDeroDaemonAddress string
 	TestWalletsPath       string
@@ -73,6 +119,10 @@ func LoadFromENV(filenames ...string) error {
 	}
 
 	RedisAddress = os.Getenv("REDIS_ADDRESS")
+	RedisNamespace = os.Getenv("REDIS_NAMESPACE")
+	if RedisNamespace == "" {
+		RedisNamespace = DefaultRedisNamespace
+	}
 
 	DeroNetwork = strings.ToLower(os.Getenv("DERO_NETWORK"))
 	DeroDaemonAddress = os.Getenv("DERO_DAEMON_ADDRESS")
@@ -85,6 +135,50 @@ func LoadFromENV(filenames ...string) error {
 	if err != nil {
 		return errors.Wrap(err, "cannot convert string to integer")
 	}
+	PaymentMaxConfirmations = DefaultPaymentMaxConfirmations
+	if maxConfirmations := os.Getenv("PAYMENT_MAX_CONFIRMATIONS"); maxConfirmations != "" {
+		PaymentMaxConfirmations, err = strconv.Atoi(maxConfirmations)
+		if err != nil {
+			return errors.Wrap(err, "cannot convert string to integer")
+		}
+	}
+	if PaymentMaxConfirmations < PaymentMinConfirmations {
+		PaymentMaxConfirmations = PaymentMinConfirmations
+	}
+	PaymentTierMinConfirmations = DefaultPaymentTierMinConfirmations
+	if tierMinConfirmations := os.Getenv("PAYMENT_TIER_MIN_CONFIRMATIONS"); tierMinConfirmations != "" {
+		PaymentTierMinConfirmations, err = strconv.Atoi(tierMinConfirmations)
+		if err != nil {
+			return errors.Wrap(err, "cannot convert string to integer")
+		}
+	}
+	if PaymentTierMinConfirmations > PaymentMinConfirmations {
+		PaymentTierMinConfirmations = PaymentMinConfirmations
+	}
+	PaymentBatchMaxSize = DefaultPaymentBatchMaxSize
+	if batchMaxSize := os.Getenv("PAYMENT_BATCH_MAX_SIZE"); batchMaxSize != "" {
+		PaymentBatchMaxSize, err = strconv.Atoi(batchMaxSize)
+		if err != nil {
+			return errors.Wrap(err, "cannot convert string to integer")
+		}
+	}
+
+	BaseURL = strings.TrimRight(os.Getenv("BASE_URL"), "/")
+	if BaseURL == "" {
+		BaseURL = "http://localhost:" + strconv.Itoa(ServerPort)
+	}
+
+	SMTPHost = os.Getenv("SMTP_HOST")
+	SMTPPort = DefaultSMTPPort
+	if smtpPort := os.Getenv("SMTP_PORT"); smtpPort != "" {
+		SMTPPort, err = strconv.Atoi(smtpPort)
+		if err != nil {
+			return errors.Wrap(err, "cannot convert string to integer")
+		}
+	}
+	SMTPUsername = os.Getenv("SMTP_USERNAME")
+	SMTPPassword = os.Getenv("SMTP_PASSWORD")
+	SMTPFrom = os.Getenv("SMTP_FROM")
 
 	TestDBName = os.Getenv("TEST_DB_NAME")
 	TestDBUser = os.Getenv("TEST_DB_USER")
@@ -95,6 +189,10 @@ func LoadFromENV(filenames ...string) error {
 		return errors.Wrap(err, "cannot convert string to integer")
 	}
 	TestRedisAddress = os.Getenv("TEST_REDIS_ADDRESS")
+	TestRedisNamespace = os.Getenv("TEST_REDIS_NAMESPACE")
+	if TestRedisNamespace == "" {
+		TestRedisNamespace = DefaultTestRedisNamespace
+	}
 	TestDeroNetwork = strings.ToLower(os.Getenv("TEST_DERO_NETWORK"))
 	TestDeroDaemonAddress = os.Getenv("TEST_DERO_DAEMON_ADDRESS")
 	TestWalletsPath = os.Getenv("TEST_WALLETS_PATH")
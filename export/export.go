@@ -0,0 +1,98 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Supported export formats
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+	FormatXLSX  = "xlsx"
+)
+
+// ErrInvalidFormat is returned when a tabular format other than FormatCSV or FormatXLSX is requested
+var ErrInvalidFormat = errors.New("Invalid export format: allowed values are csv and xlsx")
+
+// Number is a cell holding the text representation of a number (e.g. "10.5").
+// Any other value is written as text.
+type Number string
+
+// RowWriter writes rows of cells one by one, without keeping them in memory
+type RowWriter interface {
+	// WriteRow writes a row. Cells are either strings or Numbers.
+	WriteRow(cells ...interface{}) error
+	// Close flushes any buffered data. It does not close the underlying writer.
+	Close() error
+}
+
+// NewRowWriter returns a RowWriter that writes rows to w in the given tabular format
+func NewRowWriter(w io.Writer, format string) (RowWriter, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSVWriter(w), nil
+	case FormatXLSX:
+		return NewXLSXWriter(w)
+	default:
+		return nil, ErrInvalidFormat
+	}
+}
+
+// ContentType returns the MIME type of format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatJSONL:
+		return "application/x-ndjson"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// CSVWriter is a RowWriter that writes CSV
+type CSVWriter struct {
+	w *csv.Writer
+}
+
+// NewCSVWriter returns a new CSVWriter that writes to w
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{
+		w: csv.NewWriter(w),
+	}
+}
+
+// WriteRow writes a CSV record
+func (cw *CSVWriter) WriteRow(cells ...interface{}) error {
+	record := make([]string, len(cells))
+	for i, c := range cells {
+		switch v := c.(type) {
+		case Number:
+			record[i] = string(v)
+		case string:
+			record[i] = escapeFormula(v)
+		}
+	}
+
+	return cw.w.Write(record)
+}
+
+// Close flushes the records written
+func (cw *CSVWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// escapeFormula prevents spreadsheet applications from evaluating text starting like a formula (CSV injection)
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
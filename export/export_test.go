@@ -0,0 +1,82 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewRowWriter(&buf, FormatCSV)
+	assert.Nil(t, err)
+
+	assert.Nil(t, w.WriteRow("Order ID", "Amount", "Description"))
+	assert.Nil(t, w.WriteRow("1234", Number("-10.5"), `Blue "T-Shirt", size M`))
+	assert.Nil(t, w.WriteRow("=HYPERLINK(\"http://example.com\")", Number("0"), "@SUM(A1)"))
+	assert.Nil(t, w.Close())
+
+	expected := "Order ID,Amount,Description\n" +
+		"1234,-10.5,\"Blue \"\"T-Shirt\"\", size M\"\n" +
+		"\"'=HYPERLINK(\"\"http://example.com\"\")\",0,'@SUM(A1)\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewRowWriter(&buf, FormatXLSX)
+	assert.Nil(t, err)
+
+	assert.Nil(t, w.WriteRow("Order ID", "Amount"))
+	assert.Nil(t, w.WriteRow("<1234> & co", Number("10.5")))
+	assert.NotNil(t, w.WriteRow("1235", Number("ten")))
+	assert.Nil(t, w.Close())
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+
+	files := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		assert.Nil(t, err)
+		b, err := ioutil.ReadAll(rc)
+		assert.Nil(t, err)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		assert.Contains(t, files, name)
+	}
+	sheet := files["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<row r="1"><c r="A1" t="inlineStr"><is><t xml:space="preserve">Order ID</t></is></c>`)
+	assert.Contains(t, sheet, `<c r="A2" t="inlineStr"><is><t xml:space="preserve">&lt;1234&gt; &amp; co</t></is></c><c r="B2"><v>10.5</v></c></row>`)
+	assert.Contains(t, sheet, `</sheetData></worksheet>`)
+}
+
+func TestNewRowWriter(t *testing.T) {
+	_, err := NewRowWriter(&bytes.Buffer{}, FormatJSONL) // Not tabular
+	assert.Equal(t, ErrInvalidFormat, err)
+	_, err = NewRowWriter(&bytes.Buffer{}, "pdf")
+	assert.Equal(t, ErrInvalidFormat, err)
+}
+
+func TestColumnName(t *testing.T) {
+	tests := map[int]string{
+		0:   "A",
+		25:  "Z",
+		26:  "AA",
+		27:  "AB",
+		51:  "AZ",
+		52:  "BA",
+		701: "ZZ",
+		702: "AAA",
+	}
+
+	for i, expected := range tests {
+		assert.Equal(t, expected, columnName(i))
+	}
+}
@@ -0,0 +1,157 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Static parts of a workbook made up of a single worksheet
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="1"><fill><patternFill patternType="none"/></fill></fills>` +
+		`<borders count="1"><border/></borders>` +
+		`<cellStyleXfs count="1"><xf/></cellStyleXfs>` +
+		`<cellXfs count="1"><xf/></cellXfs>` +
+		`</styleSheet>`
+	xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetFooter = `</sheetData></worksheet>`
+)
+
+// XLSXWriter is a RowWriter that writes an Office Open XML workbook with a single worksheet.
+// Rows are compressed and written as soon as they are buffered, so that the whole sheet is never kept in memory.
+type XLSXWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+// NewXLSXWriter returns a new XLSXWriter that writes to w
+func NewXLSXWriter(w io.Writer) (*XLSXWriter, error) {
+	zw := zip.NewWriter(w)
+
+	parts := []struct {
+		Name    string
+		Content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot create workbook part")
+		}
+		_, err = io.WriteString(f, p.Content)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot write workbook part")
+		}
+	}
+
+	// The worksheet is the last part, so that rows can be written to it until Close
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create worksheet")
+	}
+	sheet := bufio.NewWriter(f)
+	_, err = sheet.WriteString(xlsxSheetHeader)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot write worksheet")
+	}
+
+	return &XLSXWriter{
+		zw:    zw,
+		sheet: sheet,
+	}, nil
+}
+
+// WriteRow writes a row to the worksheet. Strings are written as inline strings, Numbers as numeric cells.
+func (xw *XLSXWriter) WriteRow(cells ...interface{}) error {
+	for i, c := range cells {
+		if v, ok := c.(Number); ok {
+			if _, err := strconv.ParseFloat(string(v), 64); err != nil {
+				return errors.Errorf("invalid number %q in column %s", v, columnName(i))
+			}
+		}
+	}
+
+	xw.row++
+	rowRef := strconv.Itoa(xw.row)
+
+	xw.sheet.WriteString(`<row r="`)
+	xw.sheet.WriteString(rowRef)
+	xw.sheet.WriteString(`">`)
+	for i, c := range cells {
+		ref := columnName(i) + rowRef
+		switch v := c.(type) {
+		case Number:
+			xw.sheet.WriteString(`<c r="` + ref + `"><v>`)
+			xw.sheet.WriteString(string(v))
+			xw.sheet.WriteString(`</v></c>`)
+		case string:
+			if v == "" {
+				continue
+			}
+			xw.sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(xw.sheet, []byte(v))
+			xw.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := xw.sheet.WriteString(`</row>`)
+
+	return err
+}
+
+// Close terminates the worksheet and writes the central directory of the workbook
+func (xw *XLSXWriter) Close() error {
+	_, err := xw.sheet.WriteString(xlsxSheetFooter)
+	if err != nil {
+		return errors.Wrap(err, "cannot write worksheet")
+	}
+	err = xw.sheet.Flush()
+	if err != nil {
+		return errors.Wrap(err, "cannot flush worksheet")
+	}
+
+	return xw.zw.Close()
+}
+
+// columnName returns the name of the column with 0-based index i (A, B, ..., Z, AA, AB, ...)
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
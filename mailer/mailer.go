@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/config"
+)
+
+// IsSet returns whether an SMTP server to send emails through is configured
+func IsSet() bool {
+	return config.SMTPHost != "" && config.SMTPFrom != ""
+}
+
+// stripNewlines removes CR and LF from a header value, preventing headers injection
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// message returns a plain text email ready to be sent through SMTP
+func message(from, to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + stripNewlines(from) + "\r\n")
+	b.WriteString("To: " + stripNewlines(to) + "\r\n")
+	b.WriteString("Subject: " + stripNewlines(subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// Send sends a plain text email to the given address through the configured SMTP server
+func Send(to, subject, body string) error {
+	if !IsSet() {
+		return errors.New("SMTP server not configured")
+	}
+
+	addr := net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort))
+
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+	}
+
+	from := config.SMTPFrom
+	if i := strings.LastIndex(from, "<"); i != -1 { // "Name <address>"
+		from = strings.TrimSuffix(from[i+1:], ">")
+	}
+
+	err := smtp.SendMail(addr, auth, from, []string{to}, message(config.SMTPFrom, to, subject, body))
+	if err != nil {
+		return errors.Wrap(err, "cannot send mail")
+	}
+
+	return nil
+}
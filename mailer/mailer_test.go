@@ -0,0 +1,23 @@
+package mailer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage(t *testing.T) {
+	msg := message("Store <noreply@store.com>", "customer@example.com", "Your invoice", "Hello,\nthis is your invoice.\r\nBye")
+	expected := "From: Store <noreply@store.com>\r\n" +
+		"To: customer@example.com\r\n" +
+		"Subject: Your invoice\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"Hello,\r\nthis is your invoice.\r\nBye"
+	assert.Equal(t, expected, string(msg))
+
+	// Headers cannot be injected
+	msg = message("a@b.com", "c@d.com\r\nBcc: e@f.com", "Subject\nBcc: e@f.com", "")
+	assert.NotContains(t, string(msg), "\r\nBcc:")
+}
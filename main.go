@@ -17,11 +17,13 @@ import (
 
 	"github.com/peppinux/dero-merchant/api"
 	"github.com/peppinux/dero-merchant/auth"
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/coingecko"
 	"github.com/peppinux/dero-merchant/config"
 	"github.com/peppinux/dero-merchant/postgres"
 	"github.com/peppinux/dero-merchant/processor"
 	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/repository"
 	"github.com/peppinux/dero-merchant/webapp"
 	"github.com/peppinux/dero-merchant/webapp/store"
 	"github.com/peppinux/dero-merchant/webapp/user"
@@ -58,10 +60,28 @@ func main() {
 	} else {
 		log.Println("PostgreSQL Server: ONLINE.")
 	}
-	postgres.CreateTablesIfNotExist()
+
+	// Subcommand migrate only migrates the database, without starting the application
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrateCommand(os.Args[2:])
+		if err != nil {
+			log.Fatalln("Migrations:", err)
+		}
+		return
+	}
+
+	// Apply the migrations missing from the database. Refuse to start if it was migrated by a newer version of the application.
+	applied, err := postgres.Migrate()
+	if err != nil {
+		log.Fatalln("Error migrating PostgreSQL database:", err)
+	}
+	log.Printf("PostgreSQL database: %d migrations applied.\n", len(applied))
+
+	repos := repository.NewPostgres(postgres.DB)
 
 	// Redis init
 	redis.Pool = redis.NewPool(config.RedisAddress)
+	redis.Namespace = config.RedisNamespace
 	defer redis.Pool.Close()
 	err = redis.Ping()
 	if err != nil {
@@ -69,7 +89,13 @@ func main() {
 	} else {
 		log.Println("Redis Server: ONLINE.")
 	}
-	redis.FlushAll()
+	cache.Default = cache.NewRedisCache()
+
+	// Keys are not flushed on startup, so that users stay signed in. Cache the stores in case Redis was emptied meanwhile.
+	err = cache.Warm(cache.Default, repos.Stores)
+	if err != nil {
+		log.Println("Error warming up cache:", err)
+	}
 
 	// CoinGecko API V3 server status check
 	statusCode := coingecko.Ping()
@@ -80,7 +106,7 @@ func main() {
 	}
 
 	// Payment processor init
-	processor.ActiveWallets = processor.NewStoresWallets()
+	processor.ActiveWallets = processor.NewStoresWallets(repos.Stores, repos.Payments, repos.Invoices)
 	err = processor.SetupDaemonConnection()
 	if err != nil {
 		log.Fatalf("Error setting up connection to daemon %s: %v\n", config.DeroDaemonAddress, err)
@@ -94,11 +120,20 @@ func main() {
 
 	// In case of application restart after a crash, update the status of pending payments to "error"
 	// since the application could have not been able to check for them.
-	err = processor.CleanAllPendingPayments()
+	err = processor.ActiveWallets.CleanAllPendingPayments()
 	if err != nil {
 		log.Println("Error cleaning all pending payments:", err)
 	}
 
+	// Subscriptions are billed in the background, sending their invoices to customers
+	go api.RunSubscriptionScheduler(repos.Stores, repos.Invoices, repos.Subscriptions)
+
+	// Expired idempotency keys are deleted in the background
+	go api.RunIdempotencyKeysCleaner(repos.IdempotencyKeys)
+
+	// Cached stores and API Keys are checked against the database in the background, repairing them if they drifted
+	go cache.RunConsistencyChecker(cache.Default, repos.Stores)
+
 	// Router init
 	r := gin.Default()
 
@@ -122,15 +157,15 @@ func main() {
 			notAuthOrRedirect := userGroup.Group("", auth.SessionNotAuthOrRedirect())
 			{
 				notAuthOrRedirect.GET("/signup", user.SignUpGetHandler)
-				notAuthOrRedirect.POST("/signup", user.SignUpPostHandler)
+				notAuthOrRedirect.POST("/signup", user.SignUpPostHandler(repos.Users))
 
 				notAuthOrRedirect.GET("/signin", user.SignInGetHandler)
-				notAuthOrRedirect.POST("/signin", user.SignInPostHandler)
+				notAuthOrRedirect.POST("/signin", user.SignInPostHandler(repos.Users, repos.Stores))
 
 				notAuthOrRedirect.GET("/forgot_password", user.ForgotPasswordGetHandler)
-				notAuthOrRedirect.POST("/forgot_password", user.ForgotPasswordPostHandler)
+				notAuthOrRedirect.POST("/forgot_password", user.ForgotPasswordPostHandler(repos.Users))
 				notAuthOrRedirect.GET("/recover", user.RecoverGetHandler)
-				notAuthOrRedirect.POST("/recover", user.RecoverPostHandler)
+				notAuthOrRedirect.POST("/recover", user.RecoverPostHandler(repos.Users))
 			}
 
 			authOrRedirect := userGroup.Group("", auth.SessionAuthOrRedirect())
@@ -139,13 +174,13 @@ func main() {
 				authOrRedirect.POST("/signout_all", user.SignOutAllHandler)
 			}
 
-			userGroup.GET("/verify", user.VerifyHandler)
+			userGroup.GET("/verify", user.VerifyHandler(repos.Users))
 			userGroup.GET("/new_verification_token", user.NewVerificationTokenGetHandler)
-			userGroup.POST("/new_verification_token", user.NewVerificationTokenPostHandler)
+			userGroup.POST("/new_verification_token", user.NewVerificationTokenPostHandler(repos.Users))
 
 			authOrForbidden := userGroup.Group("", auth.SessionAuthOrForbidden())
 			{
-				authOrForbidden.PUT("", user.PutHandler)
+				authOrForbidden.PUT("", user.PutHandler(repos.Users))
 			}
 		}
 
@@ -157,49 +192,101 @@ func main() {
 			stores := dashboard.Group("/stores")
 			{
 				stores.GET("", webapp.MyStoresHandler)
-				stores.GET("/view/:id", webapp.ViewStoreHandler)
+				stores.GET("/view/:id", webapp.ViewStoreHandler(repos.Stores))
 				stores.GET("/add", webapp.AddStoreGetHandler)
-				stores.POST("/add", webapp.AddStorePostHandler)
+				stores.POST("/add", webapp.AddStorePostHandler(repos.Stores))
 				stores.GET("/view/:id/payments", webapp.ViewStorePaymentsHandler)
+				stores.GET("/view/:id/payments/:payment_id", webapp.ViewStorePaymentHandler(repos.Stores, repos.Payments))
+				stores.POST("/view/:id/payments/:payment_id/cancel", webapp.CancelStorePaymentPostHandler(repos.Stores, repos.Payments))
+				stores.POST("/view/:id/payments/:payment_id/webhook", webapp.ResendStorePaymentWebhookPostHandler(repos.Stores, repos.Payments))
+				stores.GET("/view/:id/overview", webapp.ViewStoreOverviewHandler(repos.Stores))
+				stores.GET("/view/:id/links", webapp.ViewStoreLinksHandler(repos.Stores, repos.Links))
+				stores.POST("/view/:id/links", webapp.AddStoreLinkPostHandler(repos.Stores, repos.Links))
+				stores.POST("/view/:id/links/:link_id/toggle", webapp.ToggleStoreLinkPostHandler(repos.Stores, repos.Links))
+				stores.POST("/view/:id/links/:link_id/remove", webapp.RemoveStoreLinkPostHandler(repos.Stores, repos.Links))
 			}
 		}
 
 		storeGroup := web.Group("/store", auth.SessionAuthOrForbidden())
 		{
-			storeGroup.GET("/:id/payments", store.PaymentsGetHandler)
+			storeGroup.GET("/:id/payments", store.PaymentsGetHandler(repos.Stores, repos.Payments))
+			storeGroup.GET("/:id/payments/export", store.PaymentsExportGetHandler(repos.Stores, repos.Payments))
+			storeGroup.GET("/:id/stats", store.StatsGetHandler(repos.Stores, repos.Payments))
+			storeGroup.GET("/:id/events", store.EventsWSHandler(repos.Stores)) // Web Socket handler used by the dashboard to update payments in real time
 
-			requirePassword := storeGroup.Group("", auth.RequireUserPassword())
+			requirePassword := storeGroup.Group("", auth.RequireUserPassword(repos.Users))
 			{
-				requirePassword.PUT("/:id", store.PutHandler)
-				requirePassword.DELETE("/:id", store.DeleteHandler)
+				requirePassword.PUT("/:id", store.PutHandler(repos.Stores))
+				requirePassword.DELETE("/:id", store.DeleteHandler(repos.Stores))
 			}
 		}
 	}
 
 	// Pay helper endpoint for customers
-	r.GET("/pay/:payment_id", webapp.PayHandler)
+	r.GET("/pay/:payment_id", webapp.PayHandler(repos.Stores, repos.Payments))
+	r.GET("/pay/:payment_id/redirect", webapp.PayRedirectHandler(repos.Stores, repos.Payments))
+	r.POST("/pay/:payment_id/requote", webapp.PayRequoteHandler(repos.Stores, repos.Payments, repos.Links))
+	r.GET("/pay/:payment_id/receipt.pdf", webapp.PayReceiptHandler(repos.Stores, repos.Payments))
+
+	// Payment links render a landing page, and create a new payment when customers submit it, redirecting them to the pay helper page
+	r.GET("/link/:slug", webapp.LinkGetHandler(repos.Stores, repos.Links))
+	r.POST("/link/:slug", webapp.LinkPostHandler(repos.Stores, repos.Payments, repos.Links))
+
+	// Hosted invoice page. Paying an invoice redirects customers to the pay helper page of its payment
+	r.GET("/invoice/:invoice_id", webapp.InvoiceHandler(repos.Stores, repos.Invoices))
+	r.POST("/invoice/:invoice_id/pay", webapp.InvoicePayHandler(repos.Stores, repos.Payments, repos.Invoices))
+
 	// Web Socket handler used by /pay/:payment_id to update payment's status on page
-	r.GET("/ws/payment/:payment_id/status", webapp.WSPaymentStatusHandler)
+	r.GET("/ws/payment/:payment_id/status", webapp.WSPaymentStatusHandler(repos.Payments))
 
 	// API routes
 	apiGroup := r.Group("/api")
 	{
-		v1 := apiGroup.Group("/v1", auth.APIKeyAuth())
+		v1 := apiGroup.Group("/v1", auth.APIKeyAuth(repos.Stores))
 		{
 			v1.GET("/ping", api.PingGetHandler)
 
 			payment := v1.Group("/payment")
 			{
-				requireSecretKey := payment.Group("", auth.SecretKeyAuth())
+				requireSecretKey := payment.Group("", auth.SecretKeyAuth(repos.Stores), api.Idempotent(repos.IdempotencyKeys))
+				{
+					requireSecretKey.POST("", api.PaymentPostHandler(repos.Stores, repos.Payments))
+					requireSecretKey.POST("/:payment_id/cancel", api.PaymentCancelPostHandler(repos.Payments))
+					requireSecretKey.POST("/:payment_id/extend", api.PaymentExtendPostHandler(repos.Payments))
+					requireSecretKey.POST("/:payment_id/requote", api.PaymentRequotePostHandler(repos.Stores, repos.Payments, repos.Links))
+				}
+
+				payment.GET("/:payment_id", api.PaymentGetHandler(repos.Payments))
+				payment.GET("/:payment_id/receipt", api.PaymentReceiptGetHandler(repos.Stores, repos.Payments))
+			}
+
+			invoice := v1.Group("/invoice")
+			{
+				requireSecretKey := invoice.Group("", auth.SecretKeyAuth(repos.Stores), api.Idempotent(repos.IdempotencyKeys))
+				{
+					requireSecretKey.POST("", api.InvoicePostHandler(repos.Invoices))
+					requireSecretKey.POST("/:invoice_id/void", api.InvoiceVoidPostHandler(repos.Payments, repos.Invoices))
+				}
+
+				invoice.GET("/:invoice_id", api.InvoiceGetHandler(repos.Invoices))
+			}
+
+			subscription := v1.Group("/subscription")
+			{
+				requireSecretKey := subscription.Group("", auth.SecretKeyAuth(repos.Stores), api.Idempotent(repos.IdempotencyKeys))
 				{
-					requireSecretKey.POST("", api.PaymentPostHandler)
+					requireSecretKey.POST("", api.SubscriptionPostHandler(repos.Subscriptions))
+					requireSecretKey.POST("/:subscription_id/cancel", api.SubscriptionCancelPostHandler(repos.Subscriptions))
 				}
 
-				payment.GET("/:payment_id", api.PaymentGetHandler)
+				subscription.GET("/:subscription_id", api.SubscriptionGetHandler(repos.Subscriptions))
 			}
 
-			v1.POST("/payments", api.PaymentsPostHandler)
-			v1.GET("/payments", api.PaymentsGetHandler)
+			v1.POST("/payments", api.PaymentsPostHandler(repos.Payments))
+			v1.GET("/payments", api.PaymentsGetHandler(repos.Payments))
+			v1.GET("/payments/export", api.PaymentsExportGetHandler(repos.Payments))
+			v1.GET("/stats", api.StatsGetHandler(repos.Payments))
+			v1.POST("/payments/batch", auth.SecretKeyAuth(repos.Stores), api.Idempotent(repos.IdempotencyKeys), api.PaymentsBatchPostHandler(repos.Stores, repos.Payments))
 		}
 	}
 
@@ -234,7 +321,7 @@ func main() {
 	<-quit
 
 	log.Println("Cleaning all pending payments...")
-	err = processor.CleanAllPendingPayments()
+	err = processor.ActiveWallets.CleanAllPendingPayments()
 	if err != nil {
 		log.Println("Error cleaning all pending payments:", err)
 	}
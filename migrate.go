@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/postgres"
+)
+
+const migrateUsage = "usage: migrate [up | down [steps] | version]"
+
+// runMigrateCommand runs subcommand migrate:
+//
+//	migrate [up]         applies all the migrations missing from the database
+//	migrate down [steps] reverts the last steps migrations (default 1)
+//	migrate version      prints the schema version of the database and the latest migration known
+func runMigrateCommand(args []string) error {
+	m, err := postgres.NewMigrator(postgres.DB)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+
+		applied, err := m.Up()
+		for _, migration := range applied {
+			log.Println("Migrations: applied", migration)
+		}
+		if err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 || len(args) > 2 {
+				return errors.New(migrateUsage)
+			}
+		}
+
+		reverted, err := m.Down(steps)
+		for _, migration := range reverted {
+			log.Println("Migrations: reverted", migration)
+		}
+		if err != nil {
+			return err
+		}
+	case "version":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrations: database schema version %d (latest %d).\n", version, m.Latest())
+
+	return nil
+}
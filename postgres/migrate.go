@@ -0,0 +1,296 @@
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/config"
+)
+
+// migrationsFS holds the SQL scripts of the migrations, named NNNN_name.up.sql and NNNN_name.down.sql
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// ErrUnknownSchemaVersion is returned when DB was migrated by a newer version of the application
+var ErrUnknownSchemaVersion = errors.New("database schema version is newer than the latest migration known by the application")
+
+// migrationsLockID is the key of the advisory lock held while migrating, so that instances started together do not apply the same migration twice
+const migrationsLockID = 4036921
+
+var migrationFilenameRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration represents a version of the schema of DB, reached by running Up on the previous version and reverted by running Down
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m *Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// LoadMigrations reads the migrations in the root of fsys, sorted by version.
+// Versions have to start from 1 and be contiguous, and every migration needs both an up and a down script.
+func LoadMigrations(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read migrations directory")
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		match := migrationFilenameRegex.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, errors.Errorf("invalid migration filename %q", e.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		name, direction := match[2], match[3]
+
+		script, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read migration %s", e.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{
+				Version: version,
+				Name:    name,
+			}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, errors.Errorf("migration version %d used by both %q and %q", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, errors.Errorf("missing migration version %d", i+1)
+		}
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, errors.Errorf("migration %s has no up script", m)
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			return nil, errors.Errorf("migration %s has no down script", m)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Migrator applies and reverts Migrations on a database, recording its schema version in table schema_migrations
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []*Migration
+	// Settings are set as run-time parameters for the duration of every migration, so that scripts can read them with current_setting
+	// (e.g. to backfill new columns with the values configured on the server)
+	Settings map[string]string
+}
+
+// NewMigrator returns a new Migrator of the migrations embedded in the application
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	fsys, err := fs.Sub(migrationsFS, "migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot open embedded migrations")
+	}
+
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		DB:         db,
+		Migrations: migrations,
+		Settings: map[string]string{
+			"deromerchant.payment_max_ttl":           strconv.Itoa(config.PaymentMaxTTL),
+			"deromerchant.payment_min_confirmations": strconv.Itoa(config.PaymentMinConfirmations),
+		},
+	}, nil
+}
+
+// Latest returns the version of the last migration known by the Migrator
+func (m *Migrator) Latest() int {
+	return len(m.Migrations)
+}
+
+// Version returns the current schema version of the database. 0 means that no migration was applied.
+func (m *Migrator) Version() (version int, err error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot begin transaction")
+	}
+
+	defer tx.Rollback()
+
+	version, err = lockedVersion(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot commit transaction")
+	}
+
+	return version, nil
+}
+
+// Up applies the migrations newer than the current schema version of the database, returning the ones applied.
+// Every migration is applied in its own transaction.
+func (m *Migrator) Up() (applied []*Migration, err error) {
+	for {
+		migration, err := m.step(true)
+		if err != nil {
+			return applied, err
+		}
+		if migration == nil {
+			return applied, nil
+		}
+		applied = append(applied, migration)
+	}
+}
+
+// Down reverts at most steps migrations, starting from the current schema version of the database, returning the ones reverted
+func (m *Migrator) Down(steps int) (reverted []*Migration, err error) {
+	for i := 0; i < steps; i++ {
+		migration, err := m.step(false)
+		if err != nil {
+			return reverted, err
+		}
+		if migration == nil {
+			break
+		}
+		reverted = append(reverted, migration)
+	}
+
+	return reverted, nil
+}
+
+// step applies (up) or reverts (!up) a single migration. It returns nil if there is nothing left to migrate.
+func (m *Migrator) step(up bool) (*Migration, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot begin transaction")
+	}
+
+	defer tx.Rollback()
+
+	version, err := lockedVersion(tx)
+	if err != nil {
+		return nil, err
+	}
+	if version > m.Latest() {
+		return nil, ErrUnknownSchemaVersion
+	}
+
+	for name, value := range m.Settings {
+		_, err = tx.Exec(`SELECT set_config($1, $2, true)`, name, value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot set %s", name)
+		}
+	}
+
+	var migration *Migration
+	if up {
+		if version == m.Latest() {
+			return nil, nil
+		}
+
+		migration = m.Migrations[version] // Versions start from 1 and are contiguous
+		_, err = tx.Exec(migration.Up)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot apply migration %s", migration)
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+	} else {
+		if version == 0 {
+			return nil, nil
+		}
+
+		migration = m.Migrations[version-1]
+		_, err = tx.Exec(migration.Down)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot revert migration %s", migration)
+		}
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version=$1`, migration.Version)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot update schema version")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot commit transaction")
+	}
+
+	return migration, nil
+}
+
+// lockedVersion locks the migrations until the end of tx and returns the current schema version of the database
+func lockedVersion(tx *sql.Tx) (version int, err error) {
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationsLockID)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot lock migrations")
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version integer NOT NULL,
+			name character varying NOT NULL,
+			applied_at timestamp without time zone NOT NULL DEFAULT now(),
+			CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)
+		)`)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot create table schema_migrations")
+	}
+
+	err = tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot query schema version")
+	}
+
+	return version, nil
+}
+
+// Migrate applies to DB the embedded migrations it is missing, returning the ones applied.
+// Databases whose tables were created before migrations existed are migrated too, since migrations skip what already exists.
+// It returns ErrUnknownSchemaVersion if DB was migrated by a newer version of the application.
+func Migrate() (applied []*Migration, err error) {
+	m, err := NewMigrator(DB)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Up()
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_column.down.sql": {Data: []byte("ALTER TABLE foo DROP COLUMN bar;")},
+		"0002_add_column.up.sql":   {Data: []byte("ALTER TABLE foo ADD COLUMN bar integer;")},
+		"0001_create_foo.up.sql":   {Data: []byte("CREATE TABLE foo (id integer);")},
+		"0001_create_foo.down.sql": {Data: []byte("DROP TABLE foo;")},
+	}
+	migrations, err := LoadMigrations(fsys)
+	assert.Nil(t, err)
+	if assert.Len(t, migrations, 2) {
+		assert.Equal(t, &Migration{Version: 1, Name: "create_foo", Up: "CREATE TABLE foo (id integer);", Down: "DROP TABLE foo;"}, migrations[0])
+		assert.Equal(t, 2, migrations[1].Version)
+		assert.Equal(t, "0002_add_column", migrations[1].String())
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"invalid filename": {
+			"0001_create_foo.sql": {Data: []byte("CREATE TABLE foo (id integer);")},
+		},
+		"missing down script": {
+			"0001_create_foo.up.sql": {Data: []byte("CREATE TABLE foo (id integer);")},
+		},
+		"empty up script": {
+			"0001_create_foo.up.sql":   {Data: []byte("\n")},
+			"0001_create_foo.down.sql": {Data: []byte("DROP TABLE foo;")},
+		},
+		"missing version": {
+			"0002_create_foo.up.sql":   {Data: []byte("CREATE TABLE foo (id integer);")},
+			"0002_create_foo.down.sql": {Data: []byte("DROP TABLE foo;")},
+		},
+		"duplicate version": {
+			"0001_create_foo.up.sql":   {Data: []byte("CREATE TABLE foo (id integer);")},
+			"0001_create_foo.down.sql": {Data: []byte("DROP TABLE foo;")},
+			"0001_create_bar.up.sql":   {Data: []byte("CREATE TABLE bar (id integer);")},
+			"0001_create_bar.down.sql": {Data: []byte("DROP TABLE bar;")},
+		},
+	}
+	for name, fsys := range invalid {
+		_, err := LoadMigrations(fsys)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	m, err := NewMigrator(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, len(m.Migrations), m.Latest())
+	assert.NotZero(t, m.Latest())
+	assert.Contains(t, m.Settings, "deromerchant.payment_max_ttl")
+	assert.Contains(t, m.Settings, "deromerchant.payment_min_confirmations")
+}
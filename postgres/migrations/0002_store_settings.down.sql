@@ -0,0 +1,9 @@
+ALTER TABLE stores
+    DROP COLUMN IF EXISTS rate_markup,
+    DROP COLUMN IF EXISTS underpayment_tolerance,
+    DROP COLUMN IF EXISTS dero_decimals,
+    DROP COLUMN IF EXISTS payment_ttl,
+    DROP COLUMN IF EXISTS payment_min_confirmations,
+    DROP COLUMN IF EXISTS confirmation_tiers,
+    DROP COLUMN IF EXISTS receipt_color,
+    DROP COLUMN IF EXISTS receipt_footer;
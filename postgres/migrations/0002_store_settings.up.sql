@@ -0,0 +1,10 @@
+-- Pricing policy, payment requirements and receipt branding of stores
+ALTER TABLE stores
+    ADD COLUMN IF NOT EXISTS rate_markup numeric(4,2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS underpayment_tolerance numeric(4,2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS dero_decimals smallint NOT NULL DEFAULT 12,
+    ADD COLUMN IF NOT EXISTS payment_ttl integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS payment_min_confirmations integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS confirmation_tiers jsonb NOT NULL DEFAULT '[]'::jsonb,
+    ADD COLUMN IF NOT EXISTS receipt_color character varying(7) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS receipt_footer character varying(512) NOT NULL DEFAULT '';
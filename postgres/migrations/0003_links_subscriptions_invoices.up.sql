@@ -0,0 +1,87 @@
+CREATE TABLE IF NOT EXISTS payment_links
+(
+    id integer NOT NULL GENERATED BY DEFAULT AS IDENTITY (INCREMENT 1 START 1 MINVALUE 1 MAXVALUE 2147483647 CACHE 1),
+    slug character varying(32) NOT NULL,
+    title character varying(64) NOT NULL,
+    description character varying(256) NOT NULL DEFAULT '',
+    currency character varying NOT NULL,
+    amount numeric,
+    active boolean NOT NULL DEFAULT true,
+    removed boolean NOT NULL DEFAULT false,
+    creation_time timestamp without time zone NOT NULL DEFAULT now(),
+    store_id integer NOT NULL,
+    CONSTRAINT payment_links_pkey PRIMARY KEY (id),
+    CONSTRAINT payment_links_slug_key UNIQUE (slug),
+    CONSTRAINT payment_links_store_id_fkey FOREIGN KEY (store_id)
+        REFERENCES public.stores (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+        NOT VALID
+);
+
+CREATE TABLE IF NOT EXISTS subscriptions
+(
+    id integer NOT NULL GENERATED BY DEFAULT AS IDENTITY (INCREMENT 1 START 1 MINVALUE 1 MAXVALUE 2147483647 CACHE 1),
+    subscription_id character(64) NOT NULL,
+    status character varying NOT NULL,
+    currency character varying NOT NULL,
+    amount numeric NOT NULL,
+    billing_interval character varying(8) NOT NULL,
+    interval_count integer NOT NULL DEFAULT 1,
+    customer_name character varying(128) NOT NULL,
+    customer_email character varying(64) NOT NULL,
+    customer_address character varying(512) NOT NULL DEFAULT '',
+    description character varying(256) NOT NULL DEFAULT '',
+    grace_period_days integer NOT NULL DEFAULT 0,
+    start_date date NOT NULL,
+    next_billing_date date NOT NULL,
+    billed_cycles integer NOT NULL DEFAULT 0,
+    missed_cycles integer NOT NULL DEFAULT 0,
+    creation_time timestamp without time zone NOT NULL DEFAULT now(),
+    cancelled_time timestamp without time zone,
+    store_id integer NOT NULL,
+    CONSTRAINT subscriptions_pkey PRIMARY KEY (id),
+    CONSTRAINT subscriptions_subscription_id_key UNIQUE (subscription_id),
+    CONSTRAINT subscriptions_store_id_fkey FOREIGN KEY (store_id)
+        REFERENCES public.stores (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+        NOT VALID
+);
+CREATE INDEX IF NOT EXISTS subscriptions_next_billing_date_idx ON subscriptions (next_billing_date);
+
+CREATE TABLE IF NOT EXISTS invoices
+(
+    id integer NOT NULL GENERATED BY DEFAULT AS IDENTITY (INCREMENT 1 START 1 MINVALUE 1 MAXVALUE 2147483647 CACHE 1),
+    invoice_id character(64) NOT NULL,
+    number character varying(64) NOT NULL DEFAULT '',
+    status character varying NOT NULL,
+    currency character varying NOT NULL,
+    customer_name character varying(128) NOT NULL,
+    customer_email character varying(64) NOT NULL DEFAULT '',
+    customer_address character varying(512) NOT NULL DEFAULT '',
+    line_items jsonb NOT NULL,
+    subtotal numeric NOT NULL,
+    tax_total numeric NOT NULL,
+    total numeric NOT NULL,
+    due_date date NOT NULL,
+    notes character varying(1024) NOT NULL DEFAULT '',
+    payment_id character(64),
+    creation_time timestamp without time zone NOT NULL DEFAULT now(),
+    paid_time timestamp without time zone,
+    subscription_id integer,
+    store_id integer NOT NULL,
+    CONSTRAINT invoices_pkey PRIMARY KEY (id),
+    CONSTRAINT invoices_invoice_id_key UNIQUE (invoice_id),
+    CONSTRAINT invoices_store_id_fkey FOREIGN KEY (store_id)
+        REFERENCES public.stores (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+        NOT VALID,
+    CONSTRAINT invoices_subscription_id_fkey FOREIGN KEY (subscription_id)
+        REFERENCES public.subscriptions (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+        NOT VALID
+);
+CREATE INDEX IF NOT EXISTS invoices_subscription_id_idx ON invoices (subscription_id);
@@ -0,0 +1,27 @@
+DROP INDEX IF EXISTS payments_metadata_idx;
+DROP INDEX IF EXISTS payments_store_id_currency_amount_idx;
+DROP INDEX IF EXISTS payments_store_id_creation_time_idx;
+DROP INDEX IF EXISTS payments_payment_link_id_idx;
+
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS min_atomic_dero_amount,
+    DROP COLUMN IF EXISTS rate_markup,
+    DROP COLUMN IF EXISTS underpayment_tolerance,
+    DROP COLUMN IF EXISTS dero_decimals,
+    DROP COLUMN IF EXISTS max_ttl,
+    DROP COLUMN IF EXISTS min_confirmations,
+    DROP COLUMN IF EXISTS required_confirmations,
+    DROP COLUMN IF EXISTS order_id,
+    DROP COLUMN IF EXISTS description,
+    DROP COLUMN IF EXISTS customer_email,
+    DROP COLUMN IF EXISTS metadata,
+    DROP COLUMN IF EXISTS requoted_from,
+    DROP COLUMN IF EXISTS requoted_to,
+    DROP COLUMN IF EXISTS success_url,
+    DROP COLUMN IF EXISTS cancel_url,
+    DROP COLUMN IF EXISTS payment_link_id,
+    DROP COLUMN IF EXISTS invoice_id,
+    DROP COLUMN IF EXISTS tx_ids,
+    DROP COLUMN IF EXISTS paid_time,
+    ALTER COLUMN currency_amount TYPE double precision,
+    ALTER COLUMN exchange_rate TYPE double precision;
@@ -0,0 +1,42 @@
+-- Exact amounts, pricing policy, requirements, customer details, re-quotes, redirect URLs, links, invoices and receipts of payments
+ALTER TABLE payments
+    ALTER COLUMN currency_amount TYPE numeric,
+    ALTER COLUMN exchange_rate TYPE numeric,
+    ADD COLUMN IF NOT EXISTS min_atomic_dero_amount bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS rate_markup numeric(4,2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS underpayment_tolerance numeric(4,2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS dero_decimals smallint NOT NULL DEFAULT 12,
+    ADD COLUMN IF NOT EXISTS max_ttl integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS min_confirmations integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS required_confirmations integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS order_id character varying(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS description character varying(256) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS customer_email character varying(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS metadata jsonb,
+    ADD COLUMN IF NOT EXISTS requoted_from character(64) CONSTRAINT payments_requoted_from_key UNIQUE,
+    ADD COLUMN IF NOT EXISTS requoted_to character(64),
+    ADD COLUMN IF NOT EXISTS success_url character varying(2048) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS cancel_url character varying(2048) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS payment_link_id integer CONSTRAINT payments_payment_link_id_fkey REFERENCES public.payment_links (id),
+    ADD COLUMN IF NOT EXISTS invoice_id integer CONSTRAINT payments_invoice_id_fkey REFERENCES public.invoices (id),
+    ADD COLUMN IF NOT EXISTS tx_ids jsonb,
+    ADD COLUMN IF NOT EXISTS paid_time timestamp without time zone;
+
+-- Payments created before the columns were added expired after the max TTL and required the min confirmations configured on the server,
+-- which are set by the migrator. A max TTL of 0 marks the payments that were not backfilled yet.
+UPDATE payments
+SET max_ttl=current_setting('deromerchant.payment_max_ttl')::integer,
+    min_confirmations=current_setting('deromerchant.payment_min_confirmations')::integer,
+    required_confirmations=current_setting('deromerchant.payment_min_confirmations')::integer
+WHERE max_ttl=0;
+
+-- Defaults were only needed to add the columns to the existing payments
+ALTER TABLE payments
+    ALTER COLUMN max_ttl DROP DEFAULT,
+    ALTER COLUMN min_confirmations DROP DEFAULT,
+    ALTER COLUMN required_confirmations DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS payments_payment_link_id_idx ON payments (payment_link_id);
+CREATE INDEX IF NOT EXISTS payments_store_id_creation_time_idx ON payments (store_id, creation_time, payment_id);
+CREATE INDEX IF NOT EXISTS payments_store_id_currency_amount_idx ON payments (store_id, currency_amount, payment_id);
+CREATE INDEX IF NOT EXISTS payments_metadata_idx ON payments USING gin (metadata jsonb_path_ops);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    store_id integer NOT NULL,
+    idempotency_key character varying(255) NOT NULL,
+    request_hash character(64) NOT NULL,
+    response_status integer NOT NULL DEFAULT 0,
+    response_body text NOT NULL DEFAULT '',
+    creation_time timestamp without time zone NOT NULL DEFAULT now(),
+    CONSTRAINT idempotency_keys_pkey PRIMARY KEY (store_id, idempotency_key),
+    CONSTRAINT idempotency_keys_store_id_fkey FOREIGN KEY (store_id)
+        REFERENCES public.stores (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+        NOT VALID
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_creation_time_idx ON idempotency_keys (creation_time);
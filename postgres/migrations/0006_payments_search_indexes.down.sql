@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS payments_description_trgm_idx;
+DROP INDEX IF EXISTS payments_order_id_trgm_idx;
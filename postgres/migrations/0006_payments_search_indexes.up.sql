@@ -0,0 +1,11 @@
+-- Trigram indexes used by case insensitive substring searches of payments.
+-- Extension pg_trgm may not be available to the DB user, in which case searches work without indexes.
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS pg_trgm;
+    CREATE INDEX IF NOT EXISTS payments_order_id_trgm_idx ON payments USING gin (order_id gin_trgm_ops);
+    CREATE INDEX IF NOT EXISTS payments_description_trgm_idx ON payments USING gin (description gin_trgm_ops);
+EXCEPTION WHEN OTHERS THEN
+    RAISE WARNING 'Trigram indexes of payments not created: %', SQLERRM;
+END
+$$;
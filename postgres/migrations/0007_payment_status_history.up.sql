@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS payment_status_history
+(
+    id bigint GENERATED BY DEFAULT AS IDENTITY,
+    payment_id character(64) NOT NULL,
+    old_status character varying,
+    new_status character varying NOT NULL,
+    cause character varying(32) NOT NULL,
+    time timestamp without time zone NOT NULL DEFAULT now(),
+    CONSTRAINT payment_status_history_pkey PRIMARY KEY (id),
+    CONSTRAINT payment_status_history_payment_id_fkey FOREIGN KEY (payment_id)
+        REFERENCES public.payments (payment_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+        NOT VALID
+);
+CREATE INDEX IF NOT EXISTS payment_status_history_payment_id_idx ON payment_status_history (payment_id, id);
+
+-- Rows of payment_status_history can only be inserted
+CREATE OR REPLACE FUNCTION payment_status_history_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'payment_status_history is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS payment_status_history_append_only ON payment_status_history;
+CREATE TRIGGER payment_status_history_append_only BEFORE UPDATE OR DELETE ON payment_status_history
+    FOR EACH ROW EXECUTE PROCEDURE payment_status_history_append_only();
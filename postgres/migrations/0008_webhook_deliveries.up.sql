@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id bigint GENERATED BY DEFAULT AS IDENTITY,
+    payment_id character(64) NOT NULL,
+    status character varying NOT NULL,
+    response_status integer NOT NULL DEFAULT 0,
+    error character varying(512) NOT NULL DEFAULT '',
+    time timestamp without time zone NOT NULL DEFAULT now(),
+    CONSTRAINT webhook_deliveries_pkey PRIMARY KEY (id),
+    CONSTRAINT webhook_deliveries_payment_id_fkey FOREIGN KEY (payment_id)
+        REFERENCES public.payments (payment_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+        NOT VALID
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_payment_id_idx ON webhook_deliveries (payment_id, id);
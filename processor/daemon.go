@@ -93,3 +93,77 @@ func getDaemonNetwork(address string) (network string, err error) {
 	}
 	return
 }
+
+// postDaemon sends a JSON POST request to path of the daemon and unmarshals the response into result
+func postDaemon(path string, params interface{}, result interface{}) error {
+	reqBody, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal request body")
+	}
+
+	url := stringutil.Build(config.DeroDaemonAddress, path)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return errors.Wrap(err, "error sending post request")
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "cannot read response body")
+	}
+
+	err = json.Unmarshal(respBody, result)
+	if err != nil {
+		return errors.Wrap(err, "cannot unmarshal response body")
+	}
+
+	return nil
+}
+
+// GetTransactionsConfirmations returns the number of confirmations of transactions mapped to their IDs, as known by the daemon.
+// Transactions still in the pool have 0 confirmations, while transactions unknown to the daemon are not mapped.
+func GetTransactionsConfirmations(txIDs []string) (map[string]uint64, error) {
+	info := struct {
+		Result struct {
+			Height uint64 `json:"height"`
+		} `json:"result"`
+	}{}
+	err := postDaemon("/json_rpc", map[string]string{
+		"jsonrpc": "2.0",
+		"id":      "1",
+		"method":  "get_info",
+	}, &info)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get daemon height")
+	}
+
+	txs := struct {
+		Txs []struct {
+			BlockHeight int64 `json:"block_height"`
+			InPool      bool  `json:"in_pool"`
+		} `json:"txs"`
+	}{}
+	err = postDaemon("/gettransactions", map[string][]string{
+		"txs_hashes": txIDs,
+	}, &txs)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get transactions")
+	}
+	if len(txs.Txs) != len(txIDs) {
+		return nil, errors.Errorf("daemon returned %d transactions instead of %d", len(txs.Txs), len(txIDs))
+	}
+
+	confirmations := make(map[string]uint64)
+	for i, tx := range txs.Txs {
+		switch {
+		case tx.InPool:
+			confirmations[txIDs[i]] = 0
+		case tx.BlockHeight > 0 && uint64(tx.BlockHeight) <= info.Result.Height:
+			confirmations[txIDs[i]] = info.Result.Height - uint64(tx.BlockHeight)
+		}
+	}
+
+	return confirmations, nil
+}
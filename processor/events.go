@@ -0,0 +1,165 @@
+/*
+	events.go manages communication to /ws/store/:id/events WS connections
+	of signed in dashboard sessions listening for events of the payments of a store.
+	It is used to update the payments table and overview of the dashboard in real time.
+*/
+
+package processor
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+// Dashboard event types
+const (
+	EventPaymentCreated       = "payment_created"
+	EventPaymentStatusChanged = "payment_status_changed"
+)
+
+// eventWriteTimeout is the time allowed to write an event to a WS connection, so that slow clients cannot hold up the others
+const eventWriteTimeout = 5 * time.Second
+
+// DashboardEvent is the event sent to dashboard sessions when a payment of a store is created or its status changes
+type DashboardEvent struct {
+	Type      string `json:"type"`
+	PaymentID string `json:"paymentID"`
+	Status    string `json:"status"`
+	// OldStatus is only set for status changes
+	OldStatus string `json:"oldStatus,omitempty"`
+}
+
+// StoresConnections maps the WS connections of dashboard sessions to the store IDs they are listening to, with a Mutex for map synchronization
+type StoresConnections struct {
+	Map   map[int][]net.Conn
+	Mutex sync.Mutex
+
+	// writeMutexes serialize the writes to each connection, since events are written without holding Mutex. Synchronized by Mutex
+	writeMutexes map[net.Conn]*sync.Mutex
+}
+
+// NewStoresConnections returns a new StoresConnections struct
+func NewStoresConnections() *StoresConnections {
+	return &StoresConnections{
+		Map:          make(map[int][]net.Conn),
+		writeMutexes: make(map[net.Conn]*sync.Mutex),
+	}
+}
+
+// StoreWSConnections is the global variable that holds WS connections listening for events of the payments of stores
+var StoreWSConnections = NewStoresConnections()
+
+// Add adds a WS connection listening for the events of a store
+func (sc *StoresConnections) Add(storeID int, conn net.Conn) {
+	sc.Mutex.Lock()
+	defer sc.Mutex.Unlock()
+
+	sc.Map[storeID] = append(sc.Map[storeID], conn)
+	sc.writeMutexes[conn] = &sync.Mutex{}
+}
+
+// Remove removes and closes a WS connection listening for the events of a store
+func (sc *StoresConnections) Remove(storeID int, conn net.Conn) {
+	sc.Mutex.Lock()
+	defer sc.Mutex.Unlock()
+
+	sc.remove(storeID, conn)
+}
+
+func (sc *StoresConnections) remove(storeID int, conn net.Conn) {
+	conns := sc.Map[storeID]
+	for i, c := range conns {
+		if c == conn {
+			sc.Map[storeID] = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(sc.Map[storeID]) == 0 {
+		delete(sc.Map, storeID)
+	}
+	delete(sc.writeMutexes, conn)
+
+	conn.Close()
+}
+
+// Count returns the number of WS connections listening for the events of a store
+func (sc *StoresConnections) Count(storeID int) int {
+	sc.Mutex.Lock()
+	defer sc.Mutex.Unlock()
+
+	return len(sc.Map[storeID])
+}
+
+// Listen reads from a WS connection listening for the events of a store until the connection fails, then removes it.
+// Messages sent by the client are ignored. Reading is only needed to answer control frames and to know when the connection gets closed.
+func (sc *StoresConnections) Listen(storeID int, conn net.Conn) {
+	sc.Mutex.Lock()
+	writeMutex := sc.writeMutexes[conn]
+	sc.Mutex.Unlock()
+
+	if writeMutex == nil { // Already removed
+		return
+	}
+
+	// Replies to control frames are written by wsutil in a single Write, holding the write mutex of the connection so that they do not interleave with events
+	rw := &lockedConn{Conn: conn, writeMutex: writeMutex}
+	for {
+		_, _, err := wsutil.ReadClientData(rw)
+		if err != nil {
+			sc.Remove(storeID, conn)
+			return
+		}
+	}
+}
+
+// lockedConn is a net.Conn whose writes hold a write mutex of StoresConnections
+type lockedConn struct {
+	net.Conn
+	writeMutex *sync.Mutex
+}
+
+func (c *lockedConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.Conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+	return c.Conn.Write(p)
+}
+
+// SendEvent sends an event to the WS connections listening for the events of a store.
+// Connections the event cannot be written to are removed.
+// Events are written without holding Mutex, so that slow connections do not hold up the connections of other stores.
+func (sc *StoresConnections) SendEvent(storeID int, e *DashboardEvent) {
+	sc.Mutex.Lock()
+	conns := append([]net.Conn(nil), sc.Map[storeID]...)
+	writeMutexes := make([]*sync.Mutex, len(conns))
+	for i, conn := range conns {
+		writeMutexes[i] = sc.writeMutexes[conn]
+	}
+	sc.Mutex.Unlock()
+
+	if len(conns) == 0 {
+		return
+	}
+
+	msg, err := json.Marshal(e)
+	if err != nil {
+		log.Println("Error marshalling dashboard event:", err)
+		return
+	}
+
+	for i, conn := range conns {
+		writeMutexes[i].Lock()
+		conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		err = wsutil.WriteServerText(conn, msg)
+		writeMutexes[i].Unlock()
+		if err != nil {
+			sc.Remove(storeID, conn)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoresConnections(t *testing.T) {
+	sc := NewStoresConnections()
+
+	server, client := net.Pipe()
+	sc.Add(1, server)
+	assert.Equal(t, 1, sc.Count(1))
+	assert.Zero(t, sc.Count(2))
+
+	// Test sending an event
+	received := make(chan *DashboardEvent, 1)
+	go func() {
+		msg, err := wsutil.ReadServerText(client)
+		assert.Nil(t, err)
+
+		var e DashboardEvent
+		assert.Nil(t, json.Unmarshal(msg, &e))
+		received <- &e
+	}()
+	sc.SendEvent(1, &DashboardEvent{
+		Type:      EventPaymentStatusChanged,
+		PaymentID: "payment",
+		Status:    PaymentStatusPaid,
+		OldStatus: PaymentStatusPending,
+	})
+	e := <-received
+	assert.Equal(t, EventPaymentStatusChanged, e.Type)
+	assert.Equal(t, "payment", e.PaymentID)
+	assert.Equal(t, PaymentStatusPaid, e.Status)
+	assert.Equal(t, PaymentStatusPending, e.OldStatus)
+
+	// Test stores without connections
+	sc.SendEvent(2, &DashboardEvent{Type: EventPaymentCreated})
+
+	// Test connections closed by the client being removed
+	client.Close()
+	sc.SendEvent(1, &DashboardEvent{Type: EventPaymentCreated})
+	assert.Zero(t, sc.Count(1))
+
+	// Test removing a connection
+	server, client = net.Pipe()
+	defer client.Close()
+	sc.Add(1, server)
+	sc.Remove(1, server)
+	assert.Zero(t, sc.Count(1))
+	_, ok := sc.Map[1]
+	assert.False(t, ok)
+}
+
+func TestStoresConnectionsSlowClient(t *testing.T) {
+	sc := NewStoresConnections()
+
+	// Client of store 1 never reads, so writing to it blocks until the write deadline
+	slowServer, slowClient := net.Pipe()
+	sc.Add(1, slowServer)
+	sent := make(chan struct{})
+	go func() {
+		sc.SendEvent(1, &DashboardEvent{Type: EventPaymentCreated})
+		close(sent)
+	}()
+	time.Sleep(100 * time.Millisecond) // Let the write to the slow client start
+
+	// Connections of other stores are not held up
+	start := time.Now()
+	server, client := net.Pipe()
+	defer client.Close()
+	sc.Add(2, server)
+	go wsutil.ReadServerText(client)
+	sc.SendEvent(2, &DashboardEvent{Type: EventPaymentCreated})
+	assert.Equal(t, 1, sc.Count(2))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// The slow connection is removed once the write to it fails
+	slowClient.Close()
+	<-sent
+	assert.Zero(t, sc.Count(1))
+}
+
+func TestStoresConnectionsListen(t *testing.T) {
+	sc := NewStoresConnections()
+
+	server, client := net.Pipe()
+	sc.Add(1, server)
+	done := make(chan struct{})
+	go func() {
+		sc.Listen(1, server)
+		close(done)
+	}()
+
+	// Pings are answered while events are being sent
+	go sc.SendEvent(1, &DashboardEvent{Type: EventPaymentCreated})
+	go wsutil.WriteClientMessage(client, ws.OpPing, []byte("ping"))
+
+	var pong, event bool
+	for i := 0; i < 2; i++ {
+		f, err := ws.ReadFrame(client)
+		assert.Nil(t, err)
+		switch f.Header.OpCode {
+		case ws.OpPong:
+			pong = true
+			assert.Equal(t, "ping", string(f.Payload))
+		case ws.OpText:
+			event = true
+			var e DashboardEvent
+			assert.Nil(t, json.Unmarshal(f.Payload, &e))
+			assert.Equal(t, EventPaymentCreated, e.Type)
+		}
+	}
+	assert.True(t, pong)
+	assert.True(t, event)
+
+	// The connection is removed once closed by the client
+	client.Close()
+	<-done
+	assert.Zero(t, sc.Count(1))
+}
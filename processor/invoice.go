@@ -0,0 +1,28 @@
+package processor
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// Invoice statuses
+const (
+	InvoiceStatusOpen    = repository.InvoiceStatusOpen
+	InvoiceStatusPaid    = repository.InvoiceStatusPaid
+	InvoiceStatusVoid    = repository.InvoiceStatusVoid
+	InvoiceStatusOverdue = "overdue" // Open past its due date. Not stored in DB
+)
+
+// markInvoicePaid marks the open invoice a paid payment was created for (if any) as paid
+func (w *StoreWallet) markInvoicePaid(paymentID string) error {
+	p, err := w.Payments.ByID(paymentID)
+	if err != nil {
+		return errors.Wrap(err, "cannot fetch payment")
+	}
+	if p.InvoiceID == 0 {
+		return nil
+	}
+
+	return w.Invoices.MarkPaid(p.InvoiceID, paymentID)
+}
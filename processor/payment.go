@@ -1,38 +1,107 @@
 package processor
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
+
+	"github.com/peppinux/dero-merchant/config"
+	"github.com/peppinux/dero-merchant/repository"
 )
 
 // Payment statuses
 const (
-	PaymentStatusPending = "pending"
-	PaymentStatusPaid    = "paid"
-	PaymentStatusExpired = "expired"
-	PaymentStatusError   = "error"
+	PaymentStatusPending   = repository.PaymentStatusPending
+	PaymentStatusPaid      = repository.PaymentStatusPaid
+	PaymentStatusExpired   = repository.PaymentStatusExpired
+	PaymentStatusError     = repository.PaymentStatusError
+	PaymentStatusCancelled = repository.PaymentStatusCancelled
+)
+
+// Causes of payment status changes recorded in the status history of payments
+const (
+	StatusCauseCreated         = "created"          // Payment was created as pending
+	StatusCauseProcessor       = "processor"        // Payment was paid or expired while being checked for by the store wallet
+	StatusCauseAPICancel       = "api_cancel"       // Payment was cancelled by the store through the API
+	StatusCauseDashboardCancel = "dashboard_cancel" // Payment was cancelled by the store owner from the dashboard
+	StatusCauseInvoiceVoid     = "invoice_void"     // Payment was cancelled because the invoice it was created for was voided
+	StatusCauseRestartCleanup  = "restart_cleanup"  // Payment was still pending when the application was started or shut down
+	StatusCauseWalletError     = "wallet_error"     // Payment was cancelled because the store wallet could not check for it
 )
 
 // PendingPayment represents a pending payment
 type PendingPayment struct {
-	AtomicDeroAmount uint64
-	CreationTime     time.Time
+	AtomicDeroAmount    uint64
+	MinAtomicDeroAmount uint64 // Minimum amount of atomic DERO the payment is considered paid for
+	TTL                 int    // Number of minutes allowed to receive the payment before it expires
+	MinConfirmations    int    // Minimum number of confirmations the payment needs to have before it is considered valid, if no confirmation tier applies
+	ConfirmationTiers   ConfirmationTiers
+	CreationTime        time.Time
+	PaymentMetadata     // Sent along with status updates to the webhook of the store
 }
 
-// NewPendingPayment returns a new PendingPayment struct
-func NewPendingPayment(atomicDeroAmount uint64) *PendingPayment {
+// NewPendingPayment returns a new PendingPayment struct.
+// If minAtomicDeroAmount is 0, the full atomicDeroAmount is required for the payment to be considered paid.
+// If ttl is 0, the payment expires after PAYMENT_MAX_TTL minutes.
+func NewPendingPayment(atomicDeroAmount, minAtomicDeroAmount uint64, ttl, minConfirmations int) *PendingPayment {
+	if minAtomicDeroAmount == 0 || minAtomicDeroAmount > atomicDeroAmount {
+		minAtomicDeroAmount = atomicDeroAmount
+	}
+	if ttl <= 0 {
+		ttl = config.PaymentMaxTTL
+	}
+
 	return &PendingPayment{
-		AtomicDeroAmount: atomicDeroAmount,
-		CreationTime:     time.Now(),
+		AtomicDeroAmount:    atomicDeroAmount,
+		MinAtomicDeroAmount: minAtomicDeroAmount,
+		TTL:                 ttl,
+		MinConfirmations:    minConfirmations,
+		CreationTime:        time.Now(),
 	}
 }
 
+// RequiredConfirmations returns the number of confirmations the payment needs to have before it is considered valid:
+// the ones of the confirmation tier its amount falls in, if any, otherwise MinConfirmations
+func (p *PendingPayment) RequiredConfirmations() int {
+	if confirmations, ok := p.ConfirmationTiers.Confirmations(p.AtomicDeroAmount); ok {
+		return confirmations
+	}
+	return p.MinConfirmations
+}
+
 // MinutesFromCreation returns the number of minutes passed from the creation of the pending payment
 func (p *PendingPayment) MinutesFromCreation() float64 {
 	t := time.Now().Sub(p.CreationTime)
 	return t.Minutes()
 }
 
+// PaymentMetadata represents the optional info a store attaches to a payment to link it to its own records
+type PaymentMetadata struct {
+	OrderID       string          `json:"orderID,omitempty"`
+	Description   string          `json:"description,omitempty"`
+	CustomerEmail string          `json:"customerEmail,omitempty"`
+	Metadata      json.RawMessage `json:"metadata,omitempty"` // Free-form JSON object
+}
+
+// ConfirmationTier requires payments of less than MaxAtomicDeroAmount atomic DERO to have Confirmations confirmations
+type ConfirmationTier struct {
+	MaxAtomicDeroAmount uint64 // Exclusive upper bound. 0 means no upper bound
+	Confirmations       int
+}
+
+// ConfirmationTiers is a list of ConfirmationTier(s) sorted by MaxAtomicDeroAmount in ascending order, with the unbounded tier (if any) last
+type ConfirmationTiers []ConfirmationTier
+
+// Confirmations returns the number of confirmations required by the first tier atomicDeroAmount falls in and whether such tier exists
+func (t ConfirmationTiers) Confirmations(atomicDeroAmount uint64) (confirmations int, ok bool) {
+	for _, tier := range t {
+		if tier.MaxAtomicDeroAmount == 0 || atomicDeroAmount < tier.MaxAtomicDeroAmount {
+			return tier.Confirmations, true
+		}
+	}
+	return 0, false
+}
+
 // PendingPayments stores a map of PendingPayment(s) associated to their payment ID,
 // a RWMutex for map synchronization
 // and a ticker needed to loop over PendingPayments every minute to check if payment was sent to the wallet.
@@ -40,6 +109,8 @@ type PendingPayments struct {
 	Map     map[string]*PendingPayment
 	Mutex   sync.RWMutex
 	Checker *time.Ticker
+
+	checking bool // Whether the ticker is running. Synchronized by Mutex
 }
 
 // NewPendingPayments returns a new PendingPayments struct
@@ -57,6 +128,67 @@ func (p *PendingPayments) Set(paymentID string, pendingPayment *PendingPayment)
 	p.Map[paymentID] = pendingPayment
 }
 
+// Add adds a new PendingPayment associated to its PaymentID to a PendingPayments struct
+// and returns whether the checker has to be started, because it is not running yet
+func (p *PendingPayments) Add(paymentID string, pendingPayment *PendingPayment) (startChecker bool) {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+
+	p.Map[paymentID] = pendingPayment
+	startChecker = !p.checking
+	p.checking = true
+	return
+}
+
+// StopCheckerIfEmpty returns whether a PendingPayments struct is empty, in which case its checker has to be stopped.
+// Since the check is atomic with Add, a checker is always running as long as there are PendingPayment(s).
+func (p *PendingPayments) StopCheckerIfEmpty() bool {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+
+	if len(p.Map) > 0 {
+		return false
+	}
+	p.checking = false
+	return true
+}
+
+// Get returns the PendingPayment associated to a PaymentID from a PendingPayments struct and whether it exists
+func (p *PendingPayments) Get(paymentID string) (pendingPayment *PendingPayment, ok bool) {
+	p.Mutex.RLock()
+	defer p.Mutex.RUnlock()
+
+	pendingPayment, ok = p.Map[paymentID]
+	return
+}
+
+// Replace replaces the PendingPayment associated to a PaymentID with an updated one and returns whether it was replaced.
+// It is not replaced if the payment is no longer pending (i.e. it was deleted) or if the current PendingPayment has a longer TTL,
+// since TTLs only grow and the current one is then the result of a later update.
+func (p *PendingPayments) Replace(paymentID string, pendingPayment *PendingPayment) bool {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+
+	current, ok := p.Map[paymentID]
+	if !ok || current.TTL > pendingPayment.TTL {
+		return false
+	}
+	p.Map[paymentID] = pendingPayment
+	return true
+}
+
+// Snapshot returns a copy of the map of a PendingPayments struct, which can be looped over while PendingPayment(s) are added or deleted
+func (p *PendingPayments) Snapshot() map[string]*PendingPayment {
+	p.Mutex.RLock()
+	defer p.Mutex.RUnlock()
+
+	snapshot := make(map[string]*PendingPayment, len(p.Map))
+	for paymentID, pendingPayment := range p.Map {
+		snapshot[paymentID] = pendingPayment
+	}
+	return snapshot
+}
+
 // Delete deletes a PendingPayment associated to a PaymentID from a PendingPayments struct
 func (p *PendingPayments) Delete(paymentID string) {
 	p.Mutex.Lock()
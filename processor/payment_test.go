@@ -0,0 +1,110 @@
+package processor
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingPaymentsReplace(t *testing.T) {
+	pps := NewPendingPayments()
+	p := NewPendingPayment(1, 0, 10, 5)
+	pps.Add("payment", p)
+
+	extended := *p
+	extended.TTL = 20
+	assert.True(t, pps.Replace("payment", &extended))
+	got, _ := pps.Get("payment")
+	assert.Equal(t, 20, got.TTL)
+
+	// An older update does not replace a later one
+	older := *p
+	older.TTL = 15
+	assert.False(t, pps.Replace("payment", &older))
+	got, _ = pps.Get("payment")
+	assert.Equal(t, 20, got.TTL)
+
+	// A payment that is no longer pending is not added back
+	pps.Delete("payment")
+	assert.False(t, pps.Replace("payment", &extended))
+	_, ok := pps.Get("payment")
+	assert.False(t, ok)
+}
+
+func TestPendingPaymentsSnapshot(t *testing.T) {
+	pps := NewPendingPayments()
+	for i := 0; i < 10; i++ {
+		pps.Add(fmt.Sprint("payment ", i), NewPendingPayment(1, 0, 60, 5))
+	}
+
+	snapshot := pps.Snapshot()
+	assert.Len(t, snapshot, 10)
+
+	// Looping over a snapshot while PendingPayment(s) are added and deleted does not race
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			pps.Delete(fmt.Sprint("payment ", i))
+			pps.Add(fmt.Sprint("new payment ", i), NewPendingPayment(1, 0, 60, 5))
+		}
+	}()
+	for paymentID := range pps.Snapshot() {
+		pps.Get(paymentID)
+	}
+	wg.Wait()
+
+	assert.Len(t, snapshot, 10) // Snapshot is not affected by later changes
+	assert.Equal(t, 10, pps.Count())
+}
+
+func TestRequiredConfirmations(t *testing.T) {
+	tiers := ConfirmationTiers{
+		{MaxAtomicDeroAmount: 10000000000000, Confirmations: 2},    // < 10 DERO
+		{MaxAtomicDeroAmount: 1000000000000000, Confirmations: 10}, // < 1000 DERO
+		{MaxAtomicDeroAmount: 0, Confirmations: 30},                // >= 1000 DERO
+	}
+
+	tests := []struct {
+		AtomicDeroAmount  uint64
+		ConfirmationTiers ConfirmationTiers
+		Expected          int
+	}{
+		{AtomicDeroAmount: 1, ConfirmationTiers: tiers, Expected: 2},
+		{AtomicDeroAmount: 9999999999999, ConfirmationTiers: tiers, Expected: 2},
+		{AtomicDeroAmount: 10000000000000, ConfirmationTiers: tiers, Expected: 10},
+		{AtomicDeroAmount: 1000000000000000, ConfirmationTiers: tiers, Expected: 30},
+		{AtomicDeroAmount: 1000000000000000, ConfirmationTiers: tiers[:2], Expected: 5}, // No tier applies
+		{AtomicDeroAmount: 1, ConfirmationTiers: nil, Expected: 5},
+	}
+
+	for _, test := range tests {
+		p := NewPendingPayment(test.AtomicDeroAmount, 0, 60, 5)
+		p.ConfirmationTiers = test.ConfirmationTiers
+		assert.Equal(t, test.Expected, p.RequiredConfirmations())
+	}
+}
+
+func TestPendingPaymentsChecker(t *testing.T) {
+	pps := NewPendingPayments()
+	p := NewPendingPayment(1, 0, 60, 5)
+
+	assert.True(t, pps.StopCheckerIfEmpty())
+	assert.True(t, pps.Add("payment 1", p))  // Checker not running yet
+	assert.False(t, pps.Add("payment 2", p)) // Checker already running
+	assert.False(t, pps.StopCheckerIfEmpty())
+
+	got, ok := pps.Get("payment 1")
+	assert.True(t, ok)
+	assert.Equal(t, p, got)
+
+	pps.Delete("payment 1")
+	pps.Delete("payment 2")
+	_, ok = pps.Get("payment 1")
+	assert.False(t, ok)
+	assert.True(t, pps.StopCheckerIfEmpty())
+	assert.True(t, pps.Add("payment 3", p)) // Checker stopped, has to be started again
+}
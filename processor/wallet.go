@@ -12,7 +12,7 @@ import (
 	derowallet "github.com/deroproject/derosuite/walletapi"
 
 	"github.com/peppinux/dero-merchant/config"
-	"github.com/peppinux/dero-merchant/postgres"
+	"github.com/peppinux/dero-merchant/repository"
 	"github.com/peppinux/dero-merchant/stringutil"
 )
 
@@ -33,16 +33,20 @@ func CreateWalletsDirectory() error {
 
 // StoreWallet represents the wallet of a store.
 // It holds an istance to the actual Dero wallet,
-// a list of payments the wallet is supposed to receive
-// and the info of the webhook (if set) where payment update events will be sent to
+// a list of payments the wallet is supposed to receive,
+// the info of the webhook (if set) where payment update events will be sent to
+// and the repositories the status of payments and of the invoices they were created for is updated in
 type StoreWallet struct {
+	StoreID         int
 	DeroWallet      *derowallet.Wallet
 	PendingPayments *PendingPayments
 	Webhook         Webhook
+	Payments        repository.PaymentRepository
+	Invoices        repository.InvoiceRepository
 }
 
 // NewStoreWallet returns a new StoreWallet struct
-func NewStoreWallet(filename string, viewKey string, webhook Webhook) (w *StoreWallet, err error) {
+func NewStoreWallet(storeID int, filename string, viewKey string, webhook Webhook, payments repository.PaymentRepository, invoices repository.InvoiceRepository) (w *StoreWallet, err error) {
 	// Generate random password for wallet file encryption
 	password, err := stringutil.RandomHexString(32)
 	if err != nil {
@@ -61,9 +65,12 @@ func NewStoreWallet(filename string, viewKey string, webhook Webhook) (w *StoreW
 	dw.SetDaemonAddress(config.DeroDaemonAddress)
 
 	w = &StoreWallet{
+		StoreID:         storeID,
 		DeroWallet:      dw,
 		PendingPayments: NewPendingPayments(),
 		Webhook:         webhook,
+		Payments:        payments,
+		Invoices:        invoices,
 	}
 
 	return
@@ -78,24 +85,23 @@ func (w *StoreWallet) GenerateIntegratedAddress() (integratedAddress string, pay
 }
 
 // AddPendingPayment adds a new pending payment the store wallet expects to receive
-func (w *StoreWallet) AddPendingPayment(paymentID string, atomicDeroAmount uint64) error {
+func (w *StoreWallet) AddPendingPayment(paymentID string, p *PendingPayment) error {
 	err := w.DeroWallet.IsDaemonOnline()
 	if err != nil {
 		return errors.Wrap(err, "daemon offline")
 	}
 
-	p := NewPendingPayment(atomicDeroAmount)
-	w.PendingPayments.Set(paymentID, p)
-
-	paymentsCount := w.PendingPayments.Count()
+	startChecker := w.PendingPayments.Add(paymentID, p)
 
 	fmt.Println("DEBUG: Payment ID", paymentID, "added to PendingPayments map.")
-	fmt.Println("DEBUG: Map length:", paymentsCount)
+	fmt.Println("DEBUG: Map length:", w.PendingPayments.Count())
 
 	// Make sure store wallet actively checks for new payments every minute
-	if paymentsCount == 1 { // If no payments previous than this one are pending, start checking for payments
+	if startChecker { // If wallet is not already checking for payments previous than this one, start checking
 		err := w.StartCheckingForPayments()
 		if err != nil {
+			w.PendingPayments.Delete(paymentID)
+			w.PendingPayments.StopCheckerIfEmpty()
 			return errors.Wrap(err, "cannot start checking for payments")
 		}
 
@@ -154,22 +160,30 @@ func (w *StoreWallet) CheckForPayments() {
 		fmt.Println("DEBUG: WALLET H/TH", w.DeroWallet.Get_Height(), w.DeroWallet.Get_TopoHeight())
 		fmt.Println("DEBUG: DAEMON H/TH", w.DeroWallet.Get_Daemon_Height(), w.DeroWallet.Get_Daemon_TopoHeight())
 
-		for paymentID, payment := range w.PendingPayments.Map {
+		for paymentID := range w.PendingPayments.Snapshot() {
 			fmt.Println("DEBUG: In loop Payment ID", paymentID)
 
+			// Get the current PendingPayment, since it may have been cancelled or extended after the snapshot was taken
+			payment, ok := w.PendingPayments.Get(paymentID)
+			if !ok {
+				continue
+			}
+
 			var (
 				receivedAmount uint64
 				confirmations  uint64
 				notConfirmed   bool
+				txIDs          []string
 			)
 
 			payid, _ := hex.DecodeString(paymentID)
 			entries := w.DeroWallet.Get_Payments_Payment_ID(payid, 0)
 			for _, e := range entries {
 				receivedAmount += e.Amount
+				txIDs = append(txIDs, e.TXID)
 
 				confirmations = w.DeroWallet.Get_Daemon_Height() - e.Height
-				if confirmations < uint64(config.PaymentMinConfirmations) {
+				if confirmations < uint64(payment.RequiredConfirmations()) {
 					notConfirmed = true
 				}
 			}
@@ -179,11 +193,11 @@ func (w *StoreWallet) CheckForPayments() {
 			}
 
 			var newStatus string
-			if receivedAmount >= payment.AtomicDeroAmount { // Wallet received payment
+			if receivedAmount >= payment.MinAtomicDeroAmount { // Wallet received payment (within the underpayment tolerance of the store)
 				newStatus = PaymentStatusPaid
 			} else {
 				minsFromCreation := payment.MinutesFromCreation()
-				if minsFromCreation > float64(config.PaymentMaxTTL) { // Wallet did not receive payment in time (ORDER_MAX_TTL env variable)
+				if minsFromCreation > float64(payment.TTL) { // Wallet did not receive payment in time
 					heightDifference := w.DeroWallet.Get_Daemon_Height() - w.DeroWallet.Get_Height()
 
 					// Make sure wallet is synced with daemon with a tolerance of 20 blocks.
@@ -195,33 +209,17 @@ func (w *StoreWallet) CheckForPayments() {
 			}
 
 			if newStatus != "" { // Payment status changed
-				// Update Payment in DB (Set new status)
-				_, err := postgres.DB.Exec(`
-					UPDATE payments 
-					SET status=$1 
-					WHERE payment_id=$2 AND status=$3`, newStatus, paymentID, PaymentStatusPending)
-				if err != nil {
-					log.Println("Error executing query:", err)
+				err := w.finalizePendingPayment(paymentID, newStatus, StatusCauseProcessor, txIDs, payment)
+				if err != nil && err != ErrPaymentNotPending {
+					log.Println("Error finalizing pending payment:", err)
 					continue
 				}
-
-				// Send payment status update event to store webhook endpoint if set
-				if w.Webhook.IsSet() {
-					go w.Webhook.SendPaymentUpdateEvent(paymentID, newStatus)
-				}
-
-				// Send payment's new status to WebSockets clients (used to update payment status of customer helper page /pay/:payment_id)
-				go PaymentWSConnections.SendStatusUpdate(paymentID, newStatus)
-
-				// Delete payment from pending payments since it has either been paid or expired
-				w.PendingPayments.Delete(paymentID)
 				fmt.Println("DEBUG: Payment removed from map. New status:", newStatus)
 			}
 		}
 
-		count := w.PendingPayments.Count()
-		fmt.Println("DEBUG: PendingPayments map length:", count)
-		if count == 0 { // If there are no more pending payments to check the wallet for, clean wallet file and stop the ticker
+		fmt.Println("DEBUG: PendingPayments map length:", w.PendingPayments.Count())
+		if w.PendingPayments.StopCheckerIfEmpty() { // If there are no more pending payments to check the wallet for, clean wallet file and stop the ticker
 			w.StopCheckingForPayments()
 			fmt.Println("DEBUG: PendingPayments: 0. Therefore, stopped ticker and cleaned wallet.")
 			return
@@ -229,42 +227,119 @@ func (w *StoreWallet) CheckForPayments() {
 	}
 }
 
+// ErrPaymentNotPending is returned when the status of a payment that is no longer pending is about to be changed
+var ErrPaymentNotPending = errors.New("payment not pending")
+
+// finalizePendingPayment updates the status of a pending payment in DB, stops listening to it
+// and notifies the webhook of the store and WebSockets clients of the new status.
+// txIDs are the IDs of the transactions the payment was received in (if any), stored along with the paid time for receipts.
+// The status change is recorded in the status history of the payment along with its cause.
+// It returns ErrPaymentNotPending if the status of the payment was already changed in the meantime (e.g. payment was cancelled).
+func (w *StoreWallet) finalizePendingPayment(paymentID, newStatus, cause string, txIDs []string, payment *PendingPayment) error {
+	// Update Payment in DB (Set new status, transaction IDs and paid time) and record the status change
+	err := w.Payments.ChangeStatus(paymentID, PaymentStatusPending, newStatus, cause, txIDs, newStatus == PaymentStatusPaid)
+	if err != nil && err != repository.ErrUnexpectedStatus {
+		return errors.Wrap(err, "cannot change payment status")
+	}
+
+	// Delete payment from pending payments since it has either been paid, expired or cancelled
+	w.PendingPayments.Delete(paymentID)
+
+	if err == repository.ErrUnexpectedStatus {
+		return ErrPaymentNotPending
+	}
+
+	if newStatus == PaymentStatusPaid {
+		err = w.markInvoicePaid(paymentID)
+		if err != nil { // Payment is paid regardless, so notify the store anyway
+			log.Println("Error marking invoice as paid:", err)
+		}
+	}
+
+	// Send payment status update event to store webhook endpoint if set
+	if w.Webhook.IsSet() {
+		go w.Webhook.SendPaymentUpdateEvent(w.Payments, paymentID, newStatus, payment.PaymentMetadata)
+	}
+
+	// Send payment's new status to WebSockets clients (used to update payment status of customer helper page /pay/:payment_id)
+	go PaymentWSConnections.SendStatusUpdate(paymentID, newStatus)
+
+	// Send status change event to dashboard sessions of the store
+	go StoreWSConnections.SendEvent(w.StoreID, &DashboardEvent{
+		Type:      EventPaymentStatusChanged,
+		PaymentID: paymentID,
+		Status:    newStatus,
+		OldStatus: PaymentStatusPending,
+	})
+
+	return nil
+}
+
+// CancelPendingPayment cancels a payment the store wallet is listening to, so that it is no longer checked for.
+// cause is recorded in the status history of the payment.
+func (w *StoreWallet) CancelPendingPayment(paymentID, cause string) error {
+	payment, ok := w.PendingPayments.Get(paymentID)
+	if !ok {
+		return ErrPaymentNotPending
+	}
+
+	return w.finalizePendingPayment(paymentID, PaymentStatusCancelled, cause, nil, payment)
+}
+
 // CleanAllPendingPayments updates the status of all pending payments to "error".
 // This function is supposed to be called only when the application is started (useful after a crash) or gets shut down.
-func CleanAllPendingPayments() error {
-	for _, w := range ActiveWallets.Map {
-		if w.PendingPayments.Count() > 0 {
-			w.StopCheckingForPayments()
-			for payid := range w.PendingPayments.Map {
-				if w.Webhook.IsSet() {
-					w.Webhook.SendPaymentUpdateEvent(payid, PaymentStatusError)
+func (w *StoresWallets) CleanAllPendingPayments() error {
+	w.Mutex.RLock()
+	wallets := make([]*StoreWallet, 0, len(w.Map))
+	for _, sw := range w.Map {
+		wallets = append(wallets, sw)
+	}
+	w.Mutex.RUnlock()
+
+	for _, sw := range wallets {
+		if sw.PendingPayments.Count() > 0 {
+			sw.StopCheckingForPayments()
+			for payid, p := range sw.PendingPayments.Snapshot() {
+				if sw.Webhook.IsSet() {
+					sw.Webhook.SendPaymentUpdateEvent(w.Payments, payid, PaymentStatusError, p.PaymentMetadata)
 				}
 
 				PaymentWSConnections.SendStatusUpdate(payid, PaymentStatusError)
+				StoreWSConnections.SendEvent(sw.StoreID, &DashboardEvent{
+					Type:      EventPaymentStatusChanged,
+					PaymentID: payid,
+					Status:    PaymentStatusError,
+					OldStatus: PaymentStatusPending,
+				})
 			}
 		}
 	}
-	_, err := postgres.DB.Exec(`
-		UPDATE payments
-		SET status=$1
-		WHERE status=$2`, PaymentStatusError, PaymentStatusPending)
+
+	err := w.Payments.ChangeAllStatuses(PaymentStatusPending, PaymentStatusError, StatusCauseRestartCleanup)
 	if err != nil {
-		return errors.Wrap(err, "cannot execute query")
+		return errors.Wrap(err, "cannot change payments status")
 	}
 
 	return nil
 }
 
-// StoresWallets stores a map of StoreWallet(s) to their store ID and a RWMutex for map synchronization
+// StoresWallets stores a map of StoreWallet(s) to their store ID and a RWMutex for map synchronization,
+// along with the repositories wallets are created from and update payments in
 type StoresWallets struct {
-	Map   map[int]*StoreWallet
-	Mutex sync.RWMutex
+	Map      map[int]*StoreWallet
+	Mutex    sync.RWMutex
+	Stores   repository.StoreRepository
+	Payments repository.PaymentRepository
+	Invoices repository.InvoiceRepository
 }
 
 // NewStoresWallets returns a new StoresWallets struct
-func NewStoresWallets() *StoresWallets {
+func NewStoresWallets(stores repository.StoreRepository, payments repository.PaymentRepository, invoices repository.InvoiceRepository) *StoresWallets {
 	return &StoresWallets{
-		Map: make(map[int]*StoreWallet),
+		Map:      make(map[int]*StoreWallet),
+		Stores:   stores,
+		Payments: payments,
+		Invoices: invoices,
 	}
 }
 
@@ -298,22 +373,18 @@ func (w *StoresWallets) CreateWalletFromStoreID(storeID int) error {
 	defer w.Mutex.Unlock()
 
 	// Fetch Wallet View Key and Webhook data from DB
-	var (
-		viewKey string
-		webhook Webhook
-	)
-	err := postgres.DB.QueryRow(`
-		SELECT wallet_view_key, webhook, webhook_secret_key 
-		FROM stores 
-		WHERE id=$1`, storeID).
-		Scan(&viewKey, &webhook.URL, &webhook.SecretKey)
+	s, err := w.Stores.ByID(storeID)
 	if err != nil {
-		return errors.Wrap(err, "cannot query database")
+		return errors.Wrap(err, "cannot fetch store")
+	}
+	webhook := Webhook{
+		URL:       s.Webhook,
+		SecretKey: s.WebhookSecretKey,
 	}
 
 	// Create store wallet
 	filename := fmt.Sprintf("%sstore_%d.wallet", config.WalletsPath, storeID)
-	storeWallet, err := NewStoreWallet(filename, viewKey, webhook)
+	storeWallet, err := NewStoreWallet(storeID, filename, s.WalletViewKey, webhook, w.Payments, w.Invoices)
 	if err != nil {
 		return errors.Wrap(err, "cannot create new store wallet")
 	}
@@ -9,12 +9,12 @@ import (
 	"time"
 
 	"github.com/alexedwards/argon2id"
-	_ "github.com/lib/pq"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/config"
-	"github.com/peppinux/dero-merchant/postgres"
-	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/repository"
 	"github.com/peppinux/dero-merchant/stringutil"
 )
 
@@ -58,6 +58,7 @@ type WalletTestSuite struct {
 	mockUsers    []*UserMock
 	mockStores   []*StoreMock
 	mockPayments []*PaymentMock
+	repos        *repository.Repositories
 }
 
 func (suite *WalletTestSuite) SetupSuite() {
@@ -66,28 +67,13 @@ func (suite *WalletTestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	redis.Pool = redis.NewPool(config.TestRedisAddress)
-	err = redis.Ping()
-	if err != nil {
-		panic(err)
-	}
-
-	err = redis.FlushAll()
-	if err != nil {
-		panic(err)
-	}
+	cache.Default = cache.NewMemoryCache()
 
-	postgres.DB, err = postgres.Connect(config.TestDBName, config.TestDBUser, config.TestDBPassword, config.TestDBHost, config.TestDBPort, "disable") // TODO: Gestire SSLMode
-	if err != nil {
-		panic(err)
-	}
-
-	postgres.DropTables()
-	postgres.CreateTablesIfNotExist()
+	suite.repos = repository.NewMemory()
 
 	config.DeroNetwork = config.TestDeroNetwork
 	config.DeroDaemonAddress = config.TestDeroDaemonAddress
-	ActiveWallets = NewStoresWallets()
+	ActiveWallets = NewStoresWallets(suite.repos.Stores, suite.repos.Payments, suite.repos.Invoices)
 	err = SetupDaemonConnection()
 	if err != nil {
 		panic(err)
@@ -124,11 +110,14 @@ func (suite *WalletTestSuite) SetupSuite() {
 			panic(err)
 		}
 
-		err = postgres.DB.QueryRow(`
-			INSERT INTO users (username, email, password, verification_token, email_verified)
-			VALUES ($1, $2, $3, $4, $5)
-			RETURNING id`, u.Username, u.Email, u.HashedPassword, u.VerificationToken, u.Verified).
-			Scan(&u.ID)
+		user := &repository.User{Username: u.Username, Email: u.Email, Password: u.HashedPassword}
+		err = suite.repos.Users.Insert(user, u.VerificationToken)
+		if err != nil {
+			panic(err)
+		}
+		u.ID = user.ID
+
+		err = suite.repos.Users.VerifyEmail(u.ID)
 		if err != nil {
 			panic(err)
 		}
@@ -171,14 +160,12 @@ func (suite *WalletTestSuite) SetupSuite() {
 			panic(err)
 		}
 
-		err = postgres.DB.QueryRow(`
-			INSERT INTO stores (title, wallet_view_key, webhook, webhook_secret_key, api_key, secret_key, owner_id)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
-			RETURNING id`, s.Title, s.ViewKey, s.Webhook, s.WebhookSecretKey, s.APIKey, s.SecretKey, s.OwnerID).
-			Scan(&s.ID)
+		store := &repository.Store{Title: s.Title, WalletViewKey: s.ViewKey, Webhook: s.Webhook, WebhookSecretKey: s.WebhookSecretKey, APIKey: s.APIKey, SecretKey: s.SecretKey, OwnerID: s.OwnerID}
+		err = suite.repos.Stores.Insert(store)
 		if err != nil {
 			panic(err)
 		}
+		s.ID = store.ID
 	}
 
 	suite.mockPayments = append(suite.mockPayments, &PaymentMock{
@@ -229,24 +216,29 @@ func (suite *WalletTestSuite) SetupSuite() {
 
 		p.IntegratedAddress, p.PaymentID = w.GenerateIntegratedAddress()
 
-		err = postgres.DB.QueryRow(`
-			INSERT INTO payments (payment_id, status, currency, currency_amount, exchange_rate, dero_amount, atomic_dero_amount, integrated_address, store_id) 
-			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) 
-			RETURNING creation_time`, p.PaymentID, p.Status, p.Currency, p.CurrencyAmount, p.ExchangeRate, p.DeroAmount, p.AtomicDeroAmount, p.IntegratedAddress, p.StoreID).
-			Scan(&p.CreationTime)
+		payment := &repository.Payment{
+			PaymentID:             p.PaymentID,
+			Status:                p.Status,
+			Currency:              p.Currency,
+			CurrencyAmount:        decimal.NewFromFloat(p.CurrencyAmount),
+			ExchangeRate:          decimal.NewFromFloat(p.ExchangeRate),
+			DeroAmount:            p.DeroAmount,
+			AtomicDeroAmount:      p.AtomicDeroAmount,
+			IntegratedAddress:     p.IntegratedAddress,
+			MaxTTL:                config.PaymentMaxTTL,
+			MinConfirmations:      config.PaymentMinConfirmations,
+			RequiredConfirmations: config.PaymentMinConfirmations,
+			StoreID:               p.StoreID,
+		}
+		err = suite.repos.Payments.Insert(payment, StatusCauseCreated)
 		if err != nil {
 			panic(err)
 		}
+		p.CreationTime = payment.CreationTime
 	}
 }
 
 func (suite *WalletTestSuite) TearDownSuite() {
-	redis.FlushAll()
-	redis.Pool.Close()
-
-	postgres.DropTables()
-	postgres.DB.Close()
-
 	os.RemoveAll(config.TestWalletsPath)
 }
 
@@ -254,6 +246,52 @@ func TestWalletTestSuite(t *testing.T) {
 	suite.Run(t, new(WalletTestSuite))
 }
 
+func (suite *WalletTestSuite) TestMarkInvoicePaid() {
+	storeID := suite.mockStores[0].ID
+	w, err := ActiveWallets.GetWalletFromStoreID(storeID)
+	suite.Nil(err)
+
+	for _, status := range []string{InvoiceStatusOpen, InvoiceStatusVoid} {
+		invoiceID, _ := stringutil.RandomHexString(32)
+		i := &repository.Invoice{InvoiceID: invoiceID, Status: status, Currency: "DERO", CustomerName: "Customer", LineItems: []byte(`[]`),
+			Subtotal: decimal.NewFromInt(1), Total: decimal.NewFromInt(1), DueDate: time.Now().UTC().Format(repository.InvoiceDateLayout), StoreID: storeID}
+		suite.Nil(suite.repos.Invoices.Insert(i))
+
+		iaddr, paymentID := w.GenerateIntegratedAddress()
+		err = suite.repos.Payments.Insert(&repository.Payment{
+			PaymentID:             paymentID,
+			Status:                PaymentStatusPaid,
+			Currency:              "DERO",
+			CurrencyAmount:        decimal.NewFromInt(1),
+			ExchangeRate:          decimal.NewFromInt(1),
+			DeroAmount:            "1.000000000000",
+			AtomicDeroAmount:      1000000000000,
+			IntegratedAddress:     iaddr,
+			MaxTTL:                config.PaymentMaxTTL,
+			MinConfirmations:      config.PaymentMinConfirmations,
+			RequiredConfirmations: config.PaymentMinConfirmations,
+			InvoiceID:             i.ID,
+			StoreID:               storeID,
+		}, StatusCauseCreated)
+		suite.Nil(err)
+
+		suite.Nil(w.markInvoicePaid(paymentID))
+
+		fetched, err := suite.repos.Invoices.ByInvoiceID(invoiceID)
+		suite.Nil(err)
+		if status == InvoiceStatusOpen {
+			suite.Equal(InvoiceStatusPaid, fetched.Status)
+			suite.Equal(paymentID, fetched.PaymentID)
+		} else { // Only open invoices can be marked as paid
+			suite.Equal(status, fetched.Status)
+			suite.Empty(fetched.PaymentID)
+		}
+	}
+
+	// Payments not created for an invoice are ignored
+	suite.Nil(w.markInvoicePaid(suite.mockPayments[0].PaymentID))
+}
+
 /*func (suite *WalletTestSuite) TestPaymentProcessor() {
 	fmt.Println("This part of testing requires manual intervention.")
 	fmt.Println("Execute the following actions to continue:")
@@ -267,7 +305,7 @@ func TestWalletTestSuite(t *testing.T) {
 		w, err := ActiveWallets.GetWalletFromStoreID(p.StoreID)
 		suite.Nil(err)
 
-		err = w.AddPendingPayment(p.PaymentID, p.AtomicDeroAmount)
+		err = w.AddPendingPayment(p.PaymentID, NewPendingPayment(p.AtomicDeroAmount, 0, config.PaymentMaxTTL, config.PaymentMinConfirmations))
 		suite.Nil(err)
 	}
 
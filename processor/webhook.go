@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/peppinux/dero-merchant/cryptoutil"
+	"github.com/peppinux/dero-merchant/repository"
 )
 
 // Webhook is a type that contains the URL and Secret Key of the Webhook of a store, and whose main purpose is to send events to said URL
@@ -22,6 +24,16 @@ type Webhook struct {
 type PaymentUpdateEvent struct {
 	PaymentID string `json:"paymentID,omitempty"`
 	Status    string `json:"status,omitempty"`
+	PaymentMetadata
+}
+
+// SubscriptionEvent is the event sent to the Webhook URL when a subscription is billed or its status changes
+type SubscriptionEvent struct {
+	SubscriptionID string `json:"subscriptionID"`
+	Status         string `json:"status"`
+	// InvoiceID and PayURL are set when the subscription is billed
+	InvoiceID string `json:"invoiceID,omitempty"`
+	PayURL    string `json:"payURL,omitempty"`
 }
 
 // IsSet returns whether valid Webhook URL and Secret Key are set in the struct
@@ -29,42 +41,91 @@ func (w *Webhook) IsSet() bool {
 	return w.URL != "" && w.SecretKey != ""
 }
 
-// SendPaymentUpdateEvent sends a signed PaymentUpdateEvent to the Webhook URL
-func (w *Webhook) SendPaymentUpdateEvent(paymentID string, newStatus string) error {
+// SendPaymentUpdateEvent sends a signed PaymentUpdateEvent to the Webhook URL.
+// The delivery is recorded in payments.
+func (w *Webhook) SendPaymentUpdateEvent(payments repository.PaymentRepository, paymentID string, newStatus string, metadata PaymentMetadata) error {
 	e := &PaymentUpdateEvent{
-		PaymentID: paymentID,
-		Status:    newStatus,
+		PaymentID:       paymentID,
+		Status:          newStatus,
+		PaymentMetadata: metadata,
+	}
+
+	statusCode, err := w.send(e)
+
+	// Record the delivery, so that it can be inspected from the dashboard
+	errRecord := recordWebhookDelivery(payments, paymentID, newStatus, statusCode, err)
+	if errRecord != nil {
+		log.Println("Error recording webhook delivery:", errRecord)
 	}
 
+	return err
+}
+
+// SendSubscriptionEvent sends a signed SubscriptionEvent to the Webhook URL
+func (w *Webhook) SendSubscriptionEvent(e *SubscriptionEvent) error {
+	_, err := w.send(e)
+	return err
+}
+
+// send sends an event, marshalled to JSON and signed with the Webhook Secret Key, to the Webhook URL.
+// It returns the HTTP status code of the response (0 if no response was received) and an error if the event was not delivered with a 2xx response.
+func (w *Webhook) send(e interface{}) (statusCode int, err error) {
 	body, err := json.Marshal(e)
 	if err != nil {
-		return errors.Wrap(err, "cannot marshal event")
+		return 0, errors.Wrap(err, "cannot marshal event")
 	}
 
 	secretKeyBytes, err := hex.DecodeString(w.SecretKey)
 	if err != nil {
-		return errors.Wrap(err, "cannot decode hex string")
+		return 0, errors.Wrap(err, "cannot decode hex string")
 	}
 
 	bodySignature, err := cryptoutil.SignMessage(body, secretKeyBytes)
 	if err != nil {
-		return errors.Wrap(err, "cannot sign message")
+		return 0, errors.Wrap(err, "cannot sign message")
 	}
 
 	bodySignatureHex := hex.EncodeToString(bodySignature)
 
 	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewBuffer(body))
 	if err != nil {
-		return errors.Wrap(err, "cannot create new request")
+		return 0, errors.Wrap(err, "cannot create new request")
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Signature", bodySignatureHex)
 
 	httpClient := &http.Client{
-		Timeout: time.Second, // Don't need a response
+		Timeout: time.Second, // Only the status code of the response is needed
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot send request")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// maxDeliveryErrorLength is the max number of characters of the error of a webhook delivery stored in DB
+const maxDeliveryErrorLength = 512
+
+// recordWebhookDelivery stores in payments the outcome of the delivery of the status of a payment to the webhook of its store
+func recordWebhookDelivery(payments repository.PaymentRepository, paymentID, status string, statusCode int, deliveryErr error) error {
+	d := &repository.WebhookDelivery{
+		Status:         status,
+		ResponseStatus: statusCode,
+	}
+	if deliveryErr != nil {
+		d.Error = deliveryErr.Error()
+		if r := []rune(d.Error); len(r) > maxDeliveryErrorLength {
+			d.Error = string(r[:maxDeliveryErrorLength])
+		}
 	}
-	_, err = httpClient.Do(req)
 
-	return nil
+	return payments.AddWebhookDelivery(paymentID, d)
 }
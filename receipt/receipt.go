@@ -0,0 +1,230 @@
+package receipt
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pkg/errors"
+)
+
+// MaxFooterLength is the max length of the footer of the receipts of a store
+const MaxFooterLength = 512 // Chars
+
+// DefaultColor is the color of the header of receipts of stores that did not set a brand color
+const DefaultColor = "#2b3e5a"
+
+var colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Branding represents the optional branding a store applies to the receipts of its payments
+type Branding struct {
+	// Color is the hex RGB color (e.g. #ff0000) of the header of receipts. Empty for DefaultColor
+	Color string `json:"color"`
+	// Footer is printed at the bottom of receipts (e.g. business name, address and VAT number)
+	Footer string `json:"footer"`
+}
+
+// Branding validation errors
+var (
+	ErrInvalidColor  = errors.New("Invalid receipt color: required hex RGB color (e.g. #2b3e5a)")
+	ErrInvalidFooter = errors.New("Invalid receipt footer: required max 512 chars long string")
+)
+
+// Validate trims the fields of Branding and returns an error if any of them is invalid
+func (b *Branding) Validate() error {
+	b.Color = strings.ToLower(strings.TrimSpace(b.Color))
+	if b.Color != "" && !colorRegexp.MatchString(b.Color) {
+		return ErrInvalidColor
+	}
+
+	b.Footer = strings.TrimSpace(b.Footer)
+	if utf8.RuneCountInString(b.Footer) > MaxFooterLength {
+		return ErrInvalidFooter
+	}
+
+	return nil
+}
+
+// rgb returns the red, green and blue components of the color of Branding
+func (b *Branding) rgb() (r, g, bl int) {
+	color := b.Color
+	if !colorRegexp.MatchString(color) {
+		color = DefaultColor
+	}
+
+	v, _ := strconv.ParseUint(color[1:], 16, 32)
+	return int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)
+}
+
+// Receipt represents the receipt of a paid payment
+type Receipt struct {
+	StoreTitle        string
+	PaymentID         string
+	OrderID           string
+	Description       string
+	Currency          string
+	CurrencyAmount    string
+	ExchangeRate      string // Value of 1 DERO in Currency. Not printed if Currency is DERO
+	DeroAmount        string
+	IntegratedAddress string
+	TxIDs             []string
+	CreationTime      time.Time
+	PaidTime          time.Time // Zero if unknown (payments paid before paid times were tracked)
+	Branding          Branding
+}
+
+const timeLayout = "2006-01-02 15:04:05 UTC"
+
+// Render writes Receipt to w as an A4 PDF document
+func (r *Receipt) Render(w io.Writer) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("") // Core fonts are cp1252 encoded
+
+	pdf.SetTitle("Receipt "+r.PaymentID, true)
+	pdf.SetAuthor(r.StoreTitle, true)
+	pdf.SetCreator("DERO Merchant", true)
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.AddPage()
+
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	width := pageWidth - left - right
+	labelWidth := 45.0
+
+	// Header
+	red, green, blue := r.Branding.rgb()
+	pdf.SetFillColor(red, green, blue)
+	pdf.Rect(0, 0, pageWidth, 32, "F")
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetY(11)
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(width*0.7, 10, tr(r.StoreTitle), "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 14)
+	pdf.CellFormat(width*0.3, 10, "RECEIPT", "", 1, "R", false, 0, "")
+	pdf.SetY(42)
+
+	section := func(title string) {
+		pdf.SetTextColor(red, green, blue)
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.CellFormat(width, 8, title, "B", 1, "L", false, 0, "")
+		pdf.Ln(2)
+	}
+	row := func(label, value string, mono bool) {
+		pdf.SetTextColor(110, 110, 110)
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(labelWidth, 6, label, "", 0, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		if mono {
+			pdf.SetFont("Courier", "", 9)
+		}
+		pdf.MultiCell(width-labelWidth, 6, tr(value), "", "L", false)
+	}
+
+	// Payment details
+	section("Payment")
+	row("Payment ID", r.PaymentID, true)
+	if r.OrderID != "" {
+		row("Order ID", r.OrderID, false)
+	}
+	if r.Description != "" {
+		row("Description", r.Description, false)
+	}
+	row("Status", "Paid", false)
+	row("Created", r.CreationTime.UTC().Format(timeLayout), false)
+	if !r.PaidTime.IsZero() {
+		row("Paid", r.PaidTime.UTC().Format(timeLayout), false)
+	}
+	pdf.Ln(4)
+
+	// Amounts
+	section("Amount")
+	row("Total", r.CurrencyAmount+" "+r.Currency, false)
+	if r.Currency != "DERO" {
+		row("Exchange rate", "1 DERO = "+r.ExchangeRate+" "+r.Currency, false)
+	}
+	row("Amount due", r.DeroAmount+" DERO", false)
+	pdf.Ln(4)
+
+	// Blockchain details
+	section("Transaction")
+	row("Integrated address", r.IntegratedAddress, true)
+	txIDs := strings.Join(r.TxIDs, "\n")
+	if txIDs == "" {
+		txIDs = "-"
+	}
+	row("Transaction IDs", txIDs, true)
+	pdf.Ln(8)
+
+	// Footer
+	if r.Branding.Footer != "" {
+		pdf.SetTextColor(80, 80, 80)
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.MultiCell(width, 5, tr(r.Branding.Footer), "T", "L", false)
+		pdf.Ln(2)
+	}
+	pdf.SetTextColor(150, 150, 150)
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.CellFormat(width, 5, "Payment processed by DERO Merchant", "", 1, "L", false, 0, "")
+
+	err := pdf.Output(w)
+	if err != nil {
+		return errors.Wrap(err, "cannot render PDF")
+	}
+	return nil
+}
+
+// Text returns Receipt as plain text, with the same content as the PDF document
+func (r *Receipt) Text() string {
+	var b strings.Builder
+
+	row := func(label, value string) {
+		b.WriteString(label)
+		b.WriteString(": ")
+		b.WriteString(value)
+		b.WriteString("\n")
+	}
+
+	b.WriteString(r.StoreTitle)
+	b.WriteString(" - RECEIPT\n\n")
+
+	row("Payment ID", r.PaymentID)
+	if r.OrderID != "" {
+		row("Order ID", r.OrderID)
+	}
+	if r.Description != "" {
+		row("Description", r.Description)
+	}
+	row("Status", "Paid")
+	row("Created", r.CreationTime.UTC().Format(timeLayout))
+	if !r.PaidTime.IsZero() {
+		row("Paid", r.PaidTime.UTC().Format(timeLayout))
+	}
+	b.WriteString("\n")
+
+	row("Total", r.CurrencyAmount+" "+r.Currency)
+	if r.Currency != "DERO" {
+		row("Exchange rate", "1 DERO = "+r.ExchangeRate+" "+r.Currency)
+	}
+	row("Amount due", r.DeroAmount+" DERO")
+	b.WriteString("\n")
+
+	row("Integrated address", r.IntegratedAddress)
+	txIDs := strings.Join(r.TxIDs, ", ")
+	if txIDs == "" {
+		txIDs = "-"
+	}
+	row("Transaction IDs", txIDs)
+
+	if r.Branding.Footer != "" {
+		b.WriteString("\n")
+		b.WriteString(r.Branding.Footer)
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
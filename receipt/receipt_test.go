@@ -0,0 +1,108 @@
+package receipt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrandingValidate(t *testing.T) {
+	tests := []struct {
+		Branding Branding
+		Expected error
+	}{
+		{Branding{}, nil},
+		{Branding{Color: " #FF00aa ", Footer: " ACME Inc. - VAT 123 "}, nil},
+		{Branding{Color: "#fff"}, ErrInvalidColor},
+		{Branding{Color: "red"}, ErrInvalidColor},
+		{Branding{Footer: strings.Repeat("a", MaxFooterLength+1)}, ErrInvalidFooter},
+	}
+
+	for _, test := range tests {
+		b := test.Branding
+		assert.Equal(t, test.Expected, b.Validate())
+	}
+
+	b := Branding{Color: " #FF00aa ", Footer: " ACME Inc. "}
+	b.Validate()
+	assert.Equal(t, "#ff00aa", b.Color)
+	assert.Equal(t, "ACME Inc.", b.Footer)
+
+	r, g, bl := b.rgb()
+	assert.Equal(t, []int{255, 0, 170}, []int{r, g, bl})
+	r, g, bl = (&Branding{}).rgb()
+	assert.Equal(t, []int{0x2b, 0x3e, 0x5a}, []int{r, g, bl})
+}
+
+func TestRender(t *testing.T) {
+	r := &Receipt{
+		StoreTitle:        "Caffè Store",
+		PaymentID:         strings.Repeat("a", 64),
+		OrderID:           "1234",
+		Description:       "Order #1234",
+		Currency:          "EUR",
+		CurrencyAmount:    "10.5",
+		ExchangeRate:      "0.25",
+		DeroAmount:        "42.000000000000",
+		IntegratedAddress: strings.Repeat("d", 142),
+		TxIDs:             []string{strings.Repeat("b", 64), strings.Repeat("c", 64)},
+		CreationTime:      time.Now(),
+		PaidTime:          time.Now(),
+		Branding:          Branding{Color: "#ff0000", Footer: "ACME Inc.\nMain Street 1"},
+	}
+
+	var buf bytes.Buffer
+	err := r.Render(&buf)
+	assert.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")))
+
+	// Receipts without transaction IDs and branding are rendered too
+	r.TxIDs = nil
+	r.Branding = Branding{}
+	buf.Reset()
+	assert.Nil(t, r.Render(&buf))
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")))
+}
+
+func TestText(t *testing.T) {
+	created := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	r := &Receipt{
+		StoreTitle:        "Caffè Store",
+		PaymentID:         "payment",
+		OrderID:           "1234",
+		Currency:          "EUR",
+		CurrencyAmount:    "10.5",
+		ExchangeRate:      "0.25",
+		DeroAmount:        "42.000000000000",
+		IntegratedAddress: "address",
+		TxIDs:             []string{"tx1", "tx2"},
+		CreationTime:      created,
+		PaidTime:          created.Add(5 * time.Minute),
+		Branding:          Branding{Footer: "ACME Inc."},
+	}
+
+	expected := "Caffè Store - RECEIPT\n\n" +
+		"Payment ID: payment\n" +
+		"Order ID: 1234\n" +
+		"Status: Paid\n" +
+		"Created: 2020-05-01 10:00:00 UTC\n" +
+		"Paid: 2020-05-01 10:05:00 UTC\n\n" +
+		"Total: 10.5 EUR\n" +
+		"Exchange rate: 1 DERO = 0.25 EUR\n" +
+		"Amount due: 42.000000000000 DERO\n\n" +
+		"Integrated address: address\n" +
+		"Transaction IDs: tx1, tx2\n\n" +
+		"ACME Inc.\n"
+	assert.Equal(t, expected, r.Text())
+
+	// DERO receipts have no exchange rate
+	r.Currency = "DERO"
+	r.TxIDs = nil
+	r.Branding = Branding{}
+	text := r.Text()
+	assert.NotContains(t, text, "Exchange rate")
+	assert.Contains(t, text, "Transaction IDs: -\n")
+}
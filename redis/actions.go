@@ -2,6 +2,7 @@ package redis
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -175,6 +176,15 @@ func RemoveUserStore(userID, storeID int) error {
 	return nil
 }
 
+// GetUsersWithStores returns the users that have a userid:<userID>:storeids set
+func GetUsersWithStores() (userIDs []int, err error) {
+	keys, err := Keys("userid:*:storeids")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get keys from Redis")
+	}
+	return keysIDs(keys)
+}
+
 // SetStoreTitle sets title as the value of storeid:<storeID>:title key
 func SetStoreTitle(storeID int, title string) error {
 	key := stringutil.Build("storeid:", strconv.Itoa(storeID), ":title")
@@ -205,6 +215,32 @@ func DeleteStoreTitle(storeID int) error {
 	return nil
 }
 
+// GetStoresWithTitle returns the stores that have a storeid:<storeID>:title key
+func GetStoresWithTitle() (storeIDs []int, err error) {
+	keys, err := Keys("storeid:*:title")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get keys from Redis")
+	}
+	return keysIDs(keys)
+}
+
+// keysIDs returns the IDs in the middle of keys formatted as <type>:<ID>:<field>
+func keysIDs(keys []string) (ids []int, err error) {
+	for _, k := range keys {
+		parts := strings.Split(k, ":")
+		if len(parts) != 3 {
+			continue
+		}
+
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot convert ID of key %s to int", k)
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
 // SetAPIKeyStore sets storeID as the value of apikey:<apiKey>:storeid
 func SetAPIKeyStore(apiKey string, storeID int) error {
 	key := stringutil.Build("apikey:", apiKey, ":storeid")
@@ -265,6 +301,26 @@ func DeleteAPIKeySecretKey(apiKey string) error {
 	return nil
 }
 
+// GetAPIKeys returns the API Keys that have either an apikey:<apiKey>:storeid or an apikey:<apiKey>:secretkey key
+func GetAPIKeys() (apiKeys []string, err error) {
+	keys, err := Keys("apikey:*")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get keys from Redis")
+	}
+
+	found := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		parts := strings.Split(k, ":")
+		if len(parts) != 3 || found[parts[1]] {
+			continue
+		}
+
+		found[parts[1]] = true
+		apiKeys = append(apiKeys, parts[1])
+	}
+	return
+}
+
 // SetSupportedCurrencies adds all currencies to the supportedcurrencies set
 func SetSupportedCurrencies(currencies []string) error {
 	for _, c := range currencies {
@@ -20,19 +20,20 @@ func (suite *ActionsTestSuite) SetupSuite() {
 	}
 
 	Pool = NewPool(config.RedisAddress)
+	Namespace = config.TestRedisNamespace
 	err = Ping()
 	if err != nil {
 		panic(err)
 	}
 
-	err = FlushAll()
+	err = FlushNamespace()
 	if err != nil {
 		panic(err)
 	}
 }
 
 func (suite *ActionsTestSuite) TearDownSuite() {
-	FlushAll()
+	FlushNamespace()
 	Pool.Close()
 }
 
@@ -1,10 +1,22 @@
 package redis
 
 import (
+	"strings"
+
 	"github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
+
+	"github.com/peppinux/dero-merchant/stringutil"
 )
 
+// namespaced returns key prefixed with Namespace
+func namespaced(key string) string {
+	if Namespace == "" {
+		return key
+	}
+	return stringutil.Build(Namespace, ":", key)
+}
+
 // Ping pings a Redis DB
 func Ping() error {
 	conn := Pool.Get()
@@ -17,7 +29,7 @@ func Ping() error {
 	return nil
 }
 
-// FlushAll deletes all the keys from a Redis DB
+// FlushAll deletes all the keys from a Redis DB, including the ones of other namespaces. Use FlushNamespace to only delete the keys of the application.
 func FlushAll() error {
 	conn := Pool.Get()
 	defer conn.Close()
@@ -29,12 +41,61 @@ func FlushAll() error {
 	return nil
 }
 
+// FlushNamespace deletes all the keys in Namespace from a Redis DB, leaving the keys of other namespaces untouched
+func FlushNamespace() error {
+	keys, err := Keys("*")
+	if err != nil {
+		return errors.Wrap(err, "cannot get keys")
+	}
+
+	conn := Pool.Get()
+	defer conn.Close()
+
+	for _, key := range keys {
+		_, err = conn.Do("DEL", namespaced(key))
+		if err != nil {
+			return errors.Wrapf(err, "cannot delete key %s", key)
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys in Namespace matching pattern (without the Namespace prefix).
+// Unlike the KEYS command, it does not block the Redis DB while iterating over its keys.
+func Keys(pattern string) (keys []string, err error) {
+	conn := Pool.Get()
+	defer conn.Close()
+
+	prefix := namespaced("")
+	cursor := 0
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", namespaced(pattern), "COUNT", 1000))
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot scan keys matching %s", pattern)
+		}
+
+		var page []string
+		_, err = redis.Scan(reply, &cursor, &page)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan reply")
+		}
+
+		for _, k := range page {
+			keys = append(keys, strings.TrimPrefix(k, prefix))
+		}
+
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
 // Set sets the value of a key in a Redis DB
 func Set(key string, value interface{}) error {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("SET", key, value)
+	_, err := conn.Do("SET", namespaced(key), value)
 	if err != nil {
 		var v interface{}
 
@@ -59,7 +120,7 @@ func GetString(key string) (value string, err error) {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	value, err = redis.String(conn.Do("GET", key))
+	value, err = redis.String(conn.Do("GET", namespaced(key)))
 	if err != nil {
 		err = errors.Wrapf(err, "cannot get key %s", key)
 	}
@@ -71,7 +132,7 @@ func GetInt(key string) (value int, err error) {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	value, err = redis.Int(conn.Do("GET", key))
+	value, err = redis.Int(conn.Do("GET", namespaced(key)))
 	if err != nil {
 		err = errors.Wrapf(err, "cannot get key %s", key)
 	}
@@ -83,7 +144,7 @@ func Exists(key string) (exists bool, err error) {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	exists, err = redis.Bool(conn.Do("EXISTS", key))
+	exists, err = redis.Bool(conn.Do("EXISTS", namespaced(key)))
 	if err != nil {
 		err = errors.Wrapf(err, "cannot check if key %s exists", key)
 	}
@@ -95,7 +156,7 @@ func Delete(key string) error {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("DEL", key)
+	_, err := conn.Do("DEL", namespaced(key))
 	if err != nil {
 		return errors.Wrapf(err, "cannot delete key %s", key)
 	}
@@ -107,7 +168,7 @@ func Expire(key string, ttl int) error {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("EXPIRE", key, ttl)
+	_, err := conn.Do("EXPIRE", namespaced(key), ttl)
 	if err != nil {
 		return errors.Wrapf(err, "cannot set key %s expiration to %d seconds", key, ttl)
 	}
@@ -119,7 +180,7 @@ func SetAddMember(key string, member interface{}) error {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("SADD", key, member)
+	_, err := conn.Do("SADD", namespaced(key), member)
 	if err != nil {
 		var m interface{}
 
@@ -144,7 +205,7 @@ func GetSetMembers(key string) (members []string, err error) {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	members, err = redis.Strings(conn.Do("SMEMBERS", key))
+	members, err = redis.Strings(conn.Do("SMEMBERS", namespaced(key)))
 	if err != nil {
 		err = errors.Wrapf(err, "cannot get members of set %s", key)
 	}
@@ -156,7 +217,7 @@ func IsSetMember(key string, member interface{}) (bool, error) {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	return redis.Bool(conn.Do("SISMEMBER", key, member))
+	return redis.Bool(conn.Do("SISMEMBER", namespaced(key), member))
 }
 
 // SetRemoveMember removes the member of a set from a Redis DB
@@ -164,7 +225,7 @@ func SetRemoveMember(key string, member interface{}) error {
 	conn := Pool.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("SREM", key, member)
+	_, err := conn.Do("SREM", namespaced(key), member)
 	if err != nil {
 		var m interface{}
 
@@ -20,19 +20,20 @@ func (suite *CommandsTestSuite) SetupSuite() {
 	}
 
 	Pool = NewPool(config.RedisAddress)
+	Namespace = config.TestRedisNamespace
 	err = Ping()
 	if err != nil {
 		panic(err)
 	}
 
-	err = FlushAll()
+	err = FlushNamespace()
 	if err != nil {
 		panic(err)
 	}
 }
 
 func (suite *CommandsTestSuite) TearDownSuite() {
-	FlushAll()
+	FlushNamespace()
 	Pool.Close()
 }
 
@@ -177,3 +178,45 @@ func (suite *CommandsTestSuite) TestSets() {
 	members, _ = GetSetMembers(setKey)
 	suite.Equal([]string{}, members)
 }
+
+func (suite *CommandsTestSuite) TestNamespace() {
+	defer func() {
+		Namespace = config.TestRedisNamespace
+	}()
+
+	key := "test:namespace:foo"
+
+	// Set in namespace
+	err := Set(key, "bar")
+	suite.Nil(err)
+
+	keys, err := Keys("test:namespace:*")
+	suite.Nil(err)
+	suite.Equal([]string{key}, keys)
+
+	// Key is not visible from other namespaces
+	Namespace = config.TestRedisNamespace + "_other"
+	exists, _ := Exists(key)
+	suite.False(exists)
+
+	err = Set(key, "baz")
+	suite.Nil(err)
+
+	// Flushing a namespace does not delete the keys of other namespaces
+	Namespace = config.TestRedisNamespace
+	err = FlushNamespace()
+	suite.Nil(err)
+	exists, _ = Exists(key)
+	suite.False(exists)
+
+	Namespace = config.TestRedisNamespace + "_other"
+	val, err := GetString(key)
+	suite.Nil(err)
+	suite.Equal("baz", val)
+
+	err = FlushNamespace()
+	suite.Nil(err)
+	keys, err = Keys("*")
+	suite.Nil(err)
+	suite.Empty(keys)
+}
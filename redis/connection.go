@@ -9,6 +9,9 @@ import (
 // Pool is the gloabl pool of Redis connections opened in main
 var Pool *redis.Pool
 
+// Namespace prefixes all the keys set and got by the package (as "<Namespace>:<key>"). Keys are not prefixed if it is empty.
+var Namespace string
+
 // NewPool creates a new pool of Redis connections
 func NewPool(address string) *redis.Pool {
 	return &redis.Pool{
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IdempotencyKey represents an idempotency key used by a store, along with the response to the request it was reserved for
+type IdempotencyKey struct {
+	StoreID     int
+	Key         string
+	RequestHash string
+	// ResponseStatus is 0 while the request the key was reserved for is being processed
+	ResponseStatus int
+	ResponseBody   []byte
+	CreationTime   time.Time
+}
+
+func (k IdempotencyKey) clone() *IdempotencyKey {
+	k.ResponseBody = append([]byte(nil), k.ResponseBody...)
+	return &k
+}
+
+// IdempotencyRepository stores idempotency keys
+type IdempotencyRepository interface {
+	// Reserve stores k, setting its creation time, unless its store already used its key.
+	// In that case, the stored key is returned instead, or ErrNotFound if it was deleted in the meantime.
+	// A stored key whose request is still being processed after reclaimAfter is assumed abandoned and replaced by k.
+	Reserve(k *IdempotencyKey, reclaimAfter time.Duration) (stored *IdempotencyKey, err error)
+	// SaveResponse stores the response to the request a key of a store was reserved for
+	SaveResponse(storeID int, key string, status int, body []byte) error
+	// Delete deletes a key of a store
+	Delete(storeID int, key string) error
+	// DeleteExpired deletes the keys created more than ttl ago
+	DeleteExpired(ttl time.Duration) error
+}
+
+// PostgresIdempotencyRepository is an IdempotencyRepository backed by table idempotency_keys of DB
+type PostgresIdempotencyRepository struct {
+	DB *sql.DB
+}
+
+// Reserve stores k unless its store already used its key, in which case the stored key is returned.
+// Abandoned keys are reclaimed.
+func (r *PostgresIdempotencyRepository) Reserve(k *IdempotencyKey, reclaimAfter time.Duration) (*IdempotencyKey, error) {
+	err := r.DB.QueryRow(`
+		INSERT INTO idempotency_keys (store_id, idempotency_key, request_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (store_id, idempotency_key) DO UPDATE
+		SET request_hash=EXCLUDED.request_hash, response_body='', creation_time=NOW()
+		WHERE idempotency_keys.response_status=0 AND idempotency_keys.creation_time < NOW() - $4 * INTERVAL '1 second'
+		RETURNING creation_time`, k.StoreID, k.Key, k.RequestHash, int64(reclaimAfter.Seconds())).
+		Scan(&k.CreationTime)
+	if err == nil { // Key reserved for this request
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	stored := IdempotencyKey{StoreID: k.StoreID, Key: k.Key}
+	err = r.DB.QueryRow(`
+		SELECT request_hash, response_status, response_body, creation_time
+		FROM idempotency_keys
+		WHERE store_id=$1 AND idempotency_key=$2`, k.StoreID, k.Key).
+		Scan(&stored.RequestHash, &stored.ResponseStatus, &stored.ResponseBody, &stored.CreationTime)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	return &stored, nil
+}
+
+// SaveResponse stores the response to the request a key of a store was reserved for
+func (r *PostgresIdempotencyRepository) SaveResponse(storeID int, key string, status int, body []byte) error {
+	return checkUpdated(r.DB.Exec(`
+		UPDATE idempotency_keys
+		SET response_status=$1, response_body=$2
+		WHERE store_id=$3 AND idempotency_key=$4`, status, string(body), storeID, key))
+}
+
+// Delete deletes a key of a store
+func (r *PostgresIdempotencyRepository) Delete(storeID int, key string) error {
+	_, err := r.DB.Exec(`
+		DELETE FROM idempotency_keys
+		WHERE store_id=$1 AND idempotency_key=$2`, storeID, key)
+	if err != nil {
+		return errors.Wrap(err, "cannot execute query")
+	}
+
+	return nil
+}
+
+// DeleteExpired deletes the keys created more than ttl ago
+func (r *PostgresIdempotencyRepository) DeleteExpired(ttl time.Duration) error {
+	_, err := r.DB.Exec(`
+		DELETE FROM idempotency_keys
+		WHERE creation_time < NOW() - $1 * INTERVAL '1 second'`, int64(ttl.Seconds()))
+	if err != nil {
+		return errors.Wrap(err, "cannot execute query")
+	}
+
+	return nil
+}
+
+// idempotencyKeyID identifies an idempotency key, as the primary key of table idempotency_keys
+type idempotencyKeyID struct {
+	storeID int
+	key     string
+}
+
+// MemoryIdempotencyRepository is a concurrency-safe IdempotencyRepository that keeps idempotency keys in memory
+type MemoryIdempotencyRepository struct {
+	mutex sync.Mutex
+	keys  map[idempotencyKeyID]*IdempotencyKey
+}
+
+// NewMemoryIdempotencyRepository returns a new empty MemoryIdempotencyRepository
+func NewMemoryIdempotencyRepository() *MemoryIdempotencyRepository {
+	return &MemoryIdempotencyRepository{
+		keys: make(map[idempotencyKeyID]*IdempotencyKey),
+	}
+}
+
+// Reserve stores k unless its store already used its key, in which case the stored key is returned.
+// Abandoned keys are reclaimed.
+func (r *MemoryIdempotencyRepository) Reserve(k *IdempotencyKey, reclaimAfter time.Duration) (*IdempotencyKey, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id := idempotencyKeyID{k.StoreID, k.Key}
+	if stored, ok := r.keys[id]; ok && (stored.ResponseStatus != 0 || time.Since(stored.CreationTime) <= reclaimAfter) {
+		return stored.clone(), nil
+	}
+
+	k.ResponseStatus, k.ResponseBody = 0, nil
+	k.CreationTime = time.Now().UTC()
+	r.keys[id] = k.clone()
+	return nil, nil
+}
+
+// SaveResponse stores the response to the request a key of a store was reserved for
+func (r *MemoryIdempotencyRepository) SaveResponse(storeID int, key string, status int, body []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	k, ok := r.keys[idempotencyKeyID{storeID, key}]
+	if !ok {
+		return ErrNotFound
+	}
+	k.ResponseStatus, k.ResponseBody = status, append([]byte(nil), body...)
+	return nil
+}
+
+// Delete deletes a key of a store
+func (r *MemoryIdempotencyRepository) Delete(storeID int, key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.keys, idempotencyKeyID{storeID, key})
+	return nil
+}
+
+// DeleteExpired deletes the keys created more than ttl ago
+func (r *MemoryIdempotencyRepository) DeleteExpired(ttl time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for id, k := range r.keys {
+		if time.Since(k.CreationTime) > ttl {
+			delete(r.keys, id)
+		}
+	}
+	return nil
+}
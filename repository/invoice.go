@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// InvoiceDateLayout is the layout of the dates of invoices and subscriptions
+const InvoiceDateLayout = "2006-01-02"
+
+// Invoice represents an invoice issued by a store to a customer, as stored in DB.
+// Its details are validated and its totals calculated by package api.
+type Invoice struct {
+	ID              int
+	InvoiceID       string
+	Number          string
+	Status          string
+	Currency        string
+	CustomerName    string
+	CustomerEmail   string
+	CustomerAddress string
+	LineItems       json.RawMessage // JSON array of the line items of package api
+	Subtotal        decimal.Decimal
+	TaxTotal        decimal.Decimal
+	Total           decimal.Decimal
+	DueDate         string // YYYY-MM-DD
+	Notes           string
+	// PaymentID is the Payment ID of the latest payment created to pay the invoice (empty if none)
+	PaymentID    string
+	CreationTime time.Time
+	PaidTime     *time.Time
+	// SubscriptionID is the ID of the subscription the invoice was issued for (0 if none)
+	SubscriptionID int
+	StoreID        int
+}
+
+func (i Invoice) clone() *Invoice {
+	i.LineItems = append(json.RawMessage(nil), i.LineItems...)
+	if i.PaidTime != nil {
+		paidTime := *i.PaidTime
+		i.PaidTime = &paidTime
+	}
+	return &i
+}
+
+// IsOverdue returns whether the invoice is open past its due date, as of today (UTC)
+func (i *Invoice) IsOverdue() bool {
+	return i.Status == InvoiceStatusOpen && i.DueDate < time.Now().UTC().Format(InvoiceDateLayout)
+}
+
+// InvoiceRepository stores invoices
+type InvoiceRepository interface {
+	// Insert stores i, setting its ID and creation time
+	Insert(i *Invoice) error
+	// InvoiceIDExists returns whether an invoice with invoiceID is stored
+	InvoiceIDExists(invoiceID string) (bool, error)
+	// ByInvoiceID returns the invoice with invoiceID, or ErrNotFound
+	ByInvoiceID(invoiceID string) (*Invoice, error)
+	// Void changes the status of an open invoice to void, or returns ErrInvoiceNotOpen if it is not open
+	Void(id int) error
+	// MarkPaid changes the status of an open invoice to paid with the payment it was paid with.
+	// Invoices that are not open are left unchanged.
+	MarkPaid(id int, paymentID string) error
+}
+
+// PostgresInvoiceRepository is an InvoiceRepository backed by table invoices of DB
+type PostgresInvoiceRepository struct {
+	DB *sql.DB
+}
+
+// invoiceColumns are the columns of table invoices scanned by scanInvoice
+const invoiceColumns = `id, invoice_id, number, status, currency, customer_name, customer_email, customer_address, line_items,
+	subtotal, tax_total, total, TO_CHAR(due_date, 'YYYY-MM-DD'), notes, COALESCE(payment_id, ''), creation_time, paid_time,
+	COALESCE(subscription_id, 0), store_id`
+
+func scanInvoice(row interface{ Scan(...interface{}) error }) (*Invoice, error) {
+	var i Invoice
+	err := row.Scan(&i.ID, &i.InvoiceID, &i.Number, &i.Status, &i.Currency, &i.CustomerName, &i.CustomerEmail, &i.CustomerAddress, (*[]byte)(&i.LineItems),
+		&i.Subtotal, &i.TaxTotal, &i.Total, &i.DueDate, &i.Notes, &i.PaymentID, &i.CreationTime, &i.PaidTime,
+		&i.SubscriptionID, &i.StoreID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "cannot scan row")
+	}
+
+	return &i, nil
+}
+
+func insertInvoice(q queryRower, i *Invoice) error {
+	err := q.QueryRow(`
+		INSERT INTO invoices (invoice_id, number, status, currency, customer_name, customer_email, customer_address, line_items, subtotal, tax_total, total, due_date, notes, subscription_id, store_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id, creation_time`, i.InvoiceID, i.Number, i.Status, i.Currency, i.CustomerName, i.CustomerEmail, i.CustomerAddress, string(i.LineItems),
+		i.Subtotal, i.TaxTotal, i.Total, i.DueDate, i.Notes, nullIfZero(i.SubscriptionID), i.StoreID).
+		Scan(&i.ID, &i.CreationTime)
+	if err != nil {
+		return errors.Wrap(err, "cannot query database")
+	}
+
+	return nil
+}
+
+// Insert stores i, setting its ID and creation time
+func (r *PostgresInvoiceRepository) Insert(i *Invoice) error {
+	return insertInvoice(r.DB, i)
+}
+
+// InvoiceIDExists returns whether an invoice with invoiceID is stored
+func (r *PostgresInvoiceRepository) InvoiceIDExists(invoiceID string) (exists bool, err error) {
+	err = r.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM invoices WHERE invoice_id=$1)`, invoiceID).Scan(&exists)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot query database")
+	}
+
+	return exists, nil
+}
+
+// ByInvoiceID returns the invoice with invoiceID, or ErrNotFound
+func (r *PostgresInvoiceRepository) ByInvoiceID(invoiceID string) (*Invoice, error) {
+	return scanInvoice(r.DB.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE invoice_id=$1`, invoiceID))
+}
+
+// Void changes the status of an open invoice to void, or returns ErrInvoiceNotOpen if it is not open
+func (r *PostgresInvoiceRepository) Void(id int) error {
+	err := checkUpdated(r.DB.Exec(`
+		UPDATE invoices
+		SET status=$1
+		WHERE id=$2 AND status=$3`, InvoiceStatusVoid, id, InvoiceStatusOpen))
+	if err == ErrNotFound {
+		return ErrInvoiceNotOpen
+	}
+	return err
+}
+
+// MarkPaid changes the status of an open invoice to paid with the payment it was paid with
+func (r *PostgresInvoiceRepository) MarkPaid(id int, paymentID string) error {
+	_, err := r.DB.Exec(`
+		UPDATE invoices
+		SET status=$1, payment_id=$2, paid_time=NOW()
+		WHERE id=$3 AND status=$4`, InvoiceStatusPaid, paymentID, id, InvoiceStatusOpen)
+	if err != nil {
+		return errors.Wrap(err, "cannot execute query")
+	}
+
+	return nil
+}
+
+// MemoryInvoiceRepository is a concurrency-safe InvoiceRepository that keeps invoices in memory
+type MemoryInvoiceRepository struct {
+	mutex    sync.RWMutex
+	invoices map[int]*Invoice
+	lastID   int
+}
+
+// NewMemoryInvoiceRepository returns a new empty MemoryInvoiceRepository
+func NewMemoryInvoiceRepository() *MemoryInvoiceRepository {
+	return &MemoryInvoiceRepository{
+		invoices: make(map[int]*Invoice),
+	}
+}
+
+// insert stores i. r.mutex has to be locked.
+// Invoice IDs are unique, as in table invoices.
+func (r *MemoryInvoiceRepository) insert(i *Invoice) error {
+	if r.find(func(stored *Invoice) bool { return stored.InvoiceID == i.InvoiceID }) != nil {
+		return errors.New("duplicate invoice ID")
+	}
+
+	r.lastID++
+	i.ID = r.lastID
+	i.CreationTime = time.Now().UTC()
+	r.invoices[i.ID] = i.clone()
+	return nil
+}
+
+// find returns the first stored invoice matching fn, or nil. r.mutex has to be locked.
+func (r *MemoryInvoiceRepository) find(fn func(i *Invoice) bool) *Invoice {
+	for _, i := range r.invoices {
+		if fn(i) {
+			return i
+		}
+	}
+	return nil
+}
+
+// Insert stores i, setting its ID and creation time
+func (r *MemoryInvoiceRepository) Insert(i *Invoice) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.insert(i)
+}
+
+// InvoiceIDExists returns whether an invoice with invoiceID is stored
+func (r *MemoryInvoiceRepository) InvoiceIDExists(invoiceID string) (bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.find(func(i *Invoice) bool { return i.InvoiceID == invoiceID }) != nil, nil
+}
+
+// ByInvoiceID returns the invoice with invoiceID, or ErrNotFound
+func (r *MemoryInvoiceRepository) ByInvoiceID(invoiceID string) (*Invoice, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	i := r.find(func(i *Invoice) bool { return i.InvoiceID == invoiceID })
+	if i == nil {
+		return nil, ErrNotFound
+	}
+	return i.clone(), nil
+}
+
+// Void changes the status of an open invoice to void, or returns ErrInvoiceNotOpen if it is not open
+func (r *MemoryInvoiceRepository) Void(id int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i, ok := r.invoices[id]
+	if !ok || i.Status != InvoiceStatusOpen {
+		return ErrInvoiceNotOpen
+	}
+	i.Status = InvoiceStatusVoid
+	return nil
+}
+
+// MarkPaid changes the status of an open invoice to paid with the payment it was paid with
+func (r *MemoryInvoiceRepository) MarkPaid(id int, paymentID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i, ok := r.invoices[id]
+	if !ok || i.Status != InvoiceStatusOpen {
+		return nil
+	}
+	now := time.Now().UTC()
+	i.Status, i.PaymentID, i.PaidTime = InvoiceStatusPaid, paymentID, &now
+	return nil
+}
+
+// openForPayment returns the open invoice with id, or ErrNotFound or ErrInvoiceNotOpen.
+// r.mutex has to be locked.
+func (r *MemoryInvoiceRepository) openForPayment(id int) (*Invoice, error) {
+	i, ok := r.invoices[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if i.Status != InvoiceStatusOpen {
+		return nil, ErrInvoiceNotOpen
+	}
+	return i, nil
+}
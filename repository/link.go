@@ -0,0 +1,311 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// PaymentLink represents a reusable payment link of a store, as stored in DB.
+// Its details are validated by package webapp/link.
+type PaymentLink struct {
+	ID           int
+	Slug         string
+	Title        string
+	Description  string
+	Currency     string
+	Amount       *decimal.Decimal // nil if entered by the customer
+	Active       bool
+	Removed      bool
+	CreationTime time.Time
+	StoreID      int
+	Stats        LinkStats // Only set by ByStore
+}
+
+func (l PaymentLink) clone() *PaymentLink {
+	if l.Amount != nil {
+		amount := *l.Amount
+		l.Amount = &amount
+	}
+	return &l
+}
+
+// LinkStats represents the payments created from a payment link
+type LinkStats struct {
+	Payments     int
+	PaidPayments int
+	// PaidAmount is the sum of the currency amounts of the paid payments
+	PaidAmount decimal.Decimal
+}
+
+// LinkRepository stores payment links.
+// Updates only apply to links that are owned by storeID and not removed, and return ErrNotFound otherwise.
+type LinkRepository interface {
+	// Insert stores l, setting its ID and creation time
+	Insert(l *PaymentLink) error
+	// SlugExists returns whether a link with slug is stored (even if removed)
+	SlugExists(slug string) (bool, error)
+	// ByID returns the link with id (even if removed), or ErrNotFound
+	ByID(id int) (*PaymentLink, error)
+	// BySlug returns the link with slug (even if removed), or ErrNotFound
+	BySlug(slug string) (*PaymentLink, error)
+	// ByStore returns the links of storeID that are not removed, newest first, along with the stats of the payments created from them
+	ByStore(storeID int) ([]*PaymentLink, error)
+	SetActive(id, storeID int, active bool) error
+	// Remove marks a link as removed and disables it
+	Remove(id, storeID int) error
+}
+
+// PostgresLinkRepository is a LinkRepository backed by tables payment_links and payments of DB
+type PostgresLinkRepository struct {
+	DB *sql.DB
+}
+
+// linkColumns are the columns of table payment_links scanned by scanLink
+const linkColumns = `payment_links.id, payment_links.slug, payment_links.title, payment_links.description, payment_links.currency, payment_links.amount,
+	payment_links.active, payment_links.removed, payment_links.creation_time, payment_links.store_id`
+
+func scanLink(row interface{ Scan(...interface{}) error }, stats ...interface{}) (*PaymentLink, error) {
+	var (
+		l      PaymentLink
+		amount decimal.NullDecimal
+	)
+	err := row.Scan(append([]interface{}{&l.ID, &l.Slug, &l.Title, &l.Description, &l.Currency, &amount,
+		&l.Active, &l.Removed, &l.CreationTime, &l.StoreID}, stats...)...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "cannot scan row")
+	}
+	if amount.Valid {
+		l.Amount = &amount.Decimal
+	}
+
+	return &l, nil
+}
+
+// Insert stores l, setting its ID and creation time
+func (r *PostgresLinkRepository) Insert(l *PaymentLink) error {
+	err := r.DB.QueryRow(`
+		INSERT INTO payment_links (slug, title, description, currency, amount, active, store_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, creation_time`, l.Slug, l.Title, l.Description, l.Currency, l.Amount, l.Active, l.StoreID).
+		Scan(&l.ID, &l.CreationTime)
+	if err != nil {
+		return errors.Wrap(err, "cannot query database")
+	}
+
+	return nil
+}
+
+// SlugExists returns whether a link with slug is stored (even if removed)
+func (r *PostgresLinkRepository) SlugExists(slug string) (exists bool, err error) {
+	err = r.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM payment_links WHERE slug=$1)`, slug).Scan(&exists)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot query database")
+	}
+
+	return exists, nil
+}
+
+// ByID returns the link with id (even if removed), or ErrNotFound
+func (r *PostgresLinkRepository) ByID(id int) (*PaymentLink, error) {
+	return scanLink(r.DB.QueryRow(`SELECT `+linkColumns+` FROM payment_links WHERE id=$1`, id))
+}
+
+// BySlug returns the link with slug (even if removed), or ErrNotFound
+func (r *PostgresLinkRepository) BySlug(slug string) (*PaymentLink, error) {
+	return scanLink(r.DB.QueryRow(`SELECT `+linkColumns+` FROM payment_links WHERE slug=$1`, slug))
+}
+
+// ByStore returns the links of storeID that are not removed, newest first, along with the stats of the payments created from them
+func (r *PostgresLinkRepository) ByStore(storeID int) ([]*PaymentLink, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+linkColumns+`,
+			COUNT(payments.payment_id), COUNT(payments.payment_id) FILTER (WHERE payments.status=$1), COALESCE(SUM(payments.currency_amount) FILTER (WHERE payments.status=$1), 0)
+		FROM payment_links LEFT JOIN payments ON payments.payment_link_id=payment_links.id
+		WHERE payment_links.store_id=$2 AND payment_links.removed=$3
+		GROUP BY payment_links.id
+		ORDER BY payment_links.creation_time DESC`, PaymentStatusPaid, storeID, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	var ls []*PaymentLink
+	for rows.Next() {
+		var stats LinkStats
+		l, err := scanLink(rows, &stats.Payments, &stats.PaidPayments, &stats.PaidAmount)
+		if err != nil {
+			return nil, err
+		}
+		l.Stats = stats
+
+		ls = append(ls, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return ls, nil
+}
+
+// SetActive enables or disables a link
+func (r *PostgresLinkRepository) SetActive(id, storeID int, active bool) error {
+	return checkUpdated(r.DB.Exec(`
+		UPDATE payment_links
+		SET active=$3
+		WHERE id=$1 AND store_id=$2 AND removed=false`, id, storeID, active))
+}
+
+// Remove marks a link as removed and disables it
+func (r *PostgresLinkRepository) Remove(id, storeID int) error {
+	return checkUpdated(r.DB.Exec(`
+		UPDATE payment_links
+		SET removed=true, active=false
+		WHERE id=$1 AND store_id=$2 AND removed=false`, id, storeID))
+}
+
+// MemoryLinkRepository is a concurrency-safe LinkRepository that keeps links in memory.
+// The stats of links are calculated from the payments of a MemoryPaymentRepository.
+type MemoryLinkRepository struct {
+	mutex    sync.RWMutex
+	links    map[int]*PaymentLink
+	lastID   int
+	payments *MemoryPaymentRepository
+}
+
+// NewMemoryLinkRepository returns a new empty MemoryLinkRepository calculating the stats of links from payments
+func NewMemoryLinkRepository(payments *MemoryPaymentRepository) *MemoryLinkRepository {
+	return &MemoryLinkRepository{
+		links:    make(map[int]*PaymentLink),
+		payments: payments,
+	}
+}
+
+// find returns the first stored link matching fn, or nil. r.mutex has to be locked.
+func (r *MemoryLinkRepository) find(fn func(l *PaymentLink) bool) *PaymentLink {
+	for _, l := range r.links {
+		if fn(l) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Insert stores l, setting its ID and creation time. Slugs are unique, as in table payment_links.
+func (r *MemoryLinkRepository) Insert(l *PaymentLink) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.find(func(stored *PaymentLink) bool { return stored.Slug == l.Slug }) != nil {
+		return errors.New("duplicate slug")
+	}
+
+	r.lastID++
+	l.ID = r.lastID
+	l.CreationTime = time.Now().UTC()
+	r.links[l.ID] = l.clone()
+	return nil
+}
+
+// SlugExists returns whether a link with slug is stored (even if removed)
+func (r *MemoryLinkRepository) SlugExists(slug string) (bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.find(func(l *PaymentLink) bool { return l.Slug == slug }) != nil, nil
+}
+
+// ByID returns the link with id (even if removed), or ErrNotFound
+func (r *MemoryLinkRepository) ByID(id int) (*PaymentLink, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	l, ok := r.links[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return l.clone(), nil
+}
+
+// BySlug returns the link with slug (even if removed), or ErrNotFound
+func (r *MemoryLinkRepository) BySlug(slug string) (*PaymentLink, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	l := r.find(func(l *PaymentLink) bool { return l.Slug == slug })
+	if l == nil {
+		return nil, ErrNotFound
+	}
+	return l.clone(), nil
+}
+
+// ByStore returns the links of storeID that are not removed, newest first, along with the stats of the payments created from them
+func (r *MemoryLinkRepository) ByStore(storeID int) ([]*PaymentLink, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	stats := make(map[int]*LinkStats)
+	var ls []*PaymentLink
+	for _, l := range r.links {
+		if l.StoreID == storeID && !l.Removed {
+			c := l.clone()
+			stats[c.ID] = &c.Stats
+			ls = append(ls, c)
+		}
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		if !ls[i].CreationTime.Equal(ls[j].CreationTime) {
+			return ls[i].CreationTime.After(ls[j].CreationTime)
+		}
+		return ls[i].ID > ls[j].ID
+	})
+
+	r.payments.mutex.RLock()
+	defer r.payments.mutex.RUnlock()
+
+	for _, p := range r.payments.payments {
+		s, ok := stats[p.PaymentLinkID]
+		if !ok {
+			continue
+		}
+
+		s.Payments++
+		if p.Status == PaymentStatusPaid {
+			s.PaidPayments++
+			s.PaidAmount = s.PaidAmount.Add(p.CurrencyAmount)
+		}
+	}
+	return ls, nil
+}
+
+// update calls fn on the link with id if it is owned by storeID and not removed, or returns ErrNotFound
+func (r *MemoryLinkRepository) update(id, storeID int, f func(l *PaymentLink)) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	l, ok := r.links[id]
+	if !ok || l.StoreID != storeID || l.Removed {
+		return ErrNotFound
+	}
+	f(l)
+	return nil
+}
+
+// SetActive enables or disables a link
+func (r *MemoryLinkRepository) SetActive(id, storeID int, active bool) error {
+	return r.update(id, storeID, func(l *PaymentLink) { l.Active = active })
+}
+
+// Remove marks a link as removed and disables it
+func (r *MemoryLinkRepository) Remove(id, storeID int) error {
+	return r.update(id, storeID, func(l *PaymentLink) { l.Removed, l.Active = true, false })
+}
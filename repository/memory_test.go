@@ -0,0 +1,830 @@
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryUserRepository(t *testing.T) {
+	users := NewMemoryUserRepository()
+
+	foo := &User{Username: "Foo", Email: "foo@bar.baz", Password: "hash1", EmailVerified: true}
+	bar := &User{Username: "bar", Email: "bar@bar.baz", Password: "hash2"}
+	users.Add(foo)
+	users.Add(bar)
+	assert.NotEqual(t, foo.ID, bar.ID)
+
+	// Test ByID
+	u, err := users.ByID(foo.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, foo, u)
+
+	_, err = users.ByID(foo.ID + bar.ID)
+	assert.Equal(t, ErrNotFound, err)
+
+	// Test ByUsernameOrEmail
+	us, err := users.ByUsernameOrEmail("FOO", "")
+	assert.Nil(t, err)
+	assert.Len(t, us, 1)
+	assert.Equal(t, foo.ID, us[0].ID)
+
+	us, err = users.ByUsernameOrEmail("foo", "bar@bar.baz")
+	assert.Nil(t, err)
+	assert.Len(t, us, 2)
+
+	us, err = users.ByUsernameOrEmail("baz", "baz@bar.baz")
+	assert.Nil(t, err)
+	assert.Empty(t, us)
+
+	// Test UpdatePassword
+	assert.Nil(t, users.UpdatePassword(bar.ID, "hash3"))
+	u, _ = users.ByID(bar.ID)
+	assert.Equal(t, "hash3", u.Password)
+
+	assert.Equal(t, ErrNotFound, users.UpdatePassword(foo.ID+bar.ID, "hash3"))
+
+	// Test returned users being copies
+	u.Password = "hash4"
+	u, _ = users.ByID(bar.ID)
+	assert.Equal(t, "hash3", u.Password)
+}
+
+func TestMemoryUserRepositoryTokens(t *testing.T) {
+	users := NewMemoryUserRepository()
+
+	u := &User{Username: "Foo", Email: "foo@bar.baz", Password: "hash1"}
+	assert.Nil(t, users.Insert(u, "verificationtoken1"))
+	assert.NotZero(t, u.ID)
+
+	// Test duplicate username or email
+	assert.NotNil(t, users.Insert(&User{Username: "foo", Email: "bar@bar.baz"}, "verificationtoken2"))
+	assert.NotNil(t, users.Insert(&User{Username: "Bar", Email: "foo@bar.baz"}, "verificationtoken2"))
+
+	// Test ByEmail
+	got, err := users.ByEmail("foo@bar.baz")
+	assert.Nil(t, err)
+	assert.Equal(t, u.ID, got.ID)
+	assert.False(t, got.EmailVerified)
+
+	_, err = users.ByEmail("bar@bar.baz")
+	assert.Equal(t, ErrNotFound, err)
+
+	// Test TokenExists
+	exists, err := users.TokenExists(VerificationToken, "verificationtoken1")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	exists, _ = users.TokenExists(RecoverToken, "verificationtoken1")
+	assert.False(t, exists)
+
+	// Test ByVerificationToken and VerifyEmail
+	got, expired, err := users.ByVerificationToken("verificationtoken1")
+	assert.Nil(t, err)
+	assert.False(t, expired)
+	assert.Equal(t, u.ID, got.ID)
+
+	assert.Nil(t, users.SetVerificationToken(u.ID, "verificationtoken3"))
+	_, _, err = users.ByVerificationToken("verificationtoken1")
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, users.VerifyEmail(u.ID))
+	_, _, err = users.ByVerificationToken("verificationtoken3")
+	assert.Equal(t, ErrNotFound, err) // Already verified
+
+	// Test ByRecoverToken and ResetPassword
+	_, _, err = users.ByRecoverToken("foo@bar.baz", "")
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, users.SetRecoverToken(u.ID, "recovertoken1"))
+	_, _, err = users.ByRecoverToken("bar@bar.baz", "recovertoken1")
+	assert.Equal(t, ErrNotFound, err)
+	got, expired, err = users.ByRecoverToken("foo@bar.baz", "recovertoken1")
+	assert.Nil(t, err)
+	assert.False(t, expired)
+	assert.Equal(t, u.ID, got.ID)
+
+	assert.Nil(t, users.ResetPassword(u.ID, "hash2"))
+	got, _ = users.ByID(u.ID)
+	assert.Equal(t, "hash2", got.Password)
+	_, _, err = users.ByRecoverToken("foo@bar.baz", "recovertoken1")
+	assert.Equal(t, ErrNotFound, err)
+
+	// Test UpdateEmail
+	assert.Nil(t, users.UpdateEmail(u.ID, "baz@bar.baz", "verificationtoken4"))
+	got, _ = users.ByID(u.ID)
+	assert.Equal(t, "baz@bar.baz", got.Email)
+	assert.False(t, got.EmailVerified)
+	_, _, err = users.ByVerificationToken("verificationtoken4")
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrNotFound, users.VerifyEmail(u.ID+1))
+	assert.Equal(t, ErrNotFound, users.SetRecoverToken(u.ID+1, "recovertoken2"))
+}
+
+func TestMemoryStoreRepository(t *testing.T) {
+	stores := NewMemoryStoreRepository()
+
+	const ownerID = 1
+
+	s := &Store{
+		Title:            "Foo Store",
+		WalletViewKey:    "viewkey",
+		WebhookSecretKey: "webhooksecretkey1",
+		APIKey:           "apikey1",
+		SecretKey:        "secretkey1",
+		OwnerID:          ownerID,
+	}
+	assert.Nil(t, stores.Insert(s))
+	assert.NotZero(t, s.ID)
+
+	// Test duplicate keys
+	duplicate := *s
+	assert.NotNil(t, stores.Insert(&duplicate))
+
+	other := &Store{
+		Title:            "Bar Store",
+		WebhookSecretKey: "webhooksecretkey2",
+		APIKey:           "apikey2",
+		SecretKey:        "secretkey2",
+		OwnerID:          ownerID + 1,
+	}
+	assert.Nil(t, stores.Insert(other))
+
+	// Test lookups
+	found, err := stores.ByID(s.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, s, found)
+
+	found, err = stores.ByAPIKey("apikey1")
+	assert.Nil(t, err)
+	assert.Equal(t, s.ID, found.ID)
+
+	found, err = stores.ByWebhookSecretKey("webhooksecretkey2")
+	assert.Nil(t, err)
+	assert.Equal(t, other.ID, found.ID)
+
+	_, err = stores.ByAPIKey("apikey3")
+	assert.Equal(t, ErrNotFound, err)
+
+	owned, err := stores.ByOwner(ownerID)
+	assert.Nil(t, err)
+	assert.Len(t, owned, 1)
+	assert.Equal(t, s.ID, owned[0].ID)
+
+	active, err := stores.Active()
+	assert.Nil(t, err)
+	assert.Len(t, active, 2)
+
+	exists, err := stores.TitleExists(ownerID, "FOO STORE")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	exists, err = stores.TitleExists(ownerID, "Bar Store")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	// Test updates
+	assert.Nil(t, stores.UpdateViewKey(s.ID, ownerID, "newviewkey"))
+	assert.Nil(t, stores.UpdateWebhook(s.ID, ownerID, "https://foo.bar/webhook"))
+	assert.Nil(t, stores.UpdateWebhookSecretKey(s.ID, ownerID, "webhooksecretkey3"))
+	assert.Nil(t, stores.UpdateKeys(s.ID, ownerID, "apikey3", "secretkey3"))
+
+	found, _ = stores.ByID(s.ID)
+	assert.Equal(t, "newviewkey", found.WalletViewKey)
+	assert.Equal(t, "https://foo.bar/webhook", found.Webhook)
+	assert.Equal(t, "webhooksecretkey3", found.WebhookSecretKey)
+	assert.Equal(t, "apikey3", found.APIKey)
+	assert.Equal(t, "secretkey3", found.SecretKey)
+
+	// Test updates of stores not owned
+	assert.Equal(t, ErrNotFound, stores.UpdateViewKey(s.ID, ownerID+1, "viewkey"))
+	assert.Equal(t, ErrNotFound, stores.UpdateKeys(other.ID, ownerID, "apikey4", "secretkey4"))
+
+	// Test settings
+	assert.Equal(t, int32(12), s.DeroDecimals) // Default settings
+	assert.JSONEq(t, `[]`, string(s.ConfirmationTiers))
+
+	rateMarkup := decimal.NewFromInt(3)
+	deroDecimals := int32(2)
+	updated, err := stores.UpdatePricingPolicy(s.ID, ownerID, &rateMarkup, nil, &deroDecimals)
+	assert.Nil(t, err)
+	assert.True(t, rateMarkup.Equal(updated.RateMarkup))
+	assert.True(t, updated.UnderpaymentTolerance.IsZero())
+	assert.Equal(t, deroDecimals, updated.DeroDecimals)
+
+	_, err = stores.UpdatePricingPolicy(s.ID, ownerID+1, &rateMarkup, nil, nil)
+	assert.Equal(t, ErrNotFound, err)
+
+	tiers := json.RawMessage(`[{"maxDeroAmount":"10","confirmations":2}]`)
+	assert.Nil(t, stores.UpdatePaymentRequirements(s.ID, ownerID, 30, 5, tiers))
+	assert.Nil(t, stores.UpdateReceiptBranding(s.ID, ownerID, "#123456", "Thanks"))
+	assert.Equal(t, ErrNotFound, stores.UpdateReceiptBranding(other.ID, ownerID, "#123456", "Thanks"))
+
+	found, _ = stores.ByID(s.ID)
+	assert.Equal(t, 30, found.PaymentTTL)
+	assert.Equal(t, 5, found.PaymentMinConfirmations)
+	assert.JSONEq(t, string(tiers), string(found.ConfirmationTiers))
+	assert.Equal(t, "#123456", found.ReceiptColor)
+	assert.Equal(t, "Thanks", found.ReceiptFooter)
+
+	assert.Nil(t, stores.UpdatePaymentRequirements(s.ID, ownerID, 0, 0, nil))
+	found, _ = stores.ByID(s.ID)
+	assert.JSONEq(t, `[]`, string(found.ConfirmationTiers))
+
+	// Test Remove
+	removed, err := stores.Remove(s.ID, ownerID)
+	assert.Nil(t, err)
+	assert.True(t, removed.Removed)
+
+	_, err = stores.Remove(s.ID, ownerID)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, stores.UpdateWebhook(s.ID, ownerID, ""))
+
+	owned, _ = stores.ByOwner(ownerID)
+	assert.Empty(t, owned)
+
+	active, _ = stores.Active()
+	assert.Len(t, active, 1)
+	assert.Equal(t, other.ID, active[0].ID)
+
+	exists, _ = stores.TitleExists(ownerID, "Foo Store")
+	assert.False(t, exists)
+
+	found, err = stores.ByAPIKey("apikey3")
+	assert.Nil(t, err)
+	assert.True(t, found.Removed)
+}
+
+func TestMemoryPaymentRepository(t *testing.T) {
+	payments := NewMemoryPaymentRepository()
+
+	p := &Payment{
+		PaymentID: "foo",
+		Status:    "pending",
+		Currency:  "DERO",
+		StoreID:   1,
+	}
+	assert.Nil(t, payments.Insert(p, "created"))
+	assert.False(t, p.CreationTime.IsZero())
+	assert.NotNil(t, payments.Insert(p, "created"))
+
+	other := &Payment{
+		PaymentID: "bar",
+		Status:    "pending",
+		StoreID:   1,
+	}
+	assert.Nil(t, payments.Insert(other, "created"))
+
+	found, err := payments.ByID("foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, found.MinsFromCreation)
+	p.MinsFromCreation = found.MinsFromCreation
+	assert.Equal(t, p, found)
+
+	_, err = payments.ByID("baz")
+	assert.Equal(t, ErrNotFound, err)
+
+	// Test ChangeStatus
+	assert.Nil(t, payments.ChangeStatus("foo", "pending", "paid", "processor", []string{"txid"}, true))
+
+	found, _ = payments.ByID("foo")
+	assert.Equal(t, "paid", found.Status)
+	assert.Equal(t, []string{"txid"}, found.TxIDs)
+	assert.NotNil(t, found.PaidTime)
+
+	assert.Equal(t, ErrUnexpectedStatus, payments.ChangeStatus("foo", "pending", "expired", "processor", nil, false))
+	assert.Equal(t, ErrUnexpectedStatus, payments.ChangeStatus("baz", "pending", "expired", "processor", nil, false))
+
+	// Test ChangeAllStatuses
+	assert.Nil(t, payments.ChangeAllStatuses("pending", "error", "shutdown"))
+
+	found, _ = payments.ByID("foo")
+	assert.Equal(t, "paid", found.Status)
+	found, _ = payments.ByID("bar")
+	assert.Equal(t, "error", found.Status)
+
+	// Test StatusHistory
+	h, err := payments.StatusHistory("foo")
+	assert.Nil(t, err)
+	assert.Len(t, h, 2)
+	assert.Empty(t, h[0].OldStatus)
+	assert.Equal(t, "pending", h[0].NewStatus)
+	assert.Equal(t, "created", h[0].Cause)
+	assert.Equal(t, "pending", h[1].OldStatus)
+	assert.Equal(t, "paid", h[1].NewStatus)
+	assert.Equal(t, "processor", h[1].Cause)
+	assert.False(t, h[1].Time.Before(h[0].Time))
+
+	h, err = payments.StatusHistory("baz")
+	assert.Nil(t, err)
+	assert.Empty(t, h)
+
+	// Test webhook deliveries
+	assert.Nil(t, payments.AddWebhookDelivery("foo", &WebhookDelivery{Status: "paid", ResponseStatus: 200}))
+	assert.Nil(t, payments.AddWebhookDelivery("foo", &WebhookDelivery{Status: "paid", Error: "connection refused"}))
+	assert.Equal(t, ErrNotFound, payments.AddWebhookDelivery("baz", &WebhookDelivery{Status: "paid"}))
+
+	ds, err := payments.WebhookDeliveries("foo")
+	assert.Nil(t, err)
+	assert.Len(t, ds, 2)
+	assert.True(t, ds[0].Succeeded())
+	assert.False(t, ds[1].Succeeded())
+	assert.False(t, ds[0].Time.IsZero())
+
+	ds, err = payments.WebhookDeliveries("bar")
+	assert.Nil(t, err)
+	assert.Empty(t, ds)
+}
+
+func TestMemoryPaymentRepositoryInsert(t *testing.T) {
+	payments := NewMemoryPaymentRepository()
+
+	ps := []*Payment{
+		{PaymentID: "foo", Status: "pending", IntegratedAddress: "iaddr1", StoreID: 1},
+		{PaymentID: "bar", Status: "pending", IntegratedAddress: "iaddr2", StoreID: 1},
+	}
+	assert.Nil(t, payments.InsertAll(ps, "created"))
+	assert.False(t, ps[1].CreationTime.IsZero())
+
+	// None of the payments is stored if one fails
+	assert.NotNil(t, payments.InsertAll([]*Payment{{PaymentID: "baz", IntegratedAddress: "iaddr3"}, {PaymentID: "qux", IntegratedAddress: "iaddr1"}}, "created"))
+	_, err := payments.ByID("baz")
+	assert.Equal(t, ErrNotFound, err)
+
+	used, err := payments.UsedIdentifiers([]string{"foo", "baz"}, []string{"iaddr2", "iaddr3"})
+	assert.Nil(t, err)
+	assert.True(t, used["foo"])
+	assert.True(t, used["iaddr2"])
+	assert.False(t, used["baz"])
+	assert.False(t, used["iaddr3"])
+
+	found, err := payments.ByIDs(1, []string{"foo", "bar", "baz"})
+	assert.Nil(t, err)
+	assert.Len(t, found, 2)
+	found, err = payments.ByIDs(2, []string{"foo"})
+	assert.Nil(t, err)
+	assert.Empty(t, found)
+
+	// Test re-quotes
+	_, err = payments.Requote(&Payment{PaymentID: "baz", Status: "pending", IntegratedAddress: "iaddr3", RequotedFrom: "foo", StoreID: 2}, "created")
+	assert.Equal(t, ErrNotFound, err) // Wrong store
+	_, err = payments.ByID("baz")
+	assert.Equal(t, ErrNotFound, err)
+
+	requoted := &Payment{PaymentID: "baz", Status: "pending", IntegratedAddress: "iaddr3", RequotedFrom: "foo", StoreID: 1}
+	existing, err := payments.Requote(requoted, "created")
+	assert.Nil(t, err)
+	assert.Empty(t, existing)
+	_, err = payments.Requote(&Payment{PaymentID: "qux", IntegratedAddress: "iaddr4", RequotedFrom: "foo", StoreID: 1}, "created")
+	assert.NotNil(t, err)
+
+	p, err := payments.ByRequotedFrom("foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "baz", p.PaymentID)
+	_, err = payments.ByRequotedFrom("bar")
+	assert.Equal(t, ErrNotFound, err)
+	p, _ = payments.ByID("foo")
+	assert.Equal(t, "baz", p.RequotedTo)
+
+	// Test invoice payments
+	existing, err = payments.InsertForInvoice(&Payment{PaymentID: "inv1", Status: "pending", IntegratedAddress: "iaddr5", InvoiceID: 7, StoreID: 1}, "created")
+	assert.Nil(t, err)
+	assert.Empty(t, existing)
+	existing, err = payments.InsertForInvoice(&Payment{PaymentID: "inv2", Status: "pending", IntegratedAddress: "iaddr6", InvoiceID: 7, StoreID: 1}, "created")
+	assert.Nil(t, err)
+	assert.Equal(t, "inv1", existing)
+	_, err = payments.ByID("inv2")
+	assert.Equal(t, ErrNotFound, err)
+
+	p, err = payments.ByInvoice(7, "pending")
+	assert.Nil(t, err)
+	assert.Equal(t, "inv1", p.PaymentID)
+	_, err = payments.ByInvoice(7, "paid")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryPaymentRepositoryFind(t *testing.T) {
+	payments := NewMemoryPaymentRepository()
+
+	for i, amount := range []int64{3, 1, 2, 1} {
+		p := &Payment{
+			PaymentID:         string(rune('a' + i)),
+			Status:            "pending",
+			Currency:          "DERO",
+			CurrencyAmount:    decimal.NewFromInt(amount),
+			IntegratedAddress: string(rune('a' + i)),
+			StoreID:           1,
+		}
+		if i == 3 {
+			p.Status = "paid"
+		}
+		assert.Nil(t, payments.Insert(p, "created"))
+	}
+	assert.Nil(t, payments.Insert(&Payment{PaymentID: "e", IntegratedAddress: "e", StoreID: 2}, "created"))
+
+	n, err := payments.Count(&PaymentFilter{StoreID: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	n, err = payments.Count(&PaymentFilter{StoreID: 1, Status: "paid"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	ids := func(ps []*Payment) (ids []string) {
+		for _, p := range ps {
+			ids = append(ids, p.PaymentID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		Query       PaymentQuery
+		ExpectedIDs []string
+	}{
+		{PaymentQuery{PaymentFilter: PaymentFilter{StoreID: 1}, SortBy: "currency_amount", OrderBy: "asc"}, []string{"b", "d", "c", "a"}},
+		{PaymentQuery{PaymentFilter: PaymentFilter{StoreID: 1}, SortBy: "currency_amount", OrderBy: "desc"}, []string{"a", "c", "d", "b"}},
+		{PaymentQuery{PaymentFilter: PaymentFilter{StoreID: 1}, SortBy: "currency_amount", OrderBy: "asc", Limit: 2, Offset: 1}, []string{"d", "c"}},
+		{PaymentQuery{PaymentFilter: PaymentFilter{StoreID: 1}, SortBy: "currency_amount", OrderBy: "asc", Offset: 4}, nil},
+		{PaymentQuery{PaymentFilter: PaymentFilter{StoreID: 1}, SortBy: "currency_amount", OrderBy: "asc", After: &PaymentCursor{Value: "1", PaymentID: "b"}}, []string{"d", "c", "a"}},
+		{PaymentQuery{PaymentFilter: PaymentFilter{StoreID: 1}, SortBy: "currency_amount", OrderBy: "desc", After: &PaymentCursor{Value: "2", PaymentID: "c"}, Limit: 1}, []string{"d"}},
+		{PaymentQuery{PaymentFilter: PaymentFilter{StoreID: 1, Status: "pending"}, SortBy: "currency_amount", OrderBy: "asc"}, []string{"b", "c", "a"}},
+	}
+
+	for _, test := range tests {
+		ps, err := payments.Find(&test.Query)
+		assert.Nil(t, err)
+		assert.Equal(t, test.ExpectedIDs, ids(ps))
+	}
+
+	_, err = payments.Find(&PaymentQuery{SortBy: "currency_amount", OrderBy: "asc", After: &PaymentCursor{Value: "one"}})
+	assert.NotNil(t, err)
+
+	var each []*Payment
+	err = payments.Each(&PaymentQuery{PaymentFilter: PaymentFilter{StoreID: 1}, SortBy: "currency_amount", OrderBy: "asc"}, func(p *Payment) error {
+		each = append(each, p)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "d", "c", "a"}, ids(each))
+}
+
+func TestMemoryPaymentRepositoryExtend(t *testing.T) {
+	payments := NewMemoryPaymentRepository()
+
+	assert.Nil(t, payments.Insert(&Payment{PaymentID: "foo", Status: "pending", MaxTTL: 10, StoreID: 1}, "created"))
+
+	p, err := payments.Extend("foo", 1, "pending", 5, 60, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 15, p.MaxTTL)
+
+	quote := &PaymentQuote{ExchangeRate: decimal.NewFromInt(2), DeroAmount: "5", AtomicDeroAmount: 5000000000000, MinAtomicDeroAmount: 4900000000000, RequiredConfirmations: 3}
+	p, err = payments.Extend("foo", 1, "pending", 5, 60, quote)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, p.MaxTTL)
+	assert.True(t, quote.ExchangeRate.Equal(p.ExchangeRate))
+	assert.Equal(t, uint64(4900000000000), p.MinAtomicDeroAmount)
+	assert.Equal(t, 3, p.RequiredConfirmations)
+
+	// Max TTL exceeded
+	_, err = payments.Extend("foo", 1, "pending", 60, 60, nil)
+	assert.Equal(t, ErrNotFound, err)
+	// Wrong store or status
+	_, err = payments.Extend("foo", 2, "pending", 5, 60, nil)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = payments.Extend("foo", 1, "paid", 5, 60, nil)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryPaymentRepositoryAggregate(t *testing.T) {
+	payments := NewMemoryPaymentRepository()
+
+	add := func(id, status, currency string, amount int64, creationTime time.Time, secondsToPayment int) {
+		p := &Payment{PaymentID: id, Status: status, Currency: currency, CurrencyAmount: decimal.NewFromInt(amount), AtomicDeroAmount: uint64(amount), IntegratedAddress: id, StoreID: 1}
+		assert.Nil(t, payments.Insert(p, "created"))
+
+		stored := payments.payments[id]
+		stored.CreationTime = creationTime
+		if status == "paid" {
+			paidTime := creationTime.Add(time.Duration(secondsToPayment) * time.Second)
+			stored.PaidTime = &paidTime
+		}
+	}
+
+	day1 := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2020, 5, 2, 23, 30, 0, 0, time.UTC) // May 3rd in Rome
+	add("a", "paid", "USD", 10, day1, 60)
+	add("b", "paid", "USD", 20, day1, 180)
+	add("c", "expired", "USD", 5, day1, 0)
+	add("d", "paid", "USD", 30, day2, 120)
+	add("e", "paid", "USD", 40, day2.AddDate(0, 1, 0), 0) // Out of range
+
+	from, to := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 5, 4, 0, 0, 0, 0, time.UTC)
+	as, err := payments.Aggregate(1, "day", time.UTC, from, to)
+	assert.Nil(t, err)
+	if assert.Len(t, as, 3) {
+		for _, a := range as[:2] {
+			assert.Equal(t, "2020-05-01", a.Period)
+			if a.Status == "paid" {
+				assert.Equal(t, 2, a.Count)
+				assert.True(t, decimal.NewFromInt(30).Equal(a.CurrencyAmount))
+				assert.Equal(t, uint64(30), a.AtomicDeroAmount)
+				assert.Equal(t, int64(2), a.PaidCount)
+				assert.Equal(t, int64(240), a.PaidSeconds)
+			} else {
+				assert.Equal(t, "expired", a.Status)
+				assert.Zero(t, a.PaidCount)
+			}
+		}
+		assert.Equal(t, "2020-05-02", as[2].Period)
+	}
+
+	rome, _ := time.LoadLocation("Europe/Rome")
+	as, err = payments.Aggregate(1, "day", rome, from, to)
+	assert.Nil(t, err)
+	assert.Equal(t, "2020-05-03", as[len(as)-1].Period)
+
+	as, err = payments.Aggregate(1, "week", time.UTC, from, to)
+	assert.Nil(t, err)
+	for _, a := range as {
+		assert.Equal(t, "2020-04-27", a.Period) // Monday
+	}
+
+	as, err = payments.Aggregate(1, "month", rome, from, to)
+	assert.Nil(t, err)
+	for _, a := range as {
+		assert.Equal(t, "2020-05-01", a.Period)
+	}
+
+	as, err = payments.Aggregate(2, "day", time.UTC, from, to)
+	assert.Nil(t, err)
+	assert.Empty(t, as)
+}
+
+func TestMemoryInvoiceRepository(t *testing.T) {
+	repos := NewMemory()
+	invoices, payments := repos.Invoices, repos.Payments
+
+	today := time.Now().UTC().Format(InvoiceDateLayout)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(InvoiceDateLayout)
+	i := &Invoice{InvoiceID: "foo", Status: InvoiceStatusOpen, Currency: "DERO", LineItems: json.RawMessage(`[]`), Total: decimal.NewFromInt(1), DueDate: today, StoreID: 1}
+	assert.Nil(t, invoices.Insert(i))
+	assert.NotZero(t, i.ID)
+	assert.False(t, i.CreationTime.IsZero())
+	assert.NotNil(t, invoices.Insert(&Invoice{InvoiceID: "foo"}))
+
+	exists, err := invoices.InvoiceIDExists("foo")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = invoices.InvoiceIDExists("bar")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	found, err := invoices.ByInvoiceID("foo")
+	assert.Nil(t, err)
+	assert.Equal(t, i, found)
+	assert.False(t, found.IsOverdue())
+	found.DueDate = yesterday
+	assert.True(t, found.IsOverdue())
+	_, err = invoices.ByInvoiceID("bar")
+	assert.Equal(t, ErrNotFound, err)
+
+	// Test payments created for the invoice
+	existing, err := payments.InsertForInvoice(&Payment{PaymentID: "p1", Status: "pending", IntegratedAddress: "iaddr1", InvoiceID: i.ID, StoreID: 1}, "created")
+	assert.Nil(t, err)
+	assert.Empty(t, existing)
+	found, _ = invoices.ByInvoiceID("foo")
+	assert.Equal(t, "p1", found.PaymentID)
+
+	_, err = payments.InsertForInvoice(&Payment{PaymentID: "p2", Status: "pending", IntegratedAddress: "iaddr2", InvoiceID: i.ID + 1, StoreID: 1}, "created")
+	assert.Equal(t, ErrNotFound, err)
+
+	// Test MarkPaid
+	assert.Nil(t, invoices.MarkPaid(i.ID, "p1"))
+	found, _ = invoices.ByInvoiceID("foo")
+	assert.Equal(t, InvoiceStatusPaid, found.Status)
+	assert.NotNil(t, found.PaidTime)
+
+	_, err = payments.InsertForInvoice(&Payment{PaymentID: "p3", Status: "pending", IntegratedAddress: "iaddr3", InvoiceID: i.ID, StoreID: 1}, "created")
+	assert.Equal(t, ErrInvoiceNotOpen, err)
+
+	// Test Void
+	assert.Equal(t, ErrInvoiceNotOpen, invoices.Void(i.ID))
+	voided := &Invoice{InvoiceID: "bar", Status: InvoiceStatusOpen, DueDate: today, StoreID: 1}
+	assert.Nil(t, invoices.Insert(voided))
+	assert.Nil(t, invoices.Void(voided.ID))
+	assert.Nil(t, invoices.MarkPaid(voided.ID, "p1")) // Left unchanged
+	found, _ = invoices.ByInvoiceID("bar")
+	assert.Equal(t, InvoiceStatusVoid, found.Status)
+	assert.Empty(t, found.PaymentID)
+}
+
+func TestMemorySubscriptionRepository(t *testing.T) {
+	repos := NewMemory()
+	invoices, subscriptions := repos.Invoices, repos.Subscriptions
+
+	today := time.Now().UTC().Format(InvoiceDateLayout)
+	s := &Subscription{SubscriptionID: "foo", Status: SubscriptionStatusActive, Interval: "month", IntervalCount: 1, StartDate: today, NextBillingDate: today, StoreID: 1}
+	assert.Nil(t, subscriptions.Insert(s))
+	assert.NotZero(t, s.ID)
+	assert.NotNil(t, subscriptions.Insert(&Subscription{SubscriptionID: "foo"}))
+
+	exists, err := subscriptions.SubscriptionIDExists("foo")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	due, err := subscriptions.Due()
+	assert.Nil(t, err)
+	assert.Len(t, due, 1)
+
+	// Test Bill
+	i := &Invoice{InvoiceID: "inv1", Status: InvoiceStatusOpen, DueDate: today, SubscriptionID: s.ID, StoreID: 1}
+	billed, err := subscriptions.Bill(due[0], i)
+	assert.Nil(t, err)
+	assert.True(t, billed)
+	billed, err = subscriptions.Bill(due[0], &Invoice{InvoiceID: "inv2", SubscriptionID: s.ID})
+	assert.Nil(t, err)
+	assert.False(t, billed)
+	exists, _ = invoices.InvoiceIDExists("inv2")
+	assert.False(t, exists)
+
+	found, err := subscriptions.BySubscriptionID("foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, found.BilledCycles)
+	assert.Equal(t, "inv1", found.InvoiceID)
+	assert.NotEqual(t, today, found.NextBillingDate)
+
+	due, err = subscriptions.Due()
+	assert.Nil(t, err)
+	assert.Empty(t, due)
+
+	// Test UpdateMissedCycles
+	overdue := &Invoice{InvoiceID: "inv0", Status: InvoiceStatusOpen, DueDate: time.Now().UTC().AddDate(0, 0, -1).Format(InvoiceDateLayout), SubscriptionID: s.ID, StoreID: 1}
+	assert.Nil(t, invoices.Insert(overdue))
+	changes, err := subscriptions.UpdateMissedCycles()
+	assert.Nil(t, err)
+	assert.Equal(t, []*SubscriptionStatusChange{{SubscriptionID: "foo", OldStatus: SubscriptionStatusActive, NewStatus: SubscriptionStatusPastDue, StoreID: 1}}, changes)
+	changes, err = subscriptions.UpdateMissedCycles()
+	assert.Nil(t, err)
+	assert.Empty(t, changes)
+
+	assert.Nil(t, invoices.MarkPaid(overdue.ID, "p1"))
+	changes, err = subscriptions.UpdateMissedCycles()
+	assert.Nil(t, err)
+	assert.Equal(t, []*SubscriptionStatusChange{{SubscriptionID: "foo", OldStatus: SubscriptionStatusPastDue, NewStatus: SubscriptionStatusActive, StoreID: 1}}, changes)
+
+	// Test Cancel
+	assert.Nil(t, subscriptions.Cancel(s.ID))
+	assert.Equal(t, ErrNotFound, subscriptions.Cancel(s.ID))
+	found, _ = subscriptions.BySubscriptionID("foo")
+	assert.Equal(t, SubscriptionStatusCancelled, found.Status)
+	assert.NotNil(t, found.CancelledTime)
+	billed, err = subscriptions.Bill(found, &Invoice{InvoiceID: "inv3", SubscriptionID: s.ID})
+	assert.Nil(t, err)
+	assert.False(t, billed)
+}
+
+func TestNextBillingDate(t *testing.T) {
+	tests := []struct {
+		StartDate     string
+		Interval      string
+		IntervalCount int
+		BilledCycles  int
+
+		Expected string
+	}{
+		{"2020-01-31", "day", 1, 0, "2020-02-01"},
+		{"2020-01-31", "week", 2, 1, "2020-02-28"},
+		{"2020-01-31", "month", 1, 0, "2020-02-29"},
+		{"2020-01-31", "month", 1, 1, "2020-03-31"},
+		{"2020-01-31", "month", 3, 0, "2020-04-30"},
+		{"2020-02-29", "year", 1, 0, "2021-02-28"},
+		{"2020-02-29", "year", 1, 3, "2024-02-29"},
+	}
+
+	for _, test := range tests {
+		next, err := nextBillingDate(&Subscription{StartDate: test.StartDate, Interval: test.Interval, IntervalCount: test.IntervalCount, BilledCycles: test.BilledCycles})
+		assert.Nil(t, err)
+		assert.Equal(t, test.Expected, next)
+	}
+
+	_, err := nextBillingDate(&Subscription{StartDate: "31/01/2020", Interval: "day", IntervalCount: 1})
+	assert.NotNil(t, err)
+}
+
+func TestMemoryLinkRepository(t *testing.T) {
+	repos := NewMemory()
+	links, payments := repos.Links, repos.Payments
+
+	amount := decimal.NewFromInt(5)
+	fixed := &PaymentLink{Slug: "fixed", Title: "Fixed", Currency: "DERO", Amount: &amount, Active: true, StoreID: 1}
+	open := &PaymentLink{Slug: "open", Title: "Open", Currency: "DERO", Active: true, StoreID: 1}
+	assert.Nil(t, links.Insert(fixed))
+	assert.Nil(t, links.Insert(open))
+	assert.NotNil(t, links.Insert(&PaymentLink{Slug: "fixed"}))
+
+	exists, err := links.SlugExists("fixed")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	l, err := links.BySlug("fixed")
+	assert.Nil(t, err)
+	assert.Equal(t, fixed, l)
+	*l.Amount = decimal.NewFromInt(6) // Returned links are copies
+	l, _ = links.ByID(fixed.ID)
+	assert.True(t, amount.Equal(*l.Amount))
+	_, err = links.ByID(open.ID + 1)
+	assert.Equal(t, ErrNotFound, err)
+
+	for i, p := range []struct {
+		LinkID int
+		Status string
+	}{
+		{fixed.ID, PaymentStatusPaid},
+		{fixed.ID, PaymentStatusExpired},
+		{open.ID, PaymentStatusPending},
+		{0, PaymentStatusPaid},
+	} {
+		id := string(rune('a' + i))
+		assert.Nil(t, payments.Insert(&Payment{PaymentID: id, Status: p.Status, CurrencyAmount: amount, IntegratedAddress: id, PaymentLinkID: p.LinkID, StoreID: 1}, "created"))
+	}
+
+	ls, err := links.ByStore(1)
+	assert.Nil(t, err)
+	if assert.Len(t, ls, 2) {
+		assert.Equal(t, open.ID, ls[0].ID) // Newest first
+		assert.Equal(t, LinkStats{Payments: 1}, ls[0].Stats)
+		assert.Equal(t, 2, ls[1].Stats.Payments)
+		assert.Equal(t, 1, ls[1].Stats.PaidPayments)
+		assert.True(t, amount.Equal(ls[1].Stats.PaidAmount))
+	}
+
+	// Test updates
+	assert.Nil(t, links.SetActive(fixed.ID, 1, false))
+	l, _ = links.ByID(fixed.ID)
+	assert.False(t, l.Active)
+	assert.Equal(t, ErrNotFound, links.SetActive(fixed.ID, 2, true))
+
+	assert.Nil(t, links.Remove(open.ID, 1))
+	assert.Equal(t, ErrNotFound, links.Remove(open.ID, 1))
+	assert.Equal(t, ErrNotFound, links.SetActive(open.ID, 1, true))
+	l, err = links.BySlug("open")
+	assert.Nil(t, err)
+	assert.True(t, l.Removed)
+	assert.False(t, l.Active)
+
+	ls, _ = links.ByStore(1)
+	assert.Len(t, ls, 1)
+}
+
+func TestMemoryIdempotencyRepository(t *testing.T) {
+	keys := NewMemoryIdempotencyRepository()
+
+	k := &IdempotencyKey{StoreID: 1, Key: "foo", RequestHash: "hash1"}
+	stored, err := keys.Reserve(k, time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+	assert.False(t, k.CreationTime.IsZero())
+
+	// Keys are reserved per store
+	stored, err = keys.Reserve(&IdempotencyKey{StoreID: 2, Key: "foo", RequestHash: "hash2"}, time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+
+	stored, err = keys.Reserve(&IdempotencyKey{StoreID: 1, Key: "foo", RequestHash: "hash2"}, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "hash1", stored.RequestHash)
+	assert.Zero(t, stored.ResponseStatus)
+
+	// Test reclaiming an abandoned key
+	keys.keys[idempotencyKeyID{1, "foo"}].CreationTime = time.Now().Add(-2 * time.Hour)
+	stored, err = keys.Reserve(&IdempotencyKey{StoreID: 1, Key: "foo", RequestHash: "hash3"}, time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+	assert.Equal(t, "hash3", keys.keys[idempotencyKeyID{1, "foo"}].RequestHash)
+
+	// Test SaveResponse
+	assert.Nil(t, keys.SaveResponse(1, "foo", 201, []byte(`{}`)))
+	assert.Equal(t, ErrNotFound, keys.SaveResponse(1, "bar", 201, []byte(`{}`)))
+	stored, _ = keys.Reserve(&IdempotencyKey{StoreID: 1, Key: "foo", RequestHash: "hash3"}, time.Hour)
+	assert.Equal(t, 201, stored.ResponseStatus)
+	assert.Equal(t, []byte(`{}`), stored.ResponseBody)
+
+	// Keys with a stored response are never reclaimed
+	keys.keys[idempotencyKeyID{1, "foo"}].CreationTime = time.Now().Add(-2 * time.Hour)
+	stored, _ = keys.Reserve(&IdempotencyKey{StoreID: 1, Key: "foo", RequestHash: "hash3"}, time.Hour)
+	assert.Equal(t, 201, stored.ResponseStatus)
+
+	// Test Delete
+	assert.Nil(t, keys.Delete(1, "foo"))
+	stored, _ = keys.Reserve(&IdempotencyKey{StoreID: 1, Key: "foo", RequestHash: "hash2"}, time.Hour)
+	assert.Nil(t, stored)
+
+	// Test DeleteExpired
+	keys.keys[idempotencyKeyID{1, "foo"}].CreationTime = time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, keys.DeleteExpired(time.Hour))
+	assert.Len(t, keys.keys, 1)
+	_, ok := keys.keys[idempotencyKeyID{2, "foo"}]
+	assert.True(t, ok)
+}
@@ -0,0 +1,1150 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Payment statuses stored in DB
+const (
+	PaymentStatusPending   = "pending"
+	PaymentStatusPaid      = "paid"
+	PaymentStatusExpired   = "expired"
+	PaymentStatusError     = "error"
+	PaymentStatusCancelled = "cancelled" // Cancelled by the store before being paid or expiring
+)
+
+// Invoice statuses stored in DB
+const (
+	InvoiceStatusOpen = "open"
+	InvoiceStatusPaid = "paid"
+	InvoiceStatusVoid = "void" // Voided by the store before being paid
+)
+
+// Payment represents a payment made to a store, as stored in DB.
+// Its params are validated and quoted by package api.
+type Payment struct {
+	PaymentID             string
+	Status                string
+	Currency              string
+	CurrencyAmount        decimal.Decimal
+	ExchangeRate          decimal.Decimal
+	DeroAmount            string
+	AtomicDeroAmount      uint64
+	MinAtomicDeroAmount   uint64
+	IntegratedAddress     string
+	MaxTTL                int
+	MinConfirmations      int
+	RequiredConfirmations int
+	TxIDs                 []string
+	CreationTime          time.Time
+	PaidTime              *time.Time
+	StoreID               int
+
+	// Pricing policy of the store at the time the payment was quoted
+	RateMarkup            decimal.Decimal
+	UnderpaymentTolerance decimal.Decimal
+	DeroDecimals          int32
+
+	// Metadata
+	OrderID       string
+	Description   string
+	CustomerEmail string
+	Metadata      json.RawMessage
+
+	// Links to other payments and to the payment link or invoice the payment was created from (empty if none)
+	RequotedFrom  string
+	RequotedTo    string
+	SuccessURL    string
+	CancelURL     string
+	PaymentLinkID int
+	InvoiceID     int
+
+	// MinsFromCreation is the number of minutes (rounded up) passed from the creation of the payment when it was fetched
+	MinsFromCreation int
+}
+
+func (p Payment) clone() *Payment {
+	p.TxIDs = append([]string(nil), p.TxIDs...)
+	p.Metadata = append(json.RawMessage(nil), p.Metadata...)
+	return &p
+}
+
+// PaymentQuote is the amount of DERO due for a payment at an exchange rate
+type PaymentQuote struct {
+	ExchangeRate          decimal.Decimal
+	DeroAmount            string
+	AtomicDeroAmount      uint64
+	MinAtomicDeroAmount   uint64
+	RequiredConfirmations int
+}
+
+// StatusChange represents a transition of the status of a payment, as recorded in its status history
+type StatusChange struct {
+	// OldStatus is empty for the creation of the payment
+	OldStatus string    `json:"oldStatus,omitempty"`
+	NewStatus string    `json:"newStatus"`
+	Cause     string    `json:"cause"`
+	Time      time.Time `json:"time"`
+}
+
+// WebhookDelivery represents a delivery of the status of a payment to the webhook of its store
+type WebhookDelivery struct {
+	// Status is the status of the payment sent to the webhook
+	Status string `json:"status"`
+	// ResponseStatus is the HTTP status code of the response of the webhook, 0 if no response was received
+	ResponseStatus int       `json:"responseStatus"`
+	Error          string    `json:"error,omitempty"`
+	Time           time.Time `json:"time"`
+}
+
+// Succeeded returns whether the webhook received the status of the payment
+func (d *WebhookDelivery) Succeeded() bool {
+	return d.Error == "" && d.ResponseStatus >= 200 && d.ResponseStatus <= 299
+}
+
+// PaymentAggregate represents the payments of a store created in a period with the same status and currency
+type PaymentAggregate struct {
+	// Period is the first day of the period (YYYY-MM-DD)
+	Period           string
+	Status           string
+	Currency         string
+	Count            int
+	CurrencyAmount   decimal.Decimal
+	AtomicDeroAmount uint64
+	// PaidCount is the number of payments with a paid time and PaidSeconds the sum of the seconds they took to get paid since their creation
+	PaidCount   int64
+	PaidSeconds int64
+}
+
+// PaymentRepository stores payments, along with their status history and webhook deliveries.
+// Status histories are append-only: every change of status is recorded atomically with the change itself.
+type PaymentRepository interface {
+	// Insert stores p, setting its creation time, and records its creation with cause
+	Insert(p *Payment, cause string) error
+	// InsertAll stores all of ps as Insert does, in a single transaction
+	InsertAll(ps []*Payment, cause string) error
+	// InsertForInvoice stores p, created for invoice p.InvoiceID, as Insert does and links the invoice to it.
+	// The invoice is locked meanwhile, so that concurrent calls create at most one payment in the status of p for it:
+	// if the invoice already has one, p is not stored and its Payment ID is returned instead.
+	// It returns ErrNotFound if the invoice does not exist and ErrInvoiceNotOpen if it is not open.
+	InsertForInvoice(p *Payment, cause string) (existingPaymentID string, err error)
+	// Requote stores p, re-quoted from the expired payment p.RequotedFrom of its store, as Insert does
+	// (or as InsertForInvoice does if it was created for an invoice) and links the expired payment to it, in a single transaction.
+	// It returns ErrNotFound if the expired payment does not exist.
+	Requote(p *Payment, cause string) (existingPaymentID string, err error)
+	// UsedIdentifiers returns which of paymentIDs and integratedAddresses are already used by stored payments
+	UsedIdentifiers(paymentIDs, integratedAddresses []string) (map[string]bool, error)
+	// ByID returns the payment with paymentID, or ErrNotFound
+	ByID(paymentID string) (*Payment, error)
+	// ByIDs returns the payments of a store with paymentIDs. Payment IDs that are not found are ignored.
+	ByIDs(storeID int, paymentIDs []string) ([]*Payment, error)
+	// ByRequotedFrom returns the payment an expired payment was re-quoted into, or ErrNotFound
+	ByRequotedFrom(paymentID string) (*Payment, error)
+	// ByInvoice returns the latest payment in status created for an invoice, or ErrNotFound
+	ByInvoice(invoiceID int, status string) (*Payment, error)
+	// Count returns the number of payments matching f
+	Count(f *PaymentFilter) (int, error)
+	// Aggregate returns the aggregates of the payments of a store created from from (inclusive) to to (exclusive),
+	// by status, currency and period of interval (day, week or month) in loc, sorted by period
+	Aggregate(storeID int, interval string, loc *time.Location, from, to time.Time) ([]*PaymentAggregate, error)
+	// Find returns the payments matching q, sorted as requested by q
+	Find(q *PaymentQuery) ([]*Payment, error)
+	// Each calls fn for each of the payments matching q, sorted as requested by q, without keeping them all in memory.
+	// It stops at the first error returned by fn.
+	Each(q *PaymentQuery, fn func(p *Payment) error) error
+	// Extend adds minutes to the max TTL of a payment of a store in status, and sets its quote if not nil.
+	// The payment is only updated if its TTL has not elapsed yet and its minutes left do not exceed maxTTL afterwards.
+	// It returns the updated payment, or ErrNotFound if no payment was updated.
+	Extend(paymentID string, storeID int, status string, minutes, maxTTL int, quote *PaymentQuote) (*Payment, error)
+	// ChangeStatus changes the status of a payment from oldStatus to newStatus, recording the change with cause.
+	// txIDs are stored along with it, and so is the paid time if paid is true.
+	// It returns ErrUnexpectedStatus if the payment does not exist or its status is not oldStatus.
+	ChangeStatus(paymentID, oldStatus, newStatus, cause string, txIDs []string, paid bool) error
+	// ChangeAllStatuses changes the status of all the payments in oldStatus to newStatus, recording the changes with cause
+	ChangeAllStatuses(oldStatus, newStatus, cause string) error
+	// StatusHistory returns the status changes of a payment in chronological order
+	StatusHistory(paymentID string) ([]*StatusChange, error)
+	// AddWebhookDelivery records a delivery to the webhook of the store of a payment, setting its time
+	AddWebhookDelivery(paymentID string, d *WebhookDelivery) error
+	// WebhookDeliveries returns the webhook deliveries of a payment in chronological order
+	WebhookDeliveries(paymentID string) ([]*WebhookDelivery, error)
+}
+
+// PostgresPaymentRepository is a PaymentRepository backed by tables payments, payment_status_history and webhook_deliveries of DB
+type PostgresPaymentRepository struct {
+	DB *sql.DB
+}
+
+// txIDsValue returns the JSON array of txIDs stored in DB, or NULL if there are none
+func txIDsValue(txIDs []string) (interface{}, error) {
+	if len(txIDs) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(txIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal transaction IDs")
+	}
+	return string(b), nil
+}
+
+// paymentColumns are the columns of table payments scanned by scanPayment
+const paymentColumns = `payment_id, status, currency, currency_amount, exchange_rate, dero_amount, atomic_dero_amount, min_atomic_dero_amount,
+	integrated_address, max_ttl, min_confirmations, required_confirmations, tx_ids, creation_time, paid_time, store_id,
+	rate_markup, underpayment_tolerance, dero_decimals, order_id, description, customer_email, metadata,
+	COALESCE(requoted_from, ''), COALESCE(requoted_to, ''), success_url, cancel_url, COALESCE(payment_link_id, 0), COALESCE(invoice_id, 0),
+	CEIL(EXTRACT('epoch' FROM NOW() - creation_time) / 60)`
+
+func scanPayment(row interface{ Scan(...interface{}) error }) (*Payment, error) {
+	var (
+		p     Payment
+		txIDs []byte
+	)
+	err := row.Scan(&p.PaymentID, &p.Status, &p.Currency, &p.CurrencyAmount, &p.ExchangeRate, &p.DeroAmount, &p.AtomicDeroAmount, &p.MinAtomicDeroAmount,
+		&p.IntegratedAddress, &p.MaxTTL, &p.MinConfirmations, &p.RequiredConfirmations, &txIDs, &p.CreationTime, &p.PaidTime, &p.StoreID,
+		&p.RateMarkup, &p.UnderpaymentTolerance, &p.DeroDecimals, &p.OrderID, &p.Description, &p.CustomerEmail, (*[]byte)(&p.Metadata), // Scanned as []byte, since json.RawMessage does not support NULL
+		&p.RequotedFrom, &p.RequotedTo, &p.SuccessURL, &p.CancelURL, &p.PaymentLinkID, &p.InvoiceID,
+		&p.MinsFromCreation)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "cannot scan row")
+	}
+
+	if txIDs != nil {
+		err = json.Unmarshal(txIDs, &p.TxIDs)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot unmarshal transaction IDs")
+		}
+	}
+
+	return &p, nil
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// nullIfZero returns v, or NULL if v is the zero value of its type
+func nullIfZero(v interface{}) interface{} {
+	switch v {
+	case "", 0:
+		return nil
+	}
+	return v
+}
+
+// insertPayment stores p and records its creation with cause, as the first change of its status history
+func insertPayment(q queryRower, p *Payment, cause string) error {
+	txIDs, err := txIDsValue(p.TxIDs)
+	if err != nil {
+		return err
+	}
+
+	var metadata interface{} // NULL if not set. JSON is sent as string, since []byte would be encoded as bytea
+	if len(p.Metadata) > 0 {
+		metadata = string(p.Metadata)
+	}
+
+	err = q.QueryRow(`
+		WITH inserted AS (
+			INSERT INTO payments (payment_id, status, currency, currency_amount, exchange_rate, dero_amount, atomic_dero_amount, min_atomic_dero_amount,
+				integrated_address, max_ttl, min_confirmations, required_confirmations, tx_ids, paid_time, store_id,
+				rate_markup, underpayment_tolerance, dero_decimals, order_id, description, customer_email, metadata,
+				requoted_from, success_url, cancel_url, payment_link_id, invoice_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27)
+			RETURNING payment_id, status, creation_time
+		), history AS (
+			INSERT INTO payment_status_history (payment_id, new_status, cause, time)
+			SELECT payment_id, status, $28, creation_time FROM inserted
+		)
+		SELECT creation_time FROM inserted`, p.PaymentID, p.Status, p.Currency, p.CurrencyAmount, p.ExchangeRate, p.DeroAmount, p.AtomicDeroAmount, p.MinAtomicDeroAmount,
+		p.IntegratedAddress, p.MaxTTL, p.MinConfirmations, p.RequiredConfirmations, txIDs, p.PaidTime, p.StoreID,
+		p.RateMarkup, p.UnderpaymentTolerance, p.DeroDecimals, p.OrderID, p.Description, p.CustomerEmail, metadata,
+		nullIfZero(p.RequotedFrom), p.SuccessURL, p.CancelURL, nullIfZero(p.PaymentLinkID), nullIfZero(p.InvoiceID), cause).
+		Scan(&p.CreationTime)
+	if err != nil {
+		return errors.Wrap(err, "cannot query database")
+	}
+
+	return nil
+}
+
+// Insert stores p, setting its creation time, and records its creation with cause
+func (r *PostgresPaymentRepository) Insert(p *Payment, cause string) error {
+	return insertPayment(r.DB, p, cause)
+}
+
+// InsertAll stores all of ps as Insert does, in a single transaction
+func (r *PostgresPaymentRepository) InsertAll(ps []*Payment, cause string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "cannot begin DB TX")
+	}
+	defer tx.Rollback()
+
+	for _, p := range ps {
+		err = insertPayment(tx, p, cause)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "cannot commit DB TX")
+	}
+
+	return nil
+}
+
+// InsertForInvoice stores p, created for invoice p.InvoiceID, as Insert does and links the invoice to it.
+// The invoice is locked FOR UPDATE until the transaction is committed.
+func (r *PostgresPaymentRepository) InsertForInvoice(p *Payment, cause string) (existingPaymentID string, err error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot begin DB TX")
+	}
+	defer tx.Rollback()
+
+	existingPaymentID, err = insertForInvoice(tx, p, cause)
+	if err != nil || existingPaymentID != "" {
+		return existingPaymentID, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot commit DB TX")
+	}
+
+	return "", nil
+}
+
+// insertForInvoice stores p and links invoice p.InvoiceID to it within tx, as InsertForInvoice does
+func insertForInvoice(tx *sql.Tx, p *Payment, cause string) (existingPaymentID string, err error) {
+	var status string
+	err = tx.QueryRow(`
+		SELECT status
+		FROM invoices
+		WHERE id=$1
+		FOR UPDATE`, p.InvoiceID).
+		Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", errors.Wrap(err, "cannot query database")
+	}
+	if status != InvoiceStatusOpen {
+		return "", ErrInvoiceNotOpen
+	}
+
+	// Another call may have created a payment while the invoice was locked
+	err = tx.QueryRow(`
+		SELECT payment_id
+		FROM payments
+		WHERE invoice_id=$1 AND status=$2
+		ORDER BY creation_time DESC
+		LIMIT 1`, p.InvoiceID, p.Status).
+		Scan(&existingPaymentID)
+	if err == nil {
+		return existingPaymentID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", errors.Wrap(err, "cannot query database")
+	}
+
+	err = insertPayment(tx, p, cause)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`
+		UPDATE invoices
+		SET payment_id=$1
+		WHERE id=$2`, p.PaymentID, p.InvoiceID)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot execute query")
+	}
+
+	return "", nil
+}
+
+// Requote stores p and links the expired payment p.RequotedFrom to it in a single transaction
+func (r *PostgresPaymentRepository) Requote(p *Payment, cause string) (existingPaymentID string, err error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot begin DB TX")
+	}
+	defer tx.Rollback()
+
+	if p.InvoiceID != 0 {
+		existingPaymentID, err = insertForInvoice(tx, p, cause)
+		if err != nil || existingPaymentID != "" {
+			return existingPaymentID, err
+		}
+	} else {
+		err = insertPayment(tx, p, cause)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = checkUpdated(tx.Exec(`
+		UPDATE payments
+		SET requoted_to=$1
+		WHERE payment_id=$2 AND store_id=$3`, p.PaymentID, p.RequotedFrom, p.StoreID))
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot commit DB TX")
+	}
+
+	return "", nil
+}
+
+// UsedIdentifiers returns which of paymentIDs and integratedAddresses are already used by stored payments
+func (r *PostgresPaymentRepository) UsedIdentifiers(paymentIDs, integratedAddresses []string) (map[string]bool, error) {
+	rows, err := r.DB.Query(`
+		SELECT payment_id, integrated_address
+		FROM payments
+		WHERE payment_id = ANY($1) OR integrated_address = ANY($2)`, pq.Array(paymentIDs), pq.Array(integratedAddresses))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	used := make(map[string]bool)
+	for rows.Next() {
+		var paymentID, integratedAddress string
+		err = rows.Scan(&paymentID, &integratedAddress)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan row")
+		}
+		used[paymentID], used[integratedAddress] = true, true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return used, nil
+}
+
+// ByID returns the payment with paymentID, or ErrNotFound
+func (r *PostgresPaymentRepository) ByID(paymentID string) (*Payment, error) {
+	return scanPayment(r.DB.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE payment_id=$1`, paymentID))
+}
+
+// ByIDs returns the payments of a store with paymentIDs. Payment IDs that are not found are ignored.
+func (r *PostgresPaymentRepository) ByIDs(storeID int, paymentIDs []string) ([]*Payment, error) {
+	return r.query(`
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE store_id=$1 AND payment_id = ANY($2)`, storeID, pq.Array(paymentIDs))
+}
+
+// ByRequotedFrom returns the payment an expired payment was re-quoted into, or ErrNotFound
+func (r *PostgresPaymentRepository) ByRequotedFrom(paymentID string) (*Payment, error) {
+	return scanPayment(r.DB.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE requoted_from=$1`, paymentID))
+}
+
+// ByInvoice returns the latest payment in status created for an invoice, or ErrNotFound
+func (r *PostgresPaymentRepository) ByInvoice(invoiceID int, status string) (*Payment, error) {
+	return scanPayment(r.DB.QueryRow(`
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE invoice_id=$1 AND status=$2
+		ORDER BY creation_time DESC
+		LIMIT 1`, invoiceID, status))
+}
+
+// Count returns the number of payments matching f
+func (r *PostgresPaymentRepository) Count(f *PaymentFilter) (n int, err error) {
+	where, args := f.where()
+	err = r.DB.QueryRow(`SELECT COUNT(*) FROM payments WHERE `+where, args...).Scan(&n)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot query database")
+	}
+
+	return n, nil
+}
+
+// Aggregate returns the aggregates of the payments of a store created in a range, computed with SQL aggregates.
+// Creation times are stored in UTC, and truncated to the start of their period in loc.
+func (r *PostgresPaymentRepository) Aggregate(storeID int, interval string, loc *time.Location, from, to time.Time) ([]*PaymentAggregate, error) {
+	rows, err := r.DB.Query(`
+		SELECT to_char(date_trunc($2, (creation_time AT TIME ZONE 'UTC') AT TIME ZONE $3), 'YYYY-MM-DD') AS period, status, currency,
+			COUNT(*), SUM(currency_amount), SUM(atomic_dero_amount),
+			COUNT(paid_time), COALESCE(SUM(EXTRACT(EPOCH FROM paid_time - creation_time)), 0)::bigint
+		FROM payments
+		WHERE store_id=$1 AND creation_time>=$4 AND creation_time<$5
+		GROUP BY period, status, currency
+		ORDER BY period`, storeID, interval, loc.String(), from.UTC(), to.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	var as []*PaymentAggregate
+	for rows.Next() {
+		var a PaymentAggregate
+		err = rows.Scan(&a.Period, &a.Status, &a.Currency, &a.Count, &a.CurrencyAmount, &a.AtomicDeroAmount, &a.PaidCount, &a.PaidSeconds)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan row")
+		}
+
+		as = append(as, &a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return as, nil
+}
+
+// Find returns the payments matching q, sorted as requested by q
+func (r *PostgresPaymentRepository) Find(q *PaymentQuery) ([]*Payment, error) {
+	query, args := q.sql()
+	return r.query(query, args...)
+}
+
+// Each calls fn for each of the payments matching q, sorted as requested by q, scanning them one by one
+func (r *PostgresPaymentRepository) Each(q *PaymentQuery, fn func(p *Payment) error) error {
+	query, args := q.sql()
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return err
+		}
+
+		err = fn(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return nil
+}
+
+func (r *PostgresPaymentRepository) query(query string, args ...interface{}) ([]*Payment, error) {
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	var ps []*Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		ps = append(ps, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return ps, nil
+}
+
+// Extend adds minutes to the max TTL of a payment of a store in status, and sets its quote if not nil.
+// The TTL is checked in the same statement, since the payment may have been extended in the meantime.
+func (r *PostgresPaymentRepository) Extend(paymentID string, storeID int, status string, minutes, maxTTL int, quote *PaymentQuote) (*Payment, error) {
+	query := `
+		UPDATE payments
+		SET max_ttl=max_ttl+$1`
+	args := []interface{}{minutes, paymentID, storeID, status, maxTTL}
+	if quote != nil {
+		query += `, exchange_rate=$6, dero_amount=$7, atomic_dero_amount=$8, min_atomic_dero_amount=$9, required_confirmations=$10`
+		args = append(args, quote.ExchangeRate, quote.DeroAmount, quote.AtomicDeroAmount, quote.MinAtomicDeroAmount, quote.RequiredConfirmations)
+	}
+	query += `
+		WHERE payment_id=$2 AND store_id=$3 AND status=$4
+			AND max_ttl > CEIL(EXTRACT('epoch' FROM NOW() - creation_time) / 60)
+			AND max_ttl + $1 - CEIL(EXTRACT('epoch' FROM NOW() - creation_time) / 60) <= $5
+		RETURNING ` + paymentColumns
+
+	return scanPayment(r.DB.QueryRow(query, args...))
+}
+
+// ChangeStatus changes the status of a payment from oldStatus to newStatus, recording the change with cause.
+// The status and its history are updated in the same statement.
+func (r *PostgresPaymentRepository) ChangeStatus(paymentID, oldStatus, newStatus, cause string, txIDs []string, paid bool) error {
+	txIDsJSON, err := txIDsValue(txIDs)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.Exec(`
+		WITH updated AS (
+			UPDATE payments 
+			SET status=$1, tx_ids=$4, paid_time=CASE WHEN $5 THEN NOW() ELSE NULL END 
+			WHERE payment_id=$2 AND status=$3
+			RETURNING payment_id
+		)
+		INSERT INTO payment_status_history (payment_id, old_status, new_status, cause)
+		SELECT payment_id, $3, $1, $6 FROM updated`, newStatus, paymentID, oldStatus, txIDsJSON, paid, cause)
+	err = checkUpdated(res, err)
+	if err == ErrNotFound {
+		return ErrUnexpectedStatus
+	}
+	return err
+}
+
+// ChangeAllStatuses changes the status of all the payments in oldStatus to newStatus, recording the changes with cause
+func (r *PostgresPaymentRepository) ChangeAllStatuses(oldStatus, newStatus, cause string) error {
+	_, err := r.DB.Exec(`
+		WITH updated AS (
+			UPDATE payments
+			SET status=$1
+			WHERE status=$2
+			RETURNING payment_id
+		)
+		INSERT INTO payment_status_history (payment_id, old_status, new_status, cause)
+		SELECT payment_id, $2, $1, $3 FROM updated`, newStatus, oldStatus, cause)
+	if err != nil {
+		return errors.Wrap(err, "cannot execute query")
+	}
+
+	return nil
+}
+
+// StatusHistory returns the status changes of a payment in chronological order
+func (r *PostgresPaymentRepository) StatusHistory(paymentID string) ([]*StatusChange, error) {
+	rows, err := r.DB.Query(`
+		SELECT old_status, new_status, cause, time
+		FROM payment_status_history
+		WHERE payment_id=$1
+		ORDER BY id`, paymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	h := []*StatusChange{}
+	for rows.Next() {
+		var (
+			sc        StatusChange
+			oldStatus sql.NullString
+		)
+		err = rows.Scan(&oldStatus, &sc.NewStatus, &sc.Cause, &sc.Time)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan row")
+		}
+		sc.OldStatus = oldStatus.String
+
+		h = append(h, &sc)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return h, nil
+}
+
+// AddWebhookDelivery records a delivery to the webhook of the store of a payment, setting its time
+func (r *PostgresPaymentRepository) AddWebhookDelivery(paymentID string, d *WebhookDelivery) error {
+	err := r.DB.QueryRow(`
+		INSERT INTO webhook_deliveries (payment_id, status, response_status, error)
+		VALUES ($1, $2, $3, $4)
+		RETURNING time`, paymentID, d.Status, d.ResponseStatus, d.Error).
+		Scan(&d.Time)
+	if err != nil {
+		return errors.Wrap(err, "cannot query database")
+	}
+
+	return nil
+}
+
+// WebhookDeliveries returns the webhook deliveries of a payment in chronological order
+func (r *PostgresPaymentRepository) WebhookDeliveries(paymentID string) ([]*WebhookDelivery, error) {
+	rows, err := r.DB.Query(`
+		SELECT status, response_status, error, time
+		FROM webhook_deliveries
+		WHERE payment_id=$1
+		ORDER BY id`, paymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	ds := []*WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		err = rows.Scan(&d.Status, &d.ResponseStatus, &d.Error, &d.Time)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan row")
+		}
+
+		ds = append(ds, &d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return ds, nil
+}
+
+// MemoryPaymentRepository is a concurrency-safe PaymentRepository that keeps payments in memory
+type MemoryPaymentRepository struct {
+	mutex      sync.RWMutex
+	payments   map[string]*Payment
+	history    map[string][]*StatusChange
+	deliveries map[string][]*WebhookDelivery
+	// invoices are the invoices payments are created for by InsertForInvoice. If nil, invoices are always open.
+	invoices *MemoryInvoiceRepository
+}
+
+// NewMemoryPaymentRepository returns a new empty MemoryPaymentRepository
+func NewMemoryPaymentRepository() *MemoryPaymentRepository {
+	return &MemoryPaymentRepository{
+		payments:   make(map[string]*Payment),
+		history:    make(map[string][]*StatusChange),
+		deliveries: make(map[string][]*WebhookDelivery),
+	}
+}
+
+// insert stores p and records its creation. r.mutex has to be locked.
+// Payment IDs, integrated addresses and the payments re-quoted from are unique, as in table payments.
+func (r *MemoryPaymentRepository) insert(p *Payment, cause string) error {
+	for _, stored := range r.payments {
+		switch {
+		case stored.PaymentID == p.PaymentID:
+			return errors.New("duplicate payment ID")
+		case p.IntegratedAddress != "" && stored.IntegratedAddress == p.IntegratedAddress:
+			return errors.New("duplicate integrated address")
+		case p.RequotedFrom != "" && stored.RequotedFrom == p.RequotedFrom:
+			return errors.New("duplicate requoted from")
+		}
+	}
+
+	p.CreationTime = time.Now().UTC()
+	r.payments[p.PaymentID] = p.clone()
+	r.history[p.PaymentID] = []*StatusChange{{NewStatus: p.Status, Cause: cause, Time: p.CreationTime}}
+	return nil
+}
+
+// fetched returns a copy of a stored payment, with its minutes from creation as of now
+func fetched(p *Payment) *Payment {
+	c := p.clone()
+	c.MinsFromCreation = int(math.Ceil(time.Since(p.CreationTime).Minutes()))
+	return c
+}
+
+// Insert stores p, setting its creation time, and records its creation with cause
+func (r *MemoryPaymentRepository) Insert(p *Payment, cause string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.insert(p, cause)
+}
+
+// InsertAll stores all of ps as Insert does. None of them is stored if any fails.
+func (r *MemoryPaymentRepository) InsertAll(ps []*Payment, cause string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, p := range ps {
+		err := r.insert(p, cause)
+		if err != nil {
+			for _, inserted := range ps[:i] {
+				delete(r.payments, inserted.PaymentID)
+				delete(r.history, inserted.PaymentID)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertForInvoice stores p, created for invoice p.InvoiceID, as Insert does and links the invoice to it.
+// The invoices of r are locked meanwhile.
+func (r *MemoryPaymentRepository) InsertForInvoice(p *Payment, cause string) (existingPaymentID string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.insertForInvoice(p, cause)
+}
+
+// insertForInvoice stores p and links invoice p.InvoiceID to it, as InsertForInvoice does. r.mutex has to be locked.
+func (r *MemoryPaymentRepository) insertForInvoice(p *Payment, cause string) (existingPaymentID string, err error) {
+	var i *Invoice
+	if r.invoices != nil {
+		r.invoices.mutex.Lock()
+		defer r.invoices.mutex.Unlock()
+
+		i, err = r.invoices.openForPayment(p.InvoiceID)
+		if err != nil {
+			return "", err
+		}
+	}
+	if existing := r.latestByInvoice(p.InvoiceID, p.Status); existing != nil {
+		return existing.PaymentID, nil
+	}
+	err = r.insert(p, cause)
+	if err != nil {
+		return "", err
+	}
+	if i != nil {
+		i.PaymentID = p.PaymentID
+	}
+	return "", nil
+}
+
+// Requote stores p and links the expired payment p.RequotedFrom to it, as a single change
+func (r *MemoryPaymentRepository) Requote(p *Payment, cause string) (existingPaymentID string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	expired, ok := r.payments[p.RequotedFrom]
+	if !ok || expired.StoreID != p.StoreID {
+		return "", ErrNotFound
+	}
+
+	if p.InvoiceID != 0 {
+		existingPaymentID, err = r.insertForInvoice(p, cause)
+	} else {
+		err = r.insert(p, cause)
+	}
+	if err != nil || existingPaymentID != "" {
+		return existingPaymentID, err
+	}
+	expired.RequotedTo = p.PaymentID
+	return "", nil
+}
+
+// UsedIdentifiers returns which of paymentIDs and integratedAddresses are already used by stored payments
+func (r *MemoryPaymentRepository) UsedIdentifiers(paymentIDs, integratedAddresses []string) (map[string]bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	wanted := make(map[string]bool)
+	for _, id := range append(append([]string(nil), paymentIDs...), integratedAddresses...) {
+		wanted[id] = true
+	}
+
+	used := make(map[string]bool)
+	for _, p := range r.payments {
+		if wanted[p.PaymentID] || wanted[p.IntegratedAddress] {
+			used[p.PaymentID], used[p.IntegratedAddress] = true, true
+		}
+	}
+	return used, nil
+}
+
+// ByID returns the payment with paymentID, or ErrNotFound
+func (r *MemoryPaymentRepository) ByID(paymentID string) (*Payment, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	p, ok := r.payments[paymentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return fetched(p), nil
+}
+
+// ByIDs returns the payments of a store with paymentIDs. Payment IDs that are not found are ignored.
+func (r *MemoryPaymentRepository) ByIDs(storeID int, paymentIDs []string) ([]*Payment, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var ps []*Payment
+	for _, id := range paymentIDs {
+		if p, ok := r.payments[id]; ok && p.StoreID == storeID {
+			ps = append(ps, fetched(p))
+		}
+	}
+	return ps, nil
+}
+
+// ByRequotedFrom returns the payment an expired payment was re-quoted into, or ErrNotFound
+func (r *MemoryPaymentRepository) ByRequotedFrom(paymentID string) (*Payment, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, p := range r.payments {
+		if p.RequotedFrom == paymentID {
+			return fetched(p), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// latestByInvoice returns the latest payment in status created for an invoice, or nil. r.mutex has to be locked.
+func (r *MemoryPaymentRepository) latestByInvoice(invoiceID int, status string) (latest *Payment) {
+	for _, p := range r.payments {
+		if p.InvoiceID == invoiceID && p.Status == status && (latest == nil || p.CreationTime.After(latest.CreationTime)) {
+			latest = p
+		}
+	}
+	return latest
+}
+
+// ByInvoice returns the latest payment in status created for an invoice, or ErrNotFound
+func (r *MemoryPaymentRepository) ByInvoice(invoiceID int, status string) (*Payment, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	p := r.latestByInvoice(invoiceID, status)
+	if p == nil {
+		return nil, ErrNotFound
+	}
+	return fetched(p), nil
+}
+
+// Count returns the number of payments matching f
+func (r *MemoryPaymentRepository) Count(f *PaymentFilter) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var n int
+	for _, p := range r.payments {
+		if f.matches(p) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// truncate returns the first day (YYYY-MM-DD) of the period of interval (day, week or month) t is in, in loc, as date_trunc does
+func truncate(t time.Time, interval string, loc *time.Location) string {
+	t = t.In(loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	switch interval {
+	case "week": // Weeks start on Monday
+		day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		day = day.AddDate(0, 0, 1-day.Day())
+	}
+	return day.Format(InvoiceDateLayout)
+}
+
+// Aggregate returns the aggregates of the payments of a store created in a range
+func (r *MemoryPaymentRepository) Aggregate(storeID int, interval string, loc *time.Location, from, to time.Time) ([]*PaymentAggregate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	groups := make(map[[3]string]*PaymentAggregate)
+	var as []*PaymentAggregate
+	for _, p := range r.payments {
+		if p.StoreID != storeID || p.CreationTime.Before(from) || !p.CreationTime.Before(to) {
+			continue
+		}
+
+		key := [3]string{truncate(p.CreationTime, interval, loc), p.Status, p.Currency}
+		a, ok := groups[key]
+		if !ok {
+			a = &PaymentAggregate{Period: key[0], Status: key[1], Currency: key[2]}
+			groups[key] = a
+			as = append(as, a)
+		}
+
+		a.Count++
+		a.CurrencyAmount = a.CurrencyAmount.Add(p.CurrencyAmount)
+		a.AtomicDeroAmount += p.AtomicDeroAmount
+		if p.PaidTime != nil {
+			a.PaidCount++
+			a.PaidSeconds += int64(p.PaidTime.Sub(p.CreationTime).Seconds())
+		}
+	}
+	sort.SliceStable(as, func(i, j int) bool { return as[i].Period < as[j].Period })
+	return as, nil
+}
+
+// Find returns the payments matching q, sorted as requested by q
+func (r *MemoryPaymentRepository) Find(q *PaymentQuery) ([]*Payment, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var after *Payment
+	if q.After != nil {
+		var err error
+		after, err = q.After.payment(q.SortBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var ps []*Payment
+	for _, p := range r.payments {
+		if q.matches(p) && (after == nil || q.compare(p, after) > 0) {
+			ps = append(ps, p)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool { return q.compare(ps[i], ps[j]) < 0 })
+
+	if q.Offset >= len(ps) {
+		return nil, nil
+	}
+	ps = ps[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(ps) {
+		ps = ps[:q.Limit]
+	}
+
+	found := make([]*Payment, len(ps))
+	for i, p := range ps {
+		found[i] = fetched(p)
+	}
+	return found, nil
+}
+
+// Each calls fn for each of the payments matching q, sorted as requested by q
+func (r *MemoryPaymentRepository) Each(q *PaymentQuery, fn func(p *Payment) error) error {
+	ps, err := r.Find(q)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range ps {
+		err = fn(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extend adds minutes to the max TTL of a payment of a store in status, and sets its quote if not nil
+func (r *MemoryPaymentRepository) Extend(paymentID string, storeID int, status string, minutes, maxTTL int, quote *PaymentQuote) (*Payment, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p, ok := r.payments[paymentID]
+	if !ok || p.StoreID != storeID || p.Status != status {
+		return nil, ErrNotFound
+	}
+	minsFromCreation := fetched(p).MinsFromCreation
+	if p.MaxTTL <= minsFromCreation || p.MaxTTL+minutes-minsFromCreation > maxTTL {
+		return nil, ErrNotFound
+	}
+
+	p.MaxTTL += minutes
+	if quote != nil {
+		p.ExchangeRate, p.DeroAmount = quote.ExchangeRate, quote.DeroAmount
+		p.AtomicDeroAmount, p.MinAtomicDeroAmount = quote.AtomicDeroAmount, quote.MinAtomicDeroAmount
+		p.RequiredConfirmations = quote.RequiredConfirmations
+	}
+	return fetched(p), nil
+}
+
+// changeStatus changes the status of p and records the change. r.mutex has to be locked.
+func (r *MemoryPaymentRepository) changeStatus(p *Payment, newStatus, cause string) {
+	r.history[p.PaymentID] = append(r.history[p.PaymentID], &StatusChange{
+		OldStatus: p.Status,
+		NewStatus: newStatus,
+		Cause:     cause,
+		Time:      time.Now().UTC(),
+	})
+	p.Status = newStatus
+}
+
+// ChangeStatus changes the status of a payment from oldStatus to newStatus, recording the change with cause
+func (r *MemoryPaymentRepository) ChangeStatus(paymentID, oldStatus, newStatus, cause string, txIDs []string, paid bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p, ok := r.payments[paymentID]
+	if !ok || p.Status != oldStatus {
+		return ErrUnexpectedStatus
+	}
+
+	r.changeStatus(p, newStatus, cause)
+	p.TxIDs = append([]string(nil), txIDs...)
+	p.PaidTime = nil
+	if paid {
+		now := time.Now().UTC()
+		p.PaidTime = &now
+	}
+	return nil
+}
+
+// ChangeAllStatuses changes the status of all the payments in oldStatus to newStatus, recording the changes with cause
+func (r *MemoryPaymentRepository) ChangeAllStatuses(oldStatus, newStatus, cause string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, p := range r.payments {
+		if p.Status == oldStatus {
+			r.changeStatus(p, newStatus, cause)
+		}
+	}
+	return nil
+}
+
+// StatusHistory returns the status changes of a payment in chronological order
+func (r *MemoryPaymentRepository) StatusHistory(paymentID string) ([]*StatusChange, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	h := []*StatusChange{}
+	for _, sc := range r.history[paymentID] {
+		c := *sc
+		h = append(h, &c)
+	}
+	return h, nil
+}
+
+// AddWebhookDelivery records a delivery to the webhook of the store of a payment, setting its time
+func (r *MemoryPaymentRepository) AddWebhookDelivery(paymentID string, d *WebhookDelivery) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.payments[paymentID]; !ok {
+		return ErrNotFound
+	}
+
+	d.Time = time.Now().UTC()
+	c := *d
+	r.deliveries[paymentID] = append(r.deliveries[paymentID], &c)
+	return nil
+}
+
+// WebhookDeliveries returns the webhook deliveries of a payment in chronological order
+func (r *MemoryPaymentRepository) WebhookDeliveries(paymentID string) ([]*WebhookDelivery, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	ds := []*WebhookDelivery{}
+	for _, d := range r.deliveries[paymentID] {
+		c := *d
+		ds = append(ds, &c)
+	}
+	return ds, nil
+}
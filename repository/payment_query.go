@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// PaymentFilter represents the filters the payments of a store can be searched by. Zero values other than StoreID are ignored.
+type PaymentFilter struct {
+	StoreID  int
+	Status   string
+	Currency string
+	// Creation time range. From is inclusive, To is exclusive.
+	From time.Time
+	To   time.Time
+	// Currency amount range (inclusive)
+	MinAmount *decimal.Decimal
+	MaxAmount *decimal.Decimal
+	// Exact match
+	IntegratedAddress string
+	// Case insensitive substrings
+	OrderID     string
+	Description string
+	// JSON object the metadata of payments has to contain (e.g. {"sku":"abc"})
+	Metadata json.RawMessage
+}
+
+// PaymentSortColumns maps the columns payments can be sorted by to their Postgres types
+var PaymentSortColumns = map[string]string{
+	"creation_time":      "timestamp",
+	"currency_amount":    "numeric",
+	"exchange_rate":      "numeric",
+	"atomic_dero_amount": "bigint",
+}
+
+// paymentCursorTimeLayout is the layout of the creation time of payments in cursors, matching the text representation of Postgres timestamps
+const paymentCursorTimeLayout = "2006-01-02T15:04:05.999999"
+
+// PaymentCursor points to a payment in a sorting: the value of the payment in the sort column, as returned by SortValue, and its Payment ID
+type PaymentCursor struct {
+	Value     string
+	PaymentID string
+}
+
+// PaymentQuery represents a query for the payments matching a filter.
+// SortBy has to be one of PaymentSortColumns and OrderBy either "asc" or "desc", since they are not escaped.
+// Payment ID breaks ties between payments with the same sort key.
+type PaymentQuery struct {
+	PaymentFilter
+	SortBy  string
+	OrderBy string
+	// After, if not nil, skips the payments up to the one it points to (included)
+	After *PaymentCursor
+	// Limit is the max number of payments returned (0 = no limit)
+	Limit  int
+	Offset int
+}
+
+// SortValue returns the text representation of the value of Payment in column sortBy, as used by PaymentCursor
+func (p *Payment) SortValue(sortBy string) string {
+	switch sortBy {
+	case "currency_amount":
+		return p.CurrencyAmount.String()
+	case "exchange_rate":
+		return p.ExchangeRate.String()
+	case "atomic_dero_amount":
+		return strconv.FormatUint(p.AtomicDeroAmount, 10)
+	default: // creation_time
+		return p.CreationTime.Format(paymentCursorTimeLayout)
+	}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, so that s is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// where returns the WHERE clause of a query that selects the payments matching f, and its args
+func (f *PaymentFilter) where() (string, []interface{}) {
+	conds := []string{"store_id=$1"}
+	args := []interface{}{f.StoreID}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Status != "" {
+		add("status=LOWER($%d)", f.Status)
+	}
+	if f.Currency != "" {
+		add("currency=UPPER($%d)", f.Currency)
+	}
+	if !f.From.IsZero() {
+		add("creation_time>=$%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("creation_time<$%d", f.To)
+	}
+	if f.MinAmount != nil {
+		add("currency_amount>=$%d", *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		add("currency_amount<=$%d", *f.MaxAmount)
+	}
+	if f.IntegratedAddress != "" {
+		add("integrated_address=$%d", f.IntegratedAddress)
+	}
+	if f.OrderID != "" {
+		add("order_id ILIKE $%d", "%"+escapeLike(f.OrderID)+"%")
+	}
+	if f.Description != "" {
+		add("description ILIKE $%d", "%"+escapeLike(f.Description)+"%")
+	}
+	if len(f.Metadata) > 0 {
+		add("metadata @> $%d::jsonb", string(f.Metadata))
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// sql returns the query that selects the payments matching q, and its args
+func (q *PaymentQuery) sql() (string, []interface{}) {
+	where, args := q.where()
+
+	if q.After != nil {
+		comparison := "<"
+		if q.OrderBy == "asc" {
+			comparison = ">"
+		}
+		args = append(args, q.After.Value, q.After.PaymentID)
+		where += fmt.Sprintf(` AND (%s, payment_id) %s ($%d::%s, $%d)`, q.SortBy, comparison, len(args)-1, PaymentSortColumns[q.SortBy], len(args))
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM payments WHERE %s ORDER BY %s %s, payment_id %s`, paymentColumns, where, q.SortBy, q.OrderBy, q.OrderBy)
+	if q.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, q.Limit)
+	}
+	if q.Offset > 0 {
+		query += fmt.Sprintf(` OFFSET %d`, q.Offset)
+	}
+
+	return query, args
+}
+
+// matches returns whether p matches f, as the WHERE clause returned by where does
+func (f *PaymentFilter) matches(p *Payment) bool {
+	switch {
+	case p.StoreID != f.StoreID,
+		f.Status != "" && p.Status != strings.ToLower(f.Status),
+		f.Currency != "" && p.Currency != strings.ToUpper(f.Currency),
+		!f.From.IsZero() && p.CreationTime.Before(f.From),
+		!f.To.IsZero() && !p.CreationTime.Before(f.To),
+		f.MinAmount != nil && p.CurrencyAmount.LessThan(*f.MinAmount),
+		f.MaxAmount != nil && p.CurrencyAmount.GreaterThan(*f.MaxAmount),
+		f.IntegratedAddress != "" && p.IntegratedAddress != f.IntegratedAddress,
+		f.OrderID != "" && !strings.Contains(strings.ToLower(p.OrderID), strings.ToLower(f.OrderID)),
+		f.Description != "" && !strings.Contains(strings.ToLower(p.Description), strings.ToLower(f.Description)),
+		len(f.Metadata) > 0 && !jsonContains(p.Metadata, f.Metadata):
+		return false
+	}
+	return true
+}
+
+// jsonContains returns whether JSON document a contains b, as the jsonb operator @> does
+func jsonContains(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return contains(va, vb)
+}
+
+func contains(a, b interface{}) bool {
+	switch vb := b.(type) {
+	case map[string]interface{}:
+		va, ok := a.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range vb {
+			if _, ok := va[k]; !ok || !contains(va[k], v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		va, ok := a.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, v := range vb {
+			found := false
+			for _, e := range va {
+				if contains(e, v) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+// compare returns -1, 0 or 1 if a comes before, at the same position as or after b in the sorting of q
+func (q *PaymentQuery) compare(a, b *Payment) int {
+	var c int
+	switch q.SortBy {
+	case "currency_amount":
+		c = a.CurrencyAmount.Cmp(b.CurrencyAmount)
+	case "exchange_rate":
+		c = a.ExchangeRate.Cmp(b.ExchangeRate)
+	case "atomic_dero_amount":
+		switch {
+		case a.AtomicDeroAmount < b.AtomicDeroAmount:
+			c = -1
+		case a.AtomicDeroAmount > b.AtomicDeroAmount:
+			c = 1
+		}
+	default: // creation_time
+		switch {
+		case a.CreationTime.Before(b.CreationTime):
+			c = -1
+		case a.CreationTime.After(b.CreationTime):
+			c = 1
+		}
+	}
+	if c == 0 {
+		c = strings.Compare(a.PaymentID, b.PaymentID)
+	}
+
+	if q.OrderBy == "desc" {
+		return -c
+	}
+	return c
+}
+
+// payment returns a Payment with the sort key c points to in column sortBy
+func (c *PaymentCursor) payment(sortBy string) (p *Payment, err error) {
+	p = &Payment{PaymentID: c.PaymentID}
+	switch sortBy {
+	case "currency_amount":
+		p.CurrencyAmount, err = decimal.NewFromString(c.Value)
+	case "exchange_rate":
+		p.ExchangeRate, err = decimal.NewFromString(c.Value)
+	case "atomic_dero_amount":
+		p.AtomicDeroAmount, err = strconv.ParseUint(c.Value, 10, 64)
+	default: // creation_time
+		p.CreationTime, err = time.Parse(paymentCursorTimeLayout, c.Value)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse cursor value")
+	}
+
+	return p, nil
+}
+
+// Validate returns an error if the value c points to cannot be parsed as a value of column sortBy
+func (c *PaymentCursor) Validate(sortBy string) error {
+	_, err := c.payment(sortBy)
+	return err
+}
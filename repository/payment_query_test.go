@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentFilterWhere(t *testing.T) {
+	assert.Equal(t, `100\%\_a\\b`, escapeLike(`100%_a\b`))
+
+	where, args := (&PaymentFilter{StoreID: 1}).where()
+	assert.Equal(t, "store_id=$1", where)
+	assert.Equal(t, []interface{}{1}, args)
+
+	from := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	where, args = (&PaymentFilter{StoreID: 1, Status: "Paid", From: from, OrderID: "50%", Metadata: json.RawMessage(`{"sku":"abc"}`)}).where()
+	assert.Equal(t, "store_id=$1 AND status=LOWER($2) AND creation_time>=$3 AND order_id ILIKE $4 AND metadata @> $5::jsonb", where)
+	assert.Equal(t, []interface{}{1, "Paid", from, `%50\%%`, `{"sku":"abc"}`}, args)
+}
+
+func TestPaymentFilterMatches(t *testing.T) {
+	min := decimal.NewFromInt(5)
+	p := &Payment{
+		Status:         "paid",
+		Currency:       "EUR",
+		CurrencyAmount: decimal.NewFromInt(10),
+		CreationTime:   time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC),
+		OrderID:        "Order-42",
+		Metadata:       json.RawMessage(`{"sku":"abc","tags":["a","b"],"qty":2}`),
+		StoreID:        1,
+	}
+
+	tests := []struct {
+		Filter   PaymentFilter
+		Expected bool
+	}{
+		{PaymentFilter{StoreID: 1}, true},
+		{PaymentFilter{StoreID: 2}, false},
+		{PaymentFilter{StoreID: 1, Status: "PAID", Currency: "eur"}, true},
+		{PaymentFilter{StoreID: 1, Status: "pending"}, false},
+		{PaymentFilter{StoreID: 1, From: p.CreationTime, To: p.CreationTime.Add(time.Hour)}, true},
+		{PaymentFilter{StoreID: 1, To: p.CreationTime}, false},
+		{PaymentFilter{StoreID: 1, MinAmount: &min}, true},
+		{PaymentFilter{StoreID: 1, MaxAmount: &min}, false},
+		{PaymentFilter{StoreID: 1, OrderID: "order-4"}, true},
+		{PaymentFilter{StoreID: 1, Description: "order"}, false},
+		{PaymentFilter{StoreID: 1, Metadata: json.RawMessage(`{"sku":"abc","tags":["b"]}`)}, true},
+		{PaymentFilter{StoreID: 1, Metadata: json.RawMessage(`{"qty":2}`)}, true},
+		{PaymentFilter{StoreID: 1, Metadata: json.RawMessage(`{"sku":"abd"}`)}, false},
+		{PaymentFilter{StoreID: 1, Metadata: json.RawMessage(`{"tags":["c"]}`)}, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, test.Filter.matches(p), "%+v", test.Filter)
+	}
+}
+
+func TestPaymentCursor(t *testing.T) {
+	p := &Payment{
+		PaymentID:        "foo",
+		CurrencyAmount:   decimal.RequireFromString("10.5"),
+		ExchangeRate:     decimal.RequireFromString("0.25"),
+		AtomicDeroAmount: 42000000000000,
+		CreationTime:     time.Date(2020, 5, 1, 12, 30, 0, 123456000, time.UTC),
+	}
+
+	for sortBy := range PaymentSortColumns {
+		c := &PaymentCursor{Value: p.SortValue(sortBy), PaymentID: p.PaymentID}
+		parsed, err := c.payment(sortBy)
+		assert.Nil(t, err)
+
+		q := &PaymentQuery{SortBy: sortBy, OrderBy: "asc"}
+		assert.Equal(t, 0, q.compare(p, parsed), sortBy)
+	}
+
+	_, err := (&PaymentCursor{Value: "abc"}).payment("currency_amount")
+	assert.NotNil(t, err)
+	assert.NotNil(t, (&PaymentCursor{Value: "abc"}).Validate("creation_time"))
+	assert.Nil(t, (&PaymentCursor{Value: p.SortValue("creation_time")}).Validate("creation_time"))
+}
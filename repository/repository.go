@@ -0,0 +1,60 @@
+// Package repository provides access to the users, stores, payments, invoices, subscriptions, payment links and idempotency keys persisted by the application.
+// Every repository has a PostgreSQL implementation, used by the application, and an in-memory one, used by tests that do not need a database.
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// Repository errors
+var (
+	ErrNotFound = errors.New("record not found")
+	// ErrUnexpectedStatus is returned when the status of a payment about to be changed is not the expected one
+	ErrUnexpectedStatus = errors.New("unexpected payment status")
+	// ErrInvoiceNotOpen is returned when a payment is created for an invoice that is not open
+	ErrInvoiceNotOpen = errors.New("invoice not open")
+)
+
+// Repositories groups the repositories injected into handlers and the payment processor
+type Repositories struct {
+	Users           UserRepository
+	Stores          StoreRepository
+	Payments        PaymentRepository
+	Invoices        InvoiceRepository
+	Subscriptions   SubscriptionRepository
+	Links           LinkRepository
+	IdempotencyKeys IdempotencyRepository
+}
+
+// NewPostgres returns the PostgreSQL repositories of db
+func NewPostgres(db *sql.DB) *Repositories {
+	return &Repositories{
+		Users:           &PostgresUserRepository{DB: db},
+		Stores:          &PostgresStoreRepository{DB: db},
+		Payments:        &PostgresPaymentRepository{DB: db},
+		Invoices:        &PostgresInvoiceRepository{DB: db},
+		Subscriptions:   &PostgresSubscriptionRepository{DB: db},
+		Links:           &PostgresLinkRepository{DB: db},
+		IdempotencyKeys: &PostgresIdempotencyRepository{DB: db},
+	}
+}
+
+// NewMemory returns new empty in-memory repositories.
+// As in DB, payments are created for the invoices of Invoices, and links and subscriptions read the payments and invoices of the others.
+func NewMemory() *Repositories {
+	invoices := NewMemoryInvoiceRepository()
+	payments := NewMemoryPaymentRepository()
+	payments.invoices = invoices
+
+	return &Repositories{
+		Users:           NewMemoryUserRepository(),
+		Stores:          NewMemoryStoreRepository(),
+		Payments:        payments,
+		Invoices:        invoices,
+		Subscriptions:   NewMemorySubscriptionRepository(invoices),
+		Links:           NewMemoryLinkRepository(payments),
+		IdempotencyKeys: NewMemoryIdempotencyRepository(),
+	}
+}
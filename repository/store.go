@@ -0,0 +1,456 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Store represents a store owned by a user.
+// Its pricing policy, payment requirements and receipt branding are validated by package api and webapp/store.
+type Store struct {
+	ID               int
+	Title            string
+	WalletViewKey    string
+	Webhook          string
+	WebhookSecretKey string
+	APIKey           string
+	SecretKey        string
+	OwnerID          int
+	Removed          bool
+
+	// Pricing policy
+	RateMarkup            decimal.Decimal
+	UnderpaymentTolerance decimal.Decimal
+	DeroDecimals          int32
+
+	// Default payment requirements
+	PaymentTTL              int
+	PaymentMinConfirmations int
+	ConfirmationTiers       json.RawMessage // JSON array of the tiers of package api
+
+	// Receipt branding
+	ReceiptColor  string
+	ReceiptFooter string
+}
+
+// Default settings of new stores, mirroring the defaults of the columns of table stores
+const (
+	defaultDeroDecimals      = 12
+	defaultConfirmationTiers = `[]`
+)
+
+func (s Store) clone() *Store {
+	s.ConfirmationTiers = append(json.RawMessage(nil), s.ConfirmationTiers...)
+	return &s
+}
+
+// StoreRepository stores stores.
+// Updates only apply to stores that are owned by ownerID and not removed, and return ErrNotFound otherwise.
+type StoreRepository interface {
+	// Insert stores s with the default settings, setting its ID and settings
+	Insert(s *Store) error
+	// ByID returns the store with id (even if removed), or ErrNotFound
+	ByID(id int) (*Store, error)
+	// ByAPIKey returns the store with apiKey (even if removed), or ErrNotFound
+	ByAPIKey(apiKey string) (*Store, error)
+	// ByWebhookSecretKey returns the store with webhookSecretKey (even if removed), or ErrNotFound
+	ByWebhookSecretKey(webhookSecretKey string) (*Store, error)
+	// ByOwner returns the stores of ownerID that are not removed
+	ByOwner(ownerID int) ([]*Store, error)
+	// Active returns all the stores that are not removed
+	Active() ([]*Store, error)
+	// TitleExists returns whether ownerID owns a store that is not removed with title (case insensitive)
+	TitleExists(ownerID int, title string) (bool, error)
+	UpdateViewKey(id, ownerID int, viewKey string) error
+	UpdateWebhook(id, ownerID int, webhook string) error
+	UpdateWebhookSecretKey(id, ownerID int, webhookSecretKey string) error
+	UpdateKeys(id, ownerID int, apiKey, secretKey string) error
+	// UpdatePricingPolicy sets the settings of the pricing policy of a store that are not nil, returning the updated store
+	UpdatePricingPolicy(id, ownerID int, rateMarkup, underpaymentTolerance *decimal.Decimal, deroDecimals *int32) (*Store, error)
+	UpdatePaymentRequirements(id, ownerID int, ttl, minConfirmations int, confirmationTiers json.RawMessage) error
+	UpdateReceiptBranding(id, ownerID int, color, footer string) error
+	// Remove marks a store as removed, returning it
+	Remove(id, ownerID int) (*Store, error)
+}
+
+const storeColumns = `id, title, wallet_view_key, webhook, webhook_secret_key, api_key, secret_key, owner_id, removed,
+	rate_markup, underpayment_tolerance, dero_decimals, payment_ttl, payment_min_confirmations, confirmation_tiers, receipt_color, receipt_footer`
+
+func scanStore(row interface{ Scan(...interface{}) error }) (*Store, error) {
+	s := &Store{}
+	var confirmationTiers []byte
+	err := row.Scan(&s.ID, &s.Title, &s.WalletViewKey, &s.Webhook, &s.WebhookSecretKey, &s.APIKey, &s.SecretKey, &s.OwnerID, &s.Removed,
+		&s.RateMarkup, &s.UnderpaymentTolerance, &s.DeroDecimals, &s.PaymentTTL, &s.PaymentMinConfirmations, &confirmationTiers, &s.ReceiptColor, &s.ReceiptFooter)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "cannot scan row")
+	}
+	s.ConfirmationTiers = confirmationTiers
+	return s, nil
+}
+
+// PostgresStoreRepository is a StoreRepository backed by table stores of DB
+type PostgresStoreRepository struct {
+	DB *sql.DB
+}
+
+// Insert stores s with the default settings, setting its ID and settings
+func (r *PostgresStoreRepository) Insert(s *Store) error {
+	inserted, err := scanStore(r.DB.QueryRow(`
+		INSERT INTO stores (title, wallet_view_key, webhook, webhook_secret_key, api_key, secret_key, owner_id) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+storeColumns, s.Title, s.WalletViewKey, s.Webhook, s.WebhookSecretKey, s.APIKey, s.SecretKey, s.OwnerID))
+	if err != nil {
+		return errors.Wrap(err, "cannot query database")
+	}
+	*s = *inserted
+
+	return nil
+}
+
+// ByID returns the store with id (even if removed), or ErrNotFound
+func (r *PostgresStoreRepository) ByID(id int) (*Store, error) {
+	return scanStore(r.DB.QueryRow(`SELECT `+storeColumns+` FROM stores WHERE id=$1`, id))
+}
+
+// ByAPIKey returns the store with apiKey (even if removed), or ErrNotFound
+func (r *PostgresStoreRepository) ByAPIKey(apiKey string) (*Store, error) {
+	return scanStore(r.DB.QueryRow(`SELECT `+storeColumns+` FROM stores WHERE api_key=$1`, apiKey))
+}
+
+// ByWebhookSecretKey returns the store with webhookSecretKey (even if removed), or ErrNotFound
+func (r *PostgresStoreRepository) ByWebhookSecretKey(webhookSecretKey string) (*Store, error) {
+	return scanStore(r.DB.QueryRow(`SELECT `+storeColumns+` FROM stores WHERE webhook_secret_key=$1`, webhookSecretKey))
+}
+
+// ByOwner returns the stores of ownerID that are not removed
+func (r *PostgresStoreRepository) ByOwner(ownerID int) ([]*Store, error) {
+	return r.query(`
+		SELECT `+storeColumns+`
+		FROM stores
+		WHERE owner_id=$1 AND removed=$2
+		ORDER BY id`, ownerID, false)
+}
+
+// Active returns all the stores that are not removed
+func (r *PostgresStoreRepository) Active() ([]*Store, error) {
+	return r.query(`
+		SELECT `+storeColumns+`
+		FROM stores
+		WHERE removed=$1
+		ORDER BY id`, false)
+}
+
+// query returns the stores selected by query
+func (r *PostgresStoreRepository) query(query string, args ...interface{}) ([]*Store, error) {
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	var stores []*Store
+	for rows.Next() {
+		s, err := scanStore(rows)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return stores, nil
+}
+
+// TitleExists returns whether ownerID owns a store that is not removed with title (case insensitive)
+func (r *PostgresStoreRepository) TitleExists(ownerID int, title string) (exists bool, err error) {
+	err = r.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM stores
+			WHERE owner_id=$1 AND LOWER(title)=LOWER($2) AND removed=$3
+		)`, ownerID, title, false).
+		Scan(&exists)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot query database")
+	}
+
+	return exists, nil
+}
+
+// UpdateViewKey sets the Wallet View Key of a store
+func (r *PostgresStoreRepository) UpdateViewKey(id, ownerID int, viewKey string) error {
+	res, err := r.DB.Exec(`
+		UPDATE stores
+		SET wallet_view_key=$1
+		WHERE id=$2 AND owner_id=$3 AND removed=$4`, viewKey, id, ownerID, false)
+	return checkUpdated(res, err)
+}
+
+// UpdateWebhook sets the Webhook URL of a store
+func (r *PostgresStoreRepository) UpdateWebhook(id, ownerID int, webhook string) error {
+	res, err := r.DB.Exec(`
+		UPDATE stores
+		SET webhook=$1
+		WHERE id=$2 AND owner_id=$3 AND removed=$4`, webhook, id, ownerID, false)
+	return checkUpdated(res, err)
+}
+
+// UpdateWebhookSecretKey sets the Webhook Secret Key of a store
+func (r *PostgresStoreRepository) UpdateWebhookSecretKey(id, ownerID int, webhookSecretKey string) error {
+	res, err := r.DB.Exec(`
+		UPDATE stores
+		SET webhook_secret_key=$1
+		WHERE id=$2 AND owner_id=$3 AND removed=$4`, webhookSecretKey, id, ownerID, false)
+	return checkUpdated(res, err)
+}
+
+// UpdateKeys sets the API Key and Secret Key of a store
+func (r *PostgresStoreRepository) UpdateKeys(id, ownerID int, apiKey, secretKey string) error {
+	res, err := r.DB.Exec(`
+		UPDATE stores
+		SET api_key=$1, secret_key=$2
+		WHERE id=$3 AND owner_id=$4 AND removed=$5`, apiKey, secretKey, id, ownerID, false)
+	return checkUpdated(res, err)
+}
+
+// UpdatePricingPolicy sets the settings of the pricing policy of a store that are not nil, returning the updated store
+func (r *PostgresStoreRepository) UpdatePricingPolicy(id, ownerID int, rateMarkup, underpaymentTolerance *decimal.Decimal, deroDecimals *int32) (*Store, error) {
+	return scanStore(r.DB.QueryRow(`
+		UPDATE stores
+		SET rate_markup=COALESCE($1, rate_markup), underpayment_tolerance=COALESCE($2, underpayment_tolerance), dero_decimals=COALESCE($3, dero_decimals)
+		WHERE id=$4 AND owner_id=$5 AND removed=$6
+		RETURNING `+storeColumns, rateMarkup, underpaymentTolerance, deroDecimals, id, ownerID, false))
+}
+
+// UpdatePaymentRequirements sets the default payment requirements of a store
+func (r *PostgresStoreRepository) UpdatePaymentRequirements(id, ownerID int, ttl, minConfirmations int, confirmationTiers json.RawMessage) error {
+	if len(confirmationTiers) == 0 {
+		confirmationTiers = json.RawMessage(defaultConfirmationTiers)
+	}
+
+	res, err := r.DB.Exec(`
+		UPDATE stores
+		SET payment_ttl=$1, payment_min_confirmations=$2, confirmation_tiers=$3
+		WHERE id=$4 AND owner_id=$5 AND removed=$6`, ttl, minConfirmations, string(confirmationTiers), id, ownerID, false)
+	return checkUpdated(res, err)
+}
+
+// UpdateReceiptBranding sets the branding of the receipts of a store
+func (r *PostgresStoreRepository) UpdateReceiptBranding(id, ownerID int, color, footer string) error {
+	res, err := r.DB.Exec(`
+		UPDATE stores
+		SET receipt_color=$1, receipt_footer=$2
+		WHERE id=$3 AND owner_id=$4 AND removed=$5`, color, footer, id, ownerID, false)
+	return checkUpdated(res, err)
+}
+
+// Remove marks a store as removed, returning it
+func (r *PostgresStoreRepository) Remove(id, ownerID int) (*Store, error) {
+	return scanStore(r.DB.QueryRow(`
+		UPDATE stores 
+		SET removed=$1 
+		WHERE id=$2 AND owner_id=$3 AND removed=$4
+		RETURNING `+storeColumns, true, id, ownerID, false))
+}
+
+// MemoryStoreRepository is a concurrency-safe StoreRepository that keeps stores in memory
+type MemoryStoreRepository struct {
+	mutex  sync.RWMutex
+	stores map[int]*Store
+	lastID int
+}
+
+// NewMemoryStoreRepository returns a new empty MemoryStoreRepository
+func NewMemoryStoreRepository() *MemoryStoreRepository {
+	return &MemoryStoreRepository{
+		stores: make(map[int]*Store),
+	}
+}
+
+// Insert stores s with the default settings, setting its ID and settings
+func (r *MemoryStoreRepository) Insert(s *Store) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, other := range r.stores {
+		if other.APIKey == s.APIKey || other.SecretKey == s.SecretKey || other.WebhookSecretKey == s.WebhookSecretKey {
+			return errors.New("duplicate store key")
+		}
+	}
+
+	r.lastID++
+	s.ID = r.lastID
+	s.RateMarkup = decimal.Zero
+	s.UnderpaymentTolerance = decimal.Zero
+	s.DeroDecimals = defaultDeroDecimals
+	s.PaymentTTL = 0
+	s.PaymentMinConfirmations = 0
+	s.ConfirmationTiers = json.RawMessage(defaultConfirmationTiers)
+	s.ReceiptColor = ""
+	s.ReceiptFooter = ""
+	r.stores[s.ID] = s.clone()
+	return nil
+}
+
+// find returns a copy of the first store matching match, or ErrNotFound
+func (r *MemoryStoreRepository) find(match func(s *Store) bool) (*Store, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, s := range r.stores {
+		if match(s) {
+			return s.clone(), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ByID returns the store with id (even if removed), or ErrNotFound
+func (r *MemoryStoreRepository) ByID(id int) (*Store, error) {
+	return r.find(func(s *Store) bool { return s.ID == id })
+}
+
+// ByAPIKey returns the store with apiKey (even if removed), or ErrNotFound
+func (r *MemoryStoreRepository) ByAPIKey(apiKey string) (*Store, error) {
+	return r.find(func(s *Store) bool { return s.APIKey == apiKey })
+}
+
+// ByWebhookSecretKey returns the store with webhookSecretKey (even if removed), or ErrNotFound
+func (r *MemoryStoreRepository) ByWebhookSecretKey(webhookSecretKey string) (*Store, error) {
+	return r.find(func(s *Store) bool { return s.WebhookSecretKey == webhookSecretKey })
+}
+
+// ByOwner returns the stores of ownerID that are not removed
+func (r *MemoryStoreRepository) ByOwner(ownerID int) ([]*Store, error) {
+	return r.filter(func(s *Store) bool { return s.OwnerID == ownerID && !s.Removed })
+}
+
+// Active returns all the stores that are not removed
+func (r *MemoryStoreRepository) Active() ([]*Store, error) {
+	return r.filter(func(s *Store) bool { return !s.Removed })
+}
+
+// filter returns copies of the stores matching match, sorted by ID
+func (r *MemoryStoreRepository) filter(match func(s *Store) bool) ([]*Store, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var stores []*Store
+	for _, s := range r.stores {
+		if match(s) {
+			stores = append(stores, s.clone())
+		}
+	}
+	sort.Slice(stores, func(i, j int) bool {
+		return stores[i].ID < stores[j].ID
+	})
+
+	return stores, nil
+}
+
+// TitleExists returns whether ownerID owns a store that is not removed with title (case insensitive)
+func (r *MemoryStoreRepository) TitleExists(ownerID int, title string) (bool, error) {
+	_, err := r.find(func(s *Store) bool {
+		return s.OwnerID == ownerID && !s.Removed && strings.EqualFold(s.Title, title)
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// update applies f to the store with id owned by ownerID, if not removed
+func (r *MemoryStoreRepository) update(id, ownerID int, f func(s *Store)) (*Store, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, ok := r.stores[id]
+	if !ok || s.OwnerID != ownerID || s.Removed {
+		return nil, ErrNotFound
+	}
+	f(s)
+	return s.clone(), nil
+}
+
+// UpdateViewKey sets the Wallet View Key of a store
+func (r *MemoryStoreRepository) UpdateViewKey(id, ownerID int, viewKey string) error {
+	_, err := r.update(id, ownerID, func(s *Store) { s.WalletViewKey = viewKey })
+	return err
+}
+
+// UpdateWebhook sets the Webhook URL of a store
+func (r *MemoryStoreRepository) UpdateWebhook(id, ownerID int, webhook string) error {
+	_, err := r.update(id, ownerID, func(s *Store) { s.Webhook = webhook })
+	return err
+}
+
+// UpdateWebhookSecretKey sets the Webhook Secret Key of a store
+func (r *MemoryStoreRepository) UpdateWebhookSecretKey(id, ownerID int, webhookSecretKey string) error {
+	_, err := r.update(id, ownerID, func(s *Store) { s.WebhookSecretKey = webhookSecretKey })
+	return err
+}
+
+// UpdateKeys sets the API Key and Secret Key of a store
+func (r *MemoryStoreRepository) UpdateKeys(id, ownerID int, apiKey, secretKey string) error {
+	_, err := r.update(id, ownerID, func(s *Store) {
+		s.APIKey = apiKey
+		s.SecretKey = secretKey
+	})
+	return err
+}
+
+// UpdatePricingPolicy sets the settings of the pricing policy of a store that are not nil, returning the updated store
+func (r *MemoryStoreRepository) UpdatePricingPolicy(id, ownerID int, rateMarkup, underpaymentTolerance *decimal.Decimal, deroDecimals *int32) (*Store, error) {
+	return r.update(id, ownerID, func(s *Store) {
+		if rateMarkup != nil {
+			s.RateMarkup = *rateMarkup
+		}
+		if underpaymentTolerance != nil {
+			s.UnderpaymentTolerance = *underpaymentTolerance
+		}
+		if deroDecimals != nil {
+			s.DeroDecimals = *deroDecimals
+		}
+	})
+}
+
+// UpdatePaymentRequirements sets the default payment requirements of a store
+func (r *MemoryStoreRepository) UpdatePaymentRequirements(id, ownerID int, ttl, minConfirmations int, confirmationTiers json.RawMessage) error {
+	if len(confirmationTiers) == 0 {
+		confirmationTiers = json.RawMessage(defaultConfirmationTiers)
+	}
+
+	_, err := r.update(id, ownerID, func(s *Store) {
+		s.PaymentTTL = ttl
+		s.PaymentMinConfirmations = minConfirmations
+		s.ConfirmationTiers = append(json.RawMessage(nil), confirmationTiers...)
+	})
+	return err
+}
+
+// UpdateReceiptBranding sets the branding of the receipts of a store
+func (r *MemoryStoreRepository) UpdateReceiptBranding(id, ownerID int, color, footer string) error {
+	_, err := r.update(id, ownerID, func(s *Store) {
+		s.ReceiptColor = color
+		s.ReceiptFooter = footer
+	})
+	return err
+}
+
+// Remove marks a store as removed, returning it
+func (r *MemoryStoreRepository) Remove(id, ownerID int) (*Store, error) {
+	return r.update(id, ownerID, func(s *Store) { s.Removed = true })
+}
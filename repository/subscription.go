@@ -0,0 +1,445 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Subscription statuses stored in DB
+const (
+	SubscriptionStatusActive    = "active"
+	SubscriptionStatusPastDue   = "past_due" // Some invoices are still open past their due date
+	SubscriptionStatusCancelled = "cancelled"
+)
+
+// Subscription represents a plan a customer of a store is billed for on a schedule, as stored in DB.
+// Its details are validated by package api.
+type Subscription struct {
+	ID              int
+	SubscriptionID  string
+	Status          string
+	Currency        string
+	Amount          decimal.Decimal
+	Interval        string // day, week, month or year
+	IntervalCount   int
+	CustomerName    string
+	CustomerEmail   string
+	CustomerAddress string
+	Description     string
+	GracePeriodDays int
+	StartDate       string // YYYY-MM-DD
+	NextBillingDate string // YYYY-MM-DD
+	BilledCycles    int
+	MissedCycles    int
+	// InvoiceID is the Invoice ID of the latest invoice issued for the subscription (empty if none)
+	InvoiceID     string
+	CreationTime  time.Time
+	CancelledTime *time.Time
+	StoreID       int
+}
+
+func (s Subscription) clone() *Subscription {
+	if s.CancelledTime != nil {
+		cancelledTime := *s.CancelledTime
+		s.CancelledTime = &cancelledTime
+	}
+	return &s
+}
+
+// SubscriptionStatusChange represents a change of the status of a subscription caused by its missed cycles
+type SubscriptionStatusChange struct {
+	SubscriptionID string
+	OldStatus      string
+	NewStatus      string
+	StoreID        int
+}
+
+// SubscriptionRepository stores subscriptions, along with the invoices issued for them
+type SubscriptionRepository interface {
+	// Insert stores s, setting its ID and creation time
+	Insert(s *Subscription) error
+	// SubscriptionIDExists returns whether a subscription with subscriptionID is stored
+	SubscriptionIDExists(subscriptionID string) (bool, error)
+	// BySubscriptionID returns the subscription with subscriptionID, or ErrNotFound
+	BySubscriptionID(subscriptionID string) (*Subscription, error)
+	// Cancel cancels a subscription, or returns ErrNotFound if it is already cancelled
+	Cancel(id int) error
+	// Due returns the subscriptions that are not cancelled and whose next billing date is today (UTC) or earlier
+	Due() ([]*Subscription, error)
+	// Bill stores i, issued for the current billing cycle of s, and moves s to its next billing cycle, in a single transaction.
+	// Nothing is stored and false is returned if the cycle was already billed or s was cancelled in the meantime.
+	Bill(s *Subscription, i *Invoice) (billed bool, err error)
+	// UpdateMissedCycles sets the missed cycles of the subscriptions that are not cancelled to the number of their invoices
+	// still open after their due date, making them past due if they have any and active otherwise.
+	// It returns the status changes of the subscriptions whose missed cycles changed.
+	UpdateMissedCycles() ([]*SubscriptionStatusChange, error)
+}
+
+// PostgresSubscriptionRepository is a SubscriptionRepository backed by tables subscriptions and invoices of DB
+type PostgresSubscriptionRepository struct {
+	DB *sql.DB
+}
+
+// subscriptionColumns are the columns of table subscriptions scanned by scanSubscription
+const subscriptionColumns = `id, subscription_id, status, currency, amount, billing_interval, interval_count, customer_name, customer_email, customer_address, description,
+	grace_period_days, TO_CHAR(start_date, 'YYYY-MM-DD'), TO_CHAR(next_billing_date, 'YYYY-MM-DD'), billed_cycles, missed_cycles,
+	COALESCE((SELECT invoice_id FROM invoices WHERE invoices.subscription_id=subscriptions.id ORDER BY invoices.id DESC LIMIT 1), ''),
+	creation_time, cancelled_time, store_id`
+
+func scanSubscription(row interface{ Scan(...interface{}) error }) (*Subscription, error) {
+	var s Subscription
+	err := row.Scan(&s.ID, &s.SubscriptionID, &s.Status, &s.Currency, &s.Amount, &s.Interval, &s.IntervalCount, &s.CustomerName, &s.CustomerEmail, &s.CustomerAddress, &s.Description,
+		&s.GracePeriodDays, &s.StartDate, &s.NextBillingDate, &s.BilledCycles, &s.MissedCycles,
+		&s.InvoiceID,
+		&s.CreationTime, &s.CancelledTime, &s.StoreID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "cannot scan row")
+	}
+
+	return &s, nil
+}
+
+// Insert stores s, setting its ID and creation time
+func (r *PostgresSubscriptionRepository) Insert(s *Subscription) error {
+	err := r.DB.QueryRow(`
+		INSERT INTO subscriptions (subscription_id, status, currency, amount, billing_interval, interval_count, customer_name, customer_email, customer_address, description, grace_period_days, start_date, next_billing_date, store_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id, creation_time`, s.SubscriptionID, s.Status, s.Currency, s.Amount, s.Interval, s.IntervalCount, s.CustomerName, s.CustomerEmail, s.CustomerAddress,
+		s.Description, s.GracePeriodDays, s.StartDate, s.NextBillingDate, s.StoreID).
+		Scan(&s.ID, &s.CreationTime)
+	if err != nil {
+		return errors.Wrap(err, "cannot query database")
+	}
+
+	return nil
+}
+
+// SubscriptionIDExists returns whether a subscription with subscriptionID is stored
+func (r *PostgresSubscriptionRepository) SubscriptionIDExists(subscriptionID string) (exists bool, err error) {
+	err = r.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM subscriptions WHERE subscription_id=$1)`, subscriptionID).Scan(&exists)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot query database")
+	}
+
+	return exists, nil
+}
+
+// BySubscriptionID returns the subscription with subscriptionID, or ErrNotFound
+func (r *PostgresSubscriptionRepository) BySubscriptionID(subscriptionID string) (*Subscription, error) {
+	return scanSubscription(r.DB.QueryRow(`SELECT `+subscriptionColumns+` FROM subscriptions WHERE subscription_id=$1`, subscriptionID))
+}
+
+// Cancel cancels a subscription, or returns ErrNotFound if it is already cancelled
+func (r *PostgresSubscriptionRepository) Cancel(id int) error {
+	return checkUpdated(r.DB.Exec(`
+		UPDATE subscriptions
+		SET status=$1, cancelled_time=NOW()
+		WHERE id=$2 AND status!=$1`, SubscriptionStatusCancelled, id))
+}
+
+// Due returns the subscriptions that are not cancelled and whose next billing date is today (UTC) or earlier
+func (r *PostgresSubscriptionRepository) Due() ([]*Subscription, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE status!=$1 AND next_billing_date <= (NOW() AT TIME ZONE 'UTC')::date
+		ORDER BY id`, SubscriptionStatusCancelled)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	var ss []*Subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		ss = append(ss, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return ss, nil
+}
+
+// nextBillingDateExpr is the SQL expression of the billing date of the cycle following the last billed one.
+// Billing dates are calculated from the start date so that, e.g., monthly subscriptions started on the 31st are billed on the last day of shorter months.
+const nextBillingDateExpr = `(start_date + (billed_cycles + 1) * interval_count * CASE billing_interval
+	WHEN 'day' THEN INTERVAL '1 day' WHEN 'week' THEN INTERVAL '1 week' WHEN 'month' THEN INTERVAL '1 month' ELSE INTERVAL '1 year' END)::date`
+
+// Bill stores i, issued for the current billing cycle of s, and moves s to its next billing cycle, in a single transaction
+func (r *PostgresSubscriptionRepository) Bill(s *Subscription, i *Invoice) (billed bool, err error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "cannot begin DB TX")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE subscriptions
+		SET billed_cycles=billed_cycles+1, next_billing_date=`+nextBillingDateExpr+`
+		WHERE id=$1 AND billed_cycles=$2 AND status!=$3`, s.ID, s.BilledCycles, SubscriptionStatusCancelled)
+	err = checkUpdated(res, err)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = insertInvoice(tx, i)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, errors.Wrap(err, "cannot commit DB TX")
+	}
+
+	return true, nil
+}
+
+// UpdateMissedCycles updates the missed cycles and the statuses of the subscriptions that are not cancelled in a single statement
+func (r *PostgresSubscriptionRepository) UpdateMissedCycles() ([]*SubscriptionStatusChange, error) {
+	rows, err := r.DB.Query(`
+		UPDATE subscriptions
+		SET missed_cycles=m.missed, status=CASE WHEN m.missed > 0 THEN $2 ELSE $1 END
+		FROM (
+			SELECT subscriptions.id, subscriptions.status AS old_status,
+				COUNT(invoices.id) FILTER (WHERE invoices.status=$3 AND invoices.due_date < (NOW() AT TIME ZONE 'UTC')::date) AS missed
+			FROM subscriptions LEFT JOIN invoices ON invoices.subscription_id=subscriptions.id
+			WHERE subscriptions.status IN ($1, $2)
+			GROUP BY subscriptions.id
+		) m
+		WHERE subscriptions.id=m.id AND subscriptions.missed_cycles!=m.missed
+		RETURNING subscriptions.subscription_id, m.old_status, subscriptions.status, subscriptions.store_id`,
+		SubscriptionStatusActive, SubscriptionStatusPastDue, InvoiceStatusOpen)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	var changes []*SubscriptionStatusChange
+	for rows.Next() {
+		var c SubscriptionStatusChange
+		err = rows.Scan(&c.SubscriptionID, &c.OldStatus, &c.NewStatus, &c.StoreID)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan row")
+		}
+
+		changes = append(changes, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return changes, nil
+}
+
+// MemorySubscriptionRepository is a concurrency-safe SubscriptionRepository that keeps subscriptions in memory,
+// along with the invoices issued for them in a MemoryInvoiceRepository
+type MemorySubscriptionRepository struct {
+	mutex         sync.RWMutex
+	subscriptions map[int]*Subscription
+	lastID        int
+	invoices      *MemoryInvoiceRepository
+}
+
+// NewMemorySubscriptionRepository returns a new empty MemorySubscriptionRepository issuing invoices into invoices
+func NewMemorySubscriptionRepository(invoices *MemoryInvoiceRepository) *MemorySubscriptionRepository {
+	return &MemorySubscriptionRepository{
+		subscriptions: make(map[int]*Subscription),
+		invoices:      invoices,
+	}
+}
+
+// find returns the first stored subscription matching fn, or nil. r.mutex has to be locked.
+func (r *MemorySubscriptionRepository) find(fn func(s *Subscription) bool) *Subscription {
+	for _, s := range r.subscriptions {
+		if fn(s) {
+			return s
+		}
+	}
+	return nil
+}
+
+// fetched returns a copy of a stored subscription along with the Invoice ID of its latest invoice. r.mutex has to be locked.
+func (r *MemorySubscriptionRepository) fetched(s *Subscription) *Subscription {
+	c := s.clone()
+
+	r.invoices.mutex.RLock()
+	defer r.invoices.mutex.RUnlock()
+
+	var latest int
+	for _, i := range r.invoices.invoices {
+		if i.SubscriptionID == s.ID && i.ID > latest {
+			latest, c.InvoiceID = i.ID, i.InvoiceID
+		}
+	}
+	return c
+}
+
+// Insert stores s, setting its ID and creation time. Subscription IDs are unique, as in table subscriptions.
+func (r *MemorySubscriptionRepository) Insert(s *Subscription) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.find(func(stored *Subscription) bool { return stored.SubscriptionID == s.SubscriptionID }) != nil {
+		return errors.New("duplicate subscription ID")
+	}
+
+	r.lastID++
+	s.ID = r.lastID
+	s.CreationTime = time.Now().UTC()
+	r.subscriptions[s.ID] = s.clone()
+	return nil
+}
+
+// SubscriptionIDExists returns whether a subscription with subscriptionID is stored
+func (r *MemorySubscriptionRepository) SubscriptionIDExists(subscriptionID string) (bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.find(func(s *Subscription) bool { return s.SubscriptionID == subscriptionID }) != nil, nil
+}
+
+// BySubscriptionID returns the subscription with subscriptionID, or ErrNotFound
+func (r *MemorySubscriptionRepository) BySubscriptionID(subscriptionID string) (*Subscription, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	s := r.find(func(s *Subscription) bool { return s.SubscriptionID == subscriptionID })
+	if s == nil {
+		return nil, ErrNotFound
+	}
+	return r.fetched(s), nil
+}
+
+// Cancel cancels a subscription, or returns ErrNotFound if it is already cancelled
+func (r *MemorySubscriptionRepository) Cancel(id int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, ok := r.subscriptions[id]
+	if !ok || s.Status == SubscriptionStatusCancelled {
+		return ErrNotFound
+	}
+	now := time.Now().UTC()
+	s.Status, s.CancelledTime = SubscriptionStatusCancelled, &now
+	return nil
+}
+
+// Due returns the subscriptions that are not cancelled and whose next billing date is today (UTC) or earlier
+func (r *MemorySubscriptionRepository) Due() ([]*Subscription, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	today := time.Now().UTC().Format(InvoiceDateLayout)
+	var ss []*Subscription
+	for _, s := range r.subscriptions {
+		if s.Status != SubscriptionStatusCancelled && s.NextBillingDate <= today {
+			ss = append(ss, r.fetched(s))
+		}
+	}
+	sort.Slice(ss, func(i, j int) bool { return ss[i].ID < ss[j].ID })
+	return ss, nil
+}
+
+// addMonths adds months to date as PostgreSQL does, clamping its day to the last day of the resulting month
+func addMonths(date time.Time, months int) time.Time {
+	first := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	if lastDay := first.AddDate(0, 1, -1).Day(); date.Day() > lastDay {
+		return first.AddDate(0, 0, lastDay-1)
+	}
+	return first.AddDate(0, 0, date.Day()-1)
+}
+
+// nextBillingDate returns the billing date of the cycle following the last billed one of s, as nextBillingDateExpr does
+func nextBillingDate(s *Subscription) (string, error) {
+	startDate, err := time.Parse(InvoiceDateLayout, s.StartDate)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot parse start date")
+	}
+
+	n := (s.BilledCycles + 1) * s.IntervalCount
+	switch s.Interval {
+	case "day":
+		startDate = startDate.AddDate(0, 0, n)
+	case "week":
+		startDate = startDate.AddDate(0, 0, 7*n)
+	case "month":
+		startDate = addMonths(startDate, n)
+	default:
+		startDate = addMonths(startDate, 12*n)
+	}
+	return startDate.Format(InvoiceDateLayout), nil
+}
+
+// Bill stores i, issued for the current billing cycle of s, and moves s to its next billing cycle
+func (r *MemorySubscriptionRepository) Bill(s *Subscription, i *Invoice) (billed bool, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, ok := r.subscriptions[s.ID]
+	if !ok || stored.BilledCycles != s.BilledCycles || stored.Status == SubscriptionStatusCancelled {
+		return false, nil
+	}
+	next, err := nextBillingDate(stored)
+	if err != nil {
+		return false, err
+	}
+
+	err = r.invoices.Insert(i)
+	if err != nil {
+		return false, err
+	}
+	stored.BilledCycles++
+	stored.NextBillingDate = next
+	return true, nil
+}
+
+// UpdateMissedCycles updates the missed cycles and the statuses of the subscriptions that are not cancelled
+func (r *MemorySubscriptionRepository) UpdateMissedCycles() ([]*SubscriptionStatusChange, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.invoices.mutex.RLock()
+	missed := make(map[int]int)
+	for _, i := range r.invoices.invoices {
+		if i.SubscriptionID != 0 && i.IsOverdue() {
+			missed[i.SubscriptionID]++
+		}
+	}
+	r.invoices.mutex.RUnlock()
+
+	var changes []*SubscriptionStatusChange
+	for _, s := range r.subscriptions {
+		if s.Status == SubscriptionStatusCancelled || s.MissedCycles == missed[s.ID] {
+			continue
+		}
+
+		c := &SubscriptionStatusChange{SubscriptionID: s.SubscriptionID, OldStatus: s.Status, NewStatus: SubscriptionStatusActive, StoreID: s.StoreID}
+		if missed[s.ID] > 0 {
+			c.NewStatus = SubscriptionStatusPastDue
+		}
+		s.MissedCycles, s.Status = missed[s.ID], c.NewStatus
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
@@ -0,0 +1,476 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// User represents a user of the dashboard
+type User struct {
+	ID            int
+	Username      string
+	Email         string
+	Password      string // Argon2id hash
+	EmailVerified bool
+}
+
+func (u User) clone() *User {
+	return &u
+}
+
+// TokenKind is the kind of a token sent by email to a user
+type TokenKind string
+
+// User token kinds
+const (
+	VerificationToken TokenKind = "verification"
+	RecoverToken      TokenKind = "recover"
+)
+
+// userTokenTTL is the time a verification or recover token is valid for
+const userTokenTTL = time.Hour
+
+// UserRepository stores users
+type UserRepository interface {
+	// ByID returns the user with id, or ErrNotFound
+	ByID(id int) (*User, error)
+	// ByUsernameOrEmail returns the users whose username (case insensitive) or email match
+	ByUsernameOrEmail(username, email string) ([]*User, error)
+	// ByEmail returns the user with email, or ErrNotFound
+	ByEmail(email string) (*User, error)
+	// ByVerificationToken returns the user that is not verified yet with verificationToken and whether the token has expired, or ErrNotFound
+	ByVerificationToken(verificationToken string) (u *User, expired bool, err error)
+	// ByRecoverToken returns the user with email and recoverToken and whether the token has expired, or ErrNotFound
+	ByRecoverToken(email, recoverToken string) (u *User, expired bool, err error)
+	// TokenExists returns whether a token of kind is already in use by a user
+	TokenExists(kind TokenKind, token string) (bool, error)
+	// Insert stores u, not verified, with a verificationToken valid for an hour, setting its ID
+	Insert(u *User, verificationToken string) error
+	// UpdatePassword sets the hashed password of the user with id. It returns ErrNotFound if the user does not exist.
+	UpdatePassword(id int, hashedPassword string) error
+	// UpdateEmail sets the email of the user with id and marks it as not verified, with a new verificationToken valid for an hour.
+	// It returns ErrNotFound if the user does not exist.
+	UpdateEmail(id int, email, verificationToken string) error
+	// SetVerificationToken sets a new verificationToken, valid for an hour, of the user with id. It returns ErrNotFound if the user does not exist.
+	SetVerificationToken(id int, verificationToken string) error
+	// SetRecoverToken sets a recoverToken, valid for an hour, of the user with id. It returns ErrNotFound if the user does not exist.
+	SetRecoverToken(id int, recoverToken string) error
+	// VerifyEmail marks the email of the user with id as verified. It returns ErrNotFound if the user does not exist.
+	VerifyEmail(id int) error
+	// ResetPassword sets the hashed password of the user with id and deletes its recover token. It returns ErrNotFound if the user does not exist.
+	ResetPassword(id int, hashedPassword string) error
+}
+
+const userColumns = `id, username, email, password, email_verified`
+
+// PostgresUserRepository is a UserRepository backed by table users of DB
+type PostgresUserRepository struct {
+	DB *sql.DB
+}
+
+// ByID returns the user with id, or ErrNotFound
+func (r *PostgresUserRepository) ByID(id int) (*User, error) {
+	u := &User{}
+	err := r.DB.QueryRow(`
+		SELECT `+userColumns+`
+		FROM users
+		WHERE id=$1`, id).
+		Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.EmailVerified)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	return u, nil
+}
+
+// ByEmail returns the user with email, or ErrNotFound
+func (r *PostgresUserRepository) ByEmail(email string) (*User, error) {
+	u := &User{}
+	err := r.DB.QueryRow(`
+		SELECT `+userColumns+`
+		FROM users
+		WHERE email=$1`, email).
+		Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.EmailVerified)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	return u, nil
+}
+
+// ByVerificationToken returns the user that is not verified yet with verificationToken and whether the token has expired, or ErrNotFound
+func (r *PostgresUserRepository) ByVerificationToken(verificationToken string) (u *User, expired bool, err error) {
+	u = &User{}
+	err = r.DB.QueryRow(`
+		SELECT `+userColumns+`, ((verification_token_expiration_date - NOW()) < INTERVAL '0 second') AS token_expired
+		FROM users
+		WHERE email_verified=$1 AND verification_token=$2`, false, verificationToken).
+		Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.EmailVerified, &expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, ErrNotFound
+		}
+		return nil, false, errors.Wrap(err, "cannot query database")
+	}
+
+	return u, expired, nil
+}
+
+// ByRecoverToken returns the user with email and recoverToken and whether the token has expired, or ErrNotFound
+func (r *PostgresUserRepository) ByRecoverToken(email, recoverToken string) (u *User, expired bool, err error) {
+	u = &User{}
+	err = r.DB.QueryRow(`
+		SELECT `+userColumns+`, ((recover_token_expiration_date - NOW()) < INTERVAL '0 second') AS token_expired
+		FROM users
+		WHERE email=$1 AND recover_token=$2`, email, recoverToken).
+		Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.EmailVerified, &expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, ErrNotFound
+		}
+		return nil, false, errors.Wrap(err, "cannot query database")
+	}
+
+	return u, expired, nil
+}
+
+// TokenExists returns whether a token of kind is already in use by a user
+func (r *PostgresUserRepository) TokenExists(kind TokenKind, token string) (exists bool, err error) {
+	var column string
+	switch kind {
+	case VerificationToken:
+		column = "verification_token"
+	case RecoverToken:
+		column = "recover_token"
+	default:
+		return false, errors.Errorf("invalid token kind %q", kind)
+	}
+
+	err = r.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM users
+			WHERE `+column+`=$1
+		)`, token).
+		Scan(&exists)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot query database")
+	}
+
+	return exists, nil
+}
+
+// Insert stores u, not verified, with a verificationToken valid for an hour, setting its ID
+func (r *PostgresUserRepository) Insert(u *User, verificationToken string) error {
+	err := r.DB.QueryRow(`
+		INSERT INTO users (username, email, password, verification_token, verification_token_expiration_date)
+		VALUES ($1, $2, $3, $4, NOW()+interval '1 hour')
+		RETURNING id, email_verified`, u.Username, u.Email, u.Password, verificationToken).
+		Scan(&u.ID, &u.EmailVerified)
+	if err != nil {
+		return errors.Wrap(err, "cannot query database")
+	}
+
+	return nil
+}
+
+// ByUsernameOrEmail returns the users whose username (case insensitive) or email match
+func (r *PostgresUserRepository) ByUsernameOrEmail(username, email string) ([]*User, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+userColumns+`
+		FROM users
+		WHERE LOWER(username)=LOWER($1) OR email=$2
+		ORDER BY id`, username, email)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot query database")
+	}
+
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u := &User{}
+		err = rows.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.EmailVerified)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot scan row")
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot iterate over rows")
+	}
+
+	return users, nil
+}
+
+// UpdatePassword sets the hashed password of the user with id. It returns ErrNotFound if the user does not exist.
+func (r *PostgresUserRepository) UpdatePassword(id int, hashedPassword string) error {
+	res, err := r.DB.Exec(`
+		UPDATE users
+		SET password=$1
+		WHERE id=$2`, hashedPassword, id)
+	return checkUpdated(res, err)
+}
+
+// UpdateEmail sets the email of the user with id and marks it as not verified, with a new verificationToken valid for an hour.
+// It returns ErrNotFound if the user does not exist.
+func (r *PostgresUserRepository) UpdateEmail(id int, email, verificationToken string) error {
+	res, err := r.DB.Exec(`
+		UPDATE users
+		SET email=$1, email_verified=$2, verification_token=$3, verification_token_expiration_date=NOW()+interval '1 hour'
+		WHERE id=$4`, email, false, verificationToken, id)
+	return checkUpdated(res, err)
+}
+
+// SetVerificationToken sets a new verificationToken, valid for an hour, of the user with id. It returns ErrNotFound if the user does not exist.
+func (r *PostgresUserRepository) SetVerificationToken(id int, verificationToken string) error {
+	res, err := r.DB.Exec(`
+		UPDATE users
+		SET verification_token=$1, verification_token_expiration_date=NOW()+interval '1 hour'
+		WHERE id=$2`, verificationToken, id)
+	return checkUpdated(res, err)
+}
+
+// SetRecoverToken sets a recoverToken, valid for an hour, of the user with id. It returns ErrNotFound if the user does not exist.
+func (r *PostgresUserRepository) SetRecoverToken(id int, recoverToken string) error {
+	res, err := r.DB.Exec(`
+		UPDATE users
+		SET recover_token=$1, recover_token_expiration_date=NOW()+interval '1 hour'
+		WHERE id=$2`, recoverToken, id)
+	return checkUpdated(res, err)
+}
+
+// VerifyEmail marks the email of the user with id as verified. It returns ErrNotFound if the user does not exist.
+func (r *PostgresUserRepository) VerifyEmail(id int) error {
+	res, err := r.DB.Exec(`
+		UPDATE users
+		SET email_verified=$1
+		WHERE id=$2`, true, id)
+	return checkUpdated(res, err)
+}
+
+// ResetPassword sets the hashed password of the user with id and deletes its recover token. It returns ErrNotFound if the user does not exist.
+func (r *PostgresUserRepository) ResetPassword(id int, hashedPassword string) error {
+	res, err := r.DB.Exec(`
+		UPDATE users
+		SET password=$1, recover_token=NULL, recover_token_expiration_date=NULL
+		WHERE id=$2`, hashedPassword, id)
+	return checkUpdated(res, err)
+}
+
+// memoryUser is a User kept by MemoryUserRepository, with its tokens
+type memoryUser struct {
+	User
+	tokens           map[TokenKind]string
+	tokenExpirations map[TokenKind]time.Time
+}
+
+// MemoryUserRepository is a concurrency-safe UserRepository that keeps users in memory
+type MemoryUserRepository struct {
+	mutex  sync.RWMutex
+	users  map[int]*memoryUser
+	lastID int
+}
+
+// NewMemoryUserRepository returns a new empty MemoryUserRepository
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users: make(map[int]*memoryUser),
+	}
+}
+
+// Add stores a copy of u, setting its ID
+func (r *MemoryUserRepository) Add(u *User) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.add(u)
+}
+
+func (r *MemoryUserRepository) add(u *User) *memoryUser {
+	r.lastID++
+	u.ID = r.lastID
+	mu := &memoryUser{
+		User:             *u,
+		tokens:           make(map[TokenKind]string),
+		tokenExpirations: make(map[TokenKind]time.Time),
+	}
+	r.users[u.ID] = mu
+	return mu
+}
+
+// find returns a copy of the first user matching match and whether its token of kind has expired, or ErrNotFound
+func (r *MemoryUserRepository) find(kind TokenKind, match func(u *memoryUser) bool) (*User, bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, u := range r.users {
+		if match(u) {
+			return u.clone(), time.Now().After(u.tokenExpirations[kind]), nil
+		}
+	}
+	return nil, false, ErrNotFound
+}
+
+// ByID returns the user with id, or ErrNotFound
+func (r *MemoryUserRepository) ByID(id int) (*User, error) {
+	u, _, err := r.find("", func(u *memoryUser) bool { return u.ID == id })
+	return u, err
+}
+
+// ByEmail returns the user with email, or ErrNotFound
+func (r *MemoryUserRepository) ByEmail(email string) (*User, error) {
+	u, _, err := r.find("", func(u *memoryUser) bool { return u.Email == email })
+	return u, err
+}
+
+// ByVerificationToken returns the user that is not verified yet with verificationToken and whether the token has expired, or ErrNotFound
+func (r *MemoryUserRepository) ByVerificationToken(verificationToken string) (*User, bool, error) {
+	return r.find(VerificationToken, func(u *memoryUser) bool {
+		return !u.EmailVerified && verificationToken != "" && u.tokens[VerificationToken] == verificationToken
+	})
+}
+
+// ByRecoverToken returns the user with email and recoverToken and whether the token has expired, or ErrNotFound
+func (r *MemoryUserRepository) ByRecoverToken(email, recoverToken string) (*User, bool, error) {
+	return r.find(RecoverToken, func(u *memoryUser) bool {
+		return u.Email == email && recoverToken != "" && u.tokens[RecoverToken] == recoverToken
+	})
+}
+
+// TokenExists returns whether a token of kind is already in use by a user
+func (r *MemoryUserRepository) TokenExists(kind TokenKind, token string) (bool, error) {
+	_, _, err := r.find(kind, func(u *memoryUser) bool { return token != "" && u.tokens[kind] == token })
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ByUsernameOrEmail returns the users whose username (case insensitive) or email match
+func (r *MemoryUserRepository) ByUsernameOrEmail(username, email string) ([]*User, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var users []*User
+	for _, u := range r.users {
+		if strings.EqualFold(u.Username, username) || u.Email == email {
+			users = append(users, u.clone())
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	return users, nil
+}
+
+// Insert stores u, not verified, with a verificationToken valid for an hour, setting its ID
+func (r *MemoryUserRepository) Insert(u *User, verificationToken string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, other := range r.users {
+		if strings.EqualFold(other.Username, u.Username) || other.Email == u.Email {
+			return errors.New("duplicate username or email")
+		}
+	}
+
+	u.EmailVerified = false
+	mu := r.add(u)
+	mu.tokens[VerificationToken] = verificationToken
+	mu.tokenExpirations[VerificationToken] = time.Now().Add(userTokenTTL)
+	return nil
+}
+
+// update applies f to the user with id
+func (r *MemoryUserRepository) update(id int, f func(u *memoryUser)) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	f(u)
+	return nil
+}
+
+// setToken sets a token of kind, valid for an hour, of the user with id
+func (r *MemoryUserRepository) setToken(id int, kind TokenKind, token string) error {
+	return r.update(id, func(u *memoryUser) {
+		u.tokens[kind] = token
+		u.tokenExpirations[kind] = time.Now().Add(userTokenTTL)
+	})
+}
+
+// UpdatePassword sets the hashed password of the user with id. It returns ErrNotFound if the user does not exist.
+func (r *MemoryUserRepository) UpdatePassword(id int, hashedPassword string) error {
+	return r.update(id, func(u *memoryUser) { u.Password = hashedPassword })
+}
+
+// UpdateEmail sets the email of the user with id and marks it as not verified, with a new verificationToken valid for an hour.
+// It returns ErrNotFound if the user does not exist.
+func (r *MemoryUserRepository) UpdateEmail(id int, email, verificationToken string) error {
+	err := r.update(id, func(u *memoryUser) {
+		u.Email = email
+		u.EmailVerified = false
+	})
+	if err != nil {
+		return err
+	}
+	return r.setToken(id, VerificationToken, verificationToken)
+}
+
+// SetVerificationToken sets a new verificationToken, valid for an hour, of the user with id. It returns ErrNotFound if the user does not exist.
+func (r *MemoryUserRepository) SetVerificationToken(id int, verificationToken string) error {
+	return r.setToken(id, VerificationToken, verificationToken)
+}
+
+// SetRecoverToken sets a recoverToken, valid for an hour, of the user with id. It returns ErrNotFound if the user does not exist.
+func (r *MemoryUserRepository) SetRecoverToken(id int, recoverToken string) error {
+	return r.setToken(id, RecoverToken, recoverToken)
+}
+
+// VerifyEmail marks the email of the user with id as verified. It returns ErrNotFound if the user does not exist.
+func (r *MemoryUserRepository) VerifyEmail(id int) error {
+	return r.update(id, func(u *memoryUser) { u.EmailVerified = true })
+}
+
+// ResetPassword sets the hashed password of the user with id and deletes its recover token. It returns ErrNotFound if the user does not exist.
+func (r *MemoryUserRepository) ResetPassword(id int, hashedPassword string) error {
+	return r.update(id, func(u *memoryUser) {
+		u.Password = hashedPassword
+		delete(u.tokens, RecoverToken)
+		delete(u.tokenExpirations, RecoverToken)
+	})
+}
+
+// checkUpdated returns the error of an UPDATE, or ErrNotFound if it did not affect any row
+func checkUpdated(res sql.Result, err error) error {
+	if err != nil {
+		return errors.Wrap(err, "cannot execute query")
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "cannot get rows affected")
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
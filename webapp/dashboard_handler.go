@@ -2,14 +2,20 @@ package webapp
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
 
+	"github.com/peppinux/dero-merchant/api"
 	"github.com/peppinux/dero-merchant/auth"
+	"github.com/peppinux/dero-merchant/config"
 	"github.com/peppinux/dero-merchant/httperror"
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+	"github.com/peppinux/dero-merchant/webapp/link"
 	"github.com/peppinux/dero-merchant/webapp/store"
 )
 
@@ -76,42 +82,58 @@ func MyStoresHandler(c *gin.Context) {
 	c.HTML(http.StatusOK, "stores.html", resp)
 }
 
+type paymentLimits struct {
+	MaxTTL               int
+	MinConfirmations     int
+	MaxConfirmations     int
+	TierMinConfirmations int
+}
+
 type viewStoreData struct {
-	UserSignedIn bool
-	Stores       map[int]string
-	Store        *store.Store
+	UserSignedIn  bool
+	Stores        map[int]string
+	Store         *store.Store
+	PaymentLimits *paymentLimits
 }
 
 // ViewStoreHandler handles GET requests to /dashboard/stores/view/:id
-func ViewStoreHandler(c *gin.Context) {
-	// Get Store ID from URL Params
-	storeID, err := strconv.Atoi(c.Param("id"))
-	if httperror.Render500IfErr(c, err, "Error converting string to int") != nil {
-		return
-	}
+func ViewStoreHandler(stores repository.StoreRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get Store ID from URL Params
+		storeID, err := strconv.Atoi(c.Param("id"))
+		if httperror.Render500IfErr(c, err, "Error converting string to int") != nil {
+			return
+		}
 
-	// Get User data from session
-	s := c.MustGet("session").(*auth.Session)
-	storesMap, _ := s.StoresMap()
+		// Get User data from session
+		s := c.MustGet("session").(*auth.Session)
+		storesMap, _ := s.StoresMap()
+
+		store, errCode, err := store.FetchStoreFromID(stores, storeID, s.UserID)
+		if err != nil {
+			if errCode == http.StatusNotFound {
+				// If no Store was found because of wrong ID/wrong owner/store removed, redirect to Dashboard
+				c.Redirect(http.StatusSeeOther, "/dashboard/stores")
+			} else {
+				httperror.Render500(c, err, "Error fetching store from ID")
+			}
+			return
+		}
 
-	store, errCode, err := store.FetchStoreFromID(storeID, s.UserID)
-	if err != nil {
-		if errCode == http.StatusNotFound {
-			// If no Store was found because of wrong ID/wrong owner/store removed, redirect to Dashboard
-			c.Redirect(http.StatusSeeOther, "/dashboard/stores")
-		} else {
-			httperror.Render500(c, err, "Error fetching store from ID")
+		resp := &viewStoreData{
+			UserSignedIn: s.SignedIn,
+			Stores:       storesMap,
+			Store:        store,
+			PaymentLimits: &paymentLimits{
+				MaxTTL:               config.PaymentMaxTTL,
+				MinConfirmations:     config.PaymentMinConfirmations,
+				MaxConfirmations:     config.PaymentMaxConfirmations,
+				TierMinConfirmations: config.PaymentTierMinConfirmations,
+			},
 		}
-		return
-	}
 
-	resp := &viewStoreData{
-		UserSignedIn: s.SignedIn,
-		Stores:       storesMap,
-		Store:        store,
+		c.HTML(http.StatusOK, "store.html", resp)
 	}
-
-	c.HTML(http.StatusOK, "store.html", resp)
 }
 
 type addStoreFields struct {
@@ -147,72 +169,74 @@ func AddStoreGetHandler(c *gin.Context) {
 }
 
 // AddStorePostHandler handles POST requests to /dashboard/stores/add
-func AddStorePostHandler(c *gin.Context) {
-	// Get User data from session
-	sess := c.MustGet("session").(*auth.Session)
-	storesMap, _ := sess.StoresMap()
-
-	// Get new Store data submitted by user through form
-	var (
-		f    addStoreFields
-		e    addStoreErrors
-		resp = &addStoreData{
-			UserSignedIn: sess.SignedIn,
-			Stores:       storesMap,
-			Fields:       &f,
-			Errors:       &e,
-		}
-	)
-	err := c.ShouldBind(&f)
-	if err != nil {
-		// Validate User input
-		errs := err.(validator.ValidationErrors)
-		for _, err := range errs {
-			switch err.Field() {
-			case "Title":
-				e.Title = true
-			case "ViewKey":
-				e.ViewKey = true
+func AddStorePostHandler(stores repository.StoreRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get User data from session
+		sess := c.MustGet("session").(*auth.Session)
+		storesMap, _ := sess.StoresMap()
+
+		// Get new Store data submitted by user through form
+		var (
+			f    addStoreFields
+			e    addStoreErrors
+			resp = &addStoreData{
+				UserSignedIn: sess.SignedIn,
+				Stores:       storesMap,
+				Fields:       &f,
+				Errors:       &e,
+			}
+		)
+		err := c.ShouldBind(&f)
+		if err != nil {
+			// Validate User input
+			errs := err.(validator.ValidationErrors)
+			for _, err := range errs {
+				switch err.Field() {
+				case "Title":
+					e.Title = true
+				case "ViewKey":
+					e.ViewKey = true
+				}
+			}
+			if errs != nil {
+				c.HTML(http.StatusUnprocessableEntity, "add_store.html", resp)
+				return
 			}
 		}
+
+		s, errs := store.CreateNewStore(stores, f.Title, f.ViewKey, f.Webhook, sess.UserID)
 		if errs != nil {
-			c.HTML(http.StatusUnprocessableEntity, "add_store.html", resp)
-			return
-		}
-	}
+			for _, err := range errs {
+				switch err {
+				case store.ErrInvalidTitle:
+					e.Title = true
+				case store.ErrInvalidViewKey:
+					e.ViewKey = true
+				case store.ErrTitleNotUnique:
+					e.UniqueTitle = true
+					c.HTML(http.StatusForbidden, "add_store.html", resp)
+					return
+				default:
+					httperror.Render500(c, err, "Error creating new store")
+					return
+				}
+			}
 
-	s, errs := store.CreateNewStore(f.Title, f.ViewKey, f.Webhook, sess.UserID)
-	if errs != nil {
-		for _, err := range errs {
-			switch err {
-			case store.ErrInvalidTitle:
-				e.Title = true
-			case store.ErrInvalidViewKey:
-				e.ViewKey = true
-			case store.ErrTitleNotUnique:
-				e.UniqueTitle = true
-				c.HTML(http.StatusForbidden, "add_store.html", resp)
-				return
-			default:
-				httperror.Render500(c, err, "Error creating new store")
+			if e.Title || e.ViewKey {
+				c.HTML(http.StatusUnprocessableEntity, "add_store.html", resp)
 				return
 			}
 		}
 
-		if e.Title || e.ViewKey {
-			c.HTML(http.StatusUnprocessableEntity, "add_store.html", resp)
+		err = s.Insert(stores)
+		if httperror.Render500IfErr(c, err, "Error inserting store into DB") != nil {
 			return
 		}
-	}
 
-	err = s.Insert()
-	if httperror.Render500IfErr(c, err, "Error inserting store into DB") != nil {
-		return
+		// Redirect user to new Store page
+		storeURL := fmt.Sprintf("/dashboard/stores/view/%d", s.ID)
+		c.Redirect(http.StatusSeeOther, storeURL)
 	}
-
-	// Redirect user to new Store page
-	storeURL := fmt.Sprintf("/dashboard/stores/view/%d", s.ID)
-	c.Redirect(http.StatusSeeOther, storeURL)
 }
 
 type viewStorePaymentsData struct {
@@ -241,3 +265,373 @@ func ViewStorePaymentsHandler(c *gin.Context) {
 
 	c.HTML(http.StatusOK, "payments.html", resp)
 }
+
+// ViewStoreOverviewHandler handles GET requests to /dashboard/stores/view/:id/overview
+func ViewStoreOverviewHandler(stores repository.StoreRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get User data from session
+		s := c.MustGet("session").(*auth.Session)
+		storesMap, _ := s.StoresMap()
+
+		storeID, ok := ownedStoreIDOrRedirect(c, s, stores)
+		if !ok {
+			return
+		}
+
+		resp := &viewStorePaymentsData{
+			UserSignedIn: s.SignedIn,
+			Stores:       storesMap,
+			StoreID:      storeID,
+		}
+
+		c.HTML(http.StatusOK, "overview.html", resp)
+	}
+}
+
+// Notices shown on the page of a payment after an action, mapped to the value of query param 'notice'
+var paymentNotices = map[string]string{
+	"cancelled":       "Payment cancelled.",
+	"not_pending":     "Payment could not be cancelled because it is no longer pending.",
+	"webhook_sent":    "Webhook notified of the current status of the payment.",
+	"webhook_failed":  "Webhook delivery failed. See the deliveries below for details.",
+	"webhook_not_set": "Webhook of the store is not set.",
+}
+
+// paymentTransaction represents a transaction a payment was received in
+type paymentTransaction struct {
+	TxID          string
+	Confirmations uint64
+	Known         bool // Whether the daemon knows the transaction (false if the daemon could not be reached)
+}
+
+type viewStorePaymentData struct {
+	UserSignedIn bool
+	Stores       map[int]string
+	StoreID      int
+	Payment      *api.Payment
+	PayURL       string
+	Transactions []*paymentTransaction
+	Deliveries   []*api.WebhookDelivery
+	Receipt      string // Plain text receipt of paid payments
+	Notice       string
+}
+
+// ViewStorePaymentHandler handles GET requests to /dashboard/stores/view/:id/payments/:payment_id
+func ViewStorePaymentHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get User data from session
+		s := c.MustGet("session").(*auth.Session)
+		storesMap, _ := s.StoresMap()
+
+		storeID, ok := ownedStoreIDOrRedirect(c, s, stores)
+		if !ok {
+			return
+		}
+
+		p, errCode, err := api.FetchPaymentFromID(payments, c.Param("payment_id"), storeID)
+		if err != nil {
+			if errCode == http.StatusNotFound {
+				c.Redirect(http.StatusSeeOther, fmt.Sprintf("/dashboard/stores/view/%d/payments", storeID))
+			} else {
+				httperror.Render500(c, err, "Error fetching payment from ID")
+			}
+			return
+		}
+
+		p.History, err = payments.StatusHistory(p.PaymentID)
+		if httperror.Render500IfErr(c, err, "Error fetching payment status history") != nil {
+			return
+		}
+
+		deliveries, err := payments.WebhookDeliveries(p.PaymentID)
+		if httperror.Render500IfErr(c, err, "Error fetching webhook deliveries") != nil {
+			return
+		}
+
+		resp := &viewStorePaymentData{
+			UserSignedIn: s.SignedIn,
+			Stores:       storesMap,
+			StoreID:      storeID,
+			Payment:      p,
+			PayURL:       config.BaseURL + "/pay/" + p.PaymentID,
+			Deliveries:   deliveries,
+			Notice:       paymentNotices[c.Query("notice")],
+		}
+
+		if len(p.TxIDs) > 0 {
+			// The page is rendered anyway if the daemon cannot be reached, just without confirmations
+			confirmations, err := processor.GetTransactionsConfirmations(p.TxIDs)
+			if err != nil {
+				log.Println("Error getting transactions confirmations:", err)
+			}
+			for _, txID := range p.TxIDs {
+				n, known := confirmations[txID]
+				resp.Transactions = append(resp.Transactions, &paymentTransaction{
+					TxID:          txID,
+					Confirmations: n,
+					Known:         known,
+				})
+			}
+		}
+
+		if p.Status == processor.PaymentStatusPaid {
+			r, _, err := api.FetchPaymentReceipt(stores, payments, p.PaymentID, storeID)
+			if httperror.Render500IfErr(c, err, "Error fetching payment receipt") != nil {
+				return
+			}
+			resp.Receipt = r.Text()
+		}
+
+		c.HTML(http.StatusOK, "payment.html", resp)
+	}
+}
+
+// updateStorePayment runs action on a payment of the store in the URL Params and redirects the user back to the payment,
+// with a notice about the outcome of the action
+func updateStorePayment(c *gin.Context, stores repository.StoreRepository, action func(paymentID string, storeID int) (notice string, errCode int, err error)) {
+	s := c.MustGet("session").(*auth.Session)
+
+	storeID, ok := ownedStoreIDOrRedirect(c, s, stores)
+	if !ok {
+		return
+	}
+
+	paymentID := c.Param("payment_id")
+	notice, errCode, err := action(paymentID, storeID)
+	if err != nil {
+		if errCode == http.StatusNotFound {
+			c.Redirect(http.StatusSeeOther, fmt.Sprintf("/dashboard/stores/view/%d/payments", storeID))
+		} else {
+			httperror.Render500(c, err, "Error updating payment")
+		}
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/dashboard/stores/view/%d/payments/%s?notice=%s", storeID, paymentID, notice))
+}
+
+// CancelStorePaymentPostHandler handles POST requests to /dashboard/stores/view/:id/payments/:payment_id/cancel
+func CancelStorePaymentPostHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		updateStorePayment(c, stores, func(paymentID string, storeID int) (string, int, error) {
+			_, errCode, err := api.CancelPayment(payments, paymentID, storeID, processor.StatusCauseDashboardCancel)
+			if errCode == http.StatusConflict { // Payment was paid, expired or cancelled in the meantime
+				return "not_pending", 0, nil
+			}
+			return "cancelled", errCode, err
+		})
+	}
+}
+
+// ResendStorePaymentWebhookPostHandler handles POST requests to /dashboard/stores/view/:id/payments/:payment_id/webhook
+func ResendStorePaymentWebhookPostHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		updateStorePayment(c, stores, func(paymentID string, storeID int) (string, int, error) {
+			errCode, err := api.ResendPaymentWebhook(stores, payments, paymentID, storeID)
+			switch err {
+			case nil:
+				return "webhook_sent", 0, nil
+			case api.ErrWebhookNotSet:
+				return "webhook_not_set", 0, nil
+			case api.ErrWebhookDeliveryFailed:
+				return "webhook_failed", 0, nil
+			default:
+				return "", errCode, err
+			}
+		})
+	}
+}
+
+// ownedStoreIDOrRedirect returns the ID of the store in the URL Params if it is owned by the signed in user.
+// Otherwise, it redirects the user to the Dashboard (or renders an error page) and returns ok false.
+func ownedStoreIDOrRedirect(c *gin.Context, s *auth.Session, stores repository.StoreRepository) (storeID int, ok bool) {
+	// Get Store ID from URL Params
+	storeID, err := strconv.Atoi(c.Param("id"))
+	if httperror.Render500IfErr(c, err, "Error converting string to int") != nil {
+		return
+	}
+
+	_, errCode, err := store.FetchStoreFromID(stores, storeID, s.UserID)
+	if err != nil {
+		if errCode == http.StatusNotFound {
+			// If no Store was found because of wrong ID/wrong owner/store removed, redirect to Dashboard
+			c.Redirect(http.StatusSeeOther, "/dashboard/stores")
+		} else {
+			httperror.Render500(c, err, "Error fetching store from ID")
+		}
+		return
+	}
+
+	return storeID, true
+}
+
+type addLinkFields struct {
+	Title       string `form:"title"`
+	Description string `form:"description"`
+	Slug        string `form:"slug"`
+	Currency    string `form:"currency"`
+	Amount      string `form:"amount"`
+}
+
+type addLinkErrors struct {
+	Title       bool
+	Description bool
+	Slug        bool
+	UniqueSlug  bool
+	Currency    bool
+	Amount      bool
+}
+
+type viewStoreLinksData struct {
+	UserSignedIn bool
+	Stores       map[int]string
+	StoreID      int
+	Links        []*link.Link
+	Fields       *addLinkFields
+	Errors       *addLinkErrors
+}
+
+// ViewStoreLinksHandler handles GET requests to /dashboard/stores/view/:id/links
+func ViewStoreLinksHandler(stores repository.StoreRepository, links repository.LinkRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get User data from session
+		s := c.MustGet("session").(*auth.Session)
+		storesMap, _ := s.StoresMap()
+
+		storeID, ok := ownedStoreIDOrRedirect(c, s, stores)
+		if !ok {
+			return
+		}
+
+		ls, err := link.FetchLinksFromStoreID(links, storeID)
+		if httperror.Render500IfErr(c, err, "Error fetching links from store ID") != nil {
+			return
+		}
+
+		resp := &viewStoreLinksData{
+			UserSignedIn: s.SignedIn,
+			Stores:       storesMap,
+			StoreID:      storeID,
+			Links:        ls,
+			Fields:       &addLinkFields{},
+			Errors:       &addLinkErrors{},
+		}
+
+		c.HTML(http.StatusOK, "links.html", resp)
+	}
+}
+
+// AddStoreLinkPostHandler handles POST requests to /dashboard/stores/view/:id/links
+func AddStoreLinkPostHandler(stores repository.StoreRepository, links repository.LinkRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get User data from session
+		sess := c.MustGet("session").(*auth.Session)
+		storesMap, _ := sess.StoresMap()
+
+		storeID, ok := ownedStoreIDOrRedirect(c, sess, stores)
+		if !ok {
+			return
+		}
+
+		// Get new Link data submitted by user through form
+		var (
+			f    addLinkFields
+			e    addLinkErrors
+			resp = &viewStoreLinksData{
+				UserSignedIn: sess.SignedIn,
+				Stores:       storesMap,
+				StoreID:      storeID,
+				Fields:       &f,
+				Errors:       &e,
+			}
+		)
+		err := c.ShouldBind(&f)
+		if httperror.Render500IfErr(c, err, "Error binding form to fields") != nil {
+			return
+		}
+
+		l, errs := link.CreateNewLink(links, f.Title, f.Description, f.Slug, f.Currency, f.Amount, storeID)
+		if errs != nil {
+			for _, err := range errs {
+				switch err {
+				case link.ErrInvalidTitle:
+					e.Title = true
+				case link.ErrInvalidDescription:
+					e.Description = true
+				case link.ErrInvalidSlug:
+					e.Slug = true
+				case link.ErrSlugNotUnique:
+					e.UniqueSlug = true
+				case link.ErrInvalidCurrency:
+					e.Currency = true
+				case link.ErrInvalidAmount:
+					e.Amount = true
+				default:
+					httperror.Render500(c, err, "Error creating new link")
+					return
+				}
+			}
+
+			resp.Links, err = link.FetchLinksFromStoreID(links, storeID)
+			if httperror.Render500IfErr(c, err, "Error fetching links from store ID") != nil {
+				return
+			}
+
+			if e.UniqueSlug {
+				c.HTML(http.StatusForbidden, "links.html", resp)
+			} else {
+				c.HTML(http.StatusUnprocessableEntity, "links.html", resp)
+			}
+			return
+		}
+
+		err = l.Insert(links)
+		if httperror.Render500IfErr(c, err, "Error inserting link into DB") != nil {
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, fmt.Sprintf("/dashboard/stores/view/%d/links", storeID))
+	}
+}
+
+// updateStoreLink updates a link of the store in the URL Params through update and redirects the user back to the links of the store
+func updateStoreLink(c *gin.Context, stores repository.StoreRepository, update func(linkID, storeID int) (errCode int, err error)) {
+	s := c.MustGet("session").(*auth.Session)
+
+	storeID, ok := ownedStoreIDOrRedirect(c, s, stores)
+	if !ok {
+		return
+	}
+
+	linkID, err := strconv.Atoi(c.Param("link_id"))
+	if httperror.Render500IfErr(c, err, "Error converting string to int") != nil {
+		return
+	}
+
+	errCode, err := update(linkID, storeID)
+	if err != nil && errCode != http.StatusNotFound { // Links not found were most likely removed in the meantime
+		httperror.Render500(c, err, "Error updating link")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/dashboard/stores/view/%d/links", storeID))
+}
+
+// ToggleStoreLinkPostHandler handles POST requests to /dashboard/stores/view/:id/links/:link_id/toggle
+func ToggleStoreLinkPostHandler(stores repository.StoreRepository, links repository.LinkRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		active := c.PostForm("active") == "true"
+		updateStoreLink(c, stores, func(linkID, storeID int) (int, error) {
+			return link.SetActive(links, linkID, storeID, active)
+		})
+	}
+}
+
+// RemoveStoreLinkPostHandler handles POST requests to /dashboard/stores/view/:id/links/:link_id/remove
+func RemoveStoreLinkPostHandler(stores repository.StoreRepository, links repository.LinkRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		updateStoreLink(c, stores, func(linkID, storeID int) (int, error) {
+			return link.Remove(links, linkID, storeID)
+		})
+	}
+}
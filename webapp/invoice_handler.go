@@ -0,0 +1,70 @@
+package webapp
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/peppinux/dero-merchant/api"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+type invoiceData struct {
+	StoreTitle string
+	Invoice    *api.Invoice
+}
+
+func renderInvoice404(c *gin.Context) {
+	c.HTML(http.StatusNotFound, "pay_404.html", "Invoice")
+}
+
+// InvoiceHandler handles GET requests to /invoice/:invoice_id
+func InvoiceHandler(stores repository.StoreRepository, invoices repository.InvoiceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		i, errCode, err := api.FetchPublicInvoice(invoices, c.Param("invoice_id"))
+		if err != nil {
+			if errCode == http.StatusNotFound {
+				renderInvoice404(c)
+			} else {
+				renderPay500(c, err, "Error fetching invoice")
+			}
+			return
+		}
+
+		data := &invoiceData{
+			Invoice: i,
+		}
+
+		s, err := stores.ByID(i.StoreID)
+		if err != nil {
+			renderPay500(c, err, "Error fetching store of invoice")
+			return
+		}
+		data.StoreTitle = s.Title
+
+		c.HTML(http.StatusOK, "invoice.html", data)
+	}
+}
+
+// InvoicePayHandler handles POST requests to /invoice/:invoice_id/pay.
+// It redirects the customer to the pay helper page of the pending payment of the invoice, creating it if needed.
+func InvoicePayHandler(stores repository.StoreRepository, payments repository.PaymentRepository, invoices repository.InvoiceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invoiceID := c.Param("invoice_id")
+
+		paymentID, errCode, err := api.PayInvoice(stores, payments, invoices, invoiceID)
+		if err != nil {
+			switch errCode {
+			case http.StatusNotFound:
+				renderInvoice404(c)
+			case http.StatusConflict: // Invoice was paid or voided
+				c.Redirect(http.StatusSeeOther, "/invoice/"+invoiceID)
+			default:
+				renderPay500(c, err, "Error paying invoice")
+			}
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, "/pay/"+paymentID)
+	}
+}
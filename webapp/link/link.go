@@ -0,0 +1,299 @@
+package link
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/peppinux/dero-merchant/api"
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+	"github.com/peppinux/dero-merchant/stringutil"
+)
+
+// Payment link limits
+const (
+	MaxTitleLength       = 64  // Chars
+	MaxDescriptionLength = 256 // Chars
+)
+
+var slugRegexp = regexp.MustCompile(`^[a-z0-9-]{3,32}$`)
+
+// Stats represents the payments created from a Link
+type Stats struct {
+	Payments     int
+	PaidPayments int
+	// PaidAmount is the sum of the currency amounts of the paid payments
+	PaidAmount decimal.Decimal
+}
+
+// Link represents a reusable payment link of a store.
+// Every submission of the page /link/:slug creates a new payment for Amount of Currency, or for the amount entered by the customer if Amount is nil.
+type Link struct {
+	ID          int
+	Slug        string
+	Title       string
+	Description string
+	Currency    string
+	Amount      *decimal.Decimal
+	Active      bool
+	StoreID     int
+	StoreTitle  string // Only fetched along with active links
+	Stats       Stats
+}
+
+// HasOpenAmount returns whether the amount of the payments of Link is entered by the customer
+func (l *Link) HasOpenAmount() bool {
+	return l.Amount == nil
+}
+
+// URL returns the path of the public page of Link
+func (l *Link) URL() string {
+	return "/link/" + l.Slug
+}
+
+// CreateNewLink errors
+var (
+	ErrInvalidTitle       = errors.New("Invalid link Title")
+	ErrInvalidDescription = errors.New("Invalid link Description")
+	ErrInvalidSlug        = errors.New("Invalid link Slug")
+	ErrSlugNotUnique      = errors.New("Link Slug not unique")
+	ErrInvalidCurrency    = errors.New("Invalid link Currency")
+	ErrInvalidAmount      = errors.New("Invalid link Amount")
+)
+
+// GenerateUniqueSlug generates a random slug that is not already in use by other links
+func GenerateUniqueSlug(links repository.LinkRepository) (slug string, err error) {
+	for {
+		slug, err = stringutil.RandomHexString(8)
+		if err != nil {
+			return "", errors.Wrap(err, "cannot generate random hex string")
+		}
+
+		exists, err := links.SlugExists(slug)
+		if err != nil {
+			return "", errors.Wrap(err, "cannot check if slug is unique")
+		}
+
+		if !exists {
+			break
+		}
+	}
+
+	return
+}
+
+// CreateNewLink returns a new Link ready to be inserted into DB.
+// If slug is empty, a random one is generated. If amount is empty, customers enter the amount they want to pay.
+func CreateNewLink(links repository.LinkRepository, title, description, slug, currency, amount string, storeID int) (l *Link, errs []error) {
+	// Sanitize and validate input
+	l = &Link{
+		Title:       strings.TrimSpace(title),
+		Description: strings.TrimSpace(description),
+		Slug:        strings.ToLower(strings.TrimSpace(slug)),
+		Currency:    strings.ToUpper(strings.TrimSpace(currency)),
+		Active:      true,
+		StoreID:     storeID,
+	}
+
+	if l.Title == "" || utf8.RuneCountInString(l.Title) > MaxTitleLength {
+		errs = append(errs, ErrInvalidTitle)
+	}
+	if utf8.RuneCountInString(l.Description) > MaxDescriptionLength {
+		errs = append(errs, ErrInvalidDescription)
+	}
+	if l.Slug != "" && !slugRegexp.MatchString(l.Slug) {
+		errs = append(errs, ErrInvalidSlug)
+	}
+	if !api.IsSupportedCurrency(l.Currency) {
+		errs = append(errs, ErrInvalidCurrency)
+	}
+	if amount = strings.TrimSpace(amount); amount != "" {
+		a, err := decimal.NewFromString(amount)
+		if err != nil {
+			errs = append(errs, ErrInvalidAmount)
+		} else {
+			a = api.RoundCurrencyAmount(a, l.Currency)
+			if !a.IsPositive() {
+				errs = append(errs, ErrInvalidAmount)
+			}
+			l.Amount = &a
+		}
+	}
+	if errs != nil {
+		return
+	}
+
+	var err error
+	if l.Slug == "" {
+		l.Slug, err = GenerateUniqueSlug(links)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "cannot generate unique slug"))
+		}
+		return
+	}
+
+	// Check if new Link is unique
+	exists, err := links.SlugExists(l.Slug)
+	if err != nil {
+		errs = append(errs, errors.Wrap(err, "cannot check if slug is unique"))
+		return
+	}
+	if exists {
+		errs = append(errs, ErrSlugNotUnique)
+	}
+
+	return
+}
+
+// record returns the record Link is stored as
+func (l *Link) record() *repository.PaymentLink {
+	return &repository.PaymentLink{
+		ID:          l.ID,
+		Slug:        l.Slug,
+		Title:       l.Title,
+		Description: l.Description,
+		Currency:    l.Currency,
+		Amount:      l.Amount,
+		Active:      l.Active,
+		StoreID:     l.StoreID,
+	}
+}
+
+// linkFromRecord returns the Link stored as r, along with its stats
+func linkFromRecord(r *repository.PaymentLink) *Link {
+	return &Link{
+		ID:          r.ID,
+		Slug:        r.Slug,
+		Title:       r.Title,
+		Description: r.Description,
+		Currency:    r.Currency,
+		Amount:      r.Amount,
+		Active:      r.Active,
+		StoreID:     r.StoreID,
+		Stats: Stats{
+			Payments:     r.Stats.Payments,
+			PaidPayments: r.Stats.PaidPayments,
+			PaidAmount:   r.Stats.PaidAmount,
+		},
+	}
+}
+
+// Insert inserts a Link into DB
+func (l *Link) Insert(links repository.LinkRepository) error {
+	r := l.record()
+	err := links.Insert(r)
+	if err != nil {
+		return err
+	}
+	l.ID = r.ID
+
+	return nil
+}
+
+// FetchLinksFromStoreID returns the links of a store, along with the stats of the payments created from them
+func FetchLinksFromStoreID(links repository.LinkRepository, storeID int) (ls []*Link, err error) {
+	rs, err := links.ByStore(storeID)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot fetch links")
+	}
+
+	for _, r := range rs {
+		ls = append(ls, linkFromRecord(r))
+	}
+
+	return
+}
+
+// ErrLinkNotFound is returned when a link does not exist, is not active or is not owned by the store
+var ErrLinkNotFound = errors.New("Payment link not found")
+
+// FetchActiveLinkFromSlug returns an active Link of a store that is not removed fetched from DB based on its slug
+func FetchActiveLinkFromSlug(links repository.LinkRepository, stores repository.StoreRepository, slug string) (l *Link, errCode int, err error) {
+	r, err := links.BySlug(slug)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, http.StatusNotFound, ErrLinkNotFound
+		}
+
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch link")
+	}
+	if !r.Active || r.Removed {
+		return nil, http.StatusNotFound, ErrLinkNotFound
+	}
+
+	s, err := stores.ByID(r.StoreID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot fetch store of link")
+	}
+	if s.Removed {
+		return nil, http.StatusNotFound, ErrLinkNotFound
+	}
+
+	l = linkFromRecord(r)
+	l.StoreTitle = s.Title
+	return l, 0, nil
+}
+
+// updateResult returns the error code and the error an update of a link returned err with
+func updateResult(err error) (errCode int, _ error) {
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return http.StatusNotFound, ErrLinkNotFound
+		}
+
+		return http.StatusInternalServerError, errors.Wrap(err, "cannot update link")
+	}
+
+	return 0, nil
+}
+
+// SetActive enables or disables a link of a store. Disabled links can no longer be visited by customers.
+func SetActive(links repository.LinkRepository, linkID, storeID int, active bool) (errCode int, err error) {
+	return updateResult(links.SetActive(linkID, storeID, active))
+}
+
+// Remove removes a link of a store. Payments created from it are kept.
+func Remove(links repository.LinkRepository, linkID, storeID int) (errCode int, err error) {
+	return updateResult(links.Remove(linkID, storeID))
+}
+
+// CreatePayment creates a new payment from Link, inserts it into DB and starts listening to it.
+// amount is only used if Link has an open amount.
+func (l *Link) CreatePayment(stores repository.StoreRepository, payments repository.PaymentRepository, amount decimal.Decimal) (p *api.Payment, errCode int, err error) {
+	if !l.HasOpenAmount() {
+		amount = *l.Amount
+	}
+
+	description := l.Description
+	if description == "" {
+		description = l.Title
+	}
+	opts := api.PaymentOptions{
+		Metadata: processor.PaymentMetadata{
+			Description: description,
+		},
+		PaymentLinkID: l.ID,
+	}
+
+	p, w, errCode, err := api.CreateNewPayment(stores, payments, l.Currency, amount, opts, l.StoreID)
+	if err != nil {
+		return nil, errCode, err
+	}
+
+	err = p.Insert(payments)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot insert payment into DB")
+	}
+
+	err = w.AddPendingPayment(p.PaymentID, p.PendingPayment())
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot add pending payment to wallet")
+	}
+
+	return
+}
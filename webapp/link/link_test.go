@@ -0,0 +1,199 @@
+package link
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/peppinux/dero-merchant/api"
+	"github.com/peppinux/dero-merchant/cache"
+	"github.com/peppinux/dero-merchant/config"
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+	"github.com/peppinux/dero-merchant/stringutil"
+)
+
+type LinkTestSuite struct {
+	suite.Suite
+
+	storeID int
+	repos   *repository.Repositories
+}
+
+func (suite *LinkTestSuite) SetupSuite() {
+	err := config.LoadFromENV("../../.env")
+	if err != nil {
+		panic(err)
+	}
+
+	cache.Default = cache.NewMemoryCache()
+
+	suite.repos = repository.NewMemory()
+
+	owner := &repository.User{Username: "Test owner", Email: "foobar@baz.com", Password: "password"}
+	err = suite.repos.Users.Insert(owner, "token")
+	if err != nil {
+		panic(err)
+	}
+
+	store := &repository.Store{Title: "Test store", WalletViewKey: strings.Repeat("a", 128), WebhookSecretKey: strings.Repeat("b", 64), APIKey: strings.Repeat("c", 64), SecretKey: strings.Repeat("d", 64), OwnerID: owner.ID}
+	err = suite.repos.Stores.Insert(store)
+	if err != nil {
+		panic(err)
+	}
+	suite.storeID = store.ID
+}
+
+func TestLinkTestSuite(t *testing.T) {
+	suite.Run(t, new(LinkTestSuite))
+}
+
+func (suite *LinkTestSuite) TestCreateNewLink() {
+	tests := []struct {
+		Title       string
+		Description string
+		Slug        string
+		Currency    string
+		Amount      string
+
+		ExpectedAmount string
+		ExpectedErrs   []error
+	}{
+		{"Donations", "", "", "dero", "", "", nil},
+		{"T-shirt", "Black, size M", "t-shirt-m", "DERO", "10.5", "10.5", nil},
+		{"Rounded", "", "", "DERO", "1.1234567890123", "1.123456789012", nil},
+		{"Duplicate", "", "t-shirt-m", "DERO", "", "", []error{ErrSlugNotUnique}},
+		{"", "", "", "DERO", "", "", []error{ErrInvalidTitle}},
+		{strings.Repeat("a", MaxTitleLength+1), "", "", "DERO", "", "", []error{ErrInvalidTitle}},
+		{"Title", strings.Repeat("a", MaxDescriptionLength+1), "", "DERO", "", "", []error{ErrInvalidDescription}},
+		{"Title", "", "ab", "DERO", "", "", []error{ErrInvalidSlug}},
+		{"Title", "", "Not valid!", "DERO", "", "", []error{ErrInvalidSlug}},
+		{"Title", "", "", "DERO", "-1", "", []error{ErrInvalidAmount}},
+		{"Title", "", "", "DERO", "0", "", []error{ErrInvalidAmount}},
+		{"Title", "", "", "DERO", "abc", "", []error{ErrInvalidAmount}},
+		{"", "", "x", "DERO", "0", "", []error{ErrInvalidTitle, ErrInvalidSlug, ErrInvalidAmount}},
+	}
+
+	for _, t := range tests {
+		l, errs := CreateNewLink(suite.repos.Links, t.Title, t.Description, t.Slug, t.Currency, t.Amount, suite.storeID)
+		suite.Equal(t.ExpectedErrs, errs)
+		if errs != nil {
+			continue
+		}
+
+		suite.Equal("DERO", l.Currency)
+		suite.True(slugRegexp.MatchString(l.Slug))
+		if t.Slug != "" {
+			suite.Equal(t.Slug, l.Slug)
+		}
+		if t.ExpectedAmount == "" {
+			suite.True(l.HasOpenAmount())
+		} else {
+			suite.False(l.HasOpenAmount())
+			suite.Equal(t.ExpectedAmount, l.Amount.String())
+		}
+
+		suite.Nil(l.Insert(suite.repos.Links))
+		suite.NotZero(l.ID)
+	}
+}
+
+func (suite *LinkTestSuite) TestLinks() {
+	amount := decimal.NewFromInt(5)
+	fixed := &Link{Slug: "fixed-amount", Title: "Fixed", Currency: "DERO", Amount: &amount, Active: true, StoreID: suite.storeID}
+	open := &Link{Slug: "open-amount", Title: "Open", Currency: "DERO", Active: true, StoreID: suite.storeID}
+	suite.Nil(fixed.Insert(suite.repos.Links))
+	suite.Nil(open.Insert(suite.repos.Links))
+
+	// Fetch active links
+	l, errCode, err := FetchActiveLinkFromSlug(suite.repos.Links, suite.repos.Stores, fixed.Slug)
+	suite.Nil(err)
+	suite.Equal(0, errCode)
+	suite.Equal(fixed.ID, l.ID)
+	suite.Equal("Test store", l.StoreTitle)
+	suite.True(amount.Equal(*l.Amount))
+
+	l, _, err = FetchActiveLinkFromSlug(suite.repos.Links, suite.repos.Stores, open.Slug)
+	suite.Nil(err)
+	suite.True(l.HasOpenAmount())
+
+	_, errCode, err = FetchActiveLinkFromSlug(suite.repos.Links, suite.repos.Stores, "non-existent")
+	suite.NotNil(err)
+	suite.Equal(http.StatusNotFound, errCode)
+
+	// Stats of payments created from links
+	payments := []struct {
+		LinkID int
+		Status string
+		Amount int64
+	}{
+		{fixed.ID, processor.PaymentStatusPaid, 5},
+		{fixed.ID, processor.PaymentStatusPaid, 5},
+		{fixed.ID, processor.PaymentStatusExpired, 5},
+		{open.ID, processor.PaymentStatusPending, 3},
+	}
+	for _, p := range payments {
+		paymentID, _ := stringutil.RandomHexString(32)
+		iaddr, _ := stringutil.RandomHexString(71)
+		err = (&api.Payment{
+			PaymentID:         paymentID,
+			Status:            p.Status,
+			Currency:          "DERO",
+			CurrencyAmount:    decimal.NewFromInt(p.Amount),
+			ExchangeRate:      decimal.NewFromInt(1),
+			DeroAmount:        decimal.NewFromInt(p.Amount).String(),
+			AtomicDeroAmount:  uint64(p.Amount) * 1e12,
+			IntegratedAddress: iaddr,
+			PaymentLinkID:     p.LinkID,
+			StoreID:           suite.storeID,
+		}).Insert(suite.repos.Payments)
+		suite.Nil(err)
+	}
+
+	ls, err := FetchLinksFromStoreID(suite.repos.Links, suite.storeID)
+	suite.Nil(err)
+	stats := make(map[int]Stats)
+	for _, l := range ls {
+		stats[l.ID] = l.Stats
+	}
+	suite.Equal(3, stats[fixed.ID].Payments)
+	suite.Equal(2, stats[fixed.ID].PaidPayments)
+	suite.True(decimal.NewFromInt(10).Equal(stats[fixed.ID].PaidAmount))
+	suite.Equal(1, stats[open.ID].Payments)
+	suite.Equal(0, stats[open.ID].PaidPayments)
+	suite.True(stats[open.ID].PaidAmount.IsZero())
+
+	// Disable and enable link
+	_, err = SetActive(suite.repos.Links, fixed.ID, suite.storeID, false)
+	suite.Nil(err)
+	_, errCode, _ = FetchActiveLinkFromSlug(suite.repos.Links, suite.repos.Stores, fixed.Slug)
+	suite.Equal(http.StatusNotFound, errCode)
+
+	_, err = SetActive(suite.repos.Links, fixed.ID, suite.storeID, true)
+	suite.Nil(err)
+	_, _, err = FetchActiveLinkFromSlug(suite.repos.Links, suite.repos.Stores, fixed.Slug)
+	suite.Nil(err)
+
+	// Links of other stores cannot be updated
+	errCode, err = SetActive(suite.repos.Links, fixed.ID, suite.storeID+1, false)
+	suite.Equal(ErrLinkNotFound, err)
+	suite.Equal(http.StatusNotFound, errCode)
+
+	// Remove link
+	_, err = Remove(suite.repos.Links, open.ID, suite.storeID)
+	suite.Nil(err)
+	_, errCode, _ = FetchActiveLinkFromSlug(suite.repos.Links, suite.repos.Stores, open.Slug)
+	suite.Equal(http.StatusNotFound, errCode)
+	ls, err = FetchLinksFromStoreID(suite.repos.Links, suite.storeID)
+	suite.Nil(err)
+	for _, l := range ls {
+		suite.NotEqual(open.ID, l.ID)
+	}
+
+	errCode, err = Remove(suite.repos.Links, open.ID, suite.storeID)
+	suite.Equal(ErrLinkNotFound, err)
+	suite.Equal(http.StatusNotFound, errCode)
+}
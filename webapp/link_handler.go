@@ -0,0 +1,89 @@
+package webapp
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/peppinux/dero-merchant/repository"
+	"github.com/peppinux/dero-merchant/webapp/link"
+)
+
+type linkData struct {
+	Link          *link.Link
+	Amount        string
+	InvalidAmount bool
+}
+
+// createLinkPayment creates a new payment from a link and redirects the customer to its pay helper page
+func createLinkPayment(c *gin.Context, stores repository.StoreRepository, payments repository.PaymentRepository, l *link.Link, amount decimal.Decimal, data *linkData) {
+	p, errCode, err := l.CreatePayment(stores, payments, amount)
+	if err != nil {
+		if errCode == http.StatusUnprocessableEntity && l.HasOpenAmount() {
+			data.InvalidAmount = true
+			c.HTML(http.StatusUnprocessableEntity, "link.html", data)
+			return
+		}
+
+		renderPay500(c, err, "Error creating payment from link")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/pay/"+p.PaymentID)
+}
+
+// LinkGetHandler handles GET requests to /link/:slug.
+// It only renders the landing page of the link: payments are created when the customer submits it,
+// so that crawlers and link previews do not create payments (and integrated addresses the wallet has to listen to).
+func LinkGetHandler(stores repository.StoreRepository, links repository.LinkRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l, errCode, err := link.FetchActiveLinkFromSlug(links, stores, c.Param("slug"))
+		if err != nil {
+			if errCode == http.StatusNotFound {
+				renderPay404(c)
+			} else {
+				renderPay500(c, err, "Error fetching link from slug")
+			}
+			return
+		}
+
+		c.HTML(http.StatusOK, "link.html", &linkData{
+			Link: l,
+		})
+	}
+}
+
+// LinkPostHandler handles POST requests to /link/:slug.
+// It creates a new payment for the amount of the link, or for the amount entered by the customer if the link has an open amount.
+func LinkPostHandler(stores repository.StoreRepository, payments repository.PaymentRepository, links repository.LinkRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l, errCode, err := link.FetchActiveLinkFromSlug(links, stores, c.Param("slug"))
+		if err != nil {
+			if errCode == http.StatusNotFound {
+				renderPay404(c)
+			} else {
+				renderPay500(c, err, "Error fetching link from slug")
+			}
+			return
+		}
+
+		data := &linkData{
+			Link:   l,
+			Amount: strings.TrimSpace(c.PostForm("amount")),
+		}
+
+		var amount decimal.Decimal
+		if l.HasOpenAmount() {
+			amount, err = decimal.NewFromString(data.Amount)
+			if err != nil {
+				data.InvalidAmount = true
+				c.HTML(http.StatusUnprocessableEntity, "link.html", data)
+				return
+			}
+		}
+
+		createLinkPayment(c, stores, payments, l, amount, data)
+	}
+}
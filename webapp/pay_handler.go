@@ -1,28 +1,37 @@
 package webapp
 
 import (
-	"database/sql"
+	"bytes"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gobwas/ws"
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 
-	"github.com/peppinux/dero-merchant/config"
-	"github.com/peppinux/dero-merchant/postgres"
+	"github.com/peppinux/dero-merchant/api"
 	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
 )
 
 type paymentInfo struct {
-	PaymentID         string
-	Status            string
-	Currency          string
-	CurrencyAmount    float64
-	ExchangeRate      float64
-	DeroAmount        string
-	IntegratedAddress string
-	TTL               int
+	PaymentID             string
+	Status                string
+	Currency              string
+	CurrencyAmount        decimal.Decimal
+	ExchangeRate          decimal.Decimal
+	DeroAmount            string
+	IntegratedAddress     string
+	TTL                   int
+	RequiredConfirmations int
+	OrderID               string
+	Description           string
+	RequotedFrom          string
+	RequotedTo            string
+	// Whether the customer is redirected to the store after the payment is paid or cancelled
+	RedirectOnPaid      bool
+	RedirectOnCancelled bool
 }
 
 type payData struct {
@@ -39,65 +48,192 @@ func renderPay500(c *gin.Context, err error, description string) {
 	c.AbortWithError(http.StatusInternalServerError, errors.Wrap(err, description)) // Error is only logged and not sent to the user for security reasons
 }
 
+// fetchPayment returns a payment and its store, rendering the 404 page if the payment does not exist
+func fetchPayment(c *gin.Context, stores repository.StoreRepository, payments repository.PaymentRepository, paymentID string) (p *repository.Payment, s *repository.Store, ok bool) {
+	p, err := payments.ByID(paymentID)
+	if err == nil {
+		s, err = stores.ByID(p.StoreID)
+	}
+	if err != nil {
+		if err == repository.ErrNotFound {
+			renderPay404(c)
+			return nil, nil, false
+		}
+
+		renderPay500(c, err, "Error fetching payment")
+		return nil, nil, false
+	}
+
+	return p, s, true
+}
+
 // PayHandler handles GET requests to /pay/:payment_id
-func PayHandler(c *gin.Context) {
-	var data payData
+func PayHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payment, s, ok := fetchPayment(c, stores, payments, c.Param("payment_id"))
+		if !ok {
+			return
+		}
+
+		p := &paymentInfo{
+			PaymentID:             payment.PaymentID,
+			Status:                payment.Status,
+			Currency:              payment.Currency,
+			CurrencyAmount:        payment.CurrencyAmount,
+			ExchangeRate:          payment.ExchangeRate,
+			DeroAmount:            payment.DeroAmount,
+			IntegratedAddress:     payment.IntegratedAddress,
+			RequiredConfirmations: payment.RequiredConfirmations,
+			OrderID:               payment.OrderID,
+			Description:           payment.Description,
+			RequotedFrom:          payment.RequotedFrom,
+			RequotedTo:            payment.RequotedTo,
+			RedirectOnPaid:        payment.SuccessURL != "",
+			RedirectOnCancelled:   payment.CancelURL != "",
+		}
+
+		if p.Status == processor.PaymentStatusPending {
+			p.TTL = payment.MaxTTL - payment.MinsFromCreation
+			if p.TTL < 0 {
+				p.TTL = 0
+			}
+		}
 
-	p := &paymentInfo{
-		PaymentID: c.Param("payment_id"),
+		c.HTML(http.StatusOK, "pay.html", &payData{
+			StoreTitle:  s.Title,
+			PaymentInfo: p,
+		})
 	}
+}
 
-	var minsFromCreation int
-	err := postgres.DB.QueryRow(`
-		SELECT stores.title as store_title, payments.status, payments.currency, payments.currency_amount, payments.exchange_rate, payments.dero_amount, payments.integrated_address, CEIL(EXTRACT('epoch' FROM NOW() - payments.creation_time) / 60) as mins_from_creation
-		FROM payments INNER JOIN stores ON payments.store_id=stores.id
-		WHERE payments.payment_id=$1`, p.PaymentID).
-		Scan(&data.StoreTitle, &p.Status, &p.Currency, &p.CurrencyAmount, &p.ExchangeRate, &p.DeroAmount, &p.IntegratedAddress, &minsFromCreation)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			renderPay404(c)
+// PayRedirectHandler handles GET requests to /pay/:payment_id/redirect.
+// It redirects the customer to the success (or cancel) URL of a paid (or cancelled) payment,
+// with the payment ID and a signature the store can verify added to the query params.
+func PayRedirectHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
+
+		p, s, ok := fetchPayment(c, stores, payments, paymentID)
+		if !ok {
+			return
+		}
+
+		var redirectURL string
+		switch p.Status {
+		case processor.PaymentStatusPaid:
+			redirectURL = p.SuccessURL
+		case processor.PaymentStatusCancelled:
+			redirectURL = p.CancelURL
+		}
+		if redirectURL == "" { // Nowhere to redirect to (yet)
+			c.Redirect(http.StatusFound, "/pay/"+paymentID)
 			return
 		}
 
-		renderPay500(c, err, "Error querying database")
-		return
+		redirectURL, err := api.SignRedirectURL(redirectURL, paymentID, p.Status, s.WebhookSecretKey)
+		if err != nil {
+			renderPay500(c, err, "Error signing redirect URL")
+			return
+		}
+
+		c.Redirect(http.StatusFound, redirectURL)
 	}
+}
 
-	if p.Status == processor.PaymentStatusPending {
-		p.TTL = config.PaymentMaxTTL - minsFromCreation
-		if p.TTL < 0 {
-			p.TTL = 0
+// PayRequoteHandler handles POST requests to /pay/:payment_id/requote.
+// It re-quotes an expired payment into a new one and redirects the customer to the pay helper page of the new payment.
+func PayRequoteHandler(stores repository.StoreRepository, payments repository.PaymentRepository, links repository.LinkRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
+
+		expired, _, ok := fetchPayment(c, stores, payments, paymentID)
+		if !ok {
+			return
 		}
+
+		requotedTo := expired.RequotedTo
+		if requotedTo == "" {
+			p, errCode, err := api.RequotePayment(stores, payments, links, paymentID, expired.StoreID)
+			if err != nil {
+				switch {
+				case errCode == http.StatusInternalServerError:
+					renderPay500(c, err, "Error re-quoting payment")
+					return
+				case err == api.ErrPaymentAlreadyRequoted: // Payment was re-quoted in the meantime
+					requoted, err := payments.ByRequotedFrom(paymentID)
+					if err != nil {
+						renderPay500(c, err, "Error fetching re-quoted payment")
+						return
+					}
+					requotedTo = requoted.PaymentID
+				default: // Payment is not expired (anymore), or can no longer be re-quoted
+					c.Redirect(http.StatusSeeOther, "/pay/"+paymentID)
+					return
+				}
+			} else {
+				requotedTo = p.PaymentID
+			}
+		}
+
+		c.Redirect(http.StatusSeeOther, "/pay/"+requotedTo)
 	}
+}
+
+// PayReceiptHandler handles GET requests to /pay/:payment_id/receipt.pdf.
+// It renders the PDF receipt of a paid payment. Receipts of payments that are not paid are not found.
+func PayReceiptHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
+
+		p, _, ok := fetchPayment(c, stores, payments, paymentID)
+		if !ok {
+			return
+		}
+
+		r, errCode, err := api.FetchPaymentReceipt(stores, payments, paymentID, p.StoreID)
+		if err != nil {
+			if errCode == http.StatusInternalServerError {
+				renderPay500(c, err, "Error fetching payment receipt")
+				return
+			}
+
+			renderPay404(c)
+			return
+		}
+
+		var pdf bytes.Buffer
+		err = r.Render(&pdf)
+		if err != nil {
+			renderPay500(c, err, "Error rendering payment receipt")
+			return
+		}
 
-	data.PaymentInfo = p
-	c.HTML(http.StatusOK, "pay.html", data)
+		c.Header("Content-Disposition", `inline; filename="receipt-`+paymentID+`.pdf"`)
+		c.Data(http.StatusOK, "application/pdf", pdf.Bytes())
+	}
 }
 
 // WSPaymentStatusHandler handles GET requests to /ws/payment/:payment_id/status
-func WSPaymentStatusHandler(c *gin.Context) {
-	paymentID := c.Param("payment_id")
-
-	var status string
-	err := postgres.DB.QueryRow(`
-		SELECT status
-		FROM payments
-		WHERE payment_id=$1`, paymentID).
-		Scan(&status)
-	if err != nil {
-		log.Println("Error querying database:", err)
-		return
-	}
+func WSPaymentStatusHandler(payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
 
-	if status != processor.PaymentStatusPending {
-		return
-	}
+		p, err := payments.ByID(paymentID)
+		if err != nil {
+			log.Println("Error fetching payment:", err)
+			return
+		}
 
-	conn, _, _, err := ws.UpgradeHTTP(c.Request, c.Writer)
-	if err != nil {
-		log.Println("Error upgrading HTTP:", err)
-		return
-	}
+		if p.Status != processor.PaymentStatusPending {
+			return
+		}
 
-	processor.PaymentWSConnections[paymentID] = append(processor.PaymentWSConnections[paymentID], conn)
+		conn, _, _, err := ws.UpgradeHTTP(c.Request, c.Writer)
+		if err != nil {
+			log.Println("Error upgrading HTTP:", err)
+			return
+		}
+
+		processor.PaymentWSConnections[paymentID] = append(processor.PaymentWSConnections[paymentID], conn)
+	}
 }
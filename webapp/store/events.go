@@ -0,0 +1,56 @@
+package store
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gobwas/ws"
+
+	"github.com/peppinux/dero-merchant/httperror"
+	"github.com/peppinux/dero-merchant/processor"
+	"github.com/peppinux/dero-merchant/repository"
+)
+
+// sameOrigin returns whether the request was sent by a page of this web server (or not by a browser at all)
+func sameOrigin(c *gin.Context) bool {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == c.Request.Host
+}
+
+// EventsWSHandler handles WebSocket connections to /store/:id/events.
+// Dashboard sessions of the owner of the store receive the events of the payments of the store as JSON text messages.
+func EventsWSHandler(stores repository.StoreRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Prevent other websites from opening the connection with the session cookie of the user (cross-site WebSocket hijacking)
+		if !sameOrigin(c) {
+			httperror.Send(c, http.StatusForbidden, "Forbidden")
+			return
+		}
+
+		storeID, ok := ownedStoreID(c, stores)
+		if !ok {
+			return
+		}
+
+		conn, _, _, err := ws.UpgradeHTTP(c.Request, c.Writer)
+		if err != nil {
+			log.Println("Error upgrading HTTP:", err)
+			return
+		}
+
+		// The hijacked connection keeps the read and write deadlines of the HTTP server, which would close it after a few seconds
+		conn.SetReadDeadline(time.Time{})
+		conn.SetWriteDeadline(time.Time{})
+
+		processor.StoreWSConnections.Add(storeID, conn)
+		go processor.StoreWSConnections.Listen(storeID, conn)
+	}
+}
@@ -8,141 +8,246 @@ import (
 
 	"github.com/peppinux/dero-merchant/api"
 	"github.com/peppinux/dero-merchant/auth"
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/httperror"
-	"github.com/peppinux/dero-merchant/postgres"
 	"github.com/peppinux/dero-merchant/processor"
-	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/receipt"
+	"github.com/peppinux/dero-merchant/repository"
 )
 
 type storePutRequest struct {
-	ViewKey             *string `json:"viewKey"`
-	Webhook             *string `json:"webhook"`
-	NewWebhookSecretKey bool    `json:"newWebhookSecretKey"`
-	NewStoreKeys        bool    `json:"newStoreKeys"`
+	ViewKey             *string                  `json:"viewKey"`
+	Webhook             *string                  `json:"webhook"`
+	NewWebhookSecretKey bool                     `json:"newWebhookSecretKey"`
+	NewStoreKeys        bool                     `json:"newStoreKeys"`
+	PricingPolicy       *api.PricingPolicyUpdate `json:"pricingPolicy"`
+	PaymentRequirements *api.PaymentRequirements `json:"paymentRequirements"`
+	ReceiptBranding     *receipt.Branding        `json:"receiptBranding"`
 }
 
 type storePutResponse struct {
-	ViewKey          string `json:"viewKey,omitempty"`
-	Webhook          string `json:"webhook,omitempty"`
-	WebhookSecretKey string `json:"webhookSecretKey,omitempty"`
-	APIKey           string `json:"apiKey,omitempty"`
-	SecretKey        string `json:"secretKey,omitempty"`
+	ViewKey             string                   `json:"viewKey,omitempty"`
+	Webhook             string                   `json:"webhook,omitempty"`
+	WebhookSecretKey    string                   `json:"webhookSecretKey,omitempty"`
+	APIKey              string                   `json:"apiKey,omitempty"`
+	SecretKey           string                   `json:"secretKey,omitempty"`
+	PricingPolicy       *api.PricingPolicy       `json:"pricingPolicy,omitempty"`
+	PaymentRequirements *api.PaymentRequirements `json:"paymentRequirements,omitempty"`
+	ReceiptBranding     *receipt.Branding        `json:"receiptBranding,omitempty"`
 }
 
 // PutHandler handles PUT requests to /store/:id
-func PutHandler(c *gin.Context) {
-	// Get Store ID from URL Params
-	storeID, err := strconv.Atoi(c.Param("id"))
-	if httperror.Send500IfErr(c, err, "Error converting string to int") != nil {
-		return
-	}
+func PutHandler(stores repository.StoreRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get Store ID from URL Params
+		storeID, err := strconv.Atoi(c.Param("id"))
+		if httperror.Send500IfErr(c, err, "Error converting string to int") != nil {
+			return
+		}
 
-	// Get User data from session
-	s := c.MustGet("session").(*auth.Session)
+		// Get User data from session
+		s := c.MustGet("session").(*auth.Session)
 
-	store := &Store{
-		ID:      storeID,
-		OwnerID: s.UserID,
-	}
+		store := &Store{
+			ID:      storeID,
+			OwnerID: s.UserID,
+		}
 
-	var (
-		req  storePutRequest
-		resp storePutResponse
-	)
+		var (
+			req  storePutRequest
+			resp storePutResponse
+		)
 
-	// Get input submitted by user through JSON
-	err = c.ShouldBindJSON(&req)
-	if httperror.Send500IfErr(c, err, "Error binding JSON to fields") != nil {
-		return
-	}
+		// Get input submitted by user through JSON
+		err = c.ShouldBindJSON(&req)
+		if httperror.Send500IfErr(c, err, "Error binding JSON to fields") != nil {
+			return
+		}
 
-	switch {
-	case req.ViewKey != nil: // Edit Wallet View Key
-		// Check if wallet associated to current View Key is waiting for payments.
-		// If it is, View Key cannot be modified, therefore send error message.
-		if processor.ActiveWallets.HasWalletFromStoreID(store.ID) {
-			w, err := processor.ActiveWallets.GetWalletFromStoreID(store.ID)
-			if httperror.Send500IfErr(c, err, "Error getting wallet from store ID") != nil {
+		switch {
+		case req.ViewKey != nil: // Edit Wallet View Key
+			// Check if wallet associated to current View Key is waiting for payments.
+			// If it is, View Key cannot be modified, therefore send error message.
+			if processor.ActiveWallets.HasWalletFromStoreID(store.ID) {
+				w, err := processor.ActiveWallets.GetWalletFromStoreID(store.ID)
+				if httperror.Send500IfErr(c, err, "Error getting wallet from store ID") != nil {
+					return
+				}
+
+				if w.PendingPayments.Count() > 0 {
+					httperror.Send(c, http.StatusForbidden, "Wallet associated to current View Key is still waiting for pending payments")
+					return
+				}
+			}
+
+			errCode, err := store.UpdateViewKey(stores, *req.ViewKey)
+			if err != nil {
+				if errCode == http.StatusInternalServerError {
+					httperror.Send500(c, err, "Error updating store's wallet view key")
+					return
+				}
+
+				httperror.Send(c, errCode, err.Error())
 				return
 			}
 
-			if w.PendingPayments.Count() > 0 {
-				httperror.Send(c, http.StatusForbidden, "Wallet associated to current View Key is still waiting for pending payments")
+			resp.ViewKey = store.WalletViewKey
+			c.JSON(http.StatusOK, resp)
+
+		case req.Webhook != nil: // Edit Webhook
+			errCode, err := store.UpdateWebhook(stores, *req.Webhook)
+			if err != nil {
+				if errCode == http.StatusInternalServerError {
+					httperror.Send500(c, err, "Error updating store's webhook")
+					return
+				}
+
+				httperror.Send(c, errCode, err.Error())
 				return
 			}
-		}
 
-		errCode, err := store.UpdateViewKey(*req.ViewKey)
-		if err != nil {
-			if errCode == http.StatusInternalServerError {
-				httperror.Send500(c, err, "Error updating store's wallet view key")
+			// Check if wallet associated to store is waiting for payments.
+			// If it is, update webhook url of store wallet.
+			if processor.ActiveWallets.HasWalletFromStoreID(store.ID) {
+				w, err := processor.ActiveWallets.GetWalletFromStoreID(store.ID)
+				if httperror.Send500IfErr(c, err, "Error getting wallet from store ID") != nil {
+					return
+				}
+
+				w.Webhook.URL = store.Webhook
+			}
+
+			resp.Webhook = store.Webhook
+			c.JSON(http.StatusOK, resp)
+
+		case req.NewWebhookSecretKey == true: // Generate new Webhook Secret Key
+			errCode, err := store.UpdateWebhookSecretKey(stores)
+			if err != nil {
+				if errCode == http.StatusInternalServerError {
+					httperror.Send500(c, err, "Error updating store's webhook secret key")
+					return
+				}
+
+				httperror.Send(c, errCode, err.Error())
 				return
 			}
 
-			httperror.Send(c, errCode, err.Error())
-			return
-		}
+			// Check if wallet associated to store is waiting for payments.
+			// If it is, update webhook secret key of store wallet.
+			if processor.ActiveWallets.HasWalletFromStoreID(store.ID) {
+				w, err := processor.ActiveWallets.GetWalletFromStoreID(store.ID)
+				if httperror.Send500IfErr(c, err, "Error getting wallet from store ID") != nil {
+					return
+				}
 
-		resp.ViewKey = store.WalletViewKey
-		c.JSON(http.StatusOK, resp)
+				w.Webhook.SecretKey = store.WebhookSecretKey
+			}
 
-	case req.Webhook != nil: // Edit Webhook
-		errCode, err := store.UpdateWebhook(*req.Webhook)
-		if err != nil {
-			if errCode == http.StatusInternalServerError {
-				httperror.Send500(c, err, "Error updating store's webhook")
+			resp.WebhookSecretKey = store.WebhookSecretKey
+			c.JSON(http.StatusOK, resp)
+
+		case req.NewStoreKeys == true: // Generate new Store keys
+			errCode, err := store.UpdateKeys(stores)
+			if err != nil {
+				if errCode == http.StatusInternalServerError {
+					httperror.Send500(c, err, "Error updating store's API and Secret key")
+					return
+				}
+
+				httperror.Send(c, errCode, err.Error())
 				return
 			}
 
-			httperror.Send(c, errCode, err.Error())
-			return
-		}
+			resp.APIKey = store.APIKey
+			resp.SecretKey = store.SecretKey
+			c.JSON(http.StatusOK, resp)
 
-		// Check if wallet associated to store is waiting for payments.
-		// If it is, update webhook url of store wallet.
-		if processor.ActiveWallets.HasWalletFromStoreID(store.ID) {
-			w, err := processor.ActiveWallets.GetWalletFromStoreID(store.ID)
-			if httperror.Send500IfErr(c, err, "Error getting wallet from store ID") != nil {
+		case req.PricingPolicy != nil: // Edit Pricing Policy
+			errCode, err := store.UpdatePricingPolicy(stores, *req.PricingPolicy)
+			if err != nil {
+				if errCode == http.StatusInternalServerError {
+					httperror.Send500(c, err, "Error updating store's pricing policy")
+					return
+				}
+
+				httperror.Send(c, errCode, err.Error())
 				return
 			}
 
-			w.Webhook.URL = store.Webhook
-		}
+			resp.PricingPolicy = &store.PricingPolicy
+			c.JSON(http.StatusOK, resp)
 
-		resp.Webhook = store.Webhook
-		c.JSON(http.StatusOK, resp)
+		case req.PaymentRequirements != nil: // Edit default Payment Requirements
+			errCode, err := store.UpdatePaymentRequirements(stores, *req.PaymentRequirements)
+			if err != nil {
+				if errCode == http.StatusInternalServerError {
+					httperror.Send500(c, err, "Error updating store's payment requirements")
+					return
+				}
 
-	case req.NewWebhookSecretKey == true: // Generate new Webhook Secret Key
-		errCode, err := store.UpdateWebhookSecretKey()
-		if err != nil {
-			if errCode == http.StatusInternalServerError {
-				httperror.Send500(c, err, "Error updating store's webhook secret key")
+				httperror.Send(c, errCode, err.Error())
 				return
 			}
 
-			httperror.Send(c, errCode, err.Error())
+			resp.PaymentRequirements = &store.PaymentRequirements
+			c.JSON(http.StatusOK, resp)
+
+		case req.ReceiptBranding != nil: // Edit Receipt Branding
+			errCode, err := store.UpdateReceiptBranding(stores, *req.ReceiptBranding)
+			if err != nil {
+				if errCode == http.StatusInternalServerError {
+					httperror.Send500(c, err, "Error updating store's receipt branding")
+					return
+				}
+
+				httperror.Send(c, errCode, err.Error())
+				return
+			}
+
+			resp.ReceiptBranding = &store.ReceiptBranding
+			c.JSON(http.StatusOK, resp)
+
+		default: // Invalid request
+			httperror.Send(c, http.StatusBadRequest, "Bad request")
+		}
+	}
+}
+
+// DeleteHandler handles DELTE requests to /store/:id
+func DeleteHandler(stores repository.StoreRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get Store ID from URL Params
+		storeID, err := strconv.Atoi(c.Param("id"))
+		if httperror.Send500IfErr(c, err, "Error converting string to int") != nil {
 			return
 		}
 
+		// Get User data from session
+		s := c.MustGet("session").(*auth.Session)
+
+		store := &Store{
+			ID:      storeID,
+			OwnerID: s.UserID,
+		}
+
 		// Check if wallet associated to store is waiting for payments.
-		// If it is, update webhook secret key of store wallet.
+		// If it is, store cannot be deleted, therefore send error message.
 		if processor.ActiveWallets.HasWalletFromStoreID(store.ID) {
 			w, err := processor.ActiveWallets.GetWalletFromStoreID(store.ID)
 			if httperror.Send500IfErr(c, err, "Error getting wallet from store ID") != nil {
 				return
 			}
 
-			w.Webhook.SecretKey = store.WebhookSecretKey
+			if w.PendingPayments.Count() > 0 {
+				httperror.Send(c, http.StatusForbidden, "Wallet associated to store is still waiting for pending payments")
+				return
+			}
 		}
 
-		resp.WebhookSecretKey = store.WebhookSecretKey
-		c.JSON(http.StatusOK, resp)
-
-	case req.NewStoreKeys == true: // Generate new Store keys
-		errCode, err := store.UpdateKeys()
+		errCode, err := store.Remove(stores)
 		if err != nil {
 			if errCode == http.StatusInternalServerError {
-				httperror.Send500(c, err, "Error updating store's API and Secret key")
+				httperror.Send500(c, err, "Error removing store")
 				return
 			}
 
@@ -150,89 +255,76 @@ func PutHandler(c *gin.Context) {
 			return
 		}
 
-		resp.APIKey = store.APIKey
-		resp.SecretKey = store.SecretKey
-		c.JSON(http.StatusOK, resp)
-
-	default: // Invalid request
-		httperror.Send(c, http.StatusBadRequest, "Bad request")
+		c.Status(http.StatusNoContent)
 	}
 }
 
-// DeleteHandler handles DELTE requests to /store/:id
-func DeleteHandler(c *gin.Context) {
+// ownedStoreID returns the Store ID in the URL Params, if the user that made the request actually owns the store.
+// Otherwise, an error is sent and ok is false.
+func ownedStoreID(c *gin.Context, stores repository.StoreRepository) (storeID int, ok bool) {
 	// Get Store ID from URL Params
 	storeID, err := strconv.Atoi(c.Param("id"))
 	if httperror.Send500IfErr(c, err, "Error converting string to int") != nil {
-		return
+		return 0, false
 	}
 
 	// Get User data from session
 	s := c.MustGet("session").(*auth.Session)
 
-	store := &Store{
-		ID:      storeID,
-		OwnerID: s.UserID,
+	// Check if the user that made the request actually owns the store. If not, send error
+	// Check in cache first
+	userOwnsStore, _ := cache.Default.UserOwnsStore(s.UserID, storeID)
+	if !userOwnsStore {
+		// Fallback to DB if fetching from cache failed
+		store, err := stores.ByID(storeID)
+		if err != nil && err != repository.ErrNotFound {
+			httperror.Send500(c, err, "Error querying database")
+			return 0, false
+		}
+
+		userOwnsStore = err == nil && store.OwnerID == s.UserID && !store.Removed
 	}
 
-	// Check if wallet associated to store is waiting for payments.
-	// If it is, store cannot be deleted, therefore send error message.
-	if processor.ActiveWallets.HasWalletFromStoreID(store.ID) {
-		w, err := processor.ActiveWallets.GetWalletFromStoreID(store.ID)
-		if httperror.Send500IfErr(c, err, "Error getting wallet from store ID") != nil {
-			return
-		}
+	if !userOwnsStore {
+		httperror.Send(c, http.StatusForbidden, "Forbidden")
+		return 0, false
+	}
 
-		if w.PendingPayments.Count() > 0 {
-			httperror.Send(c, http.StatusForbidden, "Wallet associated to store is still waiting for pending payments")
+	return storeID, true
+}
+
+// PaymentsGetHandler handles GET requests to /store/:id/payments
+func PaymentsGetHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storeID, ok := ownedStoreID(c, stores)
+		if !ok {
 			return
 		}
+
+		api.GetFilteredPaymentsFromStoreID(c, payments, storeID)
 	}
+}
 
-	errCode, err := store.Remove()
-	if err != nil {
-		if errCode == http.StatusInternalServerError {
-			httperror.Send500(c, err, "Error removing store")
+// PaymentsExportGetHandler handles GET requests to /store/:id/payments/export
+func PaymentsExportGetHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storeID, ok := ownedStoreID(c, stores)
+		if !ok {
 			return
 		}
 
-		httperror.Send(c, errCode, err.Error())
-		return
+		api.ExportFilteredPaymentsFromStoreID(c, payments, storeID)
 	}
-
-	c.Status(http.StatusNoContent)
 }
 
-// PaymentsGetHandler handles GET requests to /store/:id/payments
-func PaymentsGetHandler(c *gin.Context) {
-	// Get Store ID from URL Params
-	storeID, err := strconv.Atoi(c.Param("id"))
-	if httperror.Send500IfErr(c, err, "Error converting string to int") != nil {
-		return
-	}
-
-	// Get User data from session
-	s := c.MustGet("session").(*auth.Session)
-
-	// Check if the user that made the request actually owns the store. If not, send error
-	// Check in Redis first
-	userOwnsStore, _ := redis.UserOwnsStore(s.UserID, storeID)
-	if !userOwnsStore {
-		// Fallback to DB if fetching from Redis failed
-		err = postgres.DB.QueryRow(`
-		SELECT (owner_id=$1)
-		FROM stores
-		WHERE id=$2 AND removed=$3`, s.UserID, storeID, false).
-			Scan(&userOwnsStore)
-		if httperror.Send500IfErr(c, err, "Error scanning row") != nil {
+// StatsGetHandler handles GET requests to /store/:id/stats
+func StatsGetHandler(stores repository.StoreRepository, payments repository.PaymentRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storeID, ok := ownedStoreID(c, stores)
+		if !ok {
 			return
 		}
-	}
 
-	if !userOwnsStore {
-		httperror.Send(c, http.StatusForbidden, "Forbidden")
-		return
+		api.GetStatsFromStoreID(c, payments, storeID)
 	}
-
-	api.GetFilteredPaymentsFromStoreID(c, storeID)
 }
@@ -1,15 +1,16 @@
 package store
 
 import (
-	"database/sql"
 	"net/http"
 	"strings"
 
 	"github.com/pkg/errors"
 
+	"github.com/peppinux/dero-merchant/api"
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/cryptoutil"
-	"github.com/peppinux/dero-merchant/postgres"
-	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/receipt"
+	"github.com/peppinux/dero-merchant/repository"
 	"github.com/peppinux/dero-merchant/stringutil"
 )
 
@@ -24,6 +25,11 @@ type Store struct {
 	SecretKey        string
 	OwnerID          int
 	Removed          bool
+	PricingPolicy    api.PricingPolicy
+	// PaymentRequirements are the defaults of the payments of Store. Requirements set to 0 default to the ones of the operator.
+	PaymentRequirements api.PaymentRequirements
+	// ReceiptBranding is applied to the PDF receipts of the paid payments of Store
+	ReceiptBranding receipt.Branding
 }
 
 // HasValidTitle returns whether the title of Store has a valid length or not
@@ -43,24 +49,13 @@ func (s *Store) HasValidViewKey() bool {
 }
 
 // HasUniqueTitle returns whther the title of Store is unique or not
-func (s *Store) HasUniqueTitle() (bool, error) {
-	lowerTitle := strings.ToLower(s.Title)
-
-	var existingTitle string
-	err := postgres.DB.QueryRow(`
-		SELECT LOWER(title)
-		FROM stores 
-		WHERE owner_id=$1 AND LOWER(title)=$2 AND removed=$3`, s.OwnerID, lowerTitle, false).
-		Scan(&existingTitle)
+func (s *Store) HasUniqueTitle(stores repository.StoreRepository) (bool, error) {
+	exists, err := stores.TitleExists(s.OwnerID, s.Title)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return true, nil
-		}
-
 		return false, err
 	}
 
-	return false, nil
+	return !exists, nil
 }
 
 func generateStoreKeys() (apiKey, secretKey string, err error) {
@@ -78,15 +73,10 @@ func generateStoreKeys() (apiKey, secretKey string, err error) {
 	return
 }
 
-func isUniqueAPIKey(apiKey string) (bool, error) {
-	var storeID int
-	err := postgres.DB.QueryRow(`
-		SELECT id 
-		FROM stores 
-		WHERE api_key=$1`, apiKey).
-		Scan(&storeID)
+func isUniqueAPIKey(stores repository.StoreRepository, apiKey string) (bool, error) {
+	_, err := stores.ByAPIKey(apiKey)
 	if err != nil {
-		if err == sql.ErrNoRows { // API Key is unique
+		if err == repository.ErrNotFound { // API Key is unique
 			return true, nil
 		}
 
@@ -97,7 +87,7 @@ func isUniqueAPIKey(apiKey string) (bool, error) {
 }
 
 // GenerateUniqueStoreKeys generates an API Key and a Secret Key that are not already in use by other stores
-func GenerateUniqueStoreKeys() (apiKey, secretKey string, err error) {
+func GenerateUniqueStoreKeys(stores repository.StoreRepository) (apiKey, secretKey string, err error) {
 	for {
 		// Generate store keys
 		apiKey, secretKey, err = generateStoreKeys()
@@ -105,7 +95,7 @@ func GenerateUniqueStoreKeys() (apiKey, secretKey string, err error) {
 			return "", "", errors.Wrap(err, "cannot generate store keys")
 		}
 
-		isUnique, err := isUniqueAPIKey(apiKey)
+		isUnique, err := isUniqueAPIKey(stores, apiKey)
 		if err != nil {
 			return "", "", errors.Wrap(err, "cannot check if API Key is unique")
 		}
@@ -126,15 +116,10 @@ func generateWebhookSecretKey() (secretKey string, err error) {
 	return
 }
 
-func isUniqueWebhookSecretKey(secretKey string) (bool, error) {
-	var storeID int
-	err := postgres.DB.QueryRow(`
-			SELECT id
-			FROM stores
-			WHERE webhook_secret_key=$1`, secretKey).
-		Scan(storeID)
+func isUniqueWebhookSecretKey(stores repository.StoreRepository, secretKey string) (bool, error) {
+	_, err := stores.ByWebhookSecretKey(secretKey)
 	if err != nil {
-		if err == sql.ErrNoRows { // Webhook Secret Key is unique
+		if err == repository.ErrNotFound { // Webhook Secret Key is unique
 			return true, nil
 		}
 
@@ -145,7 +130,7 @@ func isUniqueWebhookSecretKey(secretKey string) (bool, error) {
 }
 
 // GenerateUniqueWebhookSecretKey generates a Webhook Secret Key that is not already in use by other stores
-func GenerateUniqueWebhookSecretKey() (secretKey string, err error) {
+func GenerateUniqueWebhookSecretKey(stores repository.StoreRepository) (secretKey string, err error) {
 	for {
 		// Generate Webhook Secret Key
 		secretKey, err = generateWebhookSecretKey()
@@ -153,7 +138,7 @@ func GenerateUniqueWebhookSecretKey() (secretKey string, err error) {
 			return "", errors.Wrap(err, "cannot generate webhook secret key")
 		}
 
-		isUnique, err := isUniqueWebhookSecretKey(secretKey)
+		isUnique, err := isUniqueWebhookSecretKey(stores, secretKey)
 		if err != nil {
 			return "", errors.Wrap(err, "cannot check if webhook secret key is unique")
 		}
@@ -173,8 +158,8 @@ var (
 	ErrTitleNotUnique = errors.New("Store Title not unique")
 )
 
-// CreateNewStore returns a new Store ready to be inserted into DB
-func CreateNewStore(title, viewKey, webhook string, ownerID int) (s *Store, errs []error) {
+// CreateNewStore returns a new Store ready to be inserted into stores
+func CreateNewStore(stores repository.StoreRepository, title, viewKey, webhook string, ownerID int) (s *Store, errs []error) {
 	// Sanitize and validate input
 	s = &Store{
 		Title:         strings.TrimSpace(title),
@@ -194,7 +179,7 @@ func CreateNewStore(title, viewKey, webhook string, ownerID int) (s *Store, errs
 	}
 
 	// Check if new Store is unique
-	unique, err := s.HasUniqueTitle()
+	unique, err := s.HasUniqueTitle(stores)
 	if err != nil {
 		errs = append(errs, errors.Wrap(err, "cannot get if store has unique title"))
 		return
@@ -206,14 +191,14 @@ func CreateNewStore(title, viewKey, webhook string, ownerID int) (s *Store, errs
 	}
 
 	// Generate new Store Keys
-	s.APIKey, s.SecretKey, err = GenerateUniqueStoreKeys()
+	s.APIKey, s.SecretKey, err = GenerateUniqueStoreKeys(stores)
 	if err != nil {
 		errs = append(errs, errors.Wrap(err, "cannot generate unique store keys"))
 		return
 	}
 
 	// Generate Webhook Secret Key
-	s.WebhookSecretKey, err = GenerateUniqueWebhookSecretKey()
+	s.WebhookSecretKey, err = GenerateUniqueWebhookSecretKey(stores)
 	if err != nil {
 		errs = append(errs, errors.Wrap(err, "cannot generate unique webhook secret key"))
 		return
@@ -222,48 +207,79 @@ func CreateNewStore(title, viewKey, webhook string, ownerID int) (s *Store, errs
 	return
 }
 
-// Insert inserts a Store into DB
-func (s *Store) Insert() error {
-	err := postgres.DB.QueryRow(`
-		INSERT INTO stores (title, wallet_view_key, webhook, webhook_secret_key, api_key, secret_key, owner_id) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id`, s.Title, s.WalletViewKey, s.Webhook, s.WebhookSecretKey, s.APIKey, s.SecretKey, s.OwnerID).
-		Scan(&s.ID)
+// Insert inserts a Store into stores
+func (s *Store) Insert(stores repository.StoreRepository) error {
+	r := &repository.Store{
+		Title:            s.Title,
+		WalletViewKey:    s.WalletViewKey,
+		Webhook:          s.Webhook,
+		WebhookSecretKey: s.WebhookSecretKey,
+		APIKey:           s.APIKey,
+		SecretKey:        s.SecretKey,
+		OwnerID:          s.OwnerID,
+	}
+	err := stores.Insert(r)
 	if err != nil {
-		return errors.Wrap(err, "cannot query database")
+		return errors.Wrap(err, "cannot insert store")
 	}
+	s.ID = r.ID
 
-	// Save new Store ID and Title into Redis for future quick fetching from Dashboard
-	redis.AddUserStore(s.OwnerID, s.ID)
-	redis.SetStoreTitle(s.ID, s.Title)
-	// Also save API Key data to make auth middleware faster
-	redis.SetAPIKeyStore(s.APIKey, s.ID)
-	redis.SetAPIKeySecretKey(s.APIKey, s.SecretKey)
+	// Save new Store ID and Title into cache for future quick fetching from Dashboard
+	cache.Default.AddUserStore(s.OwnerID, s.ID)
+	cache.Default.SetStoreTitle(s.ID, s.Title)
+	// Also save API Key data (associated to Hashed API Key, like the auth middleware does) to make auth middleware faster
+	hashedAPIKey := cryptoutil.HashStringToSHA256Hex(s.APIKey)
+	cache.Default.SetAPIKeyStore(hashedAPIKey, s.ID)
+	cache.Default.SetAPIKeySecretKey(hashedAPIKey, s.SecretKey)
 
 	return nil
 }
 
-// FetchStoreFromID returns a Store fetched from DB based on its ID
-func FetchStoreFromID(storeID, ownerID int) (s *Store, errCode int, err error) {
-	s = &Store{
-		ID:      storeID,
-		OwnerID: ownerID,
+// fromRecord returns the Store of a store stored in stores
+func fromRecord(r *repository.Store) (*Store, error) {
+	s := &Store{
+		ID:               r.ID,
+		Title:            r.Title,
+		WalletViewKey:    r.WalletViewKey,
+		Webhook:          r.Webhook,
+		WebhookSecretKey: r.WebhookSecretKey,
+		APIKey:           r.APIKey,
+		SecretKey:        r.SecretKey,
+		OwnerID:          r.OwnerID,
+		Removed:          r.Removed,
+		PricingPolicy:    api.StorePricingPolicy(r),
+		ReceiptBranding: receipt.Branding{
+			Color:  r.ReceiptColor,
+			Footer: r.ReceiptFooter,
+		},
+	}
+
+	var err error
+	s.PaymentRequirements, err = api.StorePaymentRequirements(r)
+	if err != nil {
+		return nil, err
 	}
 
-	err = postgres.DB.QueryRow(`
-		SELECT title, wallet_view_key, webhook, webhook_secret_key, api_key, secret_key 
-		FROM stores 
-		WHERE id=$1 AND owner_id=$2 AND removed=$3`, s.ID, s.OwnerID, false).
-		Scan(&s.Title, &s.WalletViewKey, &s.Webhook, &s.WebhookSecretKey, &s.APIKey, &s.SecretKey)
+	return s, nil
+}
+
+// FetchStoreFromID returns a Store fetched from DB based on its ID
+func FetchStoreFromID(stores repository.StoreRepository, storeID, ownerID int) (s *Store, errCode int, err error) {
+	r, err := stores.ByID(storeID)
+	if err == nil && (r.OwnerID != ownerID || r.Removed) {
+		err = repository.ErrNotFound
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			errCode = http.StatusNotFound
-		} else {
-			errCode = http.StatusInternalServerError
-			err = errors.Wrap(err, "cannot query database")
+		if err == repository.ErrNotFound {
+			return nil, http.StatusNotFound, err
 		}
 
-		return
+		return nil, http.StatusInternalServerError, errors.Wrap(err, "cannot query database")
+	}
+
+	s, err = fromRecord(r)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
 	}
 
 	return
@@ -275,8 +291,18 @@ var (
 	ErrInvalidViewKeyVerbose = errors.New("Wallet View Key needs to be either 128 characters long")
 )
 
-// UpdateViewKey updates Store's Wallet View Key in DB
-func (s *Store) UpdateViewKey(newViewKey string) (errCode int, err error) {
+// updateErrCode returns the error code and the error of a failed update of a Store
+func updateErrCode(err error) (int, error) {
+	if err == repository.ErrNotFound {
+		// If Store was not updated in DB, most likely because user had no permission to, return error
+		return http.StatusForbidden, ErrForbidden
+	}
+
+	return http.StatusInternalServerError, errors.Wrap(err, "cannot update store")
+}
+
+// UpdateViewKey updates Store's Wallet View Key in stores
+func (s *Store) UpdateViewKey(stores repository.StoreRepository, newViewKey string) (errCode int, err error) {
 	// Sanitize input
 	s.WalletViewKey = strings.TrimSpace(newViewKey)
 
@@ -286,144 +312,162 @@ func (s *Store) UpdateViewKey(newViewKey string) (errCode int, err error) {
 	}
 
 	// Update Store's Wallet View Key in DB
-	res, err := postgres.DB.Exec(`
-		UPDATE stores
-		SET wallet_view_key=$1
-		WHERE id=$2 AND owner_id=$3 AND removed=$4`, s.WalletViewKey, s.ID, s.OwnerID, false)
+	err = stores.UpdateViewKey(s.ID, s.OwnerID, s.WalletViewKey)
 	if err != nil {
-		return http.StatusInternalServerError, errors.Wrap(err, "cannot execute query")
-	}
-
-	// If Store was not updated in DB, most likely because user had no permission to, return error
-	numRows, _ := res.RowsAffected()
-	if numRows == 0 {
-		return http.StatusForbidden, ErrForbidden
+		return updateErrCode(err)
 	}
 
 	return
 }
 
-// UpdateWebhook updates Stores' Webhook URL in DB
-func (s *Store) UpdateWebhook(newWebhook string) (errCode int, err error) {
+// UpdateWebhook updates Stores' Webhook URL in stores
+func (s *Store) UpdateWebhook(stores repository.StoreRepository, newWebhook string) (errCode int, err error) {
 	// Sanitize input
 	s.Webhook = strings.TrimSpace(newWebhook)
 
 	// Update Store's Webhook URL in DB
-	res, err := postgres.DB.Exec(`
-		UPDATE stores
-		SET webhook=$1
-		WHERE id=$2 AND owner_id=$3 AND removed=$4`, s.Webhook, s.ID, s.OwnerID, false)
+	err = stores.UpdateWebhook(s.ID, s.OwnerID, s.Webhook)
 	if err != nil {
-		return http.StatusInternalServerError, errors.Wrap(err, "cannot execute query")
+		return updateErrCode(err)
 	}
 
-	// If Store was not updated in DB, most likely because user had no permission to, return error
-	numRows, _ := res.RowsAffected()
-	if numRows == 0 {
-		return http.StatusForbidden, ErrForbidden
+	return
+}
+
+// UpdatePricingPolicy updates Store's Pricing Policy in DB. Settings not set in update keep their current value.
+// Payments created before the update keep the pricing policy they were created with.
+func (s *Store) UpdatePricingPolicy(stores repository.StoreRepository, update api.PricingPolicyUpdate) (errCode int, err error) {
+	// Validate input
+	err = update.Validate()
+	if err != nil {
+		return http.StatusUnprocessableEntity, err
 	}
 
+	// Update Store's Pricing Policy in DB
+	updated, err := stores.UpdatePricingPolicy(s.ID, s.OwnerID, update.RateMarkup, update.UnderpaymentTolerance, update.DeroDecimals)
+	if err != nil {
+		return updateErrCode(err)
+	}
+	s.PricingPolicy = api.StorePricingPolicy(updated)
+
 	return
 }
 
-// UpdateWebhookSecretKey generates a new Webhook Secret Key for Store and updates it in DB
-func (s *Store) UpdateWebhookSecretKey() (errCode int, err error) {
-	s.WebhookSecretKey, err = GenerateUniqueWebhookSecretKey()
+// UpdatePaymentRequirements updates Store's default Payment Requirements in DB.
+// Payments created before the update keep the requirements they were created with.
+func (s *Store) UpdatePaymentRequirements(stores repository.StoreRepository, newPaymentRequirements api.PaymentRequirements) (errCode int, err error) {
+	// Validate input
+	err = newPaymentRequirements.ValidateDefaults()
+	if err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+
+	s.PaymentRequirements = newPaymentRequirements
+
+	confirmationTiers, err := s.PaymentRequirements.ConfirmationTiers.Encode()
 	if err != nil {
-		return http.StatusInternalServerError, errors.Wrap(err, "cannot generate unique webhook secret key")
+		return http.StatusInternalServerError, errors.Wrap(err, "cannot marshal confirmation tiers")
 	}
 
-	// Update Store in DB (Set new Webhook Secret Key)
-	res, err := postgres.DB.Exec(`
-		UPDATE stores
-		SET webhook_secret_key=$1
-		WHERE id=$2 AND owner_id=$3 AND removed=$4`, s.WebhookSecretKey, s.ID, s.OwnerID, false)
+	// Update Store's Payment Requirements in DB
+	err = stores.UpdatePaymentRequirements(s.ID, s.OwnerID, s.PaymentRequirements.TTL, s.PaymentRequirements.MinConfirmations, confirmationTiers)
 	if err != nil {
-		return http.StatusInternalServerError, errors.Wrap(err, "cannot execute query")
+		return updateErrCode(err)
 	}
 
-	// If Store was not updated in DB, most likely because user had no permission to, return error
-	numRows, _ := res.RowsAffected()
-	if numRows == 0 {
-		return http.StatusForbidden, ErrForbidden
+	return
+}
+
+// UpdateReceiptBranding updates the branding of the receipts of Store in DB.
+// Receipts are rendered on request, so the new branding applies to the receipts of past payments too.
+func (s *Store) UpdateReceiptBranding(stores repository.StoreRepository, newReceiptBranding receipt.Branding) (errCode int, err error) {
+	// Validate input
+	err = newReceiptBranding.Validate()
+	if err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+
+	s.ReceiptBranding = newReceiptBranding
+
+	// Update Store's Receipt Branding in DB
+	err = stores.UpdateReceiptBranding(s.ID, s.OwnerID, s.ReceiptBranding.Color, s.ReceiptBranding.Footer)
+	if err != nil {
+		return updateErrCode(err)
 	}
 
 	return
 }
 
-// UpdateKeys generates new API and Secret Key for Store and updates it in DB
-func (s *Store) UpdateKeys() (errCode int, err error) {
-	// Fetch current API Key in order to remove it from Redis
-	err = postgres.DB.QueryRow(`
-		SELECT api_key 
-		FROM stores 
-		WHERE id=$1 AND owner_id=$2 AND removed=$3`, s.ID, s.OwnerID, false).
-		Scan(&s.APIKey)
+// UpdateWebhookSecretKey generates a new Webhook Secret Key for Store and updates it in stores
+func (s *Store) UpdateWebhookSecretKey(stores repository.StoreRepository) (errCode int, err error) {
+	s.WebhookSecretKey, err = GenerateUniqueWebhookSecretKey(stores)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// If Store was not found in DB, most likely because user had no permission to, return error
-			return http.StatusForbidden, ErrForbidden
-		}
+		return http.StatusInternalServerError, errors.Wrap(err, "cannot generate unique webhook secret key")
+	}
 
-		return http.StatusInternalServerError, errors.Wrap(err, "cannot query database")
+	// Update Store in DB (Set new Webhook Secret Key)
+	err = stores.UpdateWebhookSecretKey(s.ID, s.OwnerID, s.WebhookSecretKey)
+	if err != nil {
+		return updateErrCode(err)
 	}
 
-	redis.DeleteAPIKeyStore(s.APIKey)
-	redis.DeleteAPIKeySecretKey(s.APIKey)
+	return
+}
+
+// UpdateKeys generates new API and Secret Key for Store and updates it in stores
+func (s *Store) UpdateKeys(stores repository.StoreRepository) (errCode int, err error) {
+	// Fetch current API Key in order to remove it from cache
+	current, err := stores.ByID(s.ID)
+	if err == nil && (current.OwnerID != s.OwnerID || current.Removed) {
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		// If Store was not found in DB, most likely because user had no permission to, return error
+		return updateErrCode(err)
+	}
+	s.APIKey = current.APIKey
+
+	// Remove Store ID and Secret Key associated to old Hashed API Key, so that it cannot be used anymore
+	hashedAPIKey := cryptoutil.HashStringToSHA256Hex(s.APIKey)
+	cache.Default.DeleteAPIKeyStore(hashedAPIKey)
+	cache.Default.DeleteAPIKeySecretKey(hashedAPIKey)
 
 	// Generate new API Key and Secret Key
-	s.APIKey, s.SecretKey, err = GenerateUniqueStoreKeys()
+	s.APIKey, s.SecretKey, err = GenerateUniqueStoreKeys(stores)
 	if err != nil {
 		return http.StatusInternalServerError, errors.Wrap(err, "cannot generate unique store keys")
 	}
 
 	// Update Store's API and Secret Key in DB
-	res, err := postgres.DB.Exec(`
-		UPDATE stores
-		SET api_key=$1, secret_key=$2
-		WHERE id=$3 AND owner_id=$4 AND removed=$5`, s.APIKey, s.SecretKey, s.ID, s.OwnerID, false)
+	err = stores.UpdateKeys(s.ID, s.OwnerID, s.APIKey, s.SecretKey)
 	if err != nil {
-		return http.StatusInternalServerError, errors.Wrap(err, "cannot execute query")
-	}
-
-	// If Store was not updated in DB, most likely because user had no permission to, return error
-	numRows, _ := res.RowsAffected()
-	if numRows == 0 {
-		return http.StatusForbidden, ErrForbidden
+		return updateErrCode(err)
 	}
 
-	// Save new API Key and Secret Key in Redis
-	redis.SetAPIKeyStore(s.APIKey, s.ID)
-	redis.SetAPIKeySecretKey(s.APIKey, s.SecretKey)
+	// Save new API Key and Secret Key in cache
+	hashedAPIKey = cryptoutil.HashStringToSHA256Hex(s.APIKey)
+	cache.Default.SetAPIKeyStore(hashedAPIKey, s.ID)
+	cache.Default.SetAPIKeySecretKey(hashedAPIKey, s.SecretKey)
 
 	return
 }
 
-// Remove removes a Store from DB
-func (s *Store) Remove() (errCode int, err error) {
+// Remove removes a Store from stores
+func (s *Store) Remove(stores repository.StoreRepository) (errCode int, err error) {
 	// Update Store in DB (Set removed=true)
-	err = postgres.DB.QueryRow(`
-		UPDATE stores 
-		SET removed=$1 
-		WHERE id=$2 AND owner_id=$3 AND removed=$4
-		RETURNING api_key`, true, s.ID, s.OwnerID, false).
-		Scan(&s.APIKey)
+	removed, err := stores.Remove(s.ID, s.OwnerID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// If Store was not updated in DB, most likely because user had no permission to, return error
-			return http.StatusForbidden, ErrForbidden
-		}
-
-		return http.StatusInternalServerError, errors.Wrap(err, "cannot execute query")
+		return updateErrCode(err)
 	}
+	s.APIKey = removed.APIKey
 
-	// Remove Store ID, Title and Owner from Redis
-	redis.RemoveUserStore(s.OwnerID, s.ID)
-	redis.DeleteStoreTitle(s.ID)
-	// Remove Store ID and Secret Key associated to Hashed API Key in Redis
+	// Remove Store ID, Title and Owner from cache
+	cache.Default.RemoveUserStore(s.OwnerID, s.ID)
+	cache.Default.DeleteStoreTitle(s.ID)
+	// Remove Store ID and Secret Key associated to Hashed API Key in cache
 	hashedAPIKey := cryptoutil.HashStringToSHA256Hex(s.APIKey)
-	redis.DeleteAPIKeyStore(hashedAPIKey)
-	redis.DeleteAPIKeySecretKey(hashedAPIKey)
+	cache.Default.DeleteAPIKeyStore(hashedAPIKey)
+	cache.Default.DeleteAPIKeySecretKey(hashedAPIKey)
 
 	return
 }
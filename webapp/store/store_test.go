@@ -1,16 +1,19 @@
 package store
 
 import (
-	"database/sql"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/alexedwards/argon2id"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/peppinux/dero-merchant/api"
+	"github.com/peppinux/dero-merchant/cache"
 	"github.com/peppinux/dero-merchant/config"
-	"github.com/peppinux/dero-merchant/postgres"
-	"github.com/peppinux/dero-merchant/redis"
+	"github.com/peppinux/dero-merchant/receipt"
+	"github.com/peppinux/dero-merchant/repository"
 	"github.com/peppinux/dero-merchant/stringutil"
 )
 
@@ -28,6 +31,7 @@ type StoreTestSuite struct {
 	suite.Suite
 
 	mockUser *UserMock
+	stores   repository.StoreRepository
 }
 
 func (suite *StoreTestSuite) SetupSuite() {
@@ -36,24 +40,10 @@ func (suite *StoreTestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	redis.Pool = redis.NewPool(config.TestRedisAddress)
-	err = redis.Ping()
-	if err != nil {
-		panic(err)
-	}
-
-	err = redis.FlushAll()
-	if err != nil {
-		panic(err)
-	}
-
-	postgres.DB, err = postgres.Connect(config.TestDBName, config.TestDBUser, config.TestDBPassword, config.TestDBHost, config.TestDBPort, "disable") // TODO: Gestire SSLMode
-	if err != nil {
-		panic(err)
-	}
+	cache.Default = cache.NewMemoryCache()
 
-	postgres.DropTables()
-	postgres.CreateTablesIfNotExist()
+	repos := repository.NewMemory()
+	suite.stores = repos.Stores
 
 	suite.mockUser = &UserMock{
 		Username: "Test owner",
@@ -74,57 +64,23 @@ func (suite *StoreTestSuite) SetupSuite() {
 		panic(err)
 	}
 
-	err = postgres.DB.QueryRow(`
-		INSERT INTO users (username, email, password, verification_token, email_verified)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`, u.Username, u.Email, u.HashedPassword, u.VerificationToken, u.Verified).
-		Scan(&u.ID)
+	user := &repository.User{Username: u.Username, Email: u.Email, Password: u.HashedPassword}
+	err = repos.Users.Insert(user, u.VerificationToken)
 	if err != nil {
 		panic(err)
 	}
-}
-
-func (suite *StoreTestSuite) TearDownSuite() {
-	redis.FlushAll()
-	redis.Pool.Close()
+	u.ID = user.ID
 
-	postgres.DropTables()
-	postgres.DB.Close()
+	err = repos.Users.VerifyEmail(u.ID)
+	if err != nil {
+		panic(err)
+	}
 }
 
 func TestStoreTestSuite(t *testing.T) {
 	suite.Run(t, new(StoreTestSuite))
 }
 
-func (suite *StoreTestSuite) TestHasValidTitle() {
-	testStores := map[*Store]bool{
-		&Store{Title: "Amazing Store"}:                          true,
-		&Store{Title: "Bay of Products"}:                        true,
-		&Store{Title: "Productslist"}:                           true,
-		&Store{Title: ""}:                                       false,
-		&Store{Title: "The title of this store is way to long"}: false,
-	}
-
-	for s, shouldValid := range testStores {
-		isValid := s.HasValidTitle()
-		suite.Equal(shouldValid, isValid)
-	}
-}
-
-func (suite *StoreTestSuite) TestHasValidViewKey() {
-	testStores := map[*Store]bool{
-		&Store{WalletViewKey: "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c"}:  true,
-		&Store{WalletViewKey: "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0"}:   false,
-		&Store{WalletViewKey: "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0ca"}: false,
-		&Store{WalletViewKey: ""}: false,
-	}
-
-	for s, shouldValid := range testStores {
-		isValid := s.HasValidViewKey()
-		suite.Equal(shouldValid, isValid)
-	}
-}
-
 func (suite *StoreTestSuite) TestHasUniqueTitle() {
 	ownerID := suite.mockUser.ID
 
@@ -140,17 +96,17 @@ func (suite *StoreTestSuite) TestHasUniqueTitle() {
 	}
 
 	for _, t := range testTitles {
-		s, _ := CreateNewStore(t, "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
-		isUnique, err := s.HasUniqueTitle()
+		s, _ := CreateNewStore(suite.stores, t, "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+		isUnique, err := s.HasUniqueTitle(suite.stores)
 		suite.Nil(err)
 		suite.True(isUnique)
 
-		s.Insert()
+		s.Insert(suite.stores)
 	}
 
 	for _, t := range duplicateTitles {
-		s, _ := CreateNewStore(t, "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
-		isUnique, err := s.HasUniqueTitle()
+		s, _ := CreateNewStore(suite.stores, t, "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+		isUnique, err := s.HasUniqueTitle(suite.stores)
 		suite.Nil(err)
 		suite.False(isUnique)
 	}
@@ -161,7 +117,7 @@ func (suite *StoreTestSuite) TestGenerateUniqueStoreKeys() {
 	generatedAPIKeys := make([]string, count)
 
 	for i := 0; i < count; i++ {
-		apiKey, secretKey, err := GenerateUniqueStoreKeys()
+		apiKey, secretKey, err := GenerateUniqueStoreKeys(suite.stores)
 		suite.Nil(err)
 		suite.Len(apiKey, 64)
 		suite.Len(secretKey, 64)
@@ -176,7 +132,7 @@ func (suite *StoreTestSuite) TestGenerateUniqueWebhookSecretKey() {
 	generatedKeys := make([]string, count)
 
 	for i := 0; i < count; i++ {
-		secretKey, err := GenerateUniqueWebhookSecretKey()
+		secretKey, err := GenerateUniqueWebhookSecretKey(suite.stores)
 		suite.Nil(err)
 		suite.Len(secretKey, 64)
 		suite.NotContains(generatedKeys, secretKey)
@@ -210,39 +166,39 @@ func (suite *StoreTestSuite) TestStores() {
 	)
 	for _, s := range testStores {
 		// Test CreateNewStore
-		s.Store, errs = CreateNewStore(s.Title, s.ViewKey, s.Webhook, ownerID)
+		s.Store, errs = CreateNewStore(suite.stores, s.Title, s.ViewKey, s.Webhook, ownerID)
 		suite.Equal(s.ExpectedErrs, errs)
 
 		if errs == nil {
 			// Test Insert
-			err := s.Store.Insert()
+			err := s.Store.Insert(suite.stores)
 			suite.Nil(err)
 
 			lastStoreID = s.Store.ID
 
 			// Test FetchStoreFromID
-			_, errCode, err := FetchStoreFromID(s.Store.ID, ownerID)
+			_, errCode, err := FetchStoreFromID(suite.stores, s.Store.ID, ownerID)
 			suite.Zero(errCode)
 			suite.Nil(err)
 		}
 	}
 
 	// Test FetchStoreFromID (store not found)
-	_, errCode, err := FetchStoreFromID(lastStoreID+123, ownerID)
+	_, errCode, err := FetchStoreFromID(suite.stores, lastStoreID+123, ownerID)
 	suite.Equal(http.StatusNotFound, errCode)
-	suite.Equal(sql.ErrNoRows, err)
+	suite.Equal(repository.ErrNotFound, err)
 
 	// Test FetchStoreFromID (invalid owner)
-	_, errCode, err = FetchStoreFromID(lastStoreID, ownerID+123)
+	_, errCode, err = FetchStoreFromID(suite.stores, lastStoreID, ownerID+123)
 	suite.Equal(http.StatusNotFound, errCode)
-	suite.Equal(sql.ErrNoRows, err)
+	suite.Equal(repository.ErrNotFound, err)
 }
 
 func (suite *StoreTestSuite) TestUpdateViewKey() {
 	ownerID := suite.mockUser.ID
 
-	s, _ := CreateNewStore("Store to be updated 1", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
-	s.Insert()
+	s, _ := CreateNewStore(suite.stores, "Store to be updated 1", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+	s.Insert(suite.stores)
 
 	testViewKeys := []struct {
 		NewViewKey string
@@ -256,12 +212,12 @@ func (suite *StoreTestSuite) TestUpdateViewKey() {
 	}
 
 	for _, vk := range testViewKeys {
-		errCode, err := s.UpdateViewKey(vk.NewViewKey)
+		errCode, err := s.UpdateViewKey(suite.stores, vk.NewViewKey)
 		suite.Equal(vk.ExpectedErrCode, errCode)
 		suite.Equal(vk.ExpectedErr, err)
 	}
 
-	s, _, _ = FetchStoreFromID(s.ID, ownerID)
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
 	suite.Equal(testViewKeys[2].NewViewKey, s.WalletViewKey)
 }
 
@@ -269,33 +225,33 @@ func (suite *StoreTestSuite) TestUpdateWebhook() {
 	ownerID := suite.mockUser.ID
 
 	url := ""
-	s, _ := CreateNewStore("Store to be updated 2", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", url, ownerID)
-	s.Insert()
+	s, _ := CreateNewStore(suite.stores, "Store to be updated 2", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", url, ownerID)
+	s.Insert(suite.stores)
 
-	s, _, _ = FetchStoreFromID(s.ID, ownerID)
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
 	suite.Equal(url, s.Webhook)
 
 	newURL := "test.com/webhook"
-	errCode, err := s.UpdateWebhook(newURL)
+	errCode, err := s.UpdateWebhook(suite.stores, newURL)
 	suite.Zero(errCode)
 	suite.Nil(err)
 
-	s, _, _ = FetchStoreFromID(s.ID, ownerID)
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
 	suite.Equal(newURL, s.Webhook)
 }
 
 func (suite *StoreTestSuite) TestUpdateWebhookSecretKey() {
 	ownerID := suite.mockUser.ID
 
-	s, _ := CreateNewStore("Store to be updated 3", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
-	s.Insert()
+	s, _ := CreateNewStore(suite.stores, "Store to be updated 3", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+	s.Insert(suite.stores)
 
 	oldSecretKey := s.WebhookSecretKey
 
-	s, _, _ = FetchStoreFromID(s.ID, ownerID)
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
 	suite.Equal(oldSecretKey, s.WebhookSecretKey)
 
-	errCode, err := s.UpdateWebhookSecretKey()
+	errCode, err := s.UpdateWebhookSecretKey(suite.stores)
 	suite.Zero(errCode)
 	suite.Nil(err)
 
@@ -305,15 +261,15 @@ func (suite *StoreTestSuite) TestUpdateWebhookSecretKey() {
 func (suite *StoreTestSuite) TestUpdateKeys() {
 	ownerID := suite.mockUser.ID
 
-	s, _ := CreateNewStore("Store to be updated 4", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
-	s.Insert()
+	s, _ := CreateNewStore(suite.stores, "Store to be updated 4", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+	s.Insert(suite.stores)
 
 	oldAPIKey, oldSecretKey := s.APIKey, s.SecretKey
-	s, _, _ = FetchStoreFromID(s.ID, ownerID)
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
 	suite.Equal(oldAPIKey, s.APIKey)
 	suite.Equal(oldSecretKey, s.SecretKey)
 
-	errCode, err := s.UpdateKeys()
+	errCode, err := s.UpdateKeys(suite.stores)
 	suite.Zero(errCode)
 	suite.Nil(err)
 
@@ -321,27 +277,164 @@ func (suite *StoreTestSuite) TestUpdateKeys() {
 	suite.NotEqual(oldSecretKey, s.SecretKey)
 }
 
+func (suite *StoreTestSuite) TestUpdatePricingPolicy() {
+	ownerID := suite.mockUser.ID
+
+	s, _ := CreateNewStore(suite.stores, "Store to be updated 6", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+	s.Insert(suite.stores)
+
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
+	suite.True(s.PricingPolicy.RateMarkup.IsZero())
+	suite.True(s.PricingPolicy.UnderpaymentTolerance.IsZero())
+	suite.Equal(int32(api.DeroDecimals), s.PricingPolicy.DeroDecimals)
+
+	newDecimal := func(d string) *decimal.Decimal {
+		v := decimal.RequireFromString(d)
+		return &v
+	}
+	newInt32 := func(i int32) *int32 { return &i }
+
+	testPricingPolicies := []struct {
+		Update api.PricingPolicyUpdate
+
+		ExpectedErrCode int
+		ExpectedErr     error
+	}{
+		{Update: api.PricingPolicyUpdate{RateMarkup: newDecimal("-1")}, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: api.ErrInvalidRateMarkup},
+		{Update: api.PricingPolicyUpdate{UnderpaymentTolerance: newDecimal("11")}, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: api.ErrInvalidUnderpaymentTolerance},
+		{Update: api.PricingPolicyUpdate{DeroDecimals: newInt32(13)}, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: api.ErrInvalidDeroDecimals},
+		{Update: api.PricingPolicyUpdate{RateMarkup: newDecimal("2.5"), UnderpaymentTolerance: newDecimal("0.5"), DeroDecimals: newInt32(4)}, ExpectedErrCode: 0, ExpectedErr: nil},
+	}
+
+	for _, pp := range testPricingPolicies {
+		errCode, err := s.UpdatePricingPolicy(suite.stores, pp.Update)
+		suite.Equal(pp.ExpectedErrCode, errCode)
+		suite.Equal(pp.ExpectedErr, err)
+	}
+
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
+	suite.Equal("2.5", s.PricingPolicy.RateMarkup.String())
+	suite.Equal("0.5", s.PricingPolicy.UnderpaymentTolerance.String())
+	suite.Equal(int32(4), s.PricingPolicy.DeroDecimals)
+
+	// Partial update keeps the settings not set
+	errCode, err := s.UpdatePricingPolicy(suite.stores, api.PricingPolicyUpdate{RateMarkup: newDecimal("3")})
+	suite.Zero(errCode)
+	suite.Nil(err)
+	suite.Equal("3", s.PricingPolicy.RateMarkup.String())
+	suite.Equal("0.5", s.PricingPolicy.UnderpaymentTolerance.String())
+	suite.Equal(int32(4), s.PricingPolicy.DeroDecimals)
+
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
+	suite.Equal("3", s.PricingPolicy.RateMarkup.String())
+	suite.Equal("0.5", s.PricingPolicy.UnderpaymentTolerance.String())
+	suite.Equal(int32(4), s.PricingPolicy.DeroDecimals)
+}
+
+func (suite *StoreTestSuite) TestUpdatePaymentRequirements() {
+	ownerID := suite.mockUser.ID
+
+	s, _ := CreateNewStore(suite.stores, "Store to be updated 7", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+	s.Insert(suite.stores)
+
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
+	suite.Zero(s.PaymentRequirements.TTL)
+	suite.Zero(s.PaymentRequirements.MinConfirmations)
+	suite.Empty(s.PaymentRequirements.ConfirmationTiers)
+
+	maxDeroAmount := decimal.NewFromInt(10)
+
+	testPaymentRequirements := []struct {
+		NewPaymentRequirements api.PaymentRequirements
+
+		ExpectedErrCode int
+		ExpectedErr     error
+	}{
+		{NewPaymentRequirements: api.PaymentRequirements{TTL: config.PaymentMaxTTL + 1}, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: api.ErrInvalidTTL},
+		{NewPaymentRequirements: api.PaymentRequirements{MinConfirmations: config.PaymentMaxConfirmations + 1}, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: api.ErrInvalidMinConfirmations},
+		{NewPaymentRequirements: api.PaymentRequirements{ConfirmationTiers: api.ConfirmationTiers{{Confirmations: config.PaymentMaxConfirmations + 1}}}, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: api.ErrInvalidConfirmationTiers},
+		{NewPaymentRequirements: api.PaymentRequirements{TTL: 1, MinConfirmations: config.PaymentMaxConfirmations, ConfirmationTiers: api.ConfirmationTiers{{MaxDeroAmount: &maxDeroAmount, Confirmations: config.PaymentMinConfirmations}, {Confirmations: config.PaymentMaxConfirmations}}}, ExpectedErrCode: 0, ExpectedErr: nil},
+	}
+
+	for _, r := range testPaymentRequirements {
+		errCode, err := s.UpdatePaymentRequirements(suite.stores, r.NewPaymentRequirements)
+		suite.Equal(r.ExpectedErrCode, errCode)
+		suite.Equal(r.ExpectedErr, err)
+	}
+
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
+	suite.Equal(1, s.PaymentRequirements.TTL)
+	suite.Equal(config.PaymentMaxConfirmations, s.PaymentRequirements.MinConfirmations)
+	suite.Len(s.PaymentRequirements.ConfirmationTiers, 2)
+	suite.True(maxDeroAmount.Equal(*s.PaymentRequirements.ConfirmationTiers[0].MaxDeroAmount))
+	suite.Nil(s.PaymentRequirements.ConfirmationTiers[1].MaxDeroAmount)
+}
+
+func (suite *StoreTestSuite) TestUpdateReceiptBranding() {
+	ownerID := suite.mockUser.ID
+
+	s, _ := CreateNewStore(suite.stores, "Store to be updated 8", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+	s.Insert(suite.stores)
+
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
+	suite.Empty(s.ReceiptBranding.Color)
+	suite.Empty(s.ReceiptBranding.Footer)
+
+	testReceiptBrandings := []struct {
+		NewReceiptBranding receipt.Branding
+
+		ExpectedErrCode int
+		ExpectedErr     error
+	}{
+		{NewReceiptBranding: receipt.Branding{Color: "blue"}, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: receipt.ErrInvalidColor},
+		{NewReceiptBranding: receipt.Branding{Footer: strings.Repeat("a", receipt.MaxFooterLength+1)}, ExpectedErrCode: http.StatusUnprocessableEntity, ExpectedErr: receipt.ErrInvalidFooter},
+		{NewReceiptBranding: receipt.Branding{Color: "#00FF00", Footer: " ACME Inc. "}, ExpectedErrCode: 0, ExpectedErr: nil},
+	}
+
+	for _, b := range testReceiptBrandings {
+		errCode, err := s.UpdateReceiptBranding(suite.stores, b.NewReceiptBranding)
+		suite.Equal(b.ExpectedErrCode, errCode)
+		suite.Equal(b.ExpectedErr, err)
+	}
+
+	s, _, _ = FetchStoreFromID(suite.stores, s.ID, ownerID)
+	suite.Equal("#00ff00", s.ReceiptBranding.Color)
+	suite.Equal("ACME Inc.", s.ReceiptBranding.Footer)
+}
+
 func (suite *StoreTestSuite) TestUpdateByInvalidUser() {
 	ownerID := suite.mockUser.ID
 
-	s, _ := CreateNewStore("Store to be updated 5", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
-	s.Insert()
+	s, _ := CreateNewStore(suite.stores, "Store to be updated 5", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+	s.Insert(suite.stores)
 
 	s.OwnerID += 123
 
-	errCode, err := s.UpdateViewKey("d64d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b760f1d")
+	errCode, err := s.UpdateViewKey(suite.stores, "d64d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b760f1d")
 	suite.Equal(errCode, http.StatusForbidden)
 	suite.Equal(err, ErrForbidden)
 
-	errCode, err = s.UpdateWebhook("test.com/webhook")
+	errCode, err = s.UpdateWebhook(suite.stores, "test.com/webhook")
 	suite.Equal(errCode, http.StatusForbidden)
 	suite.Equal(err, ErrForbidden)
 
-	errCode, err = s.UpdateWebhookSecretKey()
+	errCode, err = s.UpdateWebhookSecretKey(suite.stores)
 	suite.Equal(errCode, http.StatusForbidden)
 	suite.Equal(err, ErrForbidden)
 
-	errCode, err = s.UpdateKeys()
+	errCode, err = s.UpdateKeys(suite.stores)
+	suite.Equal(errCode, http.StatusForbidden)
+	suite.Equal(ErrForbidden, err)
+
+	errCode, err = s.UpdatePricingPolicy(suite.stores, api.PricingPolicyUpdate{})
+	suite.Equal(errCode, http.StatusForbidden)
+	suite.Equal(ErrForbidden, err)
+
+	errCode, err = s.UpdatePaymentRequirements(suite.stores, api.PaymentRequirements{})
+	suite.Equal(errCode, http.StatusForbidden)
+	suite.Equal(ErrForbidden, err)
+
+	errCode, err = s.UpdateReceiptBranding(suite.stores, receipt.Branding{})
 	suite.Equal(errCode, http.StatusForbidden)
 	suite.Equal(ErrForbidden, err)
 }
@@ -349,35 +442,35 @@ func (suite *StoreTestSuite) TestUpdateByInvalidUser() {
 func (suite *StoreTestSuite) TestRemove() {
 	ownerID := suite.mockUser.ID
 
-	s, _ := CreateNewStore("Store to be removed", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
-	s.Insert()
+	s, _ := CreateNewStore(suite.stores, "Store to be removed", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+	s.Insert(suite.stores)
 
-	_, errCode, err := FetchStoreFromID(s.ID, ownerID)
+	_, errCode, err := FetchStoreFromID(suite.stores, s.ID, ownerID)
 	suite.Zero(errCode)
 	suite.Nil(err)
 
 	// Test remove existing store
-	_, err = s.Remove()
+	_, err = s.Remove(suite.stores)
 	suite.Nil(err)
 
-	_, errCode, err = FetchStoreFromID(s.ID, ownerID)
+	_, errCode, err = FetchStoreFromID(suite.stores, s.ID, ownerID)
 	suite.Equal(http.StatusNotFound, errCode)
-	suite.Equal(sql.ErrNoRows, err)
+	suite.Equal(repository.ErrNotFound, err)
 
 	// Test remove store that has been removed
-	_, err = s.Remove()
+	_, err = s.Remove(suite.stores)
 	suite.NotNil(err)
 }
 
 func (suite *StoreTestSuite) TestRemoveByInvalidUser() {
 	ownerID := suite.mockUser.ID
 
-	s, _ := CreateNewStore("Store to be removed 2", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
-	s.Insert()
+	s, _ := CreateNewStore(suite.stores, "Store to be removed 2", "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c", "", ownerID)
+	s.Insert(suite.stores)
 
 	s.OwnerID += 123
 
-	errCode, err := s.Remove()
+	errCode, err := s.Remove(suite.stores)
 	suite.Equal(http.StatusForbidden, errCode)
 	suite.Equal(ErrForbidden, err)
 }
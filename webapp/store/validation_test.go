@@ -0,0 +1,36 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasValidTitle(t *testing.T) {
+	testStores := map[*Store]bool{
+		&Store{Title: "Amazing Store"}:                          true,
+		&Store{Title: "Bay of Products"}:                        true,
+		&Store{Title: "Productslist"}:                           true,
+		&Store{Title: ""}:                                       false,
+		&Store{Title: "The title of this store is way to long"}: false,
+	}
+
+	for s, shouldValid := range testStores {
+		isValid := s.HasValidTitle()
+		assert.Equal(t, shouldValid, isValid)
+	}
+}
+
+func TestHasValidViewKey(t *testing.T) {
+	testStores := map[*Store]bool{
+		&Store{WalletViewKey: "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0c"}:  true,
+		&Store{WalletViewKey: "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0"}:   false,
+		&Store{WalletViewKey: "c53d44b598141c5527ab6a39e82e107d09620fda2af8c9bdc6cb06db2d4ff368cd73811194dbe53cbbe375fd3d9dc1ad1e334f56726d1289a8c096a13b76fd0ca"}: false,
+		&Store{WalletViewKey: ""}: false,
+	}
+
+	for s, shouldValid := range testStores {
+		isValid := s.HasValidViewKey()
+		assert.Equal(t, shouldValid, isValid)
+	}
+}
@@ -1,14 +1,12 @@
 package user
 
 import (
-	"database/sql"
-	"fmt"
 	"strings"
 
 	"github.com/alexedwards/argon2id"
 	"github.com/pkg/errors"
 
-	"github.com/peppinux/dero-merchant/postgres"
+	"github.com/peppinux/dero-merchant/repository"
 	"github.com/peppinux/dero-merchant/stringutil"
 )
 
@@ -57,87 +55,32 @@ func (u *User) HasValidVerificationToken() bool {
 }
 
 // IsUnique returns whether User's username and email are unique or not
-func (u *User) IsUnique() (uniqueUsername bool, uniqueEmail bool, err error) {
-	lowerUsername := strings.ToLower(u.Username)
-
-	var (
-		existingUsername string
-		existingEmail    string
-	)
-	err = postgres.DB.QueryRow(`
-		SELECT LOWER(username), email 
-		FROM users 
-		WHERE LOWER(username)=$1 OR email=$2`, lowerUsername, u.Email).
-		Scan(&existingUsername, &existingEmail)
+func (u *User) IsUnique(users repository.UserRepository) (uniqueUsername bool, uniqueEmail bool, err error) {
+	existing, err := users.ByUsernameOrEmail(u.Username, u.Email)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return true, true, nil
-		}
-
 		err = errors.Wrap(err, "cannot query database")
 		return
 	}
 
-	if lowerUsername != existingUsername {
-		uniqueUsername = true
-	}
-	if u.Email != existingEmail {
-		uniqueEmail = true
+	uniqueUsername, uniqueEmail = true, true
+	for _, e := range existing {
+		if strings.EqualFold(u.Username, e.Username) {
+			uniqueUsername = false
+		}
+		if u.Email == e.Email {
+			uniqueEmail = false
+		}
 	}
 
 	return
 }
 
-type tokenType int
-
-const (
-	verification tokenType = iota
-	recover                = iota
-)
-
-func (t tokenType) String() string {
-	switch t {
-	case verification:
-		return "verification"
-	case recover:
-		return "recover"
-	default:
-		return ""
-	}
-}
-
-var errInvalidTokenType = errors.New("invalid token type")
-
 func generateUserToken() (string, error) {
 	return stringutil.RandomBase64RawURLString(48)
 }
 
-func isUniqueUserToken(t tokenType, token string) (bool, error) {
-	typeString := t.String()
-	if typeString == "" {
-		return false, errInvalidTokenType
-	}
-
-	q := fmt.Sprintf(`
-		SELECT id
-		FROM users
-		WHERE %s_token=$1`, typeString)
-
-	var userID int
-	err := postgres.DB.QueryRow(q, token).Scan(&userID)
-	if err != nil {
-		if err == sql.ErrNoRows { // User token is unique
-			return true, nil
-		}
-
-		return false, errors.Wrap(err, "cannot query database")
-	}
-
-	return false, nil
-}
-
 // GenerateUniqueUserToken generates a verification/recover token that is not already in use by another user
-func GenerateUniqueUserToken(t tokenType) (token string, err error) {
+func GenerateUniqueUserToken(users repository.UserRepository, kind repository.TokenKind) (token string, err error) {
 	for {
 		// Generate user token
 		token, err = generateUserToken()
@@ -145,12 +88,12 @@ func GenerateUniqueUserToken(t tokenType) (token string, err error) {
 			return "", errors.Wrap(err, "cannot generate user token")
 		}
 
-		isUnique, err := isUniqueUserToken(t, token)
+		exists, err := users.TokenExists(kind, token)
 		if err != nil {
 			return "", errors.Wrap(err, "cannot check if user token is unique")
 		}
 
-		if isUnique {
+		if !exists {
 			break
 		}
 	}
@@ -159,11 +102,11 @@ func GenerateUniqueUserToken(t tokenType) (token string, err error) {
 }
 
 // GenerateVerificationTokenAsync generates a verification token and returns it in a channel
-func GenerateVerificationTokenAsync() chan string {
+func GenerateVerificationTokenAsync(users repository.UserRepository) chan string {
 	c := make(chan string)
 
 	go func() {
-		token, err := GenerateUniqueUserToken(verification)
+		token, err := GenerateUniqueUserToken(users, repository.VerificationToken)
 		if err != nil {
 			c <- ""
 			return
@@ -199,7 +142,7 @@ var (
 )
 
 // CreateNewUser returns a new User ready to be inserted into DB
-func CreateNewUser(username, email, password, confirmPassword string) (u *User, errs []error) {
+func CreateNewUser(users repository.UserRepository, username, email, password, confirmPassword string) (u *User, errs []error) {
 	// Sanitize input
 	u = &User{
 		Username: strings.TrimSpace(username),
@@ -218,7 +161,7 @@ func CreateNewUser(username, email, password, confirmPassword string) (u *User,
 	}
 
 	// Check if username and email are already used by someone else
-	uniqueUsername, uniqueEmail, err := u.IsUnique()
+	uniqueUsername, uniqueEmail, err := u.IsUnique(users)
 	if err != nil {
 		errs = append(errs, errors.Wrap(err, "cannot check if user is unique"))
 		return
@@ -233,7 +176,7 @@ func CreateNewUser(username, email, password, confirmPassword string) (u *User,
 		return
 	}
 
-	tokenChan := GenerateVerificationTokenAsync()
+	tokenChan := GenerateVerificationTokenAsync(users)
 	hashChan := HashPasswordAsync(password)
 
 	for i := 0; i < 2; i++ {
@@ -261,31 +204,24 @@ func (u *User) SendVerificationEmail() error {
 }
 
 // Insert inserts a User into DB
-func (u *User) Insert() error {
-	tx, err := postgres.DB.Begin()
-	if err != nil {
-		return errors.Wrap(err, "cannot begin DB TX")
+func (u *User) Insert(users repository.UserRepository) error {
+	ru := &repository.User{
+		Username: u.Username,
+		Email:    u.Email,
+		Password: u.HashedPassword,
 	}
-
-	_, err = tx.Exec(`
-		INSERT INTO users (username, email, password, verification_token) 
-		VALUES ($1, $2, $3, $4)`, u.Username, u.Email, u.HashedPassword, u.VerificationToken)
+	err := users.Insert(ru, u.VerificationToken)
 	if err != nil {
-		return errors.Wrap(err, "cannot execute query")
+		return errors.Wrap(err, "cannot insert user")
 	}
+	u.ID = ru.ID
 
 	// TODO: Inviare email con verification token ad utente qui
-	// Se errore durante invio TX Rollback
 	err = u.SendVerificationEmail()
 	if err != nil {
 		return errors.Wrap(err, "cannot send verification email")
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return errors.Wrap(err, "cannot commit DB TX")
-	}
-
 	return nil
 }
 
@@ -0,0 +1,30 @@
+// Listens for the events of the payments of a store (payment_created, payment_status_changed) through WebSocket.
+// The connection is opened again if it drops.
+const listenToStoreEvents = (storeID, onEvent) => {
+    const protocol = (window.location.protocol === "https:") ? "wss" : "ws"
+
+    const connect = () => {
+        const ws = new WebSocket(`${protocol}://${window.location.host}/store/${storeID}/events`)
+
+        ws.onmessage = message => {
+            try {
+                onEvent(JSON.parse(message.data))
+            } catch(e) {
+                console.error(e)
+            }
+        }
+
+        ws.onclose = () => setTimeout(connect, 5000)
+    }
+
+    connect()
+}
+
+// Returns a function that calls fn only after wait ms have passed since it was last called
+const debounce = (fn, wait) => {
+    let timeout
+    return (...args) => {
+        clearTimeout(timeout)
+        timeout = setTimeout(() => fn(...args), wait)
+    }
+}
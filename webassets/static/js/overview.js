@@ -0,0 +1,114 @@
+const formatPercentage = rate => `${(Number(rate) * 100).toFixed(2)}%`
+
+const formatDuration = seconds => {
+    if(!seconds) {
+        return "-"
+    }
+
+    const mins = Math.floor(seconds / 60)
+    const secs = seconds % 60
+    return (mins > 0) ? `${mins} min ${secs} s` : `${secs} s`
+}
+
+const formatCurrencyRevenue = revenue => {
+    const currencies = Object.keys(revenue || {}).sort()
+    if(currencies.length === 0) {
+        return "-"
+    }
+
+    return currencies.map(currency => `${revenue[currency]} ${currency}`).join("<br>")
+}
+
+const formatStatsToTableRow = stats => `
+    <tr>
+        <td>${stats.period}</td>
+        <td>${stats.created}</td>
+        <td>${stats.paid}</td>
+        <td>${stats.pending}</td>
+        <td>${stats.expired}</td>
+        <td>${stats.cancelled}</td>
+        <td>${stats.error}</td>
+        <td>${formatPercentage(stats.conversionRate)}</td>
+        <td>${formatDuration(stats.avgTimeToPayment)}</td>
+        <td>${stats.deroRevenue} DERO</td>
+        <td>${formatCurrencyRevenue(stats.currencyRevenue)}</td>
+    </tr>
+`
+
+const fillStats = stats => {
+    const totals = stats ? stats.totals : null
+
+    document.querySelector("#total-created").innerHTML = totals ? totals.created : "-"
+    document.querySelector("#total-statuses").innerHTML = totals ? `${totals.pending} pending, ${totals.expired} expired, ${totals.cancelled} cancelled, ${totals.error} error` : ""
+    document.querySelector("#total-conversion-rate").innerHTML = totals ? formatPercentage(totals.conversionRate) : "-"
+    document.querySelector("#total-paid").innerHTML = totals ? `${totals.paid} paid` : ""
+    document.querySelector("#total-avg-time").innerHTML = totals ? formatDuration(totals.avgTimeToPayment) : "-"
+    document.querySelector("#total-dero-revenue").innerHTML = totals ? `${totals.deroRevenue} DERO` : "-"
+    document.querySelector("#total-currency-revenue").innerHTML = totals ? formatCurrencyRevenue(totals.currencyRevenue) : ""
+
+    let tableRows = ""
+    if(stats && stats.periods.length > 0) {
+        for(let period of stats.periods) {
+            tableRows += formatStatsToTableRow(period)
+        }
+    } else {
+        tableRows = `<tr><td colspan="11" class="text-center">No payments in the selected range</td></tr>`
+    }
+
+    document.querySelector("table tbody").innerHTML = tableRows
+}
+
+const loadStats = async e => {
+    if(e !== undefined) {
+        e.preventDefault()
+    }
+
+    const dangerAlert = document.querySelector("div.alert")
+    dangerAlert.classList.add("d-none")
+
+    const form = document.querySelector("#stats-form")
+    const params = new URLSearchParams({
+        interval: form.querySelector("#interval").value,
+        timezone: form.querySelector("#timezone").value.trim() || "UTC",
+        from: form.querySelector("#from").value,
+        to: form.querySelector("#to").value,
+    })
+
+    try {
+        const res = await fetch(`/store/${storeID}/stats?${params}`, {
+            method: "GET",
+            credentials: "include",
+            headers: new Headers({
+                "Accept": "application/json",
+            }),
+        })
+        const json = await res.json()
+
+        if(res.status === 200) {
+            fillStats(json)
+        } else {
+            fillStats(null)
+            dangerAlert.innerHTML = json.error.message
+            dangerAlert.classList.remove("d-none")
+        }
+    } catch(e) {
+        dangerAlert.innerHTML = "An error occured while sending the request."
+        dangerAlert.classList.remove("d-none")
+        console.error(e)
+    }
+}
+
+// Stats are computed in the time zone of the browser by default
+try {
+    document.querySelector("#stats-form #timezone").value = Intl.DateTimeFormat().resolvedOptions().timeZone || "UTC"
+} catch(e) {
+    document.querySelector("#stats-form #timezone").value = "UTC"
+}
+
+document.querySelector("#stats-form").addEventListener("submit", loadStats)
+document.querySelector("#stats-form").addEventListener("change", loadStats)
+
+loadStats() // On first page load
+
+// Reload stats when a payment of the store is created or changes status
+listenToStoreEvents(storeID, debounce(() => loadStats(), 1000))
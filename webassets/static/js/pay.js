@@ -30,8 +30,8 @@ const ws = new WebSocket(`ws://${window.location.host}/ws/payment/${paymentID}/s
 ws.onmessage = event => {
     const newStatus = event.data
 
-    const statuses = ["paid", "expired", "error"]
-    const colors = ["text-success", "text-secondary", "text-danger"]
+    const statuses = ["paid", "expired", "error", "cancelled"]
+    const colors = ["text-success", "text-secondary", "text-danger", "text-warning"]
 
     const statusIndex = statuses.indexOf(newStatus)
     if(statusIndex === -1) {
@@ -47,4 +47,17 @@ ws.onmessage = event => {
     clearInterval(updateMinutesLeft)
 
     document.querySelector(".toast").classList.add("blurred")
+
+    if(newStatus === "paid") {
+        document.querySelector("#receipt-link").classList.remove("d-none")
+    }
+
+    if(newStatus === "expired") {
+        document.querySelector("#requote-form").classList.remove("d-none")
+    }
+
+    // Redirect customer back to the store
+    if(redirectOnStatus[newStatus]) {
+        setTimeout(() => window.location.assign(`/pay/${paymentID}/redirect`), 3000)
+    }
 }
@@ -0,0 +1,18 @@
+document.querySelectorAll("[data-copy]").forEach(button => {
+    button.addEventListener("click", () => {
+        const text = document.querySelector(button.dataset.copy).textContent
+
+        navigator.clipboard.writeText(text).then(() => {
+            const html = button.innerHTML
+            button.innerHTML = `<i class="fas fa-check"></i> Copied`
+            setTimeout(() => button.innerHTML = html, 2000)
+        })
+    })
+})
+
+// Reload the page when the status of the payment changes
+listenToStoreEvents(storeID, e => {
+    if(e.type === "payment_status_changed" && e.paymentID === paymentID) {
+        window.location.replace(window.location.pathname)
+    }
+})
@@ -3,19 +3,26 @@ const buildQueryString = params => {
 
     for(key in params) {
         if(params[key] !== undefined) { 
-            queryString += `${key}=${params[key]}&`
+            queryString += `${key}=${encodeURIComponent(params[key])}&`
         }
     }
 
     return (queryString.length > 1) ? queryString.slice(0, -1) : ""
 }
 
+const escapeHTML = str => {
+    const div = document.createElement("div")
+    div.textContent = str
+    return div.innerHTML
+}
+
 const formatPaymentToTableRow = payment => {
     const status = {
         "pending": 0,
         "paid": 1,
         "expired": 2,
         "error": 3,
+        "cancelled": 4,
     }
 
     const color = [
@@ -23,6 +30,7 @@ const formatPaymentToTableRow = payment => {
         "table-success", // Paid
         "table-secondary", // Expired
         "table-danger", // Error
+        "table-warning", // Cancelled
     ]
 
     return `
@@ -30,13 +38,17 @@ const formatPaymentToTableRow = payment => {
             <td>${new Date(payment.creationTime).toUTCString()}</td>
             <td>${payment.status}</td>
             <td>${payment.deroAmount}</td>
-            <td>${payment.paymentID}</td>
+            <td><a href="/dashboard/stores/view/${storeID}/payments/${payment.paymentID}">${payment.paymentID}</a></td>
             <td>${payment.integratedAddress}</td>
             <td>${payment.atomicDeroAmount}</td>
             <td>${payment.currency}</td>
             <td>${payment.currencyAmount}</td>
             <td>${(payment.currency === "DERO") ? `-` : `1 DERO = ${payment.exchangeRate} ${payment.currency}`}</td>
             <td>${payment.ttl} min(s)</td>
+            <td>${payment.orderID ? escapeHTML(payment.orderID) : `-`}</td>
+            <td>${payment.description ? escapeHTML(payment.description) : `-`}</td>
+            <td>${payment.customerEmail ? escapeHTML(payment.customerEmail) : `-`}</td>
+            <td>${payment.metadata ? `<code>${escapeHTML(JSON.stringify(payment.metadata))}</code>` : `-`}</td>
         </tr>
     `
 }
@@ -60,6 +72,10 @@ const fillPaymentsTable = payments => {
                 <td>-</td>
                 <td>-</td>
                 <td>-</td>
+                <td>-</td>
+                <td>-</td>
+                <td>-</td>
+                <td>-</td>
             </tr>
         `
     }
@@ -164,11 +180,14 @@ const updatePaymentsPagination = (currentPage, totalPages) => {
     }
 }
 
-const fetchPayments = async ({limit, page, sortBy, orderBy, status, currency} = {}) => {
+const fetchPayments = async ({limit, page, sortBy, orderBy, status, currency, from, to, minAmount, maxAmount, integratedAddress, orderID, description, metadata} = {}) => {
     const dangerAlert = document.querySelector("div.alert")
     dangerAlert.classList.add("d-none")
 
-    const query = buildQueryString({limit, page, sort_by: sortBy, order_by: orderBy, status, currency})
+    const query = buildQueryString({
+        limit, page, sort_by: sortBy, order_by: orderBy, status, currency,
+        from, to, min_amount: minAmount, max_amount: maxAmount, integrated_address: integratedAddress, order_id: orderID, description, metadata,
+    })
 
     try {
         const res = await fetch(`/store/${storeID}/payments${query}`, {
@@ -196,36 +215,77 @@ const fetchPayments = async ({limit, page, sortBy, orderBy, status, currency} =
     }
 }
 
-const loadPayments = (e, page) => {
-    if(e !== undefined) {
-        e.preventDefault()
-    }
-
-    const limit = document.querySelector("#limit-form #limit")
+// Filtering and sorting params shared by the payments table and the export
+const getFilters = () => {
     const sortBy = document.querySelector("#sort-by-form select option:checked")
     const status = document.querySelector("#filter-status-form select option:checked")
     const currency = document.querySelector("#filter-currency-form #currency")
+    const search = document.querySelector("#search-form")
 
     const sortAndOrder = sortBy.value.split("|")
     const [sort, order] = sortAndOrder
 
-    const params = {
-        limit: limit.value || 10,
-        page: page || 1,
+    return {
         sortBy: sort,
         orderBy: order,
         status: status.value,
         currency: currency.value,
+        from: search.querySelector("#from").value,
+        to: search.querySelector("#to").value,
+        minAmount: search.querySelector("#min-amount").value,
+        maxAmount: search.querySelector("#max-amount").value,
+        integratedAddress: search.querySelector("#integrated-address").value.trim(),
+        orderID: search.querySelector("#order-id").value,
+        description: search.querySelector("#description").value,
+        metadata: search.querySelector("#metadata").value.trim(),
+    }
+}
+
+const loadPayments = (e, page) => {
+    if(e !== undefined) {
+        e.preventDefault()
+    }
+
+    const limit = document.querySelector("#limit-form #limit")
+
+    const params = {
+        limit: limit.value || 10,
+        page: page || 1,
+        ...getFilters(),
     }
     
     fetchPayments(params)
 }
 
+// Exported file is streamed by the server and downloaded by the browser
+const exportPayments = e => {
+    e.preventDefault()
+
+    const {sortBy, orderBy, status, currency, from, to, minAmount, maxAmount, integratedAddress, orderID, description, metadata} = getFilters()
+    const query = buildQueryString({
+        format: e.target.dataset.format, sort_by: sortBy, order_by: orderBy, status, currency,
+        from, to, min_amount: minAmount, max_amount: maxAmount, integrated_address: integratedAddress, order_id: orderID, description, metadata,
+    })
+
+    window.location.href = `/store/${storeID}/payments/export${query}`
+}
+
 document.querySelector("#limit-form").addEventListener("submit", e => e.preventDefault())
 document.querySelector("#limit-form").addEventListener("input", loadPayments)
 document.querySelector("#sort-by-form").addEventListener("change", loadPayments)
 document.querySelector("#filter-status-form").addEventListener("change", loadPayments)
 document.querySelector("#filter-currency-form").addEventListener("submit", e => e.preventDefault())
 document.querySelector("#filter-currency-form").addEventListener("input", loadPayments)
+document.querySelector("#search-form").addEventListener("submit", loadPayments)
+for(let exportLink of document.querySelectorAll("#export-dropdown a[data-format]")) {
+    exportLink.addEventListener("click", exportPayments)
+}
+document.querySelector("#search-form").addEventListener("change", loadPayments)
 
 loadPayments() // On first page load
+
+// Reload the current page of payments when a payment of the store is created or changes status
+listenToStoreEvents(storeID, debounce(() => {
+    const activePage = document.querySelector("ul.pagination li.page-item.active")
+    loadPayments(undefined, activePage ? activePage.dataset.page : 1)
+}, 1000))
@@ -124,6 +124,222 @@ const newStoreKeysHandler = async authHeader => {
 
 document.querySelector("#btn-new-store-keys").addEventListener("click", requirePasswordMiddleware.bind(this, newStoreKeysHandler))
 
+// Pricing Policy Editor Handler
+document.querySelector("form#edit-pricing-policy .toggle-editor-btn").addEventListener("click", function() {
+    let newStatus
+    for(const input of ["#rate-markup", "#underpayment-tolerance", "#dero-decimals"]) {
+        newStatus = toggleInput(input)
+    }
+    const submitBtn = document.querySelector("form#edit-pricing-policy .submit-btn")
+    if(newStatus == true) {
+        this.innerHTML = '<i class="fas fa-times-circle"></i> Dismiss'
+        submitBtn.classList.remove("d-none")
+    } else {
+        this.innerHTML = '<i class="fas fa-edit"></i> Edit'
+        submitBtn.classList.add("d-none")
+    }
+})
+
+const editPricingPolicyHandler = async (authHeader, e) => {
+    e.preventDefault()
+    
+    const invalidFeedback = document.querySelector("form#edit-pricing-policy .invalid-feedback")
+    const inputs = document.querySelectorAll("form#edit-pricing-policy input")
+    inputs.forEach(input => input.classList.remove("is-invalid"))
+    
+    const payload = {
+        pricingPolicy: {
+            rateMarkup: e.target["rate-markup"].value,
+            underpaymentTolerance: e.target["underpayment-tolerance"].value,
+            deroDecimals: parseInt(e.target["dero-decimals"].value, 10),
+        },
+    }
+    
+    try {
+        const res = await fetch(`/store/${storeID}`, {
+            method: "PUT",
+            credentials: "include",
+            headers: new Headers({
+                "Content-Type": "application/json",
+                "Accept": "application/json",
+                "Authorization": authHeader,
+            }),
+            body: JSON.stringify(payload),
+        })
+        const json = await res.json()
+        
+        if(res.status === 200) {
+            e.target["rate-markup"].value = json.pricingPolicy.rateMarkup
+            e.target["underpayment-tolerance"].value = json.pricingPolicy.underpaymentTolerance
+            e.target["dero-decimals"].value = json.pricingPolicy.deroDecimals
+            
+            const successAlert = document.querySelector("form#edit-pricing-policy .alert.alert-success")
+            successAlert.classList.remove("d-none")
+        } else {
+            invalidFeedback.innerHTML = json.error.message
+            inputs.forEach(input => input.classList.add("is-invalid"))
+        }
+    } catch(e) {
+        invalidFeedback.innerHTML = "An error occured while sending the request."
+        inputs.forEach(input => input.classList.add("is-invalid"))
+        console.error(e)
+    }
+}
+
+document.querySelector("form#edit-pricing-policy").addEventListener("submit", requirePasswordMiddleware.bind(this, editPricingPolicyHandler))
+
+// Payment Requirements Editor Handler
+document.querySelector("form#edit-payment-requirements .toggle-editor-btn").addEventListener("click", function() {
+    let newStatus
+    for(const input of ["#payment-ttl", "#payment-min-confirmations"]) {
+        newStatus = toggleInput(input)
+    }
+    document.querySelectorAll("#confirmation-tiers input").forEach(input => {
+        input.readOnly = !newStatus
+        input.classList = newStatus ? `form-control ${input.classList[1]}` : `form-control-plaintext ${input.classList[1]}`
+    })
+    const tierBtns = document.querySelectorAll("#confirmation-tiers .remove-tier-btn, #btn-add-confirmation-tier")
+    const submitBtn = document.querySelector("form#edit-payment-requirements .submit-btn")
+    if(newStatus == true) {
+        this.innerHTML = '<i class="fas fa-times-circle"></i> Dismiss'
+        submitBtn.classList.remove("d-none")
+        tierBtns.forEach(btn => btn.classList.remove("d-none"))
+    } else {
+        this.innerHTML = '<i class="fas fa-edit"></i> Edit'
+        submitBtn.classList.add("d-none")
+        tierBtns.forEach(btn => btn.classList.add("d-none"))
+    }
+})
+
+document.querySelector("#btn-add-confirmation-tier").addEventListener("click", () => {
+    const template = document.querySelector("#confirmation-tier-template")
+    document.querySelector("#confirmation-tiers").appendChild(template.content.cloneNode(true))
+})
+
+document.querySelector("#confirmation-tiers").addEventListener("click", e => {
+    const removeBtn = e.target.closest(".remove-tier-btn")
+    if(removeBtn) {
+        removeBtn.closest(".confirmation-tier").remove()
+    }
+})
+
+const editPaymentRequirementsHandler = async (authHeader, e) => {
+    e.preventDefault()
+    
+    const invalidFeedback = document.querySelector("form#edit-payment-requirements .invalid-feedback")
+    const inputs = document.querySelectorAll("form#edit-payment-requirements input")
+    inputs.forEach(input => input.classList.remove("is-invalid"))
+    
+    const confirmationTiers = Array.from(document.querySelectorAll("#confirmation-tiers .confirmation-tier")).map(tier => {
+        const maxDeroAmount = tier.querySelector(".tier-max-dero-amount").value.trim()
+        return {
+            maxDeroAmount: maxDeroAmount === "" ? null : maxDeroAmount, // Sent as string to avoid loss of precision
+            confirmations: parseInt(tier.querySelector(".tier-confirmations").value, 10),
+        }
+    })
+
+    const payload = {
+        paymentRequirements: {
+            ttl: parseInt(e.target["payment-ttl"].value, 10),
+            minConfirmations: parseInt(e.target["payment-min-confirmations"].value, 10),
+            confirmationTiers: confirmationTiers,
+        },
+    }
+    
+    try {
+        const res = await fetch(`/store/${storeID}`, {
+            method: "PUT",
+            credentials: "include",
+            headers: new Headers({
+                "Content-Type": "application/json",
+                "Accept": "application/json",
+                "Authorization": authHeader,
+            }),
+            body: JSON.stringify(payload),
+        })
+        const json = await res.json()
+        
+        if(res.status === 200) {
+            e.target["payment-ttl"].value = json.paymentRequirements.ttl
+            e.target["payment-min-confirmations"].value = json.paymentRequirements.minConfirmations
+            
+            const successAlert = document.querySelector("form#edit-payment-requirements .alert.alert-success")
+            successAlert.classList.remove("d-none")
+        } else {
+            invalidFeedback.innerHTML = json.error.message
+            inputs.forEach(input => input.classList.add("is-invalid"))
+        }
+    } catch(e) {
+        invalidFeedback.innerHTML = "An error occured while sending the request."
+        inputs.forEach(input => input.classList.add("is-invalid"))
+        console.error(e)
+    }
+}
+
+document.querySelector("form#edit-payment-requirements").addEventListener("submit", requirePasswordMiddleware.bind(this, editPaymentRequirementsHandler))
+
+// Receipt Branding Editor Handler
+document.querySelector("form#edit-receipt-branding .toggle-editor-btn").addEventListener("click", function() {
+    let newStatus
+    for(const input of ["#receipt-color", "#receipt-footer"]) {
+        newStatus = toggleInput(input)
+    }
+    const submitBtn = document.querySelector("form#edit-receipt-branding .submit-btn")
+    if(newStatus == true) {
+        this.innerHTML = '<i class="fas fa-times-circle"></i> Dismiss'
+        submitBtn.classList.remove("d-none")
+    } else {
+        this.innerHTML = '<i class="fas fa-edit"></i> Edit'
+        submitBtn.classList.add("d-none")
+    }
+})
+
+const editReceiptBrandingHandler = async (authHeader, e) => {
+    e.preventDefault()
+    
+    const invalidFeedback = document.querySelector("form#edit-receipt-branding .invalid-feedback")
+    const inputs = document.querySelectorAll("form#edit-receipt-branding input, form#edit-receipt-branding textarea")
+    inputs.forEach(input => input.classList.remove("is-invalid"))
+    
+    const payload = {
+        receiptBranding: {
+            color: e.target["receipt-color"].value,
+            footer: e.target["receipt-footer"].value,
+        },
+    }
+    
+    try {
+        const res = await fetch(`/store/${storeID}`, {
+            method: "PUT",
+            credentials: "include",
+            headers: new Headers({
+                "Content-Type": "application/json",
+                "Accept": "application/json",
+                "Authorization": authHeader,
+            }),
+            body: JSON.stringify(payload),
+        })
+        const json = await res.json()
+        
+        if(res.status === 200) {
+            e.target["receipt-color"].value = json.receiptBranding.color
+            e.target["receipt-footer"].value = json.receiptBranding.footer
+            
+            const successAlert = document.querySelector("form#edit-receipt-branding .alert.alert-success")
+            successAlert.classList.remove("d-none")
+        } else {
+            invalidFeedback.innerHTML = json.error.message
+            inputs.forEach(input => input.classList.add("is-invalid"))
+        }
+    } catch(e) {
+        invalidFeedback.innerHTML = "An error occured while sending the request."
+        inputs.forEach(input => input.classList.add("is-invalid"))
+        console.error(e)
+    }
+}
+
+document.querySelector("form#edit-receipt-branding").addEventListener("submit", requirePasswordMiddleware.bind(this, editReceiptBrandingHandler))
+
 // Webhook URL Editor Handler
 document.querySelector("form#edit-webhook .toggle-editor-btn").addEventListener("click", function() {
     const newStatus = toggleInput("form#edit-webhook input")